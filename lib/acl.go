@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// accessList restricts which clients (by IP) are answered
+// and which ones may have their questions recursed.
+type accessList struct {
+	allow     []*net.IPNet
+	deny      []*net.IPNet
+	recursion []*net.IPNet
+}
+
+// parseSubnets parses CIDRs (e.g.: '10.0.0.0/8') such that
+// they're parsed once instead of on every query.
+func parseSubnets(entries []string) (subnets []*net.IPNet, err error) {
+	for _, entry := range entries {
+		var subnet *net.IPNet
+
+		_, subnet, err = net.ParseCIDR(entry)
+		if err != nil {
+			err = errors.Wrapf(err, "malformed subnet %s", entry)
+			subnets = nil
+			return
+		}
+
+		subnets = append(subnets, subnet)
+	}
+
+	return
+}
+
+// containsIP tells whether any of the subnets contains ip.
+func containsIP(subnets []*net.IPNet, ip net.IP) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newAccessList parses the access control lists of cfg.
+func newAccessList(cfg SdnsConfig) (acl *accessList, err error) {
+	acl = new(accessList)
+
+	acl.allow, err = parseSubnets(cfg.AllowClients)
+	if err != nil {
+		err = errors.Wrapf(err, "invalid allowed clients")
+		return
+	}
+
+	acl.deny, err = parseSubnets(cfg.DenyClients)
+	if err != nil {
+		err = errors.Wrapf(err, "invalid denied clients")
+		return
+	}
+
+	acl.recursion, err = parseSubnets(cfg.RecursionClients)
+	if err != nil {
+		err = errors.Wrapf(err, "invalid recursion clients")
+		return
+	}
+
+	return
+}
+
+// allows tells whether the client may be answered: it's
+// not denied and, if there's an allowlist, it's in it.
+// Unknown clients (e.g.: without an IP) only are if no
+// list restricts them.
+func (acl *accessList) allows(client net.IP) bool {
+	if client == nil {
+		return len(acl.allow) == 0 && len(acl.deny) == 0
+	}
+
+	if containsIP(acl.deny, client) {
+		return false
+	}
+
+	return len(acl.allow) == 0 || containsIP(acl.allow, client)
+}
+
+// recurses tells whether the questions of the client may
+// be recursed.
+func (acl *accessList) recurses(client net.IP) bool {
+	if len(acl.recursion) == 0 {
+		return true
+	}
+
+	return client != nil && containsIP(acl.recursion, client)
+}
+
+// denied tells whether the client of the query isn't
+// allowed to query at all.
+func (s *Sdns) denied(ctx *SdnsContext) (denied bool) {
+	if s.acl.allows(ctx.client) {
+		return
+	}
+
+	s.metrics.denied.WithLabelValues("query").Inc()
+	ctx.logger.Info().
+		Str("client", ctx.client.String()).
+		Msg("client denied")
+
+	denied = true
+	return
+}
+
+// recursionDenied tells whether the client of the query
+// isn't allowed to have it recursed, in which case only
+// local answers are given to it.
+func (s *Sdns) recursionDenied(ctx *SdnsContext) (denied bool) {
+	if s.acl.recurses(ctx.client) {
+		return
+	}
+
+	s.metrics.denied.WithLabelValues("recursion").Inc()
+	ctx.logger.Info().
+		Str("client", ctx.client.String()).
+		Msg("client denied recursion")
+
+	denied = true
+	return
+}
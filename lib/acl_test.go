@@ -0,0 +1,88 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_accessLists(t *testing.T) {
+	var recursor = startRecursor(t, answerHandler(0, "7.7.7.7"))
+
+	s, err := NewSdns(SdnsConfig{
+		Port:             1232,
+		Recursors:        []string{recursor},
+		AllowClients:     []string{"10.0.0.0/8", "192.168.0.0/16", "2001:db8::/32"},
+		DenyClients:      []string{"10.1.0.0/16"},
+		RecursionClients: []string{"192.168.0.0/16", "2001:db8::/32"},
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		desc    string
+		client  string
+		name    string
+		rcode   int
+		answers []string
+	}{
+		{"allowed local", "10.0.0.1", "foo.com", dns.RcodeSuccess, []string{"1.1.1.1"}},
+		{"allowed recursion", "192.168.1.1", "bar.com", dns.RcodeSuccess, []string{"7.7.7.7"}},
+		{"allowed ipv6 recursion", "2001:db8::1", "bar.com", dns.RcodeSuccess, []string{"7.7.7.7"}},
+		{"recursion restricted local", "10.0.0.1", "foo.com", dns.RcodeSuccess, []string{"1.1.1.1"}},
+		{"recursion restricted", "10.0.0.1", "bar.com", dns.RcodeRefused, []string{}},
+		{"denied within allowed", "10.1.0.1", "foo.com", dns.RcodeRefused, []string{}},
+		{"not allowed", "172.16.0.1", "foo.com", dns.RcodeRefused, []string{}},
+		{"not allowed recursion", "172.16.0.1", "bar.com", dns.RcodeRefused, []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			m := clientQuery(s, tc.client, tc.name)
+			assert.Equal(t, tc.rcode, m.Rcode)
+			assert.Equal(t, tc.answers, answerIPs(m))
+		})
+	}
+}
+
+func TestHandle_accessListsUnset(t *testing.T) {
+	var recursor = startRecursor(t, answerHandler(0, "7.7.7.7"))
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{recursor},
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(clientQuery(s, "172.16.0.1", "foo.com")))
+	assert.Equal(t, []string{"7.7.7.7"}, answerIPs(clientQuery(s, "172.16.0.1", "bar.com")))
+}
+
+func TestNewSdns_malformedAccessLists(t *testing.T) {
+	var testCases = []struct {
+		name string
+		cfg  SdnsConfig
+	}{
+		{"allowed", SdnsConfig{AllowClients: []string{"10.0.0.1"}}},
+		{"denied", SdnsConfig{DenyClients: []string{"lol"}}},
+		{"recursion", SdnsConfig{RecursionClients: []string{"10.0.0.0/33"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.Port = 1232
+			tc.cfg.Recursors = []string{"127.0.0.1:1"}
+
+			_, err := NewSdns(tc.cfg)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// AddressMode determines how many of the addresses of a
+// domain answer an address question, and in which order.
+type AddressMode string
+
+const (
+	// AddressesRoundRobin answers with a single address,
+	// cycling through them from one question to the next.
+	AddressesRoundRobin AddressMode = "round-robin"
+
+	// AddressesAll answers with every address, in the
+	// order they're configured.
+	AddressesAll AddressMode = "all"
+
+	// AddressesShuffled answers with every address, in a
+	// random order (see Domain.Seed) so that clients spread
+	// their load.
+	AddressesShuffled AddressMode = "shuffled"
+)
+
+// validateAddressMode makes sure that the mode is known,
+// accepting the empty one (i.e.: the default).
+func validateAddressMode(mode AddressMode) (err error) {
+	switch mode {
+	case "", AddressesRoundRobin, AddressesAll, AddressesShuffled:
+	default:
+		err = errors.Errorf("unknown address mode %s", mode)
+	}
+
+	return
+}
+
+// addressMode tells the address mode of a domain, which
+// can override the global one.
+func (s *Sdns) addressMode(domain *Domain) AddressMode {
+	if domain.AddressMode != "" {
+		return domain.AddressMode
+	}
+
+	return s.addresses
+}
+
+// answerAddresses returns the addresses of the family that
+// qtype (A or AAAA) refers to that the domain answers with,
+// leaving out the ones found down by health checks.
+func (s *Sdns) answerAddresses(domain *Domain, qtype uint16) (addresses []string) {
+	if s.addressMode(domain) == AddressesRoundRobin {
+		if address := domain.address(qtype); address != "" {
+			addresses = []string{address}
+		}
+		return
+	}
+
+	var (
+		records = domain.loaded()
+		pool    = records.addressesV4
+	)
+
+	if qtype == dns.TypeAAAA {
+		pool = records.addressesV6
+	}
+
+	pool, _ = records.health.healthy(pool, nil)
+	addresses = append(addresses, pool...)
+
+	if s.addressMode(domain) == AddressesShuffled {
+		domain.shuffle(len(addresses), func(i, j int) {
+			addresses[i], addresses[j] = addresses[j], addresses[i]
+		})
+	}
+
+	return
+}
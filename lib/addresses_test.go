@@ -0,0 +1,150 @@
+package lib_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_addressMode(t *testing.T) {
+	var addresses = []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "::1"}
+
+	var testCases = []struct {
+		name      string
+		global    AddressMode
+		domain    AddressMode
+		expected  [][]string
+		unordered bool
+	}{
+		{
+			name:   "round-robin by default",
+			global: "",
+			expected: [][]string{
+				{"1.1.1.1"}, {"2.2.2.2"}, {"3.3.3.3"}, {"1.1.1.1"},
+			},
+		},
+		{
+			name:   "all",
+			global: AddressesAll,
+			expected: [][]string{
+				{"1.1.1.1", "2.2.2.2", "3.3.3.3"},
+				{"1.1.1.1", "2.2.2.2", "3.3.3.3"},
+			},
+		},
+		{
+			name:   "round-robin overridden by the domain",
+			global: AddressesRoundRobin,
+			domain: AddressesAll,
+			expected: [][]string{
+				{"1.1.1.1", "2.2.2.2", "3.3.3.3"},
+			},
+		},
+		{
+			name:   "all overridden by the domain",
+			global: AddressesAll,
+			domain: AddressesRoundRobin,
+			expected: [][]string{
+				{"1.1.1.1"}, {"2.2.2.2"},
+			},
+		},
+		{
+			name:      "shuffled",
+			global:    AddressesShuffled,
+			unordered: true,
+			expected: [][]string{
+				{"1.1.1.1", "2.2.2.2", "3.3.3.3"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSdns(SdnsConfig{
+				Port:        1232,
+				Recursors:   []string{"127.0.0.1:1"},
+				AddressMode: tc.global,
+				Domains: []*Domain{
+					{Name: "foo.com", Addresses: addresses, AddressMode: tc.domain},
+				},
+			})
+			assert.NoError(t, err)
+
+			for _, expected := range tc.expected {
+				m := query(s, "foo.com", dns.TypeA)
+				if tc.unordered {
+					assert.ElementsMatch(t, expected, answerIPs(m))
+					continue
+				}
+
+				assert.Equal(t, expected, answerIPs(m))
+			}
+		})
+	}
+}
+
+func TestHandle_shuffledAddresses(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:        1232,
+		Recursors:   []string{"127.0.0.1:1"},
+		AddressMode: AddressesShuffled,
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	orderings := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		m := query(s, "foo.com", dns.TypeA)
+		orderings[strings.Join(answerIPs(m), ",")] = true
+	}
+
+	assert.True(t, len(orderings) > 1, "always answered in the same order")
+}
+
+func TestHandle_seededShuffledAddresses(t *testing.T) {
+	orderings := func(seed int64) (seen []string) {
+		s, err := NewSdns(SdnsConfig{
+			Port:        1232,
+			Recursors:   []string{"127.0.0.1:1"},
+			AddressMode: AddressesShuffled,
+			Domains: []*Domain{
+				{
+					Name:      "foo.com",
+					Addresses: []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4"},
+					Seed:      seed,
+				},
+			},
+		})
+		assert.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			m := query(s, "foo.com", dns.TypeA)
+			seen = append(seen, strings.Join(answerIPs(m), ","))
+		}
+		return
+	}
+
+	assert.Equal(t, orderings(42), orderings(42))
+	assert.NotEqual(t, orderings(42), orderings(43))
+}
+
+func TestNewSdns_malformedAddressMode(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{
+		Port:        1232,
+		AddressMode: "lol",
+	})
+	assert.Error(t, err)
+
+	_, err = NewSdns(SdnsConfig{
+		Port: 1232,
+		Domains: []*Domain{
+			{Name: "foo.com", AddressMode: "lol"},
+		},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,183 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// AdminDomainsPath is the path of the admin API that domains
+// are listed and added on, each domain being removed on its
+// own path under it (e.g.: /domains/foo.com).
+const AdminDomainsPath = "/domains"
+
+var (
+	ErrDomainExists = errors.Errorf("Domain already exists")
+)
+
+// Domains returns the domains currently loaded.
+func (s *Sdns) Domains() (domains []*Domain) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	domains = append([]*Domain{}, s.loaded.Domains...)
+	sort.Slice(domains, func(i, j int) bool {
+		return strings.ToLower(domains[i].Name) < strings.ToLower(domains[j].Name)
+	})
+
+	return
+}
+
+// update loads the configuration that results from changing
+// the one currently loaded, making sure that no other Load
+// happens in the meantime.
+func (s *Sdns) update(change func(cfg SdnsConfig) (SdnsConfig, error)) (err error) {
+	s.loadLock.Lock()
+	defer s.loadLock.Unlock()
+
+	s.lock.RLock()
+	cfg := s.loaded
+	s.lock.RUnlock()
+
+	cfg, err = change(cfg)
+	if err != nil {
+		return
+	}
+
+	err = s.load(cfg)
+	return
+}
+
+// indexOfDomain looks for the domain with the name among
+// the domains.
+func indexOfDomain(domains []*Domain, name string) int {
+	name = strings.TrimSuffix(name, ".")
+	for idx, domain := range domains {
+		if strings.EqualFold(domain.Name, name) {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// AddDomain loads a new domain, validated like the ones
+// given to Load. It lasts until the next Load (e.g.: on
+// reloads).
+func (s *Sdns) AddDomain(domain *Domain) (err error) {
+	domain.Name = strings.TrimSuffix(domain.Name, ".")
+
+	err = s.update(func(cfg SdnsConfig) (SdnsConfig, error) {
+		if indexOfDomain(cfg.Domains, domain.Name) >= 0 {
+			return cfg, ErrDomainExists
+		}
+
+		cfg.Domains = append(append([]*Domain{}, cfg.Domains...), domain)
+		return cfg, nil
+	})
+	return
+}
+
+// RemoveDomain unloads the domain with the name. It lasts
+// until the next Load (e.g.: on reloads).
+func (s *Sdns) RemoveDomain(name string) (err error) {
+	err = s.update(func(cfg SdnsConfig) (SdnsConfig, error) {
+		idx := indexOfDomain(cfg.Domains, name)
+		if idx < 0 {
+			return cfg, ErrDomainNotFound
+		}
+
+		domains := append([]*Domain{}, cfg.Domains[:idx]...)
+		cfg.Domains = append(domains, cfg.Domains[idx+1:]...)
+		return cfg, nil
+	})
+	return
+}
+
+// writeJSON writes the value as the JSON body of the
+// response.
+func writeJSON(rw http.ResponseWriter, status int, value interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(value)
+}
+
+// AdminHandler serves the admin API, through which domains
+// are managed at runtime:
+//   - GET /domains lists the domains;
+//   - POST /domains adds the domain in the body; and
+//   - DELETE /domains/{name} removes a domain.
+//
+// Domains are represented in JSON.
+func (s *Sdns) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(AdminDomainsPath, func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(rw, http.StatusOK, s.Domains())
+		case http.MethodPost:
+			var (
+				domain  = new(Domain)
+				decoder = json.NewDecoder(r.Body)
+			)
+
+			decoder.DisallowUnknownFields()
+			err := decoder.Decode(domain)
+			if err != nil {
+				http.Error(rw, "malformed domain - "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			err = s.AddDomain(domain)
+			switch {
+			case err == ErrDomainExists:
+				http.Error(rw, err.Error(), http.StatusConflict)
+				return
+			case err != nil:
+				http.Error(rw, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+
+			s.logger.Info().
+				Str("domain", domain.Name).
+				Msg("domain added")
+			writeJSON(rw, http.StatusCreated, domain)
+		default:
+			rw.Header().Set("Allow", "GET, POST")
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc(AdminDomainsPath+"/", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			rw.Header().Set("Allow", "DELETE")
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, AdminDomainsPath+"/")
+
+		err := s.RemoveDomain(name)
+		switch {
+		case err == ErrDomainNotFound:
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(rw, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		s.logger.Info().
+			Str("domain", name).
+			Msg("domain removed")
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
+}
@@ -0,0 +1,191 @@
+package lib_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// adminRequest sends a request to the admin API, decoding
+// the domains in the response (if any).
+func adminRequest(t *testing.T, method, url, body string) (status int, domains []*Domain) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	status = resp.StatusCode
+	if resp.Header.Get("Content-Type") != "application/json" {
+		return
+	}
+
+	if method == http.MethodGet {
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&domains))
+		return
+	}
+
+	domain := new(Domain)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(domain))
+	domains = []*Domain{domain}
+	return
+}
+
+// domainNames lists the names of the domains.
+func domainNames(domains []*Domain) (names []string) {
+	names = []string{}
+	for _, domain := range domains {
+		names = append(names, domain.Name)
+	}
+
+	return
+}
+
+func TestAdminHandler_domains(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	var (
+		server = httptest.NewServer(s.AdminHandler())
+		url    = server.URL + AdminDomainsPath
+	)
+	defer server.Close()
+
+	status, domains := adminRequest(t, http.MethodGet, url, "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"foo.com"}, domainNames(domains))
+	assert.Equal(t, []string{"1.1.1.1"}, domains[0].Addresses)
+
+	// create
+	status, domains = adminRequest(t, http.MethodPost, url,
+		`{"Name": "bar.com.", "Addresses": ["2.2.2.2"], "TTL": 30}`)
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, []string{"bar.com"}, domainNames(domains))
+
+	m := query(s, "bar.com", dns.TypeA)
+	assert.Equal(t, []string{"bar.com.\t30\tIN\tA\t2.2.2.2"}, answerStrings(m))
+
+	status, domains = adminRequest(t, http.MethodGet, url, "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"bar.com", "foo.com"}, domainNames(domains))
+
+	// rejected ones leave the domains untouched
+	var rejected = []struct {
+		body   string
+		status int
+	}{
+		{`{"Name": "BAR.com", "Addresses": ["3.3.3.3"]}`, http.StatusConflict},
+		{`{"Name": "baz.com", "MailExchangers": ["lol"]}`, http.StatusUnprocessableEntity},
+		{`{"Name": "*baz.com", "Addresses": ["3.3.3.3"]}`, http.StatusUnprocessableEntity},
+		{`{"Addresses": ["3.3.3.3"]}`, http.StatusUnprocessableEntity},
+		{`{"Name": "baz.com", "Lol": true}`, http.StatusBadRequest},
+		{`lol`, http.StatusBadRequest},
+	}
+
+	for _, tc := range rejected {
+		status, _ = adminRequest(t, http.MethodPost, url, tc.body)
+		assert.Equal(t, tc.status, status, tc.body)
+	}
+
+	status, domains = adminRequest(t, http.MethodGet, url, "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"bar.com", "foo.com"}, domainNames(domains))
+	assert.Equal(t, []string{"2.2.2.2"}, answerIPs(query(s, "bar.com", dns.TypeA)))
+
+	// delete
+	status, _ = adminRequest(t, http.MethodDelete, url+"/foo.com", "")
+	assert.Equal(t, http.StatusNoContent, status)
+
+	status, _ = adminRequest(t, http.MethodDelete, url+"/foo.com", "")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	m = query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, dns.RcodeServerFailure, m.Rcode)
+
+	status, domains = adminRequest(t, http.MethodGet, url, "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"bar.com"}, domainNames(domains))
+
+	status, _ = adminRequest(t, http.MethodPut, url, "")
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+
+	status, _ = adminRequest(t, http.MethodGet, url+"/bar.com", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+}
+
+func TestListen_admin(t *testing.T) {
+	var (
+		adminAddress = "127.0.0.1:" + strconv.Itoa(freePort(t))
+		url          = "http://" + adminAddress + AdminDomainsPath
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Address:      "127.0.0.1",
+		Recursors:    []string{"127.0.0.1:1"},
+		AdminAddress: adminAddress,
+	})
+	assert.NoError(t, err)
+
+	go s.Listen()
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		resp, err = http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	status, _ := adminRequest(t, http.MethodPost, url, `{"Name": "foo.com", "Addresses": ["1.1.1.1"]}`)
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(query(s, "foo.com", dns.TypeA)))
+}
+
+func TestAddDomain_rejectedKeepsRecords(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Zones: []*Zone{
+			{
+				Name:        "foo.com",
+				Ns:          "ns1.foo.com",
+				Mbox:        "admin@foo.com",
+				Nameservers: []string{"ns1.foo.com."},
+			},
+		},
+		Domains: []*Domain{
+			{Name: "www.foo.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	err = s.AddDomain(&Domain{Name: "bar.com", Addresses: []string{"not an address!"}})
+	assert.Error(t, err)
+
+	// the nameservers inherited from the zone are still
+	// answered.
+	m := query(s, "www.foo.com", dns.TypeNS)
+	assert.Equal(t, []string{"www.foo.com.\t3600\tIN\tNS\tns1.foo.com."}, answerStrings(m))
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(query(s, "www.foo.com", dns.TypeA)))
+}
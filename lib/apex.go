@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// apexRecords tells how to copy the records of a given type
+// from a wildcard into the domain synthesized for its apex.
+var apexRecords = map[uint16]func(apex, wildcard *Domain){
+	dns.TypeA: func(apex, wildcard *Domain) {
+		for _, address := range wildcard.Addresses {
+			if ip := net.ParseIP(addressOf(address)); ip == nil || ip.To4() != nil {
+				apex.Addresses = append(apex.Addresses, address)
+			}
+		}
+	},
+	dns.TypeAAAA: func(apex, wildcard *Domain) {
+		for _, address := range wildcard.Addresses {
+			if ip := net.ParseIP(addressOf(address)); ip != nil && ip.To4() == nil {
+				apex.Addresses = append(apex.Addresses, address)
+			}
+		}
+	},
+	dns.TypeNS: func(apex, wildcard *Domain) {
+		apex.Nameservers = wildcard.Nameservers
+	},
+	dns.TypeMX: func(apex, wildcard *Domain) {
+		apex.MailExchangers = wildcard.MailExchangers
+	},
+	dns.TypeTXT: func(apex, wildcard *Domain) {
+		apex.Texts = wildcard.Texts
+	},
+	dns.TypeCAA: func(apex, wildcard *Domain) {
+		apex.CAA = wildcard.CAA
+	},
+}
+
+// loadApex synthesizes the apex domains of the wildcards
+// that ask for it (see Domain.ApexTypes), unless the apex
+// is explicitly configured. Their records are added to the
+// ones pending (see storeRecords).
+func (s *Sdns) loadApex(domains []*Domain, pending map[*Domain]*domainRecords) (err error) {
+	s.apexDomains = make(map[string]*Domain)
+
+	for _, wildcard := range domains {
+		if len(wildcard.ApexTypes) == 0 {
+			continue
+		}
+
+		if wildcard.Name[0] != '*' {
+			err = errors.Errorf(
+				"domain %s: apex types can only be set on wildcards",
+				wildcard.Name)
+			return
+		}
+
+		apex := &Domain{
+			Name:                wildcard.Name[2:],
+			ResponseDelay:       wildcard.ResponseDelay,
+			ResponseDelayJitter: wildcard.ResponseDelayJitter,
+			ForceTruncate:       wildcard.ForceTruncate,
+			Seed:                wildcard.Seed,
+			AddressMode:         wildcard.AddressMode,
+			NameserverOrder:     wildcard.NameserverOrder,
+			Delegation:          wildcard.Delegation,
+			Transport:           wildcard.Transport,
+			MaxQPS:              wildcard.MaxQPS,
+			HealthCheck:         wildcard.HealthCheck,
+			HealthCheckPort:     wildcard.HealthCheckPort,
+			HealthCheckPath:     wildcard.HealthCheckPath,
+			HealthCheckInterval: wildcard.HealthCheckInterval,
+			TTL:                 wildcard.TTL,
+			Cutover:             wildcard.Cutover,
+			CutoverRamp:         wildcard.CutoverRamp,
+			CutoverTTL:          wildcard.CutoverTTL,
+			apexTypes:           make(map[uint16]bool),
+		}
+
+		for _, typeName := range wildcard.ApexTypes {
+			var (
+				qtype       = dns.StringToType[strings.ToUpper(typeName)]
+				copyRecords = apexRecords[qtype]
+			)
+
+			if copyRecords == nil {
+				err = errors.Errorf(
+					"domain %s: can't synthesize %s records for the apex",
+					wildcard.Name, typeName)
+				return
+			}
+
+			copyRecords(apex, wildcard)
+			apex.apexTypes[qtype] = true
+		}
+
+		key := strings.ToLower(apex.Name)
+		if _, found := s.exactDomains[key]; found {
+			continue
+		}
+
+		pending[apex], err = apex.buildRecords()
+		if err != nil {
+			return
+		}
+
+		s.apexDomains[key] = apex
+	}
+
+	return
+}
+
+// apexOmits tells whether the name resolves to an apex
+// synthesized from a wildcard that doesn't synthesize
+// records of the type qtype.
+func (s *Sdns) apexOmits(name string, qtype uint16) bool {
+	if qtype == dns.TypeANY {
+		return false
+	}
+
+	domain, found := s.findDomain(strings.TrimRight(name, "."))
+	if !found || domain.apexTypes == nil {
+		return false
+	}
+
+	return !domain.apexTypes[qtype]
+}
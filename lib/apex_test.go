@@ -0,0 +1,121 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_apexFromWildcard(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		qname   string
+		qtype   uint16
+		answers []string
+	}{
+		{
+			name:    "synthesized a",
+			qname:   "foo.com",
+			qtype:   dns.TypeA,
+			answers: []string{"foo.com.\t3600\tIN\tA\t1.1.1.1"},
+		},
+		{
+			name:    "synthesized ns",
+			qname:   "foo.com",
+			qtype:   dns.TypeNS,
+			answers: []string{"foo.com.\t3600\tIN\tNS\tns1.foo.com."},
+		},
+		{
+			name:    "type not synthesized recurses",
+			qname:   "foo.com",
+			qtype:   dns.TypeAAAA,
+			answers: []string{"foo.com.\t3600\tIN\tA\t7.7.7.7"},
+		},
+		{
+			name:    "wildcard still matches",
+			qname:   "www.foo.com",
+			qtype:   dns.TypeAAAA,
+			answers: []string{"www.foo.com.\t3600\tIN\tAAAA\t::1"},
+		},
+		{
+			name:    "configured apex takes precedence",
+			qname:   "bar.com",
+			qtype:   dns.TypeA,
+			answers: []string{"bar.com.\t3600\tIN\tA\t2.2.2.2"},
+		},
+		{
+			name:    "wildcard without apex types",
+			qname:   "baz.com",
+			qtype:   dns.TypeA,
+			answers: []string{"baz.com.\t3600\tIN\tA\t7.7.7.7"},
+		},
+	}
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{startRecursor(t, answerHandler(0, "7.7.7.7"))},
+		Domains: []*Domain{
+			{
+				Name:        "*.foo.com",
+				Addresses:   []string{"1.1.1.1", "::1"},
+				Nameservers: []string{"ns1.foo.com."},
+				ApexTypes:   []string{"A", "ns"},
+			},
+			{
+				Name:      "*.bar.com",
+				Addresses: []string{"1.1.1.1"},
+				ApexTypes: []string{"A"},
+			},
+			{
+				Name:      "bar.com",
+				Addresses: []string{"2.2.2.2"},
+			},
+			{
+				Name:      "*.baz.com",
+				Addresses: []string{"1.1.1.1"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := query(s, tc.qname, tc.qtype)
+			assert.Equal(t, tc.answers, answerStrings(m))
+		})
+	}
+}
+
+func TestLoad_malformedApexTypes(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		domain *Domain
+	}{
+		{
+			name:   "not a wildcard",
+			domain: &Domain{Name: "foo.com", ApexTypes: []string{"A"}},
+		},
+		{
+			name:   "unsupported type",
+			domain: &Domain{Name: "*.foo.com", ApexTypes: []string{"CNAME"}},
+		},
+		{
+			name:   "unknown type",
+			domain: &Domain{Name: "*.foo.com", ApexTypes: []string{"LOL"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port:      1053,
+				Recursors: []string{"127.0.0.1:1"},
+				Domains:   []*Domain{tc.domain},
+			})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// breakerState tracks the recent recursion failures of a
+// name.
+type breakerState struct {
+	failures  int
+	since     time.Time
+	openUntil time.Time
+}
+
+// breaker is a negative circuit breaker keyed by name: after
+// `threshold` consecutive failures within `window`, recursion
+// for the name is suspended for `cooldown`, after which a
+// single probe is let through.
+type breaker struct {
+	sync.Mutex
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	now       func() time.Time
+	names     map[string]*breakerState
+}
+
+func newBreaker(threshold int, window, cooldown time.Duration, now func() time.Time) *breaker {
+	return &breaker{
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+		now:       now,
+		names:     make(map[string]*breakerState),
+	}
+}
+
+// allow tells whether recursion for the name may proceed.
+func (b *breaker) allow(name string) bool {
+	if b.threshold <= 0 {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	state, found := b.names[name]
+	if !found {
+		return true
+	}
+
+	now := b.now()
+	if state.failures < b.threshold {
+		if now.Sub(state.since) > b.window {
+			delete(b.names, name)
+		}
+		return true
+	}
+
+	if now.Before(state.openUntil) {
+		return false
+	}
+
+	// half-open: let this one probe and hold the others
+	// until it's done.
+	state.openUntil = now.Add(b.cooldown)
+	return true
+}
+
+// success closes the breaker for the name.
+func (b *breaker) success(name string) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.names, name)
+}
+
+// failure records a failed recursion for the name, opening
+// the breaker once the threshold is reached.
+func (b *breaker) failure(name string) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	now := b.now()
+
+	state, found := b.names[name]
+	if !found || (state.failures < b.threshold && now.Sub(state.since) > b.window) {
+		state = &breakerState{since: now}
+		b.names[name] = state
+	}
+
+	state.failures++
+	if state.failures >= b.threshold {
+		state.openUntil = now.Add(b.cooldown)
+	}
+}
+
+// reset forgets about every failure.
+func (b *breaker) reset() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.names = make(map[string]*breakerState)
+}
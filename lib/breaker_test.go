@@ -0,0 +1,107 @@
+package lib_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// flakyHandler answers with SERVFAIL while healthy is unset
+// and with an A record otherwise, counting the questions it
+// receives.
+func flakyHandler(healthy *int32, received *int32) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddInt32(received, 1)
+
+		m := new(dns.Msg)
+		if atomic.LoadInt32(healthy) == 0 {
+			m.SetRcode(r, dns.RcodeServerFailure)
+			w.WriteMsg(m)
+			return
+		}
+
+		m.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " A 1.1.1.1")
+		m.Answer = append(m.Answer, rr)
+
+		w.WriteMsg(m)
+	}
+}
+
+func TestHandle_breaker(t *testing.T) {
+	var (
+		healthy, received int32
+		now               = time.Unix(1000, 0)
+		recursor          = startRecursor(t, flakyHandler(&healthy, &received))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:             1053,
+		Recursors:        []string{recursor},
+		BreakerThreshold: 3,
+		BreakerWindow:    time.Minute,
+		BreakerCooldown:  30 * time.Second,
+		Clock:            func() time.Time { return now },
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		query(s, "broken.com", dns.TypeA)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&received))
+
+	// tripped: answered without recursing.
+	m := query(s, "broken.com", dns.TypeA)
+	assert.Equal(t, dns.RcodeServerFailure, m.Rcode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&received))
+
+	// other names are unaffected.
+	query(s, "other.com", dns.TypeA)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&received))
+
+	// after the cooldown a probe goes through and, being
+	// successful, closes the breaker.
+	now = now.Add(31 * time.Second)
+	atomic.StoreInt32(&healthy, 1)
+
+	m = query(s, "broken.com", dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+	assert.Equal(t, int32(5), atomic.LoadInt32(&received))
+
+	query(s, "broken.com", dns.TypeA)
+	assert.Equal(t, int32(6), atomic.LoadInt32(&received))
+}
+
+func TestHandle_breakerWindow(t *testing.T) {
+	var (
+		healthy, received int32
+		now               = time.Unix(1000, 0)
+		recursor          = startRecursor(t, flakyHandler(&healthy, &received))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:             1053,
+		Recursors:        []string{recursor},
+		BreakerThreshold: 2,
+		BreakerWindow:    time.Minute,
+		BreakerCooldown:  30 * time.Second,
+		Clock:            func() time.Time { return now },
+	})
+	assert.NoError(t, err)
+
+	// failures spread beyond the window don't trip it.
+	query(s, "broken.com", dns.TypeA)
+	now = now.Add(2 * time.Minute)
+	query(s, "broken.com", dns.TypeA)
+	query(s, "broken.com", dns.TypeA)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&received))
+
+	query(s, "broken.com", dns.TypeA)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&received))
+}
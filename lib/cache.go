@@ -0,0 +1,401 @@
+package lib
+
+import (
+	"container/list"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// DefaultCacheSize is the number of responses the cache
+// holds when enabled without a size.
+const DefaultCacheSize = 10000
+
+// cacheKey identifies a cached response. The namespace
+// keeps apart the responses of forwarded domains (see
+// Domain.CacheNamespace) from the ones of the global
+// recursors while the subnet keeps apart the ones tailored
+// to the subnet of clients (see SdnsConfig.ClientSubnets). The
+// generation keeps apart the ones of each configuration
+// (see cacheGeneration).
+type cacheKey struct {
+	generation string
+	namespace  string
+	subnet     string
+	name       string
+	qtype      uint16
+	qclass     uint16
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	msg     *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+// responseCache keeps the responses of the recursors for as
+// long as their TTLs allow.
+type responseCache interface {
+	// get retrieves a copy of a cached response with its
+	// TTLs decremented by the time it's been cached for.
+	get(key cacheKey, now time.Time) (msg *dns.Msg, found bool)
+
+	// set caches a copy of a response.
+	set(key cacheKey, msg *dns.Msg, now time.Time)
+
+	// sweep drops the expired responses.
+	sweep(now time.Time)
+
+	// reset drops all the responses.
+	reset()
+}
+
+// memoryCache is a responseCache holding up to size
+// responses in memory: once full, the least recently used
+// one is evicted.
+type memoryCache struct {
+	sync.Mutex
+	size    int
+	entries map[cacheKey]*list.Element
+	order   *list.List // most recently used first
+}
+
+func newMemoryCache(size int) *memoryCache {
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+
+	return &memoryCache{
+		size:    size,
+		entries: make(map[cacheKey]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get drops the expired responses it comes across.
+func (c *memoryCache) get(key cacheKey, now time.Time) (msg *dns.Msg, found bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	element, found := c.entries[key]
+	if !found {
+		return
+	}
+
+	// responses stored "in the future" (i.e.: the clock
+	// moved backward) can't tell how long they've been
+	// cached for and, thus, their TTLs.
+	entry := element.Value.(*cacheEntry)
+	if !now.Before(entry.expires) || now.Before(entry.stored) {
+		c.remove(element)
+		found = false
+		return
+	}
+
+	c.order.MoveToFront(element)
+
+	msg = entry.msg.Copy()
+	age(msg, now.Sub(entry.stored))
+	return
+}
+
+// age decrements the TTLs of a response by the time it's
+// been cached for.
+func age(msg *dns.Msg, cached time.Duration) {
+	elapsed := uint32(cached / time.Second)
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+
+			if rr.Header().Ttl > elapsed {
+				rr.Header().Ttl -= elapsed
+			} else {
+				rr.Header().Ttl = 0
+			}
+		}
+	}
+}
+
+func (c *memoryCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+// positiveTTL is the smallest TTL among the answers of a
+// successful response.
+func positiveTTL(msg *dns.Msg) (ttl uint32, cacheable bool) {
+	if msg.Rcode != dns.RcodeSuccess || len(msg.Answer) == 0 {
+		return
+	}
+
+	ttl, cacheable = math.MaxUint32, true
+	for _, rr := range msg.Answer {
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+
+	return
+}
+
+// negativeTTL is how long a negative response (NXDOMAIN or
+// NODATA) can be cached for: the smallest between the TTL
+// of the SOA in its authority section and the SOA's minimum
+// (RFC 2308). Without an SOA it can't be cached.
+func negativeTTL(msg *dns.Msg) (ttl uint32, cacheable bool) {
+	switch {
+	case msg.Rcode == dns.RcodeNameError:
+	case msg.Rcode == dns.RcodeSuccess && len(msg.Answer) == 0:
+	default:
+		return
+	}
+
+	for _, rr := range msg.Ns {
+		soa, ok := rr.(*dns.SOA)
+		if !ok {
+			continue
+		}
+
+		ttl, cacheable = soa.Hdr.Ttl, true
+		if soa.Minttl < ttl {
+			ttl = soa.Minttl
+		}
+		return
+	}
+
+	return
+}
+
+// cacheTTL is how long a response can be cached for,
+// either positive (with answers) or negative. Other
+// responses (e.g.: SERVFAIL) can't be cached.
+func cacheTTL(msg *dns.Msg) (ttl uint32, cacheable bool) {
+	ttl, cacheable = positiveTTL(msg)
+	if !cacheable {
+		ttl, cacheable = negativeTTL(msg)
+	}
+
+	if ttl == 0 {
+		cacheable = false
+	}
+
+	return
+}
+
+// set only caches the responses that cacheTTL allows.
+func (c *memoryCache) set(key cacheKey, msg *dns.Msg, now time.Time) {
+	ttl, cacheable := cacheTTL(msg)
+	if !cacheable {
+		return
+	}
+
+	entry := &cacheEntry{
+		key:     key,
+		msg:     msg.Copy(),
+		stored:  now,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if element, found := c.entries[key]; found {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// sweep drops the ones stored after now as well (see get).
+func (c *memoryCache) sweep(now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		entry := element.Value.(*cacheEntry)
+		if !now.Before(entry.expires) || now.Before(entry.stored) {
+			c.remove(element)
+		}
+		element = next
+	}
+}
+
+func (c *memoryCache) reset() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.entries = make(map[cacheKey]*list.Element)
+	c.order.Init()
+}
+
+// cacheGeneration identifies the configuration that cached
+// responses depend on (the recursors and the domains, which
+// may be forwarded), so that the ones shared with other
+// instances (see SdnsConfig.CacheRedis) stop being read
+// back once it changes. Should the configuration fail to
+// encode, a random generation keeps them from being shared.
+func cacheGeneration(recursors []string, domains []*Domain) string {
+	var (
+		hash    = sha256.New()
+		encoder = json.NewEncoder(hash)
+	)
+
+	if encoder.Encode(recursors) != nil || encoder.Encode(domains) != nil {
+		random := make([]byte, 8)
+		rand.Read(random)
+		return hex.EncodeToString(random)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)[:8])
+}
+
+// validateCachePolicy makes sure that the cache settings of
+// a domain (see Domain.CacheNamespace) are only set when
+// it's forwarded and that its TTL bounds are in order.
+func validateCachePolicy(d *Domain) (err error) {
+	set := d.CacheNamespace != "" || d.CacheMinTTL > 0 ||
+		d.CacheMaxTTL > 0 || d.NoCache
+	if set && !d.forwarded() {
+		err = errors.Errorf(
+			"cache settings only apply to domains with recursors")
+		return
+	}
+
+	if d.CacheMaxTTL > 0 && d.CacheMinTTL > d.CacheMaxTTL {
+		err = errors.Errorf(
+			"cache min ttl %d exceeds cache max ttl %d",
+			d.CacheMinTTL, d.CacheMaxTTL)
+		return
+	}
+
+	return
+}
+
+// boundTTLs clamps the TTLs of a response to the ones that
+// a forwarded domain allows (see Domain.CacheMinTTL).
+func boundTTLs(msg *dns.Msg, domain *Domain) {
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+
+			if rr.Header().Ttl < domain.CacheMinTTL {
+				rr.Header().Ttl = domain.CacheMinTTL
+			}
+
+			if domain.CacheMaxTTL > 0 && rr.Header().Ttl > domain.CacheMaxTTL {
+				rr.Header().Ttl = domain.CacheMaxTTL
+			}
+		}
+	}
+}
+
+// cacheKey builds the key that the response to a question
+// is cached under, in the namespace of the forwarded domain
+// it belongs to, if any.
+func (s *Sdns) cacheKey(question dns.Question, forwarder *Domain) (key cacheKey) {
+	key = cacheKey{
+		name:   canonicalName(question.Name),
+		qtype:  question.Qtype,
+		qclass: question.Qclass,
+	}
+
+	s.lock.RLock()
+	key.generation = s.cacheGeneration
+	s.lock.RUnlock()
+
+	if forwarder == nil {
+		return
+	}
+
+	key.namespace = strings.ToLower(forwarder.CacheNamespace)
+	if key.namespace == "" {
+		key.namespace = strings.ToLower(forwarder.Name)
+	}
+
+	return
+}
+
+// recurseCached answers the question from the cache or,
+// failing that, via the recursors, caching their response.
+// Queries that turned FeatureNoCache on skip the cache, as
+// do the ones of forwarded domains set with NoCache.
+func (s *Sdns) recurseCached(ctx *SdnsContext, m *dns.Msg) (in *dns.Msg, err error) {
+	forwarder, _ := s.forwarder(m.Question[0].Name)
+
+	if s.cache == nil || ctx.enabled(FeatureNoCache) ||
+		(forwarder != nil && forwarder.NoCache) {
+		in, err = s.recurseForwarded(ctx, m, forwarder)
+		return
+	}
+
+	var key = s.cacheKey(m.Question[0], forwarder)
+
+	key.subnet = s.cacheSubnet(ctx)
+
+	in, found := s.cache.get(key, s.now())
+	if found {
+		ctx.decision = DecisionCached
+		s.metrics.cacheHits.Inc()
+		ctx.logger.Debug().
+			Str("name", key.name).
+			Msg("answered from cache")
+		return
+	}
+
+	s.metrics.cacheMisses.Inc()
+
+	in, err = s.recurseForwarded(ctx, m, forwarder)
+	if err != nil {
+		return
+	}
+
+	s.cache.set(key, in, s.now())
+	return
+}
+
+// recurseForwarded recurses the question, bounding the TTLs
+// of the response as the forwarded domain (if any) asks.
+func (s *Sdns) recurseForwarded(ctx *SdnsContext, m *dns.Msg, forwarder *Domain) (in *dns.Msg, err error) {
+	in, err = s.recurseGuarded(ctx, m)
+	if err != nil || forwarder == nil {
+		return
+	}
+
+	boundTTLs(in, forwarder)
+	return
+}
+
+// sweepCache periodically drops the expired responses from
+// the cache until done is closed.
+func (s *Sdns) sweepCache(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.cache.sweep(s.now())
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package lib_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_cache(t *testing.T) {
+	var (
+		received int32
+		now      = time.Unix(1000, 0)
+		recursor = startRecursor(t,
+			countingHandler(&received, answerHandler(0, "7.7.7.7")))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{recursor},
+		Cache:     true,
+		Clock:     func() time.Time { return now },
+	})
+	assert.NoError(t, err)
+
+	m := query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"foo.com.\t3600\tIN\tA\t7.7.7.7"}, answerStrings(m))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+	// hit, with the TTL decremented by the time cached.
+	now = now.Add(100 * time.Second)
+
+	m = query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"foo.com.\t3500\tIN\tA\t7.7.7.7"}, answerStrings(m))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+	// names differing only in casing share the entry.
+	m = query(s, "FOO.com", dns.TypeA)
+	assert.Equal(t, []string{"7.7.7.7"}, answerIPs(m))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+	// miss, for another type and another name.
+	query(s, "foo.com", dns.TypeAAAA)
+	query(s, "bar.com", dns.TypeA)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&received))
+
+	// expired.
+	now = now.Add(time.Hour)
+
+	m = query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"foo.com.\t3600\tIN\tA\t7.7.7.7"}, answerStrings(m))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&received))
+}
+
+func TestHandle_cacheClockBackward(t *testing.T) {
+	var (
+		received int32
+		now      = time.Unix(10000, 0)
+		recursor = startRecursor(t,
+			countingHandler(&received, answerHandler(0, "7.7.7.7")))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{recursor},
+		Cache:     true,
+		Clock:     func() time.Time { return now },
+	})
+	assert.NoError(t, err)
+
+	query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+	// the clock jumping backward makes the entry unusable
+	// instead of served with bogus TTLs.
+	now = now.Add(-time.Hour)
+
+	m := query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"foo.com.\t3600\tIN\tA\t7.7.7.7"}, answerStrings(m))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+
+	// the response cached anew ages from then on.
+	now = now.Add(100 * time.Second)
+
+	m = query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"foo.com.\t3500\tIN\tA\t7.7.7.7"}, answerStrings(m))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+}
+
+func TestHandle_cacheDisabled(t *testing.T) {
+	var (
+		received int32
+		recursor = startRecursor(t,
+			countingHandler(&received, answerHandler(0, "7.7.7.7")))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{recursor},
+	})
+	assert.NoError(t, err)
+
+	query(s, "foo.com", dns.TypeA)
+	query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+}
+
+func TestHandle_cacheEviction(t *testing.T) {
+	var (
+		received int32
+		recursor = startRecursor(t,
+			countingHandler(&received, answerHandler(0, "7.7.7.7")))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{recursor},
+		Cache:     true,
+		CacheSize: 2,
+	})
+	assert.NoError(t, err)
+
+	query(s, "a.com", dns.TypeA)
+	query(s, "b.com", dns.TypeA)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+
+	// touching a.com leaves b.com as the least recently
+	// used, evicted once c.com gets cached.
+	query(s, "a.com", dns.TypeA)
+	query(s, "c.com", dns.TypeA)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&received))
+
+	query(s, "a.com", dns.TypeA)
+	query(s, "c.com", dns.TypeA)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&received))
+
+	query(s, "b.com", dns.TypeA)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&received))
+}
+
+func TestHandle_cacheForwarded(t *testing.T) {
+	var public, internal int32
+
+	cfg := SdnsConfig{
+		Port: 1053,
+		Recursors: []string{startRecursor(t,
+			countingHandler(&public, answerHandler(0, "7.7.7.7")))},
+		Cache: true,
+	}
+
+	s, err := NewSdns(cfg)
+	assert.NoError(t, err)
+
+	m := query(s, "wiki.corp.internal", dns.TypeA)
+	assert.Equal(t, []string{"7.7.7.7"}, answerIPs(m))
+	assert.Equal(t, uint32(3600), m.Answer[0].Header().Ttl)
+
+	// once forwarded, the name is cached under the namespace
+	// of the domain, with its TTLs bounded.
+	cfg.Domains = []*Domain{
+		{
+			Name: "*.corp.internal",
+			Recursors: []string{startRecursor(t,
+				countingHandler(&internal, answerHandler(0, "10.0.0.1")))},
+			CacheNamespace: "internal",
+			CacheMaxTTL:    60,
+		},
+	}
+	assert.NoError(t, s.Load(cfg))
+
+	for i := 0; i < 2; i++ {
+		m = query(s, "wiki.corp.internal", dns.TypeA)
+		assert.Equal(t, []string{"10.0.0.1"}, answerIPs(m))
+		assert.Equal(t, uint32(60), m.Answer[0].Header().Ttl)
+
+		m = query(s, "wiki.corp.com", dns.TypeA)
+		assert.Equal(t, []string{"7.7.7.7"}, answerIPs(m))
+		assert.Equal(t, uint32(3600), m.Answer[0].Header().Ttl)
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&internal))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&public))
+
+	// the ones of domains set with NoCache aren't cached.
+	cfg.Domains[0].NoCache = true
+	assert.NoError(t, s.Load(cfg))
+
+	for i := 0; i < 2; i++ {
+		m = query(s, "wiki.corp.internal", dns.TypeA)
+		assert.Equal(t, []string{"10.0.0.1"}, answerIPs(m))
+	}
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&internal))
+}
+
+func TestNewSdns_cachePolicy(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		domain *Domain
+	}{
+		{
+			name:   "not forwarded",
+			domain: &Domain{Name: "a.com", CacheNamespace: "internal"},
+		},
+		{
+			name: "min ttl past max ttl",
+			domain: &Domain{
+				Name:        "*.corp.internal",
+				Recursors:   []string{"10.0.0.1:53"},
+				CacheMinTTL: 60,
+				CacheMaxTTL: 30,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port:      1053,
+				Recursors: []string{"127.0.0.1:53"},
+				Domains:   []*Domain{tc.domain},
+			})
+			assert.Error(t, err)
+		})
+	}
+}
+
+// nodataHandler answers every question with no records and
+// an SOA (minimum of 30s) in the authority section.
+func nodataHandler() dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		rr, _ := dns.NewRR(
+			"upstream. 60 SOA ns.upstream. admin.upstream. 7 1 1 1 30")
+		m.Ns = append(m.Ns, rr)
+
+		w.WriteMsg(m)
+	}
+}
+
+func TestHandle_negativeCache(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		handler dns.HandlerFunc
+		rcode   int
+		ttl     time.Duration
+		cached  bool
+	}{
+		{
+			name:    "nxdomain",
+			handler: nxdomainHandler(true),
+			rcode:   dns.RcodeNameError,
+			ttl:     time.Second,
+			cached:  true,
+		},
+		{
+			name:    "nodata",
+			handler: nodataHandler(),
+			rcode:   dns.RcodeSuccess,
+			ttl:     30 * time.Second,
+			cached:  true,
+		},
+		{
+			name:    "nxdomain without soa",
+			handler: nxdomainHandler(false),
+			rcode:   dns.RcodeNameError,
+		},
+		{
+			name:    "servfail",
+			handler: rcodeHandler(dns.RcodeServerFailure),
+			rcode:   dns.RcodeServerFailure,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				received int32
+				now      = time.Unix(1000, 0)
+				recursor = startRecursor(t, countingHandler(&received, tc.handler))
+			)
+
+			s, err := NewSdns(SdnsConfig{
+				Port:      1053,
+				Recursors: []string{recursor},
+				Cache:     true,
+				Clock:     func() time.Time { return now },
+			})
+			assert.NoError(t, err)
+
+			m := query(s, "foo.com", dns.TypeA)
+			assert.Equal(t, tc.rcode, m.Rcode)
+
+			m = query(s, "foo.com", dns.TypeA)
+			assert.Equal(t, tc.rcode, m.Rcode)
+
+			if !tc.cached {
+				assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+				return
+			}
+
+			assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+			now = now.Add(tc.ttl)
+
+			m = query(s, "foo.com", dns.TypeA)
+			assert.Equal(t, tc.rcode, m.Rcode)
+			assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+		})
+	}
+}
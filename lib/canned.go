@@ -0,0 +1,337 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// dnsHeaderLen is the length of the header of DNS messages.
+const dnsHeaderLen = 12
+
+// parseCannedResponse parses an entry in the form '<type>
+// <path>' (e.g.: 'A testdata/a.bin') into the query type it
+// answers and the response loaded from the file.
+func parseCannedResponse(entry string) (qtype uint16, response []byte, err error) {
+	fields := strings.Fields(entry)
+	if len(fields) != 2 {
+		err = errors.Errorf(
+			"canned response must be in the form '<type> <path>' - %s", entry)
+		return
+	}
+
+	qtype, err = parseQtype(fields[0])
+	if err != nil {
+		err = errors.Wrapf(err,
+			"malformed type of canned response - %s", entry)
+		return
+	}
+
+	response, err = loadCannedResponse(fields[1])
+	if err != nil {
+		err = errors.Wrapf(err,
+			"couldn't load canned response - %s", entry)
+		return
+	}
+
+	return
+}
+
+// loadCannedResponse loads a response from a file either in
+// wire format, kept as is, or in presentation format (as
+// printed by dig), packed without compression.
+func loadCannedResponse(path string) (response []byte, err error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"couldn't read canned response file %s", path)
+		return
+	}
+
+	msg := new(dns.Msg)
+	if msg.Unpack(contents) == nil {
+		response = contents
+		return
+	}
+
+	msg, err = parsePresentation(contents)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"malformed canned response file %s", path)
+		return
+	}
+
+	response, err = msg.Pack()
+	if err != nil {
+		err = errors.Wrapf(err,
+			"couldn't pack canned response from file %s", path)
+		return
+	}
+
+	return
+}
+
+// parsePresentation parses a message in the presentation
+// format printed by dig: the status and flags of the header
+// as well as the records under each section are taken while
+// any other comment (e.g.: the OPT pseudosection) is skipped.
+func parsePresentation(contents []byte) (msg *dns.Msg, err error) {
+	var (
+		scanner    = bufio.NewScanner(bytes.NewReader(contents))
+		section    *[]dns.RR
+		inQuestion bool
+		rr         dns.RR
+	)
+
+	msg = new(dns.Msg)
+	msg.Response = true
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+		case strings.Contains(line, "status:"):
+			status := strings.Fields(line[strings.Index(line, "status:")+len("status:"):])
+			if len(status) == 0 {
+				err = errors.Errorf("missing status - %s", line)
+				return
+			}
+
+			rcode, found := dns.StringToRcode[strings.TrimRight(status[0], ",")]
+			if !found {
+				err = errors.Errorf("unknown status - %s", line)
+				return
+			}
+
+			msg.Rcode = rcode
+		case strings.HasPrefix(line, ";; flags:"):
+			setPresentationFlags(msg, line)
+		case strings.HasPrefix(line, ";; QUESTION SECTION:"):
+			section, inQuestion = nil, true
+		case strings.HasPrefix(line, ";; ANSWER SECTION:"):
+			section, inQuestion = &msg.Answer, false
+		case strings.HasPrefix(line, ";; AUTHORITY SECTION:"):
+			section, inQuestion = &msg.Ns, false
+		case strings.HasPrefix(line, ";; ADDITIONAL SECTION:"):
+			section, inQuestion = &msg.Extra, false
+		case inQuestion && !strings.HasPrefix(line, ";;"):
+			var question dns.Question
+
+			question, err = parsePresentationQuestion(line)
+			if err != nil {
+				return
+			}
+
+			msg.Question = append(msg.Question, question)
+		case strings.HasPrefix(line, ";"):
+		case section == nil:
+			err = errors.Errorf("record out of a section - %s", line)
+			return
+		default:
+			rr, err = dns.NewRR(line)
+			if err != nil {
+				err = errors.Wrapf(err, "malformed record - %s", line)
+				return
+			}
+
+			*section = append(*section, rr)
+		}
+	}
+
+	err = scanner.Err()
+	return
+}
+
+// parsePresentationQuestion parses a question as printed by
+// dig (e.g.: ';foo.com. IN A').
+func parsePresentationQuestion(line string) (question dns.Question, err error) {
+	fields := strings.Fields(strings.TrimPrefix(line, ";"))
+	if len(fields) != 3 {
+		err = errors.Errorf("malformed question - %s", line)
+		return
+	}
+
+	class, found := dns.StringToClass[fields[1]]
+	if !found {
+		err = errors.Errorf("unknown class of question - %s", line)
+		return
+	}
+
+	qtype, err := parseQtype(fields[2])
+	if err != nil {
+		err = errors.Wrapf(err, "malformed question - %s", line)
+		return
+	}
+
+	question = dns.Question{
+		Name:   dns.Fqdn(fields[0]),
+		Qtype:  qtype,
+		Qclass: class,
+	}
+	return
+}
+
+// setPresentationFlags sets the header bits listed in a dig
+// flags line (e.g.: ';; flags: qr aa rd; QUERY: 1, ...').
+func setPresentationFlags(msg *dns.Msg, line string) {
+	flags := strings.TrimPrefix(line, ";; flags:")
+	if idx := strings.Index(flags, ";"); idx >= 0 {
+		flags = flags[:idx]
+	}
+
+	msg.Response = false
+	for _, flag := range strings.Fields(flags) {
+		switch flag {
+		case "qr":
+			msg.Response = true
+		case "aa":
+			msg.Authoritative = true
+		case "tc":
+			msg.Truncated = true
+		case "rd":
+			msg.RecursionDesired = true
+		case "ra":
+			msg.RecursionAvailable = true
+		case "ad":
+			msg.AuthenticatedData = true
+		case "cd":
+			msg.CheckingDisabled = true
+		}
+	}
+}
+
+// cannedResponse adjusts a canned response to the request:
+// the transaction id and the question are taken from it
+// while every other byte is served as loaded. Responses
+// whose question doesn't take the same room as the one asked
+// (e.g.: matched through a wildcard) are repacked instead,
+// as names later in the message may point into it.
+func cannedResponse(canned []byte, r *dns.Msg) (response []byte, err error) {
+	question, err := (&dns.Msg{Question: r.Question}).Pack()
+	if err != nil {
+		return
+	}
+	question = question[dnsHeaderLen:]
+
+	end, err := questionEnd(canned)
+	if err == nil && end-dnsHeaderLen == len(question) {
+		response = make([]byte, 0, len(canned))
+		response = append(response, canned[:dnsHeaderLen]...)
+		response = append(response, question...)
+		response = append(response, canned[end:]...)
+
+		binary.BigEndian.PutUint16(response[0:], r.Id)
+		binary.BigEndian.PutUint16(response[4:], uint16(len(r.Question)))
+		return
+	}
+
+	msg := new(dns.Msg)
+	err = msg.Unpack(canned)
+	if err != nil {
+		return
+	}
+
+	msg.Id = r.Id
+	msg.Question = r.Question
+	response, err = msg.Pack()
+	return
+}
+
+// questionEnd tells the offset at which the question
+// section of a packed message ends.
+func questionEnd(packed []byte) (offset int, err error) {
+	if len(packed) < dnsHeaderLen {
+		err = errors.Errorf("message shorter than its header")
+		return
+	}
+
+	offset = dnsHeaderLen
+	for count := binary.BigEndian.Uint16(packed[4:]); count > 0; count-- {
+		offset, err = nameEnd(packed, offset)
+		if err != nil {
+			return
+		}
+
+		// type and class.
+		offset += 4
+		if offset > len(packed) {
+			err = errors.Errorf("truncated question")
+			return
+		}
+	}
+
+	return
+}
+
+// nameEnd tells the offset right after the packed name that
+// starts at offset.
+func nameEnd(packed []byte, offset int) (end int, err error) {
+	for offset < len(packed) {
+		length := int(packed[offset])
+
+		switch {
+		case length == 0:
+			end = offset + 1
+			return
+		case length&0xC0 == 0xC0:
+			// compression pointer: the name ends here.
+			end = offset + 2
+			return
+		default:
+			offset += length + 1
+		}
+	}
+
+	err = errors.Errorf("truncated name")
+	return
+}
+
+// answerCanned writes the canned response of the domain
+// that the question belongs to, if any, telling whether it
+// did so.
+func (s *Sdns) answerCanned(ctx *SdnsContext, w dns.ResponseWriter, r *dns.Msg) bool {
+	if len(r.Question) != 1 {
+		return false
+	}
+
+	question := r.Question[0]
+
+	domain, found := s.findDomain(strings.TrimRight(question.Name, "."))
+	if !found {
+		return false
+	}
+
+	canned, found := domain.loaded().cannedResponses[question.Qtype]
+	if !found {
+		return false
+	}
+
+	response, err := cannedResponse(canned, r)
+	if err != nil {
+		ctx.logger.Error().
+			Err(err).
+			Str("domain", domain.Name).
+			Msg("couldn't adjust canned response")
+		return false
+	}
+
+	ctx.logger.Debug().
+		Str("domain", domain.Name).
+		Str("query", dns.TypeToString[question.Qtype]).
+		Msg("answering with canned response")
+
+	_, err = w.Write(response)
+	if err != nil {
+		ctx.logger.Error().
+			Err(err).
+			Msg("couldn't write canned response")
+	}
+
+	return true
+}
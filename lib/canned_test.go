@@ -0,0 +1,178 @@
+package lib_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+const presentationResponse = `
+; <<>> DiG 9.16.1 <<>> foo.com TXT
+;; global options: +cmd
+;; Got answer:
+;; ->>HEADER<<- opcode: QUERY, status: NXDOMAIN, id: 4242
+;; flags: qr rd ra; QUERY: 1, ANSWER: 0, AUTHORITY: 1, ADDITIONAL: 1
+
+;; OPT PSEUDOSECTION:
+; EDNS: version: 0, flags:; udp: 4096
+;; QUESTION SECTION:
+;foo.com.			IN	TXT
+
+;; AUTHORITY SECTION:
+foo.com.		60	IN	SOA	ns1.foo.com. admin.foo.com. 1 2 3 4 5
+
+;; ADDITIONAL SECTION:
+ns1.foo.com.		60	IN	A	9.9.9.9
+
+;; Query time: 1 msec
+`
+
+// writeCannedResponses writes a response in wire format and
+// another in presentation format, returning their paths and
+// the packed bytes of the former.
+func writeCannedResponses(t *testing.T) (wire, presentation string, packed []byte) {
+	var (
+		dir = t.TempDir()
+		msg = new(dns.Msg)
+	)
+
+	msg.SetQuestion("foo.com.", dns.TypeA)
+	msg.Id = 1
+	msg.Response = true
+	msg.RecursionAvailable = true
+	msg.Compress = true
+
+	for _, record := range []string{
+		"foo.com. 42 IN A 7.7.7.7",
+		"foo.com. 42 IN A 8.8.8.8",
+	} {
+		rr, err := dns.NewRR(record)
+		assert.NoError(t, err)
+		msg.Answer = append(msg.Answer, rr)
+	}
+
+	packed, err := msg.Pack()
+	assert.NoError(t, err)
+
+	wire = filepath.Join(dir, "a.bin")
+	assert.NoError(t, ioutil.WriteFile(wire, packed, 0600))
+
+	presentation = filepath.Join(dir, "txt.dig")
+	assert.NoError(t, ioutil.WriteFile(presentation, []byte(presentationResponse), 0600))
+
+	return
+}
+
+func TestHandle_cannedResponse(t *testing.T) {
+	wire, presentation, packed := writeCannedResponses(t)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{
+				Name:      "foo.com",
+				Addresses: []string{"::1"},
+				Responses: []string{"A " + wire, "txt " + presentation},
+			},
+			{
+				Name:      "*.bar.com",
+				Responses: []string{"A " + wire},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	var (
+		w = &testWriter{}
+		r = new(dns.Msg)
+	)
+
+	r.SetQuestion("FOO.com.", dns.TypeA)
+	s.ServeDNS(w, r)
+
+	// only the id and the (mixed case) question differ.
+	expected := append([]byte(nil), packed...)
+	expected[0], expected[1] = byte(r.Id>>8), byte(r.Id)
+	copy(expected[12:], "\x03FOO")
+	assert.Equal(t, expected, w.raw)
+
+	// names taking a different room than the one in the
+	// file get the message repacked.
+	w = &testWriter{}
+	r.SetQuestion("www.bar.com.", dns.TypeA)
+	s.ServeDNS(w, r)
+
+	assert.Equal(t, r.Id, w.msg.Id)
+	assert.Equal(t, r.Question, w.msg.Question)
+	assert.False(t, w.msg.Authoritative)
+	assert.Equal(t, []string{
+		"foo.com.\t42\tIN\tA\t7.7.7.7",
+		"foo.com.\t42\tIN\tA\t8.8.8.8",
+	}, answerStrings(w.msg))
+
+	w = &testWriter{}
+	r.SetQuestion("foo.com.", dns.TypeTXT)
+	s.ServeDNS(w, r)
+
+	m := w.msg
+	assert.Equal(t, r.Question, m.Question)
+	assert.Equal(t, dns.RcodeNameError, m.Rcode)
+	assert.True(t, m.RecursionDesired)
+	assert.True(t, m.RecursionAvailable)
+	assert.False(t, m.Authoritative)
+	assert.Empty(t, m.Answer)
+	assert.Len(t, m.Ns, 1)
+	assert.Equal(t, "ns1.foo.com.\t60\tIN\tA\t9.9.9.9", m.Extra[0].String())
+
+	// types without a canned response are answered as
+	// usual.
+	m = query(s, "foo.com", dns.TypeAAAA)
+	assert.True(t, m.Authoritative)
+	assert.Equal(t, []string{
+		"foo.com.\t3600\tIN\tAAAA\t::1",
+	}, answerStrings(m))
+}
+
+func TestLoad_malformedCannedResponse(t *testing.T) {
+	var (
+		wire, _, _ = writeCannedResponses(t)
+		garbage    = filepath.Join(t.TempDir(), "garbage")
+	)
+
+	assert.NoError(t, ioutil.WriteFile(garbage, []byte("lol"), 0600))
+
+	var testCases = []struct {
+		entry       string
+		shouldError bool
+	}{
+		{"A " + wire, false},
+		{wire, true},
+		{"LOL " + wire, true},
+		{"A " + wire + ".missing", true},
+		{"A " + garbage, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.entry, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port: 1232,
+				Domains: []*Domain{
+					{Name: "foo.com", Responses: []string{tc.entry}},
+				},
+			})
+
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ChainPolicy determines how questions whose local alias
+// (CNAME) chain is longer than the maximum chain length are
+// answered.
+type ChainPolicy string
+
+const (
+	// ChainServfail answers with SERVFAIL.
+	ChainServfail ChainPolicy = "servfail"
+
+	// ChainPartial answers with the aliases followed up to
+	// the maximum length, leaving the rest of the chain to
+	// the client (as done for aliases of unknown names).
+	ChainPartial ChainPolicy = "partial"
+)
+
+// validateChain makes sure that the maximum chain length
+// isn't negative and that the policy is known, accepting
+// the empty one (i.e.: the default).
+func validateChain(length int, policy ChainPolicy) (err error) {
+	if length < 0 {
+		err = errors.Errorf("negative max chain length %d", length)
+		return
+	}
+
+	switch policy {
+	case "", ChainServfail, ChainPartial:
+	default:
+		err = errors.Errorf("unknown chain policy %s", policy)
+	}
+
+	return
+}
+
+// chainTooLong handles a question whose alias chain got
+// past the maximum length according to the chain policy,
+// giving the error (if any) that it's answered with.
+func (s *Sdns) chainTooLong(ctx *SdnsContext, name string) (err error) {
+	ctx.logger.Warn().
+		Str("name", name).
+		Int("max", s.maxChain).
+		Str("policy", string(s.chainPolicy)).
+		Msg("alias chain too long")
+
+	if s.chainPolicy == ChainServfail {
+		err = ErrCnameChainTooLong
+	}
+
+	return
+}
@@ -0,0 +1,115 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_maxChainLength(t *testing.T) {
+	var domains = []*Domain{
+		{Name: "a.com", Cname: "b.com"},
+		{Name: "b.com", Cname: "c.com"},
+		{Name: "c.com", Cname: "target.com"},
+		{Name: "target.com", Addresses: []string{"1.1.1.1"}},
+	}
+
+	var testCases = []struct {
+		name    string
+		max     int
+		policy  ChainPolicy
+		rcode   int
+		answers []string
+	}{
+		{
+			name:  "within the default",
+			rcode: dns.RcodeSuccess,
+			answers: []string{
+				"a.com.\t3600\tIN\tCNAME\tb.com.",
+				"b.com.\t3600\tIN\tCNAME\tc.com.",
+				"c.com.\t3600\tIN\tCNAME\ttarget.com.",
+				"target.com.\t3600\tIN\tA\t1.1.1.1",
+			},
+		},
+		{
+			name:  "at the limit",
+			max:   3,
+			rcode: dns.RcodeSuccess,
+			answers: []string{
+				"a.com.\t3600\tIN\tCNAME\tb.com.",
+				"b.com.\t3600\tIN\tCNAME\tc.com.",
+				"c.com.\t3600\tIN\tCNAME\ttarget.com.",
+				"target.com.\t3600\tIN\tA\t1.1.1.1",
+			},
+		},
+		{
+			name:    "past the limit",
+			max:     2,
+			rcode:   dns.RcodeServerFailure,
+			answers: []string{},
+		},
+		{
+			name:    "past the limit with servfail",
+			max:     2,
+			policy:  ChainServfail,
+			rcode:   dns.RcodeServerFailure,
+			answers: []string{},
+		},
+		{
+			name:   "past the limit with a partial answer",
+			max:    2,
+			policy: ChainPartial,
+			rcode:  dns.RcodeSuccess,
+			answers: []string{
+				"a.com.\t3600\tIN\tCNAME\tb.com.",
+				"b.com.\t3600\tIN\tCNAME\tc.com.",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSdns(SdnsConfig{
+				Port:           1232,
+				Recursors:      []string{"127.0.0.1:1"},
+				MaxChainLength: tc.max,
+				ChainPolicy:    tc.policy,
+				Domains:        domains,
+			})
+			assert.NoError(t, err)
+
+			m := query(s, "a.com", dns.TypeA)
+			assert.Equal(t, tc.rcode, m.Rcode)
+			assert.Equal(t, tc.answers, answerStrings(m))
+		})
+	}
+}
+
+func TestHandle_maxChainLengthHostnames(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:           1232,
+		Recursors:      []string{"127.0.0.1:1"},
+		MaxChainLength: 1,
+		Domains: []*Domain{
+			{Name: "direct.com", Addresses: []string{"target.com"}},
+			{Name: "indirect.com", Addresses: []string{"alias.com"}},
+			{Name: "alias.com", Cname: "target.com"},
+			{Name: "target.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(query(s, "direct.com", dns.TypeA)))
+	assert.Equal(t, dns.RcodeServerFailure, query(s, "indirect.com", dns.TypeA).Rcode)
+}
+
+func TestNewSdns_malformedChain(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{Port: 1232, MaxChainLength: -1})
+	assert.Error(t, err)
+
+	_, err = NewSdns(SdnsConfig{Port: 1232, ChainPolicy: "lol"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// responseDelay computes the artificial latency to inject
+// in a response for a given domain (which might be nil).
+// Per-domain settings take precedence over global ones.
+func (s *Sdns) responseDelay(domain *Domain) time.Duration {
+	var (
+		delay  = s.responseDelayBase
+		jitter = s.responseDelayJitter
+	)
+
+	if domain != nil && (domain.ResponseDelay > 0 || domain.ResponseDelayJitter > 0) {
+		delay = domain.ResponseDelay
+		jitter = domain.ResponseDelayJitter
+	}
+
+	if jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(jitter)))
+	}
+
+	return delay
+}
+
+// delayResponse holds the response to a question for the
+// configured delay, returning early with the context's
+// error if it gets cancelled.
+func (s *Sdns) delayResponse(ctx context.Context, m *dns.Msg) (err error) {
+	var domain *Domain
+
+	if len(m.Question) > 0 {
+		domain, _ = s.findDomain(
+			strings.TrimRight(m.Question[0].Name, "."))
+	}
+
+	delay := s.responseDelay(domain)
+	if delay <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return
+}
+
+// chaosFailure decides whether the question should fail
+// on purpose, according to the failure rate configured.
+func (s *Sdns) chaosFailure(ctx *SdnsContext, m *dns.Msg) bool {
+	if s.chaosFailureRate <= 0 || len(m.Question) == 0 {
+		return false
+	}
+
+	name := strings.ToLower(strings.TrimRight(m.Question[0].Name, "."))
+	if len(s.chaosFailureNames) > 0 && !s.chaosFailureNames[name] {
+		return false
+	}
+
+	if rand.Float64() >= s.chaosFailureRate {
+		return false
+	}
+
+	ctx.logger.Warn().
+		Str("name", name).
+		Float64("rate", s.chaosFailureRate).
+		Msg("chaos: failing query on purpose")
+	return true
+}
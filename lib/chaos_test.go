@@ -0,0 +1,158 @@
+package lib_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestDelayResponse(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:          1232,
+		Recursors:     []string{"127.0.0.1:1"},
+		ResponseDelay: 20 * time.Millisecond,
+		Domains: []*Domain{
+			{
+				Name:          "slow.com",
+				Addresses:     []string{"1.1.1.1"},
+				ResponseDelay: 100 * time.Millisecond,
+			},
+			{
+				Name:                "jittery.com",
+				Addresses:           []string{"1.1.1.1"},
+				ResponseDelay:       50 * time.Millisecond,
+				ResponseDelayJitter: 50 * time.Millisecond,
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	// the upper bounds leave room for slow machines.
+	const slack = 500 * time.Millisecond
+
+	var testCases = []struct {
+		name string
+		min  time.Duration
+		max  time.Duration
+	}{
+		{"fast.com", 20 * time.Millisecond, 20 * time.Millisecond},
+		{"slow.com", 100 * time.Millisecond, 100 * time.Millisecond},
+		{"jittery.com", 50 * time.Millisecond, 100 * time.Millisecond},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := new(dns.Msg)
+			m.SetQuestion(dns.Fqdn(tc.name), dns.TypeA)
+
+			start := time.Now()
+			err := s.DelayResponse(context.Background(), m)
+			elapsed := time.Since(start)
+
+			assert.NoError(t, err)
+			assert.True(t, elapsed >= tc.min, "took %s", elapsed)
+			assert.True(t, elapsed < tc.max+slack, "took %s", elapsed)
+		})
+	}
+}
+
+func TestDelayResponse_cancelled(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:          1232,
+		Recursors:     []string{"127.0.0.1:1"},
+		ResponseDelay: time.Minute,
+	})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	m := new(dns.Msg)
+	m.SetQuestion("a.com.", dns.TypeA)
+
+	start := time.Now()
+	err = s.DelayResponse(ctx, m)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestHandle_responseDelay(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{
+				Name:          "slow.com",
+				Addresses:     []string{"1.1.1.1"},
+				ResponseDelay: 50 * time.Millisecond,
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	start := time.Now()
+	m := query(s, "slow.com", dns.TypeA)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+}
+
+func TestHandle_chaosFailureRate(t *testing.T) {
+	const queries = 5000
+
+	var testCases = []struct {
+		name     string
+		rate     float64
+		names    []string
+		expected float64
+	}{
+		{name: "disabled", rate: 0, expected: 0},
+		{name: "always", rate: 1, expected: 1},
+		{name: "some", rate: 0.3, expected: 0.3},
+		{name: "some for the name", rate: 0.3, names: []string{"FOO.com."}, expected: 0.3},
+		{name: "other names", rate: 1, names: []string{"bar.com"}, expected: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSdns(SdnsConfig{
+				Port:              1053,
+				Recursors:         []string{"127.0.0.1:1"},
+				ChaosFailureRate:  tc.rate,
+				ChaosFailureNames: tc.names,
+				Domains: []*Domain{
+					{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+				},
+			})
+			assert.NoError(t, err)
+
+			failures := 0
+			for i := 0; i < queries; i++ {
+				m := query(s, "foo.com", dns.TypeA)
+				if m.Rcode == dns.RcodeServerFailure {
+					assert.Empty(t, m.Answer)
+					failures++
+				}
+			}
+
+			// way more than enough standard deviations.
+			assert.InDelta(t, tc.expected, float64(failures)/queries, 0.05)
+		})
+	}
+}
+
+func TestNewSdns_invalidChaosFailureRate(t *testing.T) {
+	for _, rate := range []float64{-0.1, 1.1} {
+		_, err := NewSdns(SdnsConfig{
+			Port:             1053,
+			Recursors:        []string{"127.0.0.1:1"},
+			ChaosFailureRate: rate,
+		})
+		assert.Error(t, err)
+	}
+}
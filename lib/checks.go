@@ -0,0 +1,343 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HealthCheck is the kind of check that tells whether the
+// addresses of a domain are up.
+type HealthCheck string
+
+const (
+	// HealthCheckTCP considers addresses up when they
+	// accept TCP connections.
+	HealthCheckTCP HealthCheck = "tcp"
+
+	// HealthCheckHTTP considers addresses up when they
+	// answer HTTP requests with a status below 400.
+	HealthCheckHTTP HealthCheck = "http"
+)
+
+const (
+	// DefaultHealthCheckInterval is how often addresses
+	// are checked when not configured.
+	DefaultHealthCheckInterval = 10 * time.Second
+
+	// healthCheckTimeout bounds each check.
+	healthCheckTimeout = 2 * time.Second
+
+	// healthCheckTick is how often domains are looked at
+	// for checks that are due.
+	healthCheckTick = time.Second
+)
+
+// addressHealth tracks the addresses of a domain found down
+// by the last check.
+type addressHealth struct {
+	sync.Mutex
+	down    map[string]bool
+	checked time.Time
+}
+
+// validateHealthCheck makes sure that the health check of a
+// domain is known and has a port to check.
+func validateHealthCheck(d *Domain) (err error) {
+	switch d.HealthCheck {
+	case "":
+		return
+	case HealthCheckTCP, HealthCheckHTTP:
+	default:
+		err = errors.Errorf("unknown health check %s", d.HealthCheck)
+		return
+	}
+
+	if len(d.Addresses) == 0 {
+		err = errors.Errorf("no addresses to check")
+		return
+	}
+
+	if d.HealthCheckPort <= 0 || d.HealthCheckPort > 65535 {
+		err = errors.Errorf("invalid health check port %d",
+			d.HealthCheckPort)
+		return
+	}
+
+	return
+}
+
+// healthy drops the addresses (and their weights, if any)
+// found down unless all of them are, in which case they're
+// all kept as there's nothing better to answer with.
+func (h *addressHealth) healthy(addresses []string, weights []uint32) ([]string, []uint32) {
+	h.Lock()
+	defer h.Unlock()
+
+	if len(h.down) == 0 {
+		return addresses, weights
+	}
+
+	var (
+		up        []string
+		upWeights []uint32
+	)
+
+	for idx, address := range addresses {
+		if h.down[address] {
+			continue
+		}
+
+		up = append(up, address)
+		if weights != nil {
+			upWeights = append(upWeights, weights[idx])
+		}
+	}
+
+	if len(up) == 0 {
+		return addresses, weights
+	}
+
+	return up, upWeights
+}
+
+// due tells whether the domain's addresses are due to be
+// checked, taking note that they're about to be.
+func (h *addressHealth) due(now time.Time, interval time.Duration) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	if !h.checked.IsZero() && now.Sub(h.checked) < interval {
+		return false
+	}
+
+	h.checked = now
+	return true
+}
+
+// checkAddress checks whether an address of the domain is up.
+func checkAddress(d *Domain, address string) (err error) {
+	var target = net.JoinHostPort(address, strconv.Itoa(d.HealthCheckPort))
+
+	switch d.HealthCheck {
+	case HealthCheckTCP:
+		var conn net.Conn
+
+		conn, err = net.DialTimeout("tcp", target, healthCheckTimeout)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	case HealthCheckHTTP:
+		var (
+			client = &http.Client{Timeout: healthCheckTimeout}
+			path   = d.HealthCheckPath
+			resp   *http.Response
+		)
+
+		if path == "" {
+			path = "/"
+		}
+
+		resp, err = client.Get(fmt.Sprintf("http://%s%s", target, path))
+		if err != nil {
+			return
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			err = errors.Errorf("unhealthy status %d", resp.StatusCode)
+		}
+	}
+
+	return
+}
+
+// checkDomain checks, all at once, the addresses (IPs only,
+// hostnames are left alone) of the domain.
+func (s *Sdns) checkDomain(d *Domain) {
+	var (
+		records = d.loaded()
+		down    = make(map[string]bool)
+		mutex   sync.Mutex
+		wg      sync.WaitGroup
+	)
+
+	for _, entry := range d.Addresses {
+		address := addressOf(entry)
+		if net.ParseIP(address) == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+
+			err := checkAddress(d, address)
+			if err == nil {
+				return
+			}
+
+			s.logger.Debug().
+				Err(err).
+				Str("domain", d.Name).
+				Str("address", address).
+				Msg("address failed health check")
+
+			mutex.Lock()
+			down[address] = true
+			mutex.Unlock()
+		}(address)
+	}
+
+	wg.Wait()
+
+	records.health.Lock()
+	defer records.health.Unlock()
+
+	for address := range down {
+		if !records.health.down[address] {
+			s.logger.Warn().
+				Str("domain", d.Name).
+				Str("address", address).
+				Msg("address down")
+		}
+	}
+
+	for address := range records.health.down {
+		if !down[address] {
+			s.logger.Info().
+				Str("domain", d.Name).
+				Str("address", address).
+				Msg("address back up")
+		}
+	}
+
+	records.health.down = down
+}
+
+// CheckAddresses runs the health checks of the domains that
+// are due, waiting for them to finish.
+func (s *Sdns) CheckAddresses() {
+	var (
+		now     = s.now()
+		domains []*Domain
+		wg      sync.WaitGroup
+	)
+
+	s.lock.RLock()
+	for _, indexed := range []map[string]*Domain{
+		s.exactDomains,
+		s.wildcardDomains,
+		s.apexDomains,
+	} {
+		for _, domain := range indexed {
+			domains = append(domains, domain)
+		}
+	}
+	s.lock.RUnlock()
+
+	for _, domain := range domains {
+		if domain.HealthCheck == "" {
+			continue
+		}
+
+		interval := domain.HealthCheckInterval
+		if interval <= 0 {
+			interval = DefaultHealthCheckInterval
+		}
+
+		if !domain.loaded().health.due(now, interval) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(domain *Domain) {
+			defer wg.Done()
+			s.checkDomain(domain)
+		}(domain)
+	}
+
+	wg.Wait()
+}
+
+// runHealthChecks runs the health checks that are due until
+// done gets closed.
+func (s *Sdns) runHealthChecks(done <-chan struct{}) {
+	ticker := time.NewTicker(healthCheckTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.CheckAddresses()
+		case <-done:
+			return
+		}
+	}
+}
+
+// carry returns the health of the addresses still among the
+// entries, so that those found down stay down until checked
+// again. When the addresses are the same, so is the time of
+// the next check.
+func (h *addressHealth) carry(previous, entries []string) *addressHealth {
+	h.Lock()
+	defer h.Unlock()
+
+	carried := &addressHealth{}
+	if sameRecursors(previous, entries) {
+		carried.checked = h.checked
+	}
+
+	for _, entry := range entries {
+		address := addressOf(entry)
+		if !h.down[address] {
+			continue
+		}
+
+		if carried.down == nil {
+			carried.down = make(map[string]bool)
+		}
+		carried.down[address] = true
+	}
+
+	return carried
+}
+
+// carryHealth makes the domains about to be stored keep the
+// health of the ones with the same name previously loaded,
+// as long as they're checked the same way.
+func carryHealth(pending map[*Domain]*domainRecords, previous ...map[string]*Domain) {
+	for domain, records := range pending {
+		if domain.HealthCheck == "" {
+			continue
+		}
+
+		key := strings.ToLower(domain.Name)
+		if key[0] == '*' {
+			key = key[1:]
+		}
+
+		for _, indexed := range previous {
+			old, found := indexed[key]
+			if !found {
+				continue
+			}
+
+			if old.HealthCheck == domain.HealthCheck &&
+				old.HealthCheckPort == domain.HealthCheckPort &&
+				old.HealthCheckPath == domain.HealthCheckPath {
+				records.health = old.loaded().health.carry(
+					old.Addresses, domain.Addresses)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package lib_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// startBackend starts an HTTP server on 127.0.0.1 that
+// answers with 500 while healthy is unset, returning its
+// port.
+func startBackend(t *testing.T, healthy *int32) int {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(healthy) == 0 || r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server.Listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestHandle_healthChecks(t *testing.T) {
+	var testCases = []struct {
+		name  string
+		check HealthCheck
+	}{
+		{"tcp", HealthCheckTCP},
+		{"http", HealthCheckHTTP},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				healthy int32 = 1
+				port          = startBackend(t, &healthy)
+				now           = time.Unix(1000, 0)
+			)
+
+			// nothing listens on 127.0.0.2.
+			s, err := NewSdns(SdnsConfig{
+				Port:      1232,
+				Recursors: []string{"127.0.0.1:1"},
+				Clock:     func() time.Time { return now },
+				Domains: []*Domain{
+					{
+						Name:                "foo.com",
+						Addresses:           []string{"127.0.0.1", "127.0.0.2"},
+						HealthCheck:         tc.check,
+						HealthCheckPort:     port,
+						HealthCheckPath:     "/healthz",
+						HealthCheckInterval: time.Minute,
+					},
+					{
+						Name:        "all.foo.com",
+						Addresses:   []string{"127.0.0.1", "127.0.0.2"},
+						AddressMode: AddressesAll,
+						HealthCheck: tc.check,
+						// nothing listens on port 1.
+						HealthCheckPort: 1,
+					},
+				},
+			})
+			assert.NoError(t, err)
+
+			// before any check, every address is answered.
+			m := query(s, "all.foo.com", dns.TypeA)
+			assert.Equal(t, []string{"127.0.0.1", "127.0.0.2"}, answerIPs(m))
+
+			s.CheckAddresses()
+
+			for i := 0; i < 4; i++ {
+				m = query(s, "foo.com", dns.TypeA)
+				assert.Equal(t, []string{"127.0.0.1"}, answerIPs(m))
+			}
+
+			// with every address down, they're all
+			// answered as there's nothing better.
+			m = query(s, "all.foo.com", dns.TypeA)
+			assert.Equal(t, []string{"127.0.0.1", "127.0.0.2"}, answerIPs(m))
+
+			if tc.check != HealthCheckHTTP {
+				return
+			}
+
+			// a failing endpoint gets the address down
+			// once the check is due again.
+			atomic.StoreInt32(&healthy, 0)
+
+			s.CheckAddresses()
+			domain, _ := s.FindDomainFromName("foo.com")
+			assert.Equal(t, "127.0.0.1", domain.GetAddress())
+
+			now = now.Add(time.Minute)
+			s.CheckAddresses()
+
+			seen := map[string]bool{}
+			for i := 0; i < 4; i++ {
+				seen[domain.GetAddress()] = true
+			}
+			assert.Equal(t, map[string]bool{"127.0.0.1": true, "127.0.0.2": true}, seen)
+		})
+	}
+}
+
+func TestLoad_keepsHealth(t *testing.T) {
+	var (
+		healthy int32 = 1
+		port          = startBackend(t, &healthy)
+	)
+
+	// nothing listens on 127.0.0.2.
+	config := func(addresses ...string) SdnsConfig {
+		return SdnsConfig{
+			Port:      1232,
+			Recursors: []string{"127.0.0.1:1"},
+			Domains: []*Domain{
+				{
+					Name:            "foo.com",
+					Addresses:       addresses,
+					AddressMode:     AddressesAll,
+					HealthCheck:     HealthCheckTCP,
+					HealthCheckPort: port,
+				},
+			},
+		}
+	}
+
+	s, err := NewSdns(config("127.0.0.1", "127.0.0.2"))
+	assert.NoError(t, err)
+
+	s.CheckAddresses()
+
+	m := query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"127.0.0.1"}, answerIPs(m))
+
+	err = s.Load(config("127.0.0.1", "127.0.0.2"))
+	assert.NoError(t, err)
+
+	m = query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"127.0.0.1"}, answerIPs(m))
+
+	// addresses added aren't known to be down yet.
+	err = s.Load(config("127.0.0.1", "127.0.0.2", "127.0.0.3"))
+	assert.NoError(t, err)
+
+	m = query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"127.0.0.1", "127.0.0.3"}, answerIPs(m))
+}
+
+func TestLoad_malformedHealthCheck(t *testing.T) {
+	var addresses = []string{"10.0.0.1"}
+
+	var testCases = []struct {
+		name   string
+		domain *Domain
+	}{
+		{"unknown check", &Domain{Name: "foo.com", Addresses: addresses, HealthCheck: "lol", HealthCheckPort: 80}},
+		{"missing port", &Domain{Name: "foo.com", Addresses: addresses, HealthCheck: HealthCheckTCP}},
+		{"invalid port", &Domain{Name: "foo.com", Addresses: addresses, HealthCheck: HealthCheckTCP, HealthCheckPort: 70000}},
+		{"no addresses", &Domain{Name: "foo.com", HealthCheck: HealthCheckTCP, HealthCheckPort: 80}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port:    1232,
+				Domains: []*Domain{tc.domain},
+			})
+			assert.Error(t, err)
+		})
+	}
+}
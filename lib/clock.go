@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxClockSkew is how far the clock can be from the
+// reference one before a warning gets logged.
+const DefaultMaxClockSkew = time.Minute
+
+// referenceTime retrieves the time of a reference clock
+// from the Date header that an HTTP server responds with
+// (a coarse, second-precision, NTP-style hint).
+func referenceTime(client *http.Client, url string) (reference time.Time, err error) {
+	resp, err := client.Head(url)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"couldn't reach clock reference %s", url)
+		return
+	}
+	resp.Body.Close()
+
+	reference, err = http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		err = errors.Wrapf(err,
+			"malformed date from clock reference %s", url)
+		return
+	}
+
+	return
+}
+
+// CheckClockSkew compares the clock against the reference
+// one (see SdnsConfig.ClockReference), warning when they're
+// further apart than allowed as time-dependent features
+// (e.g.: DNSSEC validation and cache expiry) misbehave on
+// skewed clocks. The skew is positive when the clock is
+// ahead of the reference.
+func (s *Sdns) CheckClockSkew() (skew time.Duration, err error) {
+	if s.clockReference == "" {
+		return
+	}
+
+	var (
+		client = &http.Client{Timeout: DefaultRecursionTimeout}
+		start  = s.now()
+	)
+
+	reference, err := referenceTime(client, s.clockReference)
+	if err != nil {
+		return
+	}
+
+	// the reference answered somewhere during the
+	// exchange: compare it against the midpoint.
+	now := s.now()
+	skew = start.Add(now.Sub(start) / 2).Sub(reference)
+
+	if skew > s.maxClockSkew || -skew > s.maxClockSkew {
+		s.logger.Warn().
+			Str("reference", s.clockReference).
+			Dur("skew", skew).
+			Msg("clock skewed")
+		return
+	}
+
+	s.logger.Debug().
+		Str("reference", s.clockReference).
+		Dur("skew", skew).
+		Msg("clock in sync")
+	return
+}
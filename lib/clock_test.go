@@ -0,0 +1,78 @@
+package lib_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// startClockReference starts an HTTP server whose Date
+// header tells the time given.
+func startClockReference(t *testing.T, reference time.Time) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", reference.UTC().Format(http.TimeFormat))
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+func TestCheckClockSkew(t *testing.T) {
+	var (
+		now       = time.Unix(100000, 0)
+		testCases = []struct {
+			name      string
+			reference time.Time
+			skew      time.Duration
+			warned    bool
+		}{
+			{"in sync", now, 0, false},
+			{"within allowed skew", now.Add(-30 * time.Second), 30 * time.Second, false},
+			{"ahead", now.Add(-2 * time.Hour), 2 * time.Hour, true},
+			{"behind", now.Add(2 * time.Hour), -2 * time.Hour, true},
+		}
+	)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+
+			s, err := NewSdns(SdnsConfig{
+				Port:           1232,
+				Recursors:      []string{"127.0.0.1:1"},
+				Clock:          func() time.Time { return now },
+				ClockReference: startClockReference(t, tc.reference),
+				LogOutput:      &logs,
+			})
+			assert.NoError(t, err)
+
+			skew, err := s.CheckClockSkew()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.skew, skew)
+
+			if tc.warned {
+				assert.Contains(t, logs.String(), "clock skewed")
+			} else {
+				assert.NotContains(t, logs.String(), "clock skewed")
+			}
+		})
+	}
+}
+
+func TestCheckClockSkew_unreachableReference(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:           1232,
+		Recursors:      []string{"127.0.0.1:1"},
+		ClockReference: "http://127.0.0.1:1",
+	})
+	assert.NoError(t, err)
+
+	_, err = s.CheckClockSkew()
+	assert.Error(t, err)
+}
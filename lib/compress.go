@@ -0,0 +1,24 @@
+package lib
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// compress tells whether the response should be compressed:
+// as the domain asked for overrides or, otherwise, as the
+// global setting says.
+func (s *Sdns) compress(m *dns.Msg) bool {
+	if len(m.Question) == 0 {
+		return s.compression
+	}
+
+	domain, found := s.findDomain(
+		strings.TrimRight(m.Question[0].Name, "."))
+	if !found || domain.Compress == nil {
+		return s.compression
+	}
+
+	return *domain.Compress
+}
@@ -0,0 +1,379 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the YAML document of a configuration file.
+// Settings left out keep the value they already had (e.g.:
+// from flags).
+type fileConfig struct {
+	Address   *string      `yaml:"address"`
+	Port      *int         `yaml:"port"`
+	Debug     *bool        `yaml:"debug"`
+	NSID      *string      `yaml:"nsid"`
+	LogLevel  *string      `yaml:"log-level"`
+	LogFormat *string      `yaml:"log-format"`
+	TTL       *uint32      `yaml:"ttl"`
+	Compress  *bool        `yaml:"compress"`
+	Cache     *bool        `yaml:"cache"`
+	CacheSize *int         `yaml:"cache-size"`
+	Recursors []string     `yaml:"recursors"`
+	Domains   []fileDomain `yaml:"domains"`
+	Zones     []fileZone   `yaml:"zones"`
+}
+
+// fileDomain is a domain of a configuration file, with its
+// records as structured lists (see Domain).
+type fileDomain struct {
+	Name            string           `yaml:"name"`
+	A               []fileAddress    `yaml:"a"`
+	AAAA            []fileAddress    `yaml:"aaaa"`
+	Hosts           []fileAddress    `yaml:"hosts"`
+	NS              []string         `yaml:"ns"`
+	Cname           string           `yaml:"cname"`
+	MX              []fileMX         `yaml:"mx"`
+	TXT             []string         `yaml:"txt"`
+	CAA             []fileCAA        `yaml:"caa"`
+	SRV             []fileSRV        `yaml:"srv"`
+	Services        []fileService    `yaml:"services"`
+	Apex            []string         `yaml:"apex"`
+	WildcardTypes   []string         `yaml:"wildcard-types"`
+	WildcardPolicy  WildcardPolicy   `yaml:"wildcard-policy"`
+	Fallback        []string         `yaml:"fallback"`
+	AddressMode     AddressMode      `yaml:"addresses"`
+	NameserverOrder NameserverOrder  `yaml:"ns-order"`
+	Delegation      DelegationPolicy `yaml:"delegation"`
+	Transport       TransportPolicy  `yaml:"transport"`
+	MaxQPS          int              `yaml:"max-qps"`
+	Recursors       []string         `yaml:"recursors"`
+	CacheNamespace  string           `yaml:"cache-namespace"`
+	CacheMinTTL     uint32           `yaml:"cache-min-ttl"`
+	CacheMaxTTL     uint32           `yaml:"cache-max-ttl"`
+	NoCache         bool             `yaml:"no-cache"`
+	TTL             uint32           `yaml:"ttl"`
+	Cutover         time.Time        `yaml:"cutover"`
+	CutoverRamp     time.Duration    `yaml:"cutover-ramp"`
+	CutoverTTL      uint32           `yaml:"cutover-ttl"`
+	Compress        *bool            `yaml:"compress"`
+	Truncate        bool             `yaml:"truncate"`
+	LogLevel        string           `yaml:"log"`
+	Responses       []fileResponse   `yaml:"responses"`
+	Check           *fileHealthCheck `yaml:"check"`
+}
+
+// fileAddress is an address given either on its own or
+// along with its weight.
+type fileAddress struct {
+	Address string `yaml:"address"`
+	Weight  uint32 `yaml:"weight"`
+}
+
+// UnmarshalYAML takes addresses given on their own as well.
+func (a *fileAddress) UnmarshalYAML(value *yaml.Node) (err error) {
+	if value.Kind == yaml.ScalarNode {
+		a.Address = value.Value
+		return
+	}
+
+	type plain fileAddress
+	err = value.Decode((*plain)(a))
+	return
+}
+
+type fileMX struct {
+	Preference uint16 `yaml:"preference"`
+	Host       string `yaml:"host"`
+}
+
+type fileCAA struct {
+	Flags uint8  `yaml:"flags"`
+	Tag   string `yaml:"tag"`
+	Value string `yaml:"value"`
+}
+
+type fileSRV struct {
+	Priority uint16 `yaml:"priority"`
+	Weight   uint16 `yaml:"weight"`
+	Port     uint16 `yaml:"port"`
+	Target   string `yaml:"target"`
+}
+
+type fileService struct {
+	Labels string `yaml:"labels"`
+	Type   string `yaml:"type"`
+	Data   string `yaml:"data"`
+}
+
+type fileResponse struct {
+	Type string `yaml:"type"`
+	File string `yaml:"file"`
+}
+
+type fileHealthCheck struct {
+	Type     HealthCheck   `yaml:"type"`
+	Port     int           `yaml:"port"`
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+type fileZone struct {
+	Name         string       `yaml:"name"`
+	Ns           string       `yaml:"ns"`
+	Mbox         string       `yaml:"mbox"`
+	Serial       uint32       `yaml:"serial"`
+	SerialFormat SerialFormat `yaml:"serial-format"`
+	Refresh      uint32       `yaml:"refresh"`
+	Retry        uint32       `yaml:"retry"`
+	Expire       uint32       `yaml:"expire"`
+	Minttl       uint32       `yaml:"minttl"`
+	Nameservers  []string     `yaml:"default-ns"`
+}
+
+// LoadConfigFile merges the YAML configuration file at path
+// into cfg: its settings and recursors override the ones of
+// cfg, while its domains and zones are added to them.
+// Relative paths of canned responses are taken as relative
+// to the file.
+func LoadConfigFile(path string, cfg SdnsConfig) (merged SdnsConfig, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"couldn't open config file %s", path)
+		return
+	}
+	defer file.Close()
+
+	var (
+		doc     fileConfig
+		decoder = yaml.NewDecoder(file)
+	)
+
+	decoder.KnownFields(true)
+	err = decoder.Decode(&doc)
+	if err == io.EOF {
+		err = nil
+	}
+
+	if err != nil {
+		err = errors.Wrapf(err,
+			"malformed config file %s", path)
+		return
+	}
+
+	merged = cfg
+	if doc.Address != nil {
+		merged.Address = *doc.Address
+	}
+
+	if doc.Port != nil {
+		merged.Port = *doc.Port
+	}
+
+	if doc.Debug != nil {
+		merged.Debug = *doc.Debug
+	}
+
+	if doc.NSID != nil {
+		merged.NSID = *doc.NSID
+	}
+
+	if doc.LogLevel != nil {
+		merged.LogLevel = *doc.LogLevel
+	}
+
+	if doc.LogFormat != nil {
+		merged.LogFormat = LogFormat(*doc.LogFormat)
+	}
+
+	if doc.TTL != nil {
+		merged.TTL = *doc.TTL
+	}
+
+	if doc.Compress != nil {
+		merged.Compress = *doc.Compress
+	}
+
+	if doc.Cache != nil {
+		merged.Cache = *doc.Cache
+	}
+
+	if doc.CacheSize != nil {
+		merged.CacheSize = *doc.CacheSize
+	}
+
+	if len(doc.Recursors) > 0 {
+		merged.Recursors = doc.Recursors
+	}
+
+	merged.Domains = append([]*Domain{}, cfg.Domains...)
+	for idx, fd := range doc.Domains {
+		var domain *Domain
+
+		domain, err = fd.domain(filepath.Dir(path))
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed domain %d of config file %s", idx+1, path)
+			return
+		}
+
+		merged.Domains = append(merged.Domains, domain)
+	}
+
+	merged.Zones = append([]*Zone{}, cfg.Zones...)
+	for idx, fz := range doc.Zones {
+		if fz.Name == "" {
+			err = errors.Errorf(
+				"a zone name must be present - zone %d of config file %s",
+				idx+1, path)
+			return
+		}
+
+		merged.Zones = append(merged.Zones, &Zone{
+			Name:         fz.Name,
+			Ns:           fz.Ns,
+			Mbox:         fz.Mbox,
+			Serial:       fz.Serial,
+			SerialFormat: fz.SerialFormat,
+			Refresh:      fz.Refresh,
+			Retry:        fz.Retry,
+			Expire:       fz.Expire,
+			Minttl:       fz.Minttl,
+			Nameservers:  fz.Nameservers,
+		})
+	}
+
+	return
+}
+
+// addresses converts the addresses of a family (4 or 6, or
+// 0 for hostnames) into entries of Domain.Addresses.
+func addresses(entries []fileAddress, family int) (addresses []string, err error) {
+	for _, entry := range entries {
+		ip := net.ParseIP(entry.Address)
+
+		switch {
+		case family == 4 && (ip == nil || ip.To4() == nil):
+			err = errors.Errorf("%s isn't an ipv4 address", entry.Address)
+		case family == 6 && (ip == nil || ip.To4() != nil):
+			err = errors.Errorf("%s isn't an ipv6 address", entry.Address)
+		case family == 0 && ip != nil:
+			err = errors.Errorf("%s isn't a hostname", entry.Address)
+		}
+
+		if err != nil {
+			return
+		}
+
+		address := entry.Address
+		if entry.Weight > 0 {
+			address = fmt.Sprintf("%s;weight=%d", address, entry.Weight)
+		}
+
+		addresses = append(addresses, address)
+	}
+
+	return
+}
+
+// domain converts the domain of a configuration file into a
+// Domain, taking relative paths as relative to dir.
+func (fd *fileDomain) domain(dir string) (domain *Domain, err error) {
+	if fd.Name == "" {
+		err = errors.Errorf("a domain name must be present")
+		return
+	}
+
+	domain = &Domain{
+		Name:            fd.Name,
+		Nameservers:     fd.NS,
+		Cname:           fd.Cname,
+		Texts:           fd.TXT,
+		ApexTypes:       fd.Apex,
+		WildcardTypes:   fd.WildcardTypes,
+		WildcardPolicy:  fd.WildcardPolicy,
+		Fallback:        fd.Fallback,
+		AddressMode:     fd.AddressMode,
+		NameserverOrder: fd.NameserverOrder,
+		Delegation:      fd.Delegation,
+		Transport:       fd.Transport,
+		MaxQPS:          fd.MaxQPS,
+		Recursors:       fd.Recursors,
+		CacheNamespace:  fd.CacheNamespace,
+		CacheMinTTL:     fd.CacheMinTTL,
+		CacheMaxTTL:     fd.CacheMaxTTL,
+		NoCache:         fd.NoCache,
+		TTL:             fd.TTL,
+		Cutover:         fd.Cutover,
+		CutoverRamp:     fd.CutoverRamp,
+		CutoverTTL:      fd.CutoverTTL,
+		Compress:        fd.Compress,
+		ForceTruncate:   fd.Truncate,
+		LogLevel:        fd.LogLevel,
+	}
+
+	for _, family := range []struct {
+		entries []fileAddress
+		family  int
+	}{
+		{fd.A, 4},
+		{fd.AAAA, 6},
+		{fd.Hosts, 0},
+	} {
+		var entries []string
+
+		entries, err = addresses(family.entries, family.family)
+		if err != nil {
+			return
+		}
+
+		domain.Addresses = append(domain.Addresses, entries...)
+	}
+
+	for _, mx := range fd.MX {
+		domain.MailExchangers = append(domain.MailExchangers,
+			fmt.Sprintf("%d %s", mx.Preference, mx.Host))
+	}
+
+	for _, caa := range fd.CAA {
+		domain.CAA = append(domain.CAA,
+			fmt.Sprintf(`%d %s "%s"`, caa.Flags, caa.Tag, caa.Value))
+	}
+
+	for _, srv := range fd.SRV {
+		domain.SRV = append(domain.SRV,
+			fmt.Sprintf("%d %d %d %s", srv.Priority, srv.Weight, srv.Port, srv.Target))
+	}
+
+	for _, service := range fd.Services {
+		domain.Services = append(domain.Services,
+			strings.Join([]string{service.Labels, service.Type, service.Data}, " "))
+	}
+
+	for _, response := range fd.Responses {
+		file := response.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+
+		domain.Responses = append(domain.Responses, response.Type+" "+file)
+	}
+
+	if fd.Check != nil {
+		domain.HealthCheck = fd.Check.Type
+		domain.HealthCheckPort = fd.Check.Port
+		domain.HealthCheckPath = fd.Check.Path
+		domain.HealthCheckInterval = fd.Check.Interval
+	}
+
+	return
+}
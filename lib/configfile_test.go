@@ -0,0 +1,179 @@
+package lib_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+const sampleConfig = `
+port: 1232
+debug: false
+log-format: console
+ttl: 300
+recursors:
+  - 127.0.0.1:1
+domains:
+  - name: a.com
+    a:
+      - 1.1.1.1
+      - address: 2.2.2.2
+        weight: 20
+    aaaa: ["::1"]
+    ns: [ns1.a.com]
+    mx:
+      - preference: 10
+        host: mail.a.com
+    txt: ["v=spf1 -all"]
+    caa:
+      - flags: 0
+        tag: issue
+        value: letsencrypt.org
+    srv:
+      - priority: 10
+        weight: 60
+        port: 5060
+        target: sip.a.com
+    services:
+      - labels: _dmarc
+        type: TXT
+        data: v=DMARC1; p=none
+    responses:
+      - type: A
+        file: a.bin
+    check:
+      type: tcp
+      port: 80
+      interval: 5s
+  - name: www.a.com
+    cname: a.com
+    ttl: 30
+zones:
+  - name: a.com
+    ns: ns1.a.com
+    mbox: admin@a.com
+    serial: 10
+    default-ns: [ns1.a.com]
+`
+
+// writeConfigFile writes the configuration to a file in a
+// temporary directory, returning its path.
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "sdns.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	var (
+		path = writeConfigFile(t, sampleConfig)
+		cli  = &Domain{Name: "cli.com", Addresses: []string{"3.3.3.3"}}
+	)
+
+	cfg, err := LoadConfigFile(path, SdnsConfig{
+		Port:      1053,
+		Debug:     true,
+		Compress:  true,
+		Recursors: []string{"8.8.8.8:53"},
+		Domains:   []*Domain{cli},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, SdnsConfig{
+		Port:      1232,
+		Debug:     false,
+		LogFormat: LogFormatConsole,
+		TTL:       300,
+		Compress:  true,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			cli,
+			{
+				Name:           "a.com",
+				Addresses:      []string{"1.1.1.1", "2.2.2.2;weight=20", "::1"},
+				Nameservers:    []string{"ns1.a.com"},
+				MailExchangers: []string{"10 mail.a.com"},
+				Texts:          []string{"v=spf1 -all"},
+				CAA:            []string{`0 issue "letsencrypt.org"`},
+				SRV:            []string{"10 60 5060 sip.a.com"},
+				Services:       []string{"_dmarc TXT v=DMARC1; p=none"},
+				Responses:      []string{"A " + filepath.Join(filepath.Dir(path), "a.bin")},
+
+				HealthCheck:         HealthCheckTCP,
+				HealthCheckPort:     80,
+				HealthCheckInterval: 5 * time.Second,
+			},
+			{Name: "www.a.com", Cname: "a.com", TTL: 30},
+		},
+		Zones: []*Zone{
+			{
+				Name:        "a.com",
+				Ns:          "ns1.a.com",
+				Mbox:        "admin@a.com",
+				Serial:      10,
+				Nameservers: []string{"ns1.a.com"},
+			},
+		},
+	}, cfg)
+}
+
+func TestNewSdns_configFile(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port: 1053,
+		ConfigFile: writeConfigFile(t, `
+recursors: [127.0.0.1:1]
+domains:
+  - name: a.com
+    a: [1.1.1.1]
+  - name: www.a.com
+    cname: a.com
+`),
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"www.a.com.\t3600\tIN\tCNAME\ta.com.",
+		"a.com.\t3600\tIN\tA\t1.1.1.1",
+	}, answerStrings(query(s, "www.a.com", dns.TypeA)))
+}
+
+func TestNewSdns_malformedConfigFile(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		content string
+	}{
+		{"syntax", "domains: [name: a.com"},
+		{"unknown key", "domains:\n  - name: a.com\n    lol: 1"},
+		{"missing name", "domains:\n  - a: [1.1.1.1]"},
+		{"ipv6 as a", "domains:\n  - name: a.com\n    a: [\"::1\"]"},
+		{"ipv4 as aaaa", "domains:\n  - name: a.com\n    aaaa: [1.1.1.1]"},
+		{"ip as host", "domains:\n  - name: a.com\n    hosts: [1.1.1.1]"},
+		{"malformed record", "domains:\n  - name: a.com\n    caa: [{flags: 0, tag: is-sue, value: a}]"},
+		{"malformed duration", "domains:\n  - name: a.com\n    check: {type: tcp, port: 80, interval: lol}"},
+		{"missing zone name", "zones:\n  - ns: ns1.a.com"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port:       1053,
+				Recursors:  []string{"127.0.0.1:1"},
+				ConfigFile: writeConfigFile(t, tc.content),
+			})
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := NewSdns(SdnsConfig{
+		Port:       1053,
+		ConfigFile: filepath.Join(t.TempDir(), "missing.yaml"),
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,173 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// DelegationPolicy determines how address questions (A and
+// AAAA) for delegated domains, the ones with nameservers but
+// neither addresses nor an alias, are answered.
+type DelegationPolicy string
+
+const (
+	// DelegationNodata answers NODATA.
+	DelegationNodata DelegationPolicy = "nodata"
+
+	// DelegationReferral answers with a referral to the
+	// nameservers: them in the authority section and, for
+	// the ones that are configured domains, their addresses
+	// (glue) in the additional section.
+	DelegationReferral DelegationPolicy = "referral"
+
+	// DelegationRecurse asks the nameservers themselves
+	// (see SdnsConfig.DelegationPort), their addresses
+	// being resolved like hostnames set as addresses are.
+	DelegationRecurse DelegationPolicy = "recurse"
+)
+
+// DefaultDelegationPort is the port that nameservers are
+// asked on when recursing via them.
+const DefaultDelegationPort = 53
+
+// validateDelegation makes sure that the policy is known,
+// accepting the empty one (i.e.: the default).
+func validateDelegation(policy DelegationPolicy) (err error) {
+	switch policy {
+	case "", DelegationNodata, DelegationReferral, DelegationRecurse:
+	default:
+		err = errors.Errorf("unknown delegation policy %s", policy)
+	}
+
+	return
+}
+
+// delegated tells whether the domain only delegates to its
+// nameservers.
+func (d *Domain) delegated() bool {
+	return len(d.Nameservers) > 0 && len(d.Addresses) == 0 && d.Cname == ""
+}
+
+// delegation tells the delegation policy that applies to
+// an address question (qtype) for a name: the one of the
+// delegated domain it belongs to, if set, or the global
+// one. Other questions are never delegated.
+func (s *Sdns) delegation(name string, qtype uint16) (domain *Domain, policy DelegationPolicy) {
+	if qtype != dns.TypeA && qtype != dns.TypeAAAA {
+		return
+	}
+
+	domain, found := s.findDomain(strings.TrimRight(name, "."))
+	if !found || !domain.delegated() {
+		domain = nil
+		return
+	}
+
+	policy = domain.Delegation
+	if policy == "" {
+		policy = s.delegations
+	}
+
+	return
+}
+
+// answerReferral answers the question for a name of the
+// delegated domain with a referral (see DelegationReferral).
+func (s *Sdns) answerReferral(ctx *SdnsContext, m *dns.Msg, domain *Domain) (err error) {
+	var (
+		name = m.Question[0].Name
+		ttl  = s.answerTTL(domain)
+		rr   dns.RR
+	)
+
+	for _, ns := range s.answerNameservers(domain) {
+		m.Ns = append(m.Ns, &dns.NS{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: ttl},
+			Ns:  dns.Fqdn(ns),
+		})
+
+		glue, found := s.findDomain(strings.ToLower(strings.TrimRight(ns, ".")))
+		if !found || glue.forwarded() {
+			continue
+		}
+
+		// hostnames set as addresses can't be glue.
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			for _, address := range s.answerAddresses(glue, qtype) {
+				if net.ParseIP(address) == nil {
+					continue
+				}
+
+				rr, err = dns.NewRR(fmt.Sprintf("%s %s %s",
+					dns.Fqdn(ns), dns.TypeToString[qtype], address))
+				if err != nil {
+					skipRecord(ctx, address, err)
+					continue
+				}
+
+				rr.Header().Ttl = s.answerTTL(glue)
+				m.Extra = append(m.Extra, rr)
+			}
+		}
+	}
+
+	ctx.logger.Debug().
+		Str("domain", domain.Name).
+		Msg("referred to nameservers")
+
+	err = nil
+	return
+}
+
+// delegatedRecursors resolves the nameservers of the
+// delegated domain into the servers that questions are
+// recursed to (see DelegationRecurse).
+func (s *Sdns) delegatedRecursors(ctx *SdnsContext, domain *Domain) (servers []string, err error) {
+	var addresses []string
+
+	for _, ns := range domain.Nameservers {
+		addresses, err = s.resolveHostname(ctx, ns, dns.TypeA, 0)
+		if err != nil {
+			ctx.logger.Warn().
+				Err(err).
+				Str("nameserver", ns).
+				Msg("couldn't resolve nameserver")
+			continue
+		}
+
+		for _, address := range addresses {
+			servers = append(servers,
+				net.JoinHostPort(address, strconv.Itoa(s.delegationPort)))
+		}
+	}
+
+	err = nil
+	if len(servers) == 0 {
+		err = errors.Errorf(
+			"couldn't resolve any nameserver of domain %s", domain.Name)
+	}
+
+	return
+}
+
+// isReferral tells whether the response refers the client
+// to nameservers instead of answering, in which case it's
+// not authoritative.
+func isReferral(m *dns.Msg) bool {
+	if len(m.Answer) > 0 {
+		return false
+	}
+
+	for _, rr := range m.Ns {
+		if rr.Header().Rrtype == dns.TypeNS {
+			return true
+		}
+	}
+
+	return false
+}
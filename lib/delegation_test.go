@@ -0,0 +1,159 @@
+package lib_test
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_delegation(t *testing.T) {
+	var (
+		recursor = startRecursor(t, answerHandler(0, "7.7.7.7"))
+		_, port  = splitHostPort(t, recursor)
+	)
+
+	var testCases = []struct {
+		name          string
+		global        DelegationPolicy
+		domain        DelegationPolicy
+		answers       []string
+		nameservers   []string
+		glue          []string
+		authoritative bool
+	}{
+		{
+			name:          "default",
+			answers:       []string{},
+			authoritative: true,
+		},
+		{
+			name:          "nodata",
+			domain:        DelegationNodata,
+			answers:       []string{},
+			authoritative: true,
+		},
+		{
+			name:        "referral",
+			domain:      DelegationReferral,
+			answers:     []string{},
+			nameservers: []string{"ns1.example.net.", "ns2.example.org."},
+			glue:        []string{"127.0.0.1"},
+		},
+		{
+			name:        "global referral",
+			global:      DelegationReferral,
+			answers:     []string{},
+			nameservers: []string{"ns1.example.net.", "ns2.example.org."},
+			glue:        []string{"127.0.0.1"},
+		},
+		{
+			name:    "recurse",
+			domain:  DelegationRecurse,
+			answers: []string{"7.7.7.7"},
+		},
+		{
+			name:          "domain overriding the global policy",
+			global:        DelegationRecurse,
+			domain:        DelegationNodata,
+			answers:       []string{},
+			authoritative: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSdns(SdnsConfig{
+				Port:           1232,
+				Recursors:      []string{"127.0.0.1:1"},
+				Delegation:     tc.global,
+				DelegationPort: port,
+				Domains: []*Domain{
+					{
+						Name:        "sub.example.com",
+						Nameservers: []string{"ns1.example.net", "ns2.example.org"},
+						Delegation:  tc.domain,
+					},
+					{Name: "ns1.example.net", Addresses: []string{"127.0.0.1"}},
+				},
+			})
+			assert.NoError(t, err)
+
+			m := query(s, "sub.example.com", dns.TypeA)
+			assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+			assert.Equal(t, tc.answers, answerIPs(m))
+			assert.Equal(t, tc.authoritative, m.Authoritative)
+
+			var nameservers []string
+			for _, rr := range m.Ns {
+				if ns, ok := rr.(*dns.NS); ok {
+					assert.Equal(t, "sub.example.com.", ns.Hdr.Name)
+					nameservers = append(nameservers, ns.Ns)
+				}
+			}
+			assert.Equal(t, tc.nameservers, nameservers)
+
+			var glue []string
+			for _, rr := range m.Extra {
+				if a, ok := rr.(*dns.A); ok {
+					assert.Equal(t, "ns1.example.net.", a.Hdr.Name)
+					glue = append(glue, a.A.String())
+				}
+			}
+			assert.Equal(t, tc.glue, glue)
+		})
+	}
+}
+
+func TestHandle_delegationOtherQuestions(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:       1232,
+		Recursors:  []string{"127.0.0.1:1"},
+		Delegation: DelegationReferral,
+		Domains: []*Domain{
+			{Name: "sub.example.com", Nameservers: []string{"ns1.example.net"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	// the nameservers themselves are still answered.
+	m := query(s, "sub.example.com", dns.TypeNS)
+	assert.True(t, m.Authoritative)
+	assert.Len(t, m.Answer, 1)
+}
+
+func TestNewSdns_malformedDelegation(t *testing.T) {
+	var testCases = []struct {
+		name string
+		cfg  SdnsConfig
+	}{
+		{"global", SdnsConfig{Delegation: "lol"}},
+		{"domain", SdnsConfig{Domains: []*Domain{
+			{Name: "sub.example.com", Nameservers: []string{"ns1.example.net"}, Delegation: "lol"},
+		}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.Port = 1232
+			tc.cfg.Recursors = []string{"127.0.0.1:1"}
+
+			_, err := NewSdns(tc.cfg)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func splitHostPort(t *testing.T, address string) (host string, port int) {
+	host, portString, err := net.SplitHostPort(address)
+	assert.NoError(t, err)
+
+	port, err = strconv.Atoi(portString)
+	assert.NoError(t, err)
+
+	return
+}
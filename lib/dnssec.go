@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// Validator validates the DNSSEC signatures of recursed
+// responses.
+type Validator interface {
+	// Validate tells whether the response could be
+	// validated (secure) or not (insecure), erroring
+	// if the response is bogus.
+	Validate(m *dns.Msg) (validated bool, err error)
+}
+
+// ErrBogusResponse indicates that a recursed response
+// failed DNSSEC validation.
+var ErrBogusResponse = errors.Errorf("Response failed DNSSEC validation")
+
+// authenticate sets the AD (authentic data) bit of the
+// response only if it got validated, regardless of what
+// the recursor said.
+func (s *Sdns) authenticate(ctx *SdnsContext, in *dns.Msg, m *dns.Msg) (err error) {
+	m.AuthenticatedData = false
+
+	if s.validator == nil {
+		return
+	}
+
+	validated, err := s.validator.Validate(in)
+	if err != nil {
+		ctx.logger.Warn().
+			Err(err).
+			Msg("dnssec validation failed")
+		err = ErrBogusResponse
+		return
+	}
+
+	m.AuthenticatedData = validated
+	return
+}
@@ -0,0 +1,121 @@
+package lib_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+type fakeValidator struct {
+	validated bool
+	err       error
+}
+
+func (v *fakeValidator) Validate(m *dns.Msg) (bool, error) {
+	return v.validated, v.err
+}
+
+// authenticHandler answers every question with an A record
+// claiming (via the AD bit) that the data is authentic, and
+// records whether the questions came with the AD bit set.
+func authenticHandler(forwardedAD chan<- bool) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		forwardedAD <- r.AuthenticatedData
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.AuthenticatedData = true
+
+		rr, _ := dns.NewRR(r.Question[0].Name + " A 1.1.1.1")
+		m.Answer = append(m.Answer, rr)
+
+		w.WriteMsg(m)
+	}
+}
+
+func TestHandle_authenticatedData(t *testing.T) {
+	var testCases = []struct {
+		name      string
+		validator Validator
+		ad        bool
+		rcode     int
+	}{
+		{
+			name:      "no validation strips ad",
+			validator: nil,
+			ad:        false,
+			rcode:     dns.RcodeSuccess,
+		},
+		{
+			name:      "insecure response strips ad",
+			validator: &fakeValidator{validated: false},
+			ad:        false,
+			rcode:     dns.RcodeSuccess,
+		},
+		{
+			name:      "validated response sets ad",
+			validator: &fakeValidator{validated: true},
+			ad:        true,
+			rcode:     dns.RcodeSuccess,
+		},
+		{
+			name:      "bogus response fails",
+			validator: &fakeValidator{err: errors.New("bad sig")},
+			ad:        false,
+			rcode:     dns.RcodeServerFailure,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				forwardedAD = make(chan bool, 1)
+				recursor    = startRecursor(t, authenticHandler(forwardedAD))
+				w           = &testWriter{}
+				r           = new(dns.Msg)
+			)
+
+			s, err := NewSdns(SdnsConfig{
+				Port:      1232,
+				Recursors: []string{recursor},
+				Validator: tc.validator,
+			})
+			assert.NoError(t, err)
+
+			r.SetQuestion("example.com.", dns.TypeA)
+			r.AuthenticatedData = true
+			s.ServeDNS(w, r)
+
+			assert.False(t, <-forwardedAD)
+			assert.Equal(t, tc.ad, w.msg.AuthenticatedData)
+			assert.Equal(t, tc.rcode, w.msg.Rcode)
+		})
+	}
+}
+
+func TestHandle_authenticatedDataLocal(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Validator: &fakeValidator{validated: true},
+		Domains: []*Domain{
+			{Name: "a.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	var (
+		w = &testWriter{}
+		r = new(dns.Msg)
+	)
+
+	r.SetQuestion("a.com.", dns.TypeA)
+	r.AuthenticatedData = true
+	s.ServeDNS(w, r)
+
+	assert.False(t, w.msg.AuthenticatedData)
+}
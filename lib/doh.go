@@ -0,0 +1,155 @@
+package lib
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DefaultDoHPath is the path DNS-over-HTTPS is served
+	// on (RFC 8484).
+	DefaultDoHPath = "/dns-query"
+
+	dohMediaType = "application/dns-message"
+)
+
+// dohWriter is a dns.ResponseWriter that keeps the message
+// so that it can be written back as an HTTP response.
+type dohWriter struct {
+	msg    *dns.Msg
+	local  net.Addr
+	remote net.Addr
+}
+
+func (w *dohWriter) LocalAddr() net.Addr  { return w.local }
+func (w *dohWriter) RemoteAddr() net.Addr { return w.remote }
+
+func (w *dohWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *dohWriter) Write(b []byte) (int, error) {
+	w.msg = new(dns.Msg)
+	return len(b), w.msg.Unpack(b)
+}
+
+func (w *dohWriter) Close() error        { return nil }
+func (w *dohWriter) TsigStatus() error   { return nil }
+func (w *dohWriter) TsigTimersOnly(bool) {}
+func (w *dohWriter) Hijack()             {}
+
+// tcpAddr converts the address of an HTTP peer so that it's
+// never taken as an UDP one.
+func tcpAddr(address string) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+
+	return addr
+}
+
+// readDoHQuery extracts the wire format query from either
+// the `dns` parameter (GET) or the body (POST).
+func readDoHQuery(r *http.Request) (packed []byte, status int) {
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		param := r.URL.Query().Get("dns")
+		if param == "" {
+			status = http.StatusBadRequest
+			return
+		}
+
+		packed, err = base64.RawURLEncoding.DecodeString(
+			strings.TrimRight(param, "="))
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohMediaType {
+			status = http.StatusUnsupportedMediaType
+			return
+		}
+
+		packed, err = ioutil.ReadAll(
+			io.LimitReader(r.Body, dns.MaxMsgSize))
+	default:
+		status = http.StatusMethodNotAllowed
+		return
+	}
+
+	if err != nil {
+		status = http.StatusBadRequest
+	}
+
+	return
+}
+
+// DoHHandler serves DNS-over-HTTPS (RFC 8484) queries on
+// the path configured.
+func (s *Sdns) DoHHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.dohPath, func(rw http.ResponseWriter, r *http.Request) {
+		packed, status := readDoHQuery(r)
+		if status != 0 {
+			http.Error(rw, http.StatusText(status), status)
+			return
+		}
+
+		req := new(dns.Msg)
+		err := req.Unpack(packed)
+		if err != nil {
+			s.observeMalformed(err, len(packed), tcpAddr(r.RemoteAddr), "https")
+			http.Error(rw, "malformed dns message", http.StatusBadRequest)
+			return
+		}
+
+		w := &dohWriter{
+			local:  tcpAddr(s.dohAddress),
+			remote: tcpAddr(r.RemoteAddr),
+		}
+
+		s.ServeDNS(w, req)
+		if w.msg == nil {
+			http.Error(rw, "no response", http.StatusInternalServerError)
+			return
+		}
+
+		packed, err = w.msg.Pack()
+		if err != nil {
+			http.Error(rw, "couldn't pack response", http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("Content-Type", dohMediaType)
+		if ttl, ok := minTTL(w.msg); ok {
+			rw.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", ttl))
+		}
+
+		rw.Write(packed)
+	})
+
+	return mux
+}
+
+// minTTL is the smallest TTL among the records of the
+// answer and authority sections.
+func minTTL(m *dns.Msg) (ttl uint32, found bool) {
+	for _, section := range [][]dns.RR{m.Answer, m.Ns} {
+		for _, rr := range section {
+			if !found || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+				found = true
+			}
+		}
+	}
+
+	return
+}
@@ -0,0 +1,151 @@
+package lib_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestDoHHandler(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("foo.com.", dns.TypeA)
+
+	packed, err := r.Pack()
+	assert.NoError(t, err)
+
+	var (
+		encoded = base64.RawURLEncoding.EncodeToString(packed)
+		padded  = base64.URLEncoding.EncodeToString(packed)
+	)
+
+	var testCases = []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        []byte
+		status      int
+	}{
+		{
+			name:   "get",
+			method: "GET",
+			target: "/dns-query?dns=" + encoded,
+			status: http.StatusOK,
+		},
+		{
+			name:   "get with padding",
+			method: "GET",
+			target: "/dns-query?dns=" + padded,
+			status: http.StatusOK,
+		},
+		{
+			name:        "post",
+			method:      "POST",
+			target:      "/dns-query",
+			contentType: "application/dns-message",
+			body:        packed,
+			status:      http.StatusOK,
+		},
+		{
+			name:   "get without query",
+			method: "GET",
+			target: "/dns-query",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "get with malformed query",
+			method: "GET",
+			target: "/dns-query?dns=AAAA",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:        "post with wrong media type",
+			method:      "POST",
+			target:      "/dns-query",
+			contentType: "text/plain",
+			body:        packed,
+			status:      http.StatusUnsupportedMediaType,
+		},
+		{
+			name:   "unsupported method",
+			method: "PUT",
+			target: "/dns-query",
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "other path",
+			method: "GET",
+			target: "/lol?dns=" + encoded,
+			status: http.StatusNotFound,
+		},
+	}
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				w   = httptest.NewRecorder()
+				req = httptest.NewRequest(tc.method, tc.target,
+					bytes.NewReader(tc.body))
+			)
+
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+
+			s.DoHHandler().ServeHTTP(w, req)
+
+			res := w.Result()
+			assert.Equal(t, tc.status, res.StatusCode)
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, "application/dns-message",
+				res.Header.Get("Content-Type"))
+			assert.Equal(t, "max-age=3600",
+				res.Header.Get("Cache-Control"))
+
+			body, err := ioutil.ReadAll(res.Body)
+			assert.NoError(t, err)
+
+			m := new(dns.Msg)
+			assert.NoError(t, m.Unpack(body))
+			assert.Equal(t, r.Id, m.Id)
+			assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+		})
+	}
+}
+
+func TestDoHHandler_path(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{"127.0.0.1:1"},
+		DoHPath:   "/resolve",
+	})
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	s.DoHHandler().ServeHTTP(w, httptest.NewRequest("GET", "/resolve", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+
+	w = httptest.NewRecorder()
+	s.DoHHandler().ServeHTTP(w, httptest.NewRequest("GET", "/dns-query", nil))
+	assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+}
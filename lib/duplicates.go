@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DuplicatePolicy determines how domains sharing a name
+// (case-insensitively) are loaded.
+type DuplicatePolicy string
+
+const (
+	// DuplicatesLast loads the last of them, in the order
+	// they're configured.
+	DuplicatesLast DuplicatePolicy = "last"
+
+	// DuplicatesReject fails loading them.
+	DuplicatesReject DuplicatePolicy = "reject"
+
+	// DuplicatesMerge loads them as a single domain with
+	// the records of all of them (see mergeDomains).
+	DuplicatesMerge DuplicatePolicy = "merge"
+)
+
+// validateDuplicatePolicy makes sure that the policy is
+// known, accepting the empty one (i.e.: the default).
+func validateDuplicatePolicy(policy DuplicatePolicy) (err error) {
+	switch policy {
+	case "", DuplicatesLast, DuplicatesReject, DuplicatesMerge:
+	default:
+		err = errors.Errorf("unknown duplicate policy %s", policy)
+	}
+
+	return
+}
+
+// dedupeDomains handles the domains that share a name
+// according to the policy, logging a warning for each.
+// Domains that don't are kept as they are, in order.
+func (s *Sdns) dedupeDomains(domains []*Domain, policy DuplicatePolicy) (deduped []*Domain, err error) {
+	var byName = make(map[string]int, len(domains))
+
+	deduped = make([]*Domain, 0, len(domains))
+	for _, domain := range domains {
+		key := strings.ToLower(domain.Name)
+
+		idx, found := byName[key]
+		if !found {
+			byName[key] = len(deduped)
+			deduped = append(deduped, domain)
+			continue
+		}
+
+		s.logger.Warn().
+			Str("domain", domain.Name).
+			Str("policy", string(policy)).
+			Msg("duplicate domain")
+
+		switch policy {
+		case DuplicatesReject:
+			err = errors.Errorf("duplicate domain %s", domain.Name)
+			return
+		case DuplicatesMerge:
+			deduped[idx], err = mergeDomains(deduped[idx], domain)
+			if err != nil {
+				return
+			}
+		default:
+			deduped[idx] = domain
+		}
+	}
+
+	return
+}
+
+// orderedFields are the lists of Domain whose order
+// matters, which can't be joined when merging domains.
+var orderedFields = map[string]bool{
+	"Fallback":  true,
+	"Recursors": true,
+}
+
+// mergeDomains creates a domain with the records of both:
+// lists (e.g.: Addresses) are joined, leaving out the
+// entries already present, while the other settings
+// (including the lists in orderedFields) are taken from
+// whichever sets them, failing if both do with different
+// values. Neither domain is changed.
+func mergeDomains(a, b *Domain) (merged *Domain, err error) {
+	merged = &Domain{Name: a.Name}
+
+	var (
+		from = []reflect.Value{
+			reflect.ValueOf(a).Elem(),
+			reflect.ValueOf(b).Elem(),
+		}
+		to     = reflect.ValueOf(merged).Elem()
+		fields = to.Type()
+	)
+
+	for idx := 0; idx < fields.NumField(); idx++ {
+		field := to.Field(idx)
+		if !field.CanSet() || fields.Field(idx).Name == "Name" {
+			continue
+		}
+
+		for _, domain := range from {
+			value := domain.Field(idx)
+
+			switch {
+			case value.IsZero():
+			case field.Kind() == reflect.Slice && !orderedFields[fields.Field(idx).Name]:
+				field.Set(union(field, value))
+			case field.IsZero():
+				field.Set(value)
+			case !reflect.DeepEqual(field.Interface(), value.Interface()):
+				err = errors.Errorf(
+					"conflicting %s for duplicate domain %s",
+					fields.Field(idx).Name, a.Name)
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// union appends to a slice the entries of another that it
+// doesn't have.
+func union(slice, other reflect.Value) reflect.Value {
+	for idx := 0; idx < other.Len(); idx++ {
+		var (
+			entry   = other.Index(idx)
+			present bool
+		)
+
+		for existing := 0; existing < slice.Len() && !present; existing++ {
+			present = reflect.DeepEqual(slice.Index(existing).Interface(), entry.Interface())
+		}
+
+		if !present {
+			slice = reflect.Append(slice, entry)
+		}
+	}
+
+	return slice
+}
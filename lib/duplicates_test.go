@@ -0,0 +1,133 @@
+package lib_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestLoad_duplicates(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		policy      DuplicatePolicy
+		domains     []*Domain
+		answers     []string
+		shouldError bool
+	}{
+		{
+			name:   "last by default",
+			policy: "",
+			domains: []*Domain{
+				{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+				{Name: "FOO.com", Addresses: []string{"2.2.2.2"}},
+			},
+			answers: []string{"2.2.2.2"},
+		},
+		{
+			name:   "last",
+			policy: DuplicatesLast,
+			domains: []*Domain{
+				{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+				{Name: "foo.com", Addresses: []string{"2.2.2.2"}},
+			},
+			answers: []string{"2.2.2.2"},
+		},
+		{
+			name:   "reject",
+			policy: DuplicatesReject,
+			domains: []*Domain{
+				{Name: "*.foo.com", Addresses: []string{"1.1.1.1"}},
+				{Name: "*.foo.com", Addresses: []string{"2.2.2.2"}},
+			},
+			shouldError: true,
+		},
+		{
+			name:   "merge conflicting settings",
+			policy: DuplicatesMerge,
+			domains: []*Domain{
+				{Name: "foo.com", Addresses: []string{"1.1.1.1"}, Cname: "bar.com"},
+				{Name: "FOO.com", Addresses: []string{"2.2.2.2"}, Cname: "baz.com"},
+			},
+			shouldError: true,
+		},
+		{
+			name:   "merge conflicting fallbacks",
+			policy: DuplicatesMerge,
+			domains: []*Domain{
+				{Name: "foo.com", Addresses: []string{"1.1.1.1"}, Fallback: []string{"recurse", "nxdomain"}},
+				{Name: "FOO.com", Addresses: []string{"2.2.2.2"}, Fallback: []string{"nxdomain"}},
+			},
+			shouldError: true,
+		},
+		{
+			name:   "unknown",
+			policy: "lol",
+			domains: []*Domain{
+				{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+			},
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+
+			s, err := NewSdns(SdnsConfig{
+				Port:            1232,
+				Recursors:       []string{"127.0.0.1:1"},
+				DuplicatePolicy: tc.policy,
+				LogOutput:       &logs,
+				Domains:         tc.domains,
+			})
+
+			if len(tc.domains) > 1 {
+				assert.True(t, strings.Contains(logs.String(), "duplicate domain"))
+			}
+
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.answers, answerIPs(query(s, "foo.com", dns.TypeA)))
+		})
+	}
+}
+
+func TestLoad_duplicatesMerged(t *testing.T) {
+	var (
+		logs    bytes.Buffer
+		first   = &Domain{Name: "foo.com", Addresses: []string{"1.1.1.1"}, AddressMode: AddressesAll}
+		second  = &Domain{Name: "FOO.com", Addresses: []string{"1.1.1.1", "2.2.2.2"}, MailExchangers: []string{"10 mx.foo.com"}}
+		domains = []*Domain{first, {Name: "bar.com", Addresses: []string{"3.3.3.3"}}, second}
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:            1232,
+		Recursors:       []string{"127.0.0.1:1"},
+		DuplicatePolicy: DuplicatesMerge,
+		LogOutput:       &logs,
+		Domains:         domains,
+	})
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(logs.String(), "duplicate domain"))
+	assert.True(t, strings.Contains(logs.String(), "FOO.com"))
+
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2"}, answerIPs(query(s, "foo.com", dns.TypeA)))
+	assert.Equal(t, []string{"foo.com.\t3600\tIN\tMX\t10 mx.foo.com."},
+		answerStrings(query(s, "foo.com", dns.TypeMX)))
+	assert.Equal(t, []string{"3.3.3.3"}, answerIPs(query(s, "bar.com", dns.TypeA)))
+
+	// loaded as a single domain, leaving the configured
+	// ones alone.
+	assert.Len(t, s.Domains(), 2)
+	assert.Equal(t, []string{"1.1.1.1"}, first.Addresses)
+	assert.Empty(t, first.MailExchangers)
+}
@@ -0,0 +1,151 @@
+package lib
+
+import (
+	"encoding/hex"
+
+	"github.com/miekg/dns"
+)
+
+// requestedOption looks for an EDNS0 option in the OPT
+// record of a message.
+func requestedOption(r *dns.Msg, code uint16) (option dns.EDNS0, found bool) {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return
+	}
+
+	for _, option = range opt.Option {
+		if option.Option() == code {
+			found = true
+			return
+		}
+	}
+
+	option = nil
+	return
+}
+
+// ednsUDPSize is the UDP payload size sdns advertises to
+// both clients and recursors.
+const ednsUDPSize = dns.DefaultMsgSize
+
+// replyOPT retrieves the OPT record of the response,
+// creating one (with the DO bit of the request's) if
+// needed.
+func replyOPT(r *dns.Msg, m *dns.Msg) *dns.OPT {
+	opt := m.IsEdns0()
+	if opt != nil {
+		return opt
+	}
+
+	m.SetEdns0(ednsUDPSize, r.IsEdns0().Do())
+
+	return m.IsEdns0()
+}
+
+// answerEDNS adds an OPT record to the response whenever
+// the client speaks EDNS (RFC 6891). DNSSEC OK is echoed
+// even though sdns doesn't sign its own records.
+func answerEDNS(r *dns.Msg, m *dns.Msg) {
+	if r.IsEdns0() == nil {
+		return
+	}
+
+	replyOPT(r, m)
+}
+
+// recursionEDNS makes the question sent to recursors
+// advertise a large UDP payload, so that large responses
+// don't need to be retried over TCP, carrying the DO bit
+// of the client.
+func recursionEDNS(r *dns.Msg, rm *dns.Msg) {
+	var do bool
+	if r != nil && r.IsEdns0() != nil {
+		do = r.IsEdns0().Do()
+	}
+
+	rm.SetEdns0(ednsUDPSize, do)
+}
+
+// answerNSID identifies this instance in the response
+// whenever the client asks for it (RFC 5001).
+func (s *Sdns) answerNSID(r *dns.Msg, m *dns.Msg) {
+	_, requested := requestedOption(r, dns.EDNS0NSID)
+	if !requested {
+		return
+	}
+
+	opt := replyOPT(r, m)
+	opt.Option = append(opt.Option, &dns.EDNS0_NSID{
+		Code: dns.EDNS0NSID,
+		Nsid: hex.EncodeToString([]byte(s.nsid)),
+	})
+}
+
+// passthroughOptions picks the options of an OPT record
+// whose codes are allowed to pass through sdns.
+func (s *Sdns) passthroughOptions(opt *dns.OPT) (options []dns.EDNS0) {
+	if opt == nil {
+		return
+	}
+
+	for _, option := range opt.Option {
+		if s.passthrough[option.Option()] {
+			options = append(options, option)
+		}
+	}
+
+	return
+}
+
+// forwardOptions adds to the OPT record of the question
+// sent to a recursor the options of the request that are
+// allowed to pass through.
+func (s *Sdns) forwardOptions(r *dns.Msg, rm *dns.Msg) {
+	if r == nil {
+		return
+	}
+
+	options := s.passthroughOptions(r.IsEdns0())
+	if len(options) == 0 {
+		return
+	}
+
+	opt := rm.IsEdns0()
+	opt.Option = append(opt.Option, options...)
+}
+
+// echoOptions adds to the response the options the
+// recursor answered with that are allowed to pass through,
+// as long as the client speaks EDNS.
+func (s *Sdns) echoOptions(r *dns.Msg, in *dns.Msg, m *dns.Msg) {
+	if r.IsEdns0() == nil {
+		return
+	}
+
+	options := s.passthroughOptions(in.IsEdns0())
+	if len(options) == 0 {
+		return
+	}
+
+	opt := replyOPT(r, m)
+	opt.Option = append(opt.Option, options...)
+}
+
+// supportedEDNSVersion is the only EDNS version sdns speaks.
+const supportedEDNSVersion = 0
+
+// rejectsEDNSVersion tells whether the request must be
+// answered with BADVERS as it advertises an EDNS version
+// that sdns doesn't support (RFC 6891).
+func (s *Sdns) rejectsEDNSVersion(ctx *SdnsContext, r *dns.Msg) bool {
+	opt := r.IsEdns0()
+	if opt == nil || s.ignoreEDNSVersion || opt.Version() == supportedEDNSVersion {
+		return false
+	}
+
+	ctx.logger.Warn().
+		Uint8("version", opt.Version()).
+		Msg("unsupported edns version")
+	return true
+}
@@ -0,0 +1,308 @@
+package lib_test
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_edns(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{Name: "a.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name    string
+		edns    bool
+		udpSize uint16
+		do      bool
+	}{
+		{name: "no edns", edns: false},
+		{name: "edns", edns: true, udpSize: 4096},
+		{name: "edns with small buffer", edns: true, udpSize: 512},
+		{name: "edns with dnssec ok", edns: true, udpSize: 4096, do: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				w = &testWriter{}
+				r = new(dns.Msg)
+			)
+
+			r.SetQuestion("a.com.", dns.TypeA)
+			if tc.edns {
+				r.SetEdns0(tc.udpSize, tc.do)
+			}
+
+			s.ServeDNS(w, r)
+
+			assert.Equal(t, dns.RcodeSuccess, w.msg.Rcode)
+			assert.Equal(t, []string{"1.1.1.1"}, answerIPs(w.msg))
+
+			opt := w.msg.IsEdns0()
+			if !tc.edns {
+				assert.Nil(t, opt)
+				return
+			}
+
+			assert.NotNil(t, opt)
+			assert.Equal(t, uint16(dns.DefaultMsgSize), opt.UDPSize())
+			assert.Equal(t, tc.do, opt.Do())
+		})
+	}
+}
+
+func TestHandle_nsid(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port: 1232,
+		NSID: "sdns-1",
+		Domains: []*Domain{
+			{Name: "a.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name      string
+		edns      bool
+		requested bool
+	}{
+		{name: "no edns", edns: false, requested: false},
+		{name: "edns without nsid", edns: true, requested: false},
+		{name: "edns with nsid", edns: true, requested: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				w = &testWriter{}
+				r = new(dns.Msg)
+			)
+
+			r.SetQuestion("a.com.", dns.TypeA)
+			if tc.edns {
+				r.SetEdns0(4096, false)
+			}
+			if tc.requested {
+				opt := r.IsEdns0()
+				opt.Option = append(opt.Option,
+					&dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+			}
+
+			s.ServeDNS(w, r)
+
+			opt := w.msg.IsEdns0()
+			if !tc.edns {
+				assert.Nil(t, opt)
+				return
+			}
+
+			assert.NotNil(t, opt)
+			if !tc.requested {
+				assert.Empty(t, opt.Option)
+				return
+			}
+
+			assert.Len(t, opt.Option, 1)
+			nsid, ok := opt.Option[0].(*dns.EDNS0_NSID)
+			assert.True(t, ok)
+			assert.Equal(t, hex.EncodeToString([]byte("sdns-1")), nsid.Nsid)
+		})
+	}
+}
+
+// optionCodes lists the codes of the EDNS options of a
+// message.
+func optionCodes(m *dns.Msg) (codes []uint16) {
+	codes = []uint16{}
+
+	opt := m.IsEdns0()
+	if opt == nil {
+		return
+	}
+
+	for _, option := range opt.Option {
+		codes = append(codes, option.Option())
+	}
+
+	return
+}
+
+// optionsHandler reports the codes of the options it gets
+// and answers with the options given.
+func optionsHandler(received chan<- []uint16, options ...dns.EDNS0) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		received <- optionCodes(r)
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.SetEdns0(4096, false)
+		m.IsEdns0().Option = options
+
+		rr, _ := dns.NewRR(r.Question[0].Name + " A 1.1.1.1")
+		m.Answer = append(m.Answer, rr)
+
+		w.WriteMsg(m)
+	}
+}
+
+func TestHandle_ednsPassthrough(t *testing.T) {
+	const (
+		allowed  uint16 = 65001
+		stripped uint16 = 65002
+	)
+
+	var (
+		received = make(chan []uint16, 1)
+		recursor = startRecursor(t, optionsHandler(received,
+			&dns.EDNS0_LOCAL{Code: allowed, Data: []byte("from-upstream")},
+			&dns.EDNS0_LOCAL{Code: stripped, Data: []byte("from-upstream")},
+		))
+		w = &testWriter{}
+		r = new(dns.Msg)
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:            1232,
+		Recursors:       []string{recursor},
+		EDNSPassthrough: []uint16{allowed},
+	})
+	assert.NoError(t, err)
+
+	r.SetQuestion("foo.com.", dns.TypeA)
+	r.SetEdns0(4096, false)
+	r.IsEdns0().Option = []dns.EDNS0{
+		&dns.EDNS0_LOCAL{Code: allowed, Data: []byte("from-client")},
+		&dns.EDNS0_LOCAL{Code: stripped, Data: []byte("from-client")},
+	}
+
+	s.ServeDNS(w, r)
+
+	assert.Equal(t, []uint16{allowed}, <-received)
+	assert.Equal(t, []uint16{allowed}, optionCodes(w.msg))
+
+	local, ok := w.msg.IsEdns0().Option[0].(*dns.EDNS0_LOCAL)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("from-upstream"), local.Data)
+}
+
+func TestHandle_ednsPassthroughDisabled(t *testing.T) {
+	var (
+		received = make(chan []uint16, 1)
+		recursor = startRecursor(t, optionsHandler(received,
+			&dns.EDNS0_LOCAL{Code: 65001, Data: []byte("from-upstream")},
+		))
+		w = &testWriter{}
+		r = new(dns.Msg)
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{recursor},
+	})
+	assert.NoError(t, err)
+
+	r.SetQuestion("foo.com.", dns.TypeA)
+	r.SetEdns0(4096, false)
+	r.IsEdns0().Option = []dns.EDNS0{
+		&dns.EDNS0_LOCAL{Code: 65001, Data: []byte("from-client")},
+	}
+
+	s.ServeDNS(w, r)
+
+	assert.Equal(t, []uint16{}, <-received)
+	assert.Equal(t, []uint16{}, optionCodes(w.msg))
+}
+
+func TestHandle_ednsLargeRecursedResponse(t *testing.T) {
+	var ips []string
+	for i := 1; i <= 40; i++ {
+		ips = append(ips, fmt.Sprintf("10.0.0.%d", i))
+	}
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{startRecursor(t, answerHandler(0, ips...))},
+	})
+	assert.NoError(t, err)
+
+	var (
+		w = &testWriter{}
+		r = new(dns.Msg)
+	)
+
+	r.SetQuestion("big.com.", dns.TypeA)
+	r.SetEdns0(4096, false)
+	s.ServeDNS(w, r)
+
+	assert.False(t, w.msg.Truncated)
+	assert.Equal(t, ips, answerIPs(w.msg))
+}
+
+func TestHandle_ednsVersion(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		version uint8
+		ignore  bool
+		rcode   int
+	}{
+		{name: "supported version", version: 0, rcode: dns.RcodeSuccess},
+		{name: "unsupported version", version: 1, rcode: dns.RcodeBadVers},
+		{name: "unsupported version ignored", version: 1, ignore: true, rcode: dns.RcodeSuccess},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSdns(SdnsConfig{
+				Port:              1232,
+				Recursors:         []string{"127.0.0.1:1"},
+				IgnoreEDNSVersion: tc.ignore,
+				Domains: []*Domain{
+					{Name: "a.com", Addresses: []string{"1.1.1.1"}},
+				},
+			})
+			assert.NoError(t, err)
+
+			var (
+				w = &testWriter{}
+				r = new(dns.Msg)
+			)
+
+			r.SetQuestion("a.com.", dns.TypeA)
+			r.SetEdns0(4096, false)
+			r.IsEdns0().SetVersion(tc.version)
+			s.ServeDNS(w, r)
+
+			// go through the wire format as the extended
+			// rcode is split between the header and the OPT.
+			packed, err := w.msg.Pack()
+			assert.NoError(t, err)
+
+			m := new(dns.Msg)
+			assert.NoError(t, m.Unpack(packed))
+			assert.Equal(t, tc.rcode, m.Rcode)
+
+			opt := m.IsEdns0()
+			if assert.NotNil(t, opt) {
+				assert.Equal(t, uint8(0), opt.Version())
+			}
+
+			if tc.rcode == dns.RcodeBadVers {
+				assert.Empty(t, m.Answer)
+			} else {
+				assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Decision tells how sdns went about answering a query.
+type Decision string
+
+const (
+	// DecisionLocal answered with the records of the
+	// domains (including canned responses).
+	DecisionLocal Decision = "local"
+
+	// DecisionRecursed answered with the response of a
+	// recursor.
+	DecisionRecursed Decision = "recursed"
+
+	// DecisionCached answered with a response of a
+	// recursor found in the cache.
+	DecisionCached Decision = "cached"
+
+	// DecisionRejected didn't answer on purpose (e.g.: the
+	// query was throttled or came over a transport that
+	// isn't allowed).
+	DecisionRejected Decision = "rejected"
+
+	// DecisionFailed couldn't answer (i.e.: SERVFAIL).
+	DecisionFailed Decision = "failed"
+)
+
+// QueryEvent describes a query that sdns handled, as
+// published to SdnsConfig.EventSink.
+type QueryEvent struct {
+	// Time is when the query was handled.
+	Time time.Time
+
+	// Name and Qtype are the ones of the question (empty
+	// when the query had none).
+	Name  string
+	Qtype uint16
+
+	// Client is the IP the query came from.
+	Client net.IP
+
+	Decision Decision
+
+	// Latency is the time it took to handle the query,
+	// including writing the response.
+	Latency time.Duration
+}
+
+// publishEvent sends the event of a query started at start
+// to the event sink, if any, dropping it if the sink isn't
+// ready to take it.
+func (s *Sdns) publishEvent(ctx *SdnsContext, r *dns.Msg, start time.Time) {
+	if s.events == nil {
+		return
+	}
+
+	event := QueryEvent{
+		Time:     s.now(),
+		Client:   ctx.client,
+		Decision: ctx.decision,
+		Latency:  time.Since(start),
+	}
+
+	if len(r.Question) > 0 {
+		event.Name = r.Question[0].Name
+		event.Qtype = r.Question[0].Qtype
+	}
+
+	select {
+	case s.events <- event:
+	default:
+		s.metrics.droppedEvents.Inc()
+		ctx.logger.Debug().
+			Msg("event sink full - event dropped")
+	}
+}
@@ -0,0 +1,95 @@
+package lib_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_events(t *testing.T) {
+	var (
+		now      = time.Unix(1000, 0)
+		sink     = make(chan QueryEvent, 10)
+		recursor = startRecursor(t, answerHandler(0, "7.7.7.7"))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{recursor},
+		Cache:     true,
+		EventSink: sink,
+		Clock:     func() time.Time { return now },
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+			{Name: "tcp.com", Addresses: []string{"1.1.1.1"}, Transport: TransportTCPOnly},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name     string
+		qtype    uint16
+		decision Decision
+	}{
+		{"foo.com", dns.TypeA, DecisionLocal},
+		{"bar.com", dns.TypeA, DecisionRecursed},
+		{"bar.com", dns.TypeA, DecisionCached},
+		{"tcp.com", dns.TypeAAAA, DecisionRejected},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name+" "+string(tc.decision), func(t *testing.T) {
+			query(s, tc.name, tc.qtype)
+
+			select {
+			case event := <-sink:
+				assert.Equal(t, now, event.Time)
+				assert.Equal(t, dns.Fqdn(tc.name), event.Name)
+				assert.Equal(t, tc.qtype, event.Qtype)
+				assert.True(t, net.IPv4(127, 0, 0, 1).Equal(event.Client))
+				assert.Equal(t, tc.decision, event.Decision)
+				assert.True(t, event.Latency > 0)
+			case <-time.After(time.Second):
+				t.Fatal("no event published")
+			}
+		})
+	}
+}
+
+func TestHandle_eventsDropped(t *testing.T) {
+	sink := make(chan QueryEvent)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		EventSink: sink,
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	// answered regardless of nobody taking the events.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.Equal(t, []string{"1.1.1.1"}, answerIPs(query(s, "foo.com", dns.TypeA)))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("query stalled by the event sink")
+	}
+
+	select {
+	case <-sink:
+		t.Fatal("event published late")
+	default:
+	}
+}
@@ -0,0 +1,25 @@
+package lib
+
+import (
+	"context"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DelayResponse exposes delayResponse to the tests.
+func (s *Sdns) DelayResponse(ctx context.Context, m *dns.Msg) error {
+	return s.delayResponse(ctx, m)
+}
+
+// RateLimitedClients tells how many clients are being rate
+// limited.
+func (s *Sdns) RateLimitedClients() int {
+	return s.limiter.len()
+}
+
+// SweepClients exposes the sweeping of the rate limiter to
+// the tests.
+func (s *Sdns) SweepClients(now time.Time) {
+	s.limiter.sweep(now)
+}
@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// Feature is a behavior that clients can turn on for their
+// queries only (see SdnsConfig.FeatureOption), e.g.: to try
+// it out on part of the traffic before rolling it out.
+type Feature string
+
+const (
+	// FeatureMinimalResponses answers the query with a
+	// minimal response (see SdnsConfig.MinimalResponses).
+	FeatureMinimalResponses Feature = "minimal-responses"
+
+	// FeatureNoCache recurses the query without going
+	// through the response cache.
+	FeatureNoCache Feature = "no-cache"
+)
+
+// validateFeatureOption makes sure that the option code is
+// one of the EDNS local/experimental ones (RFC 6891) that
+// sdns doesn't use already, accepting zero (i.e.: disabled).
+func validateFeatureOption(code uint16) (err error) {
+	switch {
+	case code == 0:
+	case code < dns.EDNS0LOCALSTART || code > dns.EDNS0LOCALEND:
+		err = errors.Errorf(
+			"feature option %d isn't a local option (%d-%d)",
+			code, dns.EDNS0LOCALSTART, dns.EDNS0LOCALEND)
+	case code == TraceOptionCode:
+		err = errors.Errorf(
+			"feature option %d is the trace one", code)
+	}
+
+	return
+}
+
+// requestedFeatures parses the features that the request
+// turns on: a comma-separated list of names in the data of
+// the feature option. Unknown names are kept as well so
+// that clients can flag features ahead of sdns knowing
+// them.
+func (s *Sdns) requestedFeatures(r *dns.Msg) (features map[Feature]bool) {
+	if s.featureOption == 0 {
+		return
+	}
+
+	option, found := requestedOption(r, s.featureOption)
+	if !found {
+		return
+	}
+
+	local, ok := option.(*dns.EDNS0_LOCAL)
+	if !ok {
+		return
+	}
+
+	for _, name := range strings.Split(string(local.Data), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if features == nil {
+			features = make(map[Feature]bool)
+		}
+		features[Feature(strings.ToLower(name))] = true
+	}
+
+	return
+}
+
+// enabled tells whether the query turned the feature on.
+func (ctx *SdnsContext) enabled(feature Feature) bool {
+	return ctx.flags[feature]
+}
+
+// featureNames lists the features turned on, for logging.
+func (ctx *SdnsContext) featureNames() (names []string) {
+	for feature := range ctx.flags {
+		names = append(names, string(feature))
+	}
+
+	sort.Strings(names)
+	return
+}
@@ -0,0 +1,109 @@
+package lib_test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+const featureOption uint16 = 65300
+
+// featureQuery queries sdns with the features listed in the
+// data of an EDNS local option (none if code is zero).
+func featureQuery(s *Sdns, name string, code uint16, features string) *dns.Msg {
+	var (
+		w = &testWriter{}
+		r = new(dns.Msg)
+	)
+
+	r.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	if code != 0 {
+		r.SetEdns0(dns.DefaultMsgSize, false)
+		opt := r.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{
+			Code: code,
+			Data: []byte(features),
+		})
+	}
+
+	s.ServeDNS(w, r)
+	return w.msg
+}
+
+func TestHandle_featureMinimalResponses(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:          1232,
+		Recursors:     []string{startRecursor(t, richHandler())},
+		FeatureOption: featureOption,
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name      string
+		code      uint16
+		features  string
+		authority int
+	}{
+		{name: "no option", authority: 1},
+		{name: "flagged", code: featureOption, features: "minimal-responses", authority: 0},
+		{name: "flagged among others", code: featureOption, features: "lol, MINIMAL-RESPONSES", authority: 0},
+		{name: "other features", code: featureOption, features: "no-cache", authority: 1},
+		{name: "other option", code: featureOption + 1, features: "minimal-responses", authority: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := featureQuery(s, "example.com", tc.code, tc.features)
+			assert.Len(t, m.Answer, 1)
+			assert.Len(t, m.Ns, tc.authority)
+		})
+	}
+}
+
+func TestHandle_featureNoCache(t *testing.T) {
+	var received int32
+
+	s, err := NewSdns(SdnsConfig{
+		Port:          1232,
+		Recursors:     []string{startRecursor(t, countingHandler(&received, answerHandler(0, "1.1.1.1")))},
+		Cache:         true,
+		FeatureOption: featureOption,
+	})
+	assert.NoError(t, err)
+
+	m := featureQuery(s, "example.com", 0, "")
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+
+	m = featureQuery(s, "example.com", 0, "")
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+	m = featureQuery(s, "example.com", featureOption, "no-cache")
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+}
+
+func TestHandle_featuresDisabled(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{startRecursor(t, richHandler())},
+	})
+	assert.NoError(t, err)
+
+	m := featureQuery(s, "example.com", featureOption, "minimal-responses")
+	assert.Len(t, m.Ns, 1)
+}
+
+func TestNewSdns_malformedFeatureOption(t *testing.T) {
+	for _, code := range []uint16{1, 8, TraceOptionCode, 65535} {
+		_, err := NewSdns(SdnsConfig{
+			Port:          1232,
+			FeatureOption: code,
+		})
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// recursorState tracks the recent failures of a recursor.
+type recursorState struct {
+	failures  int
+	downUntil time.Time
+}
+
+// recursorHealth tracks the health of recursors: after
+// `threshold` consecutive failures a recursor is marked down
+// and skipped for `cooldown`, after which it's probed again
+// (once, holding other questions until the probe is done).
+type recursorHealth struct {
+	sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+	recursors map[string]*recursorState
+}
+
+func newRecursorHealth(threshold int, cooldown time.Duration, now func() time.Time) *recursorHealth {
+	return &recursorHealth{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       now,
+		recursors: make(map[string]*recursorState),
+	}
+}
+
+// order tells the recursors to try, in the order they're
+// configured, skipping the ones that are down - unless all
+// of them are, in which case they're all tried as a last
+// resort. Recursors due to be probed keep their position
+// so that, once recovered, they're preferred again.
+func (h *recursorHealth) order(recursors []string) (ordered []string) {
+	if h.threshold <= 0 {
+		ordered = recursors
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	now := h.now()
+
+	ordered = make([]string, 0, len(recursors))
+	for _, recursor := range recursors {
+		state, found := h.recursors[recursor]
+		if found && state.failures >= h.threshold {
+			if now.Before(state.downUntil) {
+				continue
+			}
+
+			// probing: hold the other questions until
+			// it's done.
+			state.downUntil = now.Add(h.cooldown)
+		}
+
+		ordered = append(ordered, recursor)
+	}
+
+	if len(ordered) == 0 {
+		ordered = recursors
+	}
+
+	return
+}
+
+// success marks the recursor as healthy.
+func (h *recursorHealth) success(recursor string) {
+	if h.threshold <= 0 {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	delete(h.recursors, recursor)
+}
+
+// failure records a failed exchange with the recursor,
+// marking it down once the threshold is reached.
+func (h *recursorHealth) failure(recursor string) {
+	if h.threshold <= 0 {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	state, found := h.recursors[recursor]
+	if !found {
+		state = &recursorState{}
+		h.recursors[recursor] = state
+	}
+
+	state.failures++
+	if state.failures >= h.threshold {
+		state.downUntil = h.now().Add(h.cooldown)
+	}
+}
+
+// reset forgets about every failure.
+func (h *recursorHealth) reset() {
+	h.Lock()
+	defer h.Unlock()
+
+	h.recursors = make(map[string]*recursorState)
+}
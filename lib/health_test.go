@@ -0,0 +1,94 @@
+package lib_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// droppingHandler drops every question (so that exchanges
+// time out) while healthy is unset and answers with the
+// address otherwise, counting the questions it receives.
+func droppingHandler(healthy *int32, received *int32, ip string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddInt32(received, 1)
+		if atomic.LoadInt32(healthy) == 0 {
+			return
+		}
+
+		answerHandler(0, ip)(w, r)
+	}
+}
+
+func TestHandle_recursorHealth(t *testing.T) {
+	var (
+		healthy, received int32
+		now               = time.Unix(1000, 0)
+		flaky             = startRecursor(t, droppingHandler(&healthy, &received, "1.1.1.1"))
+		backup            = startRecursor(t, answerHandler(0, "2.2.2.2"))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:             1053,
+		Recursors:        []string{flaky, backup},
+		ReadTimeout:      50 * time.Millisecond,
+		RecursorFailures: 2,
+		RecursorCooldown: 30 * time.Second,
+		Clock:            func() time.Time { return now },
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		m := query(s, "foo.com", dns.TypeA)
+		assert.Equal(t, []string{"2.2.2.2"}, answerIPs(m))
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+
+	// down: skipped in favor of the healthy one.
+	m := query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"2.2.2.2"}, answerIPs(m))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+
+	// after the cooldown it gets probed and, recovered,
+	// preferred again.
+	atomic.StoreInt32(&healthy, 1)
+	now = now.Add(31 * time.Second)
+
+	for i := 0; i < 2; i++ {
+		m = query(s, "foo.com", dns.TypeA)
+		assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&received))
+}
+
+func TestHandle_allRecursorsDown(t *testing.T) {
+	var (
+		healthy, received int32
+		recursor          = startRecursor(t, droppingHandler(&healthy, &received, "1.1.1.1"))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:             1053,
+		Recursors:        []string{recursor},
+		ReadTimeout:      50 * time.Millisecond,
+		RecursorFailures: 1,
+		RecursorCooldown: time.Hour,
+	})
+	assert.NoError(t, err)
+
+	m := query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, dns.RcodeServerFailure, m.Rcode)
+
+	// with nothing else to try, the recursor is still
+	// tried as a last resort.
+	atomic.StoreInt32(&healthy, 1)
+
+	m = query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+}
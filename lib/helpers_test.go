@@ -0,0 +1,172 @@
+package lib_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testWriter is a dns.ResponseWriter that keeps the
+// last message written (and, if written packed, its bytes)
+// so tests can inspect it.
+type testWriter struct {
+	msg    *dns.Msg
+	raw    []byte
+	remote net.Addr
+}
+
+func (w *testWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+
+func (w *testWriter) RemoteAddr() net.Addr {
+	if w.remote != nil {
+		return w.remote
+	}
+
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+}
+
+func (w *testWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *testWriter) Write(b []byte) (int, error) {
+	w.msg = new(dns.Msg)
+	w.raw = append([]byte(nil), b...)
+	return len(b), w.msg.Unpack(b)
+}
+
+func (w *testWriter) Close() error        { return nil }
+func (w *testWriter) TsigStatus() error   { return nil }
+func (w *testWriter) TsigTimersOnly(bool) {}
+func (w *testWriter) Hijack()             {}
+
+// query runs a question through the handler and returns
+// the response it wrote.
+func query(h dns.Handler, name string, qtype uint16) *dns.Msg {
+	var (
+		w = &testWriter{}
+		r = new(dns.Msg)
+	)
+
+	r.SetQuestion(dns.Fqdn(name), qtype)
+	h.ServeDNS(w, r)
+
+	return w.msg
+}
+
+// startRecursor starts an UDP DNS server on a random local
+// port that answers using the handler supplied, returning
+// its address.
+func startRecursor(t *testing.T, handler dns.HandlerFunc) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		started = make(chan struct{})
+		server  = &dns.Server{
+			PacketConn:        conn,
+			Handler:           handler,
+			NotifyStartedFunc: func() { close(started) },
+		}
+	)
+
+	go server.ActivateAndServe()
+	<-started
+
+	t.Cleanup(func() { server.Shutdown() })
+
+	return conn.LocalAddr().String()
+}
+
+// nxdomainHandler answers every question with NXDOMAIN,
+// including an SOA in the authority section if withSOA.
+func nxdomainHandler(withSOA bool) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeNameError)
+
+		if withSOA {
+			rr, _ := dns.NewRR(
+				"upstream. SOA ns.upstream. admin.upstream. 7 1 1 1 1")
+			m.Ns = append(m.Ns, rr)
+		}
+
+		w.WriteMsg(m)
+	}
+}
+
+// answerHandler answers every question with an A record
+// for each of the ips after waiting for the delay.
+func answerHandler(delay time.Duration, ips ...string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		time.Sleep(delay)
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		for _, ip := range ips {
+			rr, _ := dns.NewRR(r.Question[0].Name + " A " + ip)
+			m.Answer = append(m.Answer, rr)
+		}
+
+		w.WriteMsg(m)
+	}
+}
+
+// answerIPs extracts the addresses of the A records of
+// a message.
+func answerIPs(m *dns.Msg) (ips []string) {
+	ips = []string{}
+	for _, rr := range m.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			ips = append(ips, a.A.String())
+		}
+	}
+
+	return
+}
+
+// answerStrings renders the answers of a message.
+func answerStrings(m *dns.Msg) (answers []string) {
+	answers = rrStrings(m.Answer)
+	return
+}
+
+// rrStrings renders a section of a message.
+func rrStrings(rrs []dns.RR) (strs []string) {
+	strs = []string{}
+	for _, rr := range rrs {
+		strs = append(strs, rr.String())
+	}
+
+	return
+}
+
+// richHandler answers every question with an A record plus
+// a delegation (NS) in the authority section and its glue
+// in the additional section.
+func richHandler() dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		var (
+			m       = new(dns.Msg)
+			name    = r.Question[0].Name
+			a, _    = dns.NewRR(name + " A 1.1.1.1")
+			ns, _   = dns.NewRR(name + " NS ns1.upstream.")
+			glue, _ = dns.NewRR("ns1.upstream. A 9.9.9.9")
+		)
+
+		m.SetReply(r)
+		m.Answer = append(m.Answer, a)
+		m.Ns = append(m.Ns, ns)
+		m.Extra = append(m.Extra, glue)
+
+		w.WriteMsg(m)
+	}
+}
@@ -0,0 +1,188 @@
+package lib
+
+import (
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// canonicalName is the form names take in cache keys:
+// lowercased, trimmed and fully qualified (with a single
+// trailing dot) so that logically identical names share
+// the same entry.
+func canonicalName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return dns.Fqdn(strings.TrimRight(name, "."))
+}
+
+// validHostname tells whether the name is a hostname (RFC
+// 1123): dot-separated labels of letters, digits, hyphens
+// (not leading nor trailing) and underscores, the last of
+// which isn't all digits (which is a malformed IPv4
+// address instead). Surrounding spaces and trailing dots
+// are left out, as they are when resolving it (see
+// canonicalName).
+func validHostname(name string) bool {
+	name = strings.TrimRight(strings.TrimSpace(name), ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+
+	labels := strings.Split(name, ".")
+	for _, label := range labels {
+		if label == "" || len(label) > 63 ||
+			label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z',
+				c >= '0' && c <= '9', c == '-', c == '_':
+			default:
+				return false
+			}
+		}
+	}
+
+	return strings.Trim(labels[len(labels)-1], "0123456789") != ""
+}
+
+type hostnameKey struct {
+	name  string
+	qtype uint16
+}
+
+type hostnameEntry struct {
+	addresses []string
+	expires   time.Time
+}
+
+// hostnameCache keeps the addresses that hostnames used as
+// domain addresses recursed to, for as long as their TTL.
+type hostnameCache struct {
+	sync.Mutex
+	entries map[hostnameKey]hostnameEntry
+}
+
+func (c *hostnameCache) get(key hostnameKey, now time.Time) (addresses []string, found bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	entry, found := c.entries[key]
+	if !found {
+		return
+	}
+
+	if !now.Before(entry.expires) {
+		delete(c.entries, key)
+		found = false
+		return
+	}
+
+	addresses = entry.addresses
+	return
+}
+
+func (c *hostnameCache) set(key hostnameKey, addresses []string, expires time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.entries[key] = hostnameEntry{addresses: addresses, expires: expires}
+}
+
+func (c *hostnameCache) reset() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.entries = make(map[hostnameKey]hostnameEntry)
+}
+
+// address picks the next address of the family that qtype
+// (A or AAAA) refers to.
+func (d *Domain) address(qtype uint16) string {
+	if qtype == dns.TypeAAAA {
+		return d.GetAddressV6()
+	}
+
+	return d.GetAddress()
+}
+
+// resolveHostname resolves a hostname set as the address of
+// a domain to the addresses of the family that qtype refers
+// to: locally if it's a configured domain or, otherwise, via
+// the recursors (caching the result).
+func (s *Sdns) resolveHostname(ctx *SdnsContext, hostname string, qtype uint16, depth int) (addresses []string, err error) {
+	if depth == s.maxChain {
+		err = ErrCnameChainTooLong
+		return
+	}
+
+	var name = strings.ToLower(strings.TrimRight(hostname, "."))
+
+	domain, found := s.findDomain(name)
+	if found && !domain.forwarded() {
+		target := domain.Cname
+		if target == "" {
+			target = domain.address(qtype)
+		}
+
+		switch {
+		case target == "":
+		case net.ParseIP(target) != nil:
+			addresses = []string{target}
+		default:
+			addresses, err = s.resolveHostname(ctx, target, qtype, depth+1)
+		}
+		return
+	}
+
+	var (
+		key = hostnameKey{name: canonicalName(hostname), qtype: qtype}
+		now = s.now()
+		m   = new(dns.Msg)
+		in  *dns.Msg
+	)
+
+	addresses, found = s.hostnames.get(key, now)
+	if found {
+		return
+	}
+
+	m.SetQuestion(key.name, qtype)
+	in, err = s.recurseQuestion(ctx, m)
+	if err != nil {
+		return
+	}
+
+	var ttl uint32 = math.MaxUint32
+	for _, rr := range in.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			if qtype == dns.TypeA {
+				addresses = append(addresses, rr.A.String())
+			}
+		case *dns.AAAA:
+			if qtype == dns.TypeAAAA {
+				addresses = append(addresses, rr.AAAA.String())
+			}
+		default:
+			continue
+		}
+
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+
+	if len(addresses) > 0 && ttl > 0 {
+		s.hostnames.set(key, addresses,
+			now.Add(time.Duration(ttl)*time.Second))
+	}
+
+	return
+}
@@ -0,0 +1,142 @@
+package lib_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// countingHandler wraps a handler counting the questions
+// it receives.
+func countingHandler(received *int32, handler dns.HandlerFunc) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddInt32(received, 1)
+		handler(w, r)
+	}
+}
+
+func TestHandle_hostnameAddress(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		qname   string
+		qtype   uint16
+		answers []string
+	}{
+		{
+			name:    "resolved locally",
+			qname:   "local.com",
+			qtype:   dns.TypeA,
+			answers: []string{"local.com.\t3600\tIN\tA\t1.1.1.1"},
+		},
+		{
+			name:    "resolved locally by family",
+			qname:   "local.com",
+			qtype:   dns.TypeAAAA,
+			answers: []string{"local.com.\t3600\tIN\tAAAA\t::1"},
+		},
+		{
+			name:    "resolved locally through an alias",
+			qname:   "aliased.com",
+			qtype:   dns.TypeA,
+			answers: []string{"aliased.com.\t3600\tIN\tA\t1.1.1.1"},
+		},
+		{
+			name:  "resolved via recursion",
+			qname: "remote.com",
+			qtype: dns.TypeA,
+			answers: []string{
+				"remote.com.\t3600\tIN\tA\t7.7.7.7",
+				"remote.com.\t3600\tIN\tA\t8.8.8.8",
+			},
+		},
+		{
+			name:    "looping hostnames",
+			qname:   "loop.com",
+			qtype:   dns.TypeA,
+			answers: []string{},
+		},
+	}
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{startRecursor(t, answerHandler(0, "7.7.7.7", "8.8.8.8"))},
+		Domains: []*Domain{
+			{Name: "target.com", Addresses: []string{"1.1.1.1", "::1"}},
+			{Name: "alias.com", Cname: "target.com"},
+			{Name: "local.com", Addresses: []string{"target.com"}},
+			{Name: "aliased.com", Addresses: []string{"alias.com."}},
+			{Name: "remote.com", Addresses: []string{"somewhere.else.com"}},
+			{Name: "loop.com", Addresses: []string{"loop.com"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := query(s, tc.qname, tc.qtype)
+			assert.Equal(t, tc.answers, answerStrings(m))
+		})
+	}
+}
+
+func TestHandle_hostnameAddressCached(t *testing.T) {
+	var (
+		received int32
+		now      = time.Unix(1000, 0)
+		recursor = startRecursor(t,
+			countingHandler(&received, answerHandler(0, "7.7.7.7")))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{recursor},
+		Clock:     func() time.Time { return now },
+		Domains: []*Domain{
+			{Name: "remote.com", Addresses: []string{"somewhere.else.com"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		m := query(s, "remote.com", dns.TypeA)
+		assert.Equal(t, []string{"7.7.7.7"}, answerIPs(m))
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+	// past the TTL the hostname gets recursed again.
+	now = now.Add(time.Hour)
+
+	m := query(s, "remote.com", dns.TypeA)
+	assert.Equal(t, []string{"7.7.7.7"}, answerIPs(m))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+}
+
+func TestHandle_hostnameAddressCacheKey(t *testing.T) {
+	var (
+		received int32
+		recursor = startRecursor(t,
+			countingHandler(&received, answerHandler(0, "7.7.7.7")))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{recursor},
+		Domains: []*Domain{
+			{Name: "a.com", Addresses: []string{"Example.COM."}},
+			{Name: "b.com", Addresses: []string{"example.com"}},
+			{Name: "c.com", Addresses: []string{" example.com.. "}},
+		},
+	})
+	assert.NoError(t, err)
+
+	for _, name := range []string{"a.com", "b.com", "c.com"} {
+		m := query(s, name, dns.TypeA)
+		assert.Equal(t, []string{"7.7.7.7"}, answerIPs(m))
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+}
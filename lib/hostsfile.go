@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LoadHostsFile reads the mappings of a hosts(5) file (lines
+// of 'IP name [name...]', '#' starting comments) into exact
+// domains, one per name (in the order names first show up),
+// each of them getting the addresses of all the lines that
+// list it. The zone of scoped IPv6 addresses (e.g.:
+// 'fe80::1%lo0') is dropped, as records can't carry it.
+func LoadHostsFile(path string) (domains []*Domain, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"couldn't open hosts file %s", path)
+		return
+	}
+	defer file.Close()
+
+	var (
+		scanner = bufio.NewScanner(file)
+		byName  = make(map[string]*Domain)
+		line    int
+	)
+
+	for scanner.Scan() {
+		line++
+
+		text := scanner.Text()
+		if idx := strings.IndexByte(text, '#'); idx >= 0 {
+			text = text[:idx]
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) < 2 {
+			err = errors.Errorf(
+				"no names for %s - line %d of hosts file %s",
+				fields[0], line, path)
+			return
+		}
+
+		address := fields[0]
+		if idx := strings.IndexByte(address, '%'); idx >= 0 &&
+			strings.Contains(address[:idx], ":") {
+			address = address[:idx]
+		}
+
+		ip := net.ParseIP(address)
+		if ip == nil {
+			err = errors.Errorf(
+				"malformed address %s - line %d of hosts file %s",
+				fields[0], line, path)
+			return
+		}
+
+		for _, name := range fields[1:] {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+			domain, found := byName[name]
+			if !found {
+				domain = &Domain{Name: name}
+				byName[name] = domain
+				domains = append(domains, domain)
+			}
+
+			domain.Addresses = append(domain.Addresses, ip.String())
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		err = errors.Wrapf(err,
+			"couldn't read hosts file %s", path)
+		return
+	}
+
+	return
+}
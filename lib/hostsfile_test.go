@@ -0,0 +1,111 @@
+package lib_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+const sampleHosts = `# local development
+127.0.0.1   localhost
+::1         localhost ip6-localhost
+
+10.0.0.10   app.test api.test   # both on the same box
+10.0.0.11   db.test
+10.0.0.12   API.test.
+fe80::1%lo0 router.test
+`
+
+func writeHostsFile(t *testing.T, path, content string) {
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
+
+func TestLoadHostsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	writeHostsFile(t, path, sampleHosts)
+
+	domains, err := LoadHostsFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Domain{
+		{Name: "localhost", Addresses: []string{"127.0.0.1", "::1"}},
+		{Name: "ip6-localhost", Addresses: []string{"::1"}},
+		{Name: "app.test", Addresses: []string{"10.0.0.10"}},
+		{Name: "api.test", Addresses: []string{"10.0.0.10", "10.0.0.12"}},
+		{Name: "db.test", Addresses: []string{"10.0.0.11"}},
+		{Name: "router.test", Addresses: []string{"fe80::1"}},
+	}, domains)
+}
+
+func TestLoadHostsFile_malformed(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		content string
+	}{
+		{"missing names", "10.0.0.10\n"},
+		{"malformed address", "10.0.0 app.test\n"},
+		{"zone on ipv4 address", "10.0.0.10%eth0 app.test\n"},
+		{"name first", "app.test 10.0.0.10\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "hosts")
+			writeHostsFile(t, path, tc.content)
+
+			_, err := LoadHostsFile(path)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := LoadHostsFile(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestHandle_hostsFile(t *testing.T) {
+	var (
+		path = filepath.Join(t.TempDir(), "hosts")
+		cfg  = SdnsConfig{
+			Port:      1232,
+			Recursors: []string{"127.0.0.1:1"},
+			HostsFile: path,
+			Domains: []*Domain{
+				{Name: "other.test", Addresses: []string{"10.0.0.99"}},
+			},
+		}
+	)
+
+	writeHostsFile(t, path, sampleHosts)
+
+	s, err := NewSdns(cfg)
+	assert.NoError(t, err)
+
+	m := query(s, "app.test", dns.TypeA)
+	assert.Equal(t, []string{"10.0.0.10"}, answerIPs(m))
+
+	m = query(s, "db.test", dns.TypeA)
+	assert.Equal(t, []string{"10.0.0.11"}, answerIPs(m))
+
+	m = query(s, "ip6-localhost", dns.TypeAAAA)
+	assert.Equal(t, []string{"ip6-localhost.\t3600\tIN\tAAAA\t::1"}, answerStrings(m))
+
+	m = query(s, "other.test", dns.TypeA)
+	assert.Equal(t, []string{"10.0.0.99"}, answerIPs(m))
+
+	// edits are picked up on reload
+	writeHostsFile(t, path, "10.0.0.20 app.test\n10.0.0.21 new.test\n")
+	assert.NoError(t, s.Load(cfg))
+
+	m = query(s, "app.test", dns.TypeA)
+	assert.Equal(t, []string{"10.0.0.20"}, answerIPs(m))
+
+	m = query(s, "new.test", dns.TypeA)
+	assert.Equal(t, []string{"10.0.0.21"}, answerIPs(m))
+
+	m = query(s, "db.test", dns.TypeA)
+	assert.Equal(t, dns.RcodeServerFailure, m.Rcode)
+}
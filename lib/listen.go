@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// DefaultShutdownTimeout is how long in-flight queries are
+// given to finish on shutdown when not configured.
+const DefaultShutdownTimeout = 5 * time.Second
+
+// maxBindAttempts is how many ports picked by the system are
+// tried before giving up on finding one that's free for both
+// UDP and TCP.
+const maxBindAttempts = 10
+
+// bindDNS creates the sockets of the UDP and TCP servers,
+// which share the port. When the port is 0, the one picked
+// by the system for UDP is used for TCP as well, trying
+// another if it's taken.
+func (s *Sdns) bindDNS(udp, tcp *dns.Server) (err error) {
+	_, port, err := net.SplitHostPort(s.address)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"malformed address %s", s.address)
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		udp.PacketConn, err = net.ListenPacket("udp", s.address)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"couldn't listen on udp address %s", s.address)
+			return
+		}
+
+		udp.Addr = udp.PacketConn.LocalAddr().String()
+		tcp.Addr = udp.Addr
+
+		tcp.Listener, err = s.listenTCP(tcp.Addr, nil)
+		if err == nil {
+			return
+		}
+
+		udp.PacketConn.Close()
+		udp.PacketConn = nil
+
+		if port != "0" || attempt == maxBindAttempts {
+			return
+		}
+	}
+}
+
+// bound records the address that the DNS servers listen on,
+// letting those waiting on Listening know about it.
+func (s *Sdns) bound(address string) {
+	s.lock.Lock()
+	s.boundAddress = address
+	s.lock.Unlock()
+
+	select {
+	case <-s.listening:
+	default:
+		close(s.listening)
+	}
+}
+
+// Listening returns a channel that gets closed once Listen
+// bound the DNS servers, after which Addr tells where they
+// listen.
+func (s *Sdns) Listening() <-chan struct{} {
+	return s.listening
+}
+
+// Addr returns the address that the DNS servers listen on
+// (UDP and TCP share it), including the port picked by the
+// system if configured as 0. It's empty until Listen binds
+// the servers (see Listening).
+func (s *Sdns) Addr() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.boundAddress
+}
+
+// shutdown stops the servers from taking new queries and
+// waits for the in-flight ones to be answered, cancelling
+// them after the shutdown timeout.
+func (s *Sdns) shutdown(servers []*dns.Server, httpServers ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	for _, server := range servers {
+		server.ShutdownContext(ctx)
+	}
+
+	for _, server := range httpServers {
+		if server != nil {
+			server.Shutdown(ctx)
+		}
+	}
+
+	if ctx.Err() != nil {
+		s.logger.Warn().
+			Dur("timeout", s.shutdownTimeout).
+			Msg("in-flight queries didn't finish before shutting down")
+	}
+
+	s.lock.Lock()
+	s.endLifetime()
+	s.lifetime, s.endLifetime = nil, nil
+	s.lock.Unlock()
+}
+
+// beginLifetime makes the queries answered from now on
+// share a context that shutdown cancels.
+func (s *Sdns) beginLifetime() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lifetime, s.endLifetime = context.WithCancel(context.Background())
+}
+
+// queryContext is the context that queries are answered
+// under: the one of the running Listen or, when not
+// listening (e.g.: ServeDNS called directly), the
+// background one.
+func (s *Sdns) queryContext() context.Context {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.lifetime == nil {
+		return context.Background()
+	}
+
+	return s.lifetime
+}
@@ -0,0 +1,158 @@
+package lib_test
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestListen_ephemeralPort(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Address:   "127.0.0.1",
+		Port:      0,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, s.Addr())
+
+	go s.Listen()
+
+	select {
+	case <-s.Listening():
+	case <-time.After(time.Second):
+		t.Fatalf("server didn't start listening")
+	}
+
+	address := s.Addr()
+	host, port, err := net.SplitHostPort(address)
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", host)
+	assert.NotEqual(t, "0", port)
+
+	r := new(dns.Msg)
+	r.SetQuestion("foo.com.", dns.TypeA)
+
+	for _, network := range []string{"udp", "tcp"} {
+		client := &dns.Client{Net: network}
+
+		in, _, err := client.Exchange(r, address)
+		assert.NoError(t, err, network)
+		assert.Equal(t, []string{"1.1.1.1"}, answerIPs(in), network)
+	}
+}
+
+func TestListen_shutdown(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Address:         "127.0.0.1",
+		Port:            0,
+		Recursors:       []string{startRecursor(t, answerHandler(200*time.Millisecond, "7.7.7.7"))},
+		ShutdownTimeout: 2 * time.Second,
+	})
+	assert.NoError(t, err)
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- s.Listen()
+	}()
+
+	select {
+	case <-s.Listening():
+	case <-time.After(time.Second):
+		t.Fatalf("server didn't start listening")
+	}
+
+	type result struct {
+		in  *dns.Msg
+		err error
+	}
+
+	var (
+		r       = new(dns.Msg)
+		results = make(chan result, 1)
+	)
+
+	r.SetQuestion("foo.com.", dns.TypeA)
+	go func() {
+		in, _, err := new(dns.Client).Exchange(r, s.Addr())
+		results <- result{in: in, err: err}
+	}()
+
+	// let the query get in flight.
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+
+	select {
+	case err = <-listenErr:
+		assert.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("listen didn't return")
+	}
+
+	res := <-results
+	assert.NoError(t, res.err)
+	assert.Equal(t, []string{"7.7.7.7"}, answerIPs(res.in))
+
+	// no longer serving.
+	_, _, err = (&dns.Client{Timeout: 100 * time.Millisecond}).Exchange(r, s.Addr())
+	assert.Error(t, err)
+
+	// though still recursing when asked directly.
+	assert.Equal(t, []string{"7.7.7.7"}, answerIPs(query(s, "foo.com", dns.TypeA)))
+}
+
+func TestListen_shutdownCancelsQueries(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Address:         "127.0.0.1",
+		Port:            0,
+		Recursors:       []string{"127.0.0.1:1"},
+		ResponseDelay:   time.Minute,
+		ShutdownTimeout: 50 * time.Millisecond,
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- s.Listen()
+	}()
+
+	select {
+	case <-s.Listening():
+	case <-time.After(time.Second):
+		t.Fatalf("server didn't start listening")
+	}
+
+	answered := make(chan struct{})
+	go func() {
+		query(s, "foo.com", dns.TypeA)
+		close(answered)
+	}()
+
+	// let the query get in flight.
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+
+	select {
+	case err = <-listenErr:
+		assert.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("listen didn't return")
+	}
+
+	select {
+	case <-answered:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("in-flight query wasn't cancelled")
+	}
+}
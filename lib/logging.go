@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"io"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// LogFormat determines how messages are logged.
+type LogFormat string
+
+const (
+	// LogFormatJSON logs a JSON object per message, meant
+	// for ingestion.
+	LogFormatJSON LogFormat = "json"
+
+	// LogFormatConsole logs human-friendly lines.
+	LogFormatConsole LogFormat = "console"
+)
+
+// newLogger creates the logger writing to out in the format,
+// which defaults to LogFormatConsole in debug mode and to
+// LogFormatJSON otherwise, at the level (see parseLogLevel),
+// which defaults to 'info' unless in debug mode.
+func newLogger(out io.Writer, format LogFormat, level string, debug bool) (logger zerolog.Logger, err error) {
+	if format == "" {
+		format = LogFormatJSON
+		if debug {
+			format = LogFormatConsole
+		}
+	}
+
+	if level == "" && !debug {
+		level = zerolog.InfoLevel.String()
+	}
+
+	switch format {
+	case LogFormatJSON:
+		logger = zerolog.New(out)
+	case LogFormatConsole:
+		logger = zerolog.New(zerolog.ConsoleWriter{Out: out})
+	default:
+		err = errors.Errorf("unknown log format %s", format)
+		return
+	}
+
+	logLevel, err := parseLogLevel(level)
+	if err != nil {
+		return
+	}
+
+	if logLevel != zerolog.NoLevel {
+		logger = logger.Level(logLevel)
+	}
+
+	return
+}
+
+// parseLogLevel parses the name of a log level (e.g.:
+// 'debug' or 'warn'). An empty name gives zerolog.NoLevel,
+// meaning that the level isn't set.
+func parseLogLevel(name string) (level zerolog.Level, err error) {
+	level, err = zerolog.ParseLevel(strings.ToLower(name))
+	if err != nil {
+		err = errors.Wrapf(err,
+			"unknown log level %s", name)
+		return
+	}
+
+	return
+}
+
+// queryLogger tells the logger of a query: the global one
+// unless the domain asked for overrides the log level.
+func (s *Sdns) queryLogger(logger zerolog.Logger, r *dns.Msg) zerolog.Logger {
+	if len(r.Question) == 0 {
+		return logger
+	}
+
+	domain, found := s.findDomain(strings.TrimRight(r.Question[0].Name, "."))
+	if !found {
+		return logger
+	}
+
+	level := domain.loaded().logLevel
+	if level == zerolog.NoLevel {
+		return logger
+	}
+
+	return logger.Level(level)
+}
@@ -0,0 +1,125 @@
+package lib_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_domainLogLevel(t *testing.T) {
+	var logs bytes.Buffer
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		LogLevel:  "warn",
+		LogOutput: &logs,
+		Domains: []*Domain{
+			{
+				Name:      "*.noisy.com",
+				Addresses: []string{"1.1.1.1"},
+				LogLevel:  "debug",
+			},
+			{
+				Name:      "quiet.com",
+				Addresses: []string{"2.2.2.2"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	logs.Reset()
+	m := query(s, "quiet.com", dns.TypeA)
+	assert.Equal(t, []string{"2.2.2.2"}, answerIPs(m))
+	assert.Empty(t, logs.String())
+
+	m = query(s, "www.noisy.com", dns.TypeA)
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+	assert.Contains(t, logs.String(), `"level":"debug"`)
+	assert.Contains(t, logs.String(), `"message":"handling query"`)
+	assert.Contains(t, logs.String(), "www.noisy.com.")
+}
+
+func TestNewSdns_malformedLogLevel(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{
+		Port:     1232,
+		LogLevel: "lol",
+	})
+	assert.Error(t, err)
+
+	_, err = NewSdns(SdnsConfig{
+		Port: 1232,
+		Domains: []*Domain{
+			{Name: "foo.com", LogLevel: "lol"},
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestNewSdns_logConfiguration(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		debug  bool
+		format LogFormat
+		level  string
+		json   bool
+		debugs bool
+		infos  bool
+	}{
+		{name: "debug mode", debug: true, debugs: true, infos: true},
+		{name: "non-debug mode", json: true, infos: true},
+		{name: "debug mode json", debug: true, format: LogFormatJSON, json: true, debugs: true, infos: true},
+		{name: "non-debug mode console", format: LogFormatConsole, infos: true},
+		{name: "json debug", format: LogFormatJSON, level: "debug", json: true, debugs: true, infos: true},
+		{name: "json info", format: LogFormatJSON, level: "info", json: true, infos: true},
+		{name: "json warn", format: LogFormatJSON, level: "warn", json: true},
+		{name: "console debug", format: LogFormatConsole, level: "debug", debugs: true, infos: true},
+		{name: "console info", format: LogFormatConsole, level: "info", infos: true},
+		{name: "console error", debug: true, format: LogFormatConsole, level: "error"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+
+			s, err := NewSdns(SdnsConfig{
+				Port:      1232,
+				Recursors: []string{"127.0.0.1:1"},
+				Debug:     tc.debug,
+				LogFormat: tc.format,
+				LogLevel:  tc.level,
+				LogOutput: &logs,
+				Domains: []*Domain{
+					{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+				},
+			})
+			assert.NoError(t, err)
+
+			logs.Reset()
+			m := query(s, "foo.com", dns.TypeA)
+			assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+
+			// the steps of a query are logged at debug and
+			// the answer at info.
+			assert.Equal(t, tc.debugs, strings.Contains(logs.String(), "handling query"))
+			assert.Equal(t, tc.debugs, strings.Contains(logs.String(), "looking for domain"))
+			assert.Equal(t, tc.infos, strings.Contains(logs.String(), "query handled"))
+			if tc.debugs || tc.infos {
+				assert.Equal(t, tc.json, strings.HasPrefix(logs.String(), `{"level":`))
+			}
+		})
+	}
+}
+
+func TestNewSdns_malformedLogFormat(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		LogFormat: "lol",
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// MalformedPolicy determines how queries that can't be
+// unpacked are answered.
+type MalformedPolicy string
+
+const (
+	// MalformedFormerr answers them FORMERR (as long as
+	// their header could be unpacked).
+	MalformedFormerr MalformedPolicy = "formerr"
+
+	// MalformedDrop doesn't answer them at all, which
+	// keeps them from being used to amplify.
+	MalformedDrop MalformedPolicy = "drop"
+)
+
+// validateMalformed makes sure that the policy is known,
+// accepting the empty one (i.e.: the default).
+func validateMalformed(policy MalformedPolicy) (err error) {
+	switch policy {
+	case "", MalformedFormerr, MalformedDrop:
+	default:
+		err = errors.Errorf("unknown malformed query policy %s", policy)
+	}
+
+	return
+}
+
+// malformedReader reads the raw messages of a server,
+// counting and logging the ones that can't be unpacked
+// (and thus never get to ServeDNS) before the server
+// handles them, at the cost of unpacking every message
+// twice.
+type malformedReader struct {
+	dns.Reader
+	sdns *Sdns
+}
+
+// decorateReader is the dns.DecorateReader of the servers.
+func (s *Sdns) decorateReader(reader dns.Reader) dns.Reader {
+	return &malformedReader{Reader: reader, sdns: s}
+}
+
+func (r *malformedReader) ReadTCP(conn net.Conn, timeout time.Duration) (m []byte, err error) {
+	for {
+		m, err = r.Reader.ReadTCP(conn, timeout)
+		if err != nil || !r.sdns.dropsMalformed(m, conn.RemoteAddr(), "tcp") {
+			return
+		}
+	}
+}
+
+func (r *malformedReader) ReadUDP(conn *net.UDPConn, timeout time.Duration) (m []byte, session *dns.SessionUDP, err error) {
+	for {
+		m, session, err = r.Reader.ReadUDP(conn, timeout)
+		if err != nil || !r.sdns.dropsMalformed(m, session.RemoteAddr(), "udp") {
+			return
+		}
+	}
+}
+
+func (r *malformedReader) ReadPacketConn(conn net.PacketConn, timeout time.Duration) (m []byte, addr net.Addr, err error) {
+	reader, ok := r.Reader.(dns.PacketConnReader)
+	if !ok {
+		err = errors.Errorf("reader can't read from packet connections")
+		return
+	}
+
+	for {
+		m, addr, err = reader.ReadPacketConn(conn, timeout)
+		if err != nil || !r.sdns.dropsMalformed(m, addr, "udp") {
+			return
+		}
+	}
+}
+
+// dropsMalformed counts and logs the message if it can't
+// be unpacked, telling whether it's to be dropped.
+func (s *Sdns) dropsMalformed(m []byte, remote net.Addr, protocol string) bool {
+	err := new(dns.Msg).Unpack(m)
+	if err == nil {
+		return false
+	}
+
+	s.observeMalformed(err, len(m), remote, protocol)
+	return s.malformed == MalformedDrop
+}
+
+// observeMalformed counts and logs a query that couldn't be
+// unpacked.
+func (s *Sdns) observeMalformed(err error, size int, remote net.Addr, protocol string) {
+	s.metrics.malformedQueries.WithLabelValues(protocol).Inc()
+	s.logger.Warn().
+		Err(err).
+		Str("client", remoteIP(remote).String()).
+		Str("protocol", protocol).
+		Int("size", size).
+		Msg("malformed query")
+}
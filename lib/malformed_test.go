@@ -0,0 +1,111 @@
+package lib_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// truncatedQuery is a query whose question got truncated
+// in the middle of a label.
+var truncatedQuery = []byte{
+	0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x03, 'f', 'o',
+}
+
+func TestListen_malformedQueries(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		policy   MalformedPolicy
+		answered bool
+	}{
+		{name: "default", answered: true},
+		{name: "formerr", policy: MalformedFormerr, answered: true},
+		{name: "drop", policy: MalformedDrop},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSdns(SdnsConfig{
+				Address:         "127.0.0.1",
+				Port:            0,
+				Recursors:       []string{"127.0.0.1:1"},
+				MalformedPolicy: tc.policy,
+				Domains: []*Domain{
+					{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+				},
+			})
+			assert.NoError(t, err)
+
+			go s.Listen()
+
+			select {
+			case <-s.Listening():
+			case <-time.After(time.Second):
+				t.Fatalf("server didn't start listening")
+			}
+
+			conn, err := net.Dial("udp", s.Addr())
+			assert.NoError(t, err)
+			defer conn.Close()
+
+			for _, packet := range [][]byte{truncatedQuery, {0xde, 0xad}} {
+				_, err = conn.Write(packet)
+				assert.NoError(t, err)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			buf := make([]byte, 512)
+			n, err := conn.Read(buf)
+			if tc.answered {
+				assert.NoError(t, err)
+
+				in := new(dns.Msg)
+				assert.NoError(t, in.Unpack(buf[:n]))
+				assert.Equal(t, uint16(0x1234), in.Id)
+				assert.Equal(t, dns.RcodeFormatError, in.Rcode)
+			} else {
+				assert.Error(t, err)
+			}
+
+			tcp, err := net.Dial("tcp", s.Addr())
+			assert.NoError(t, err)
+			defer tcp.Close()
+
+			_, err = tcp.Write(append([]byte{0x00, byte(len(truncatedQuery))}, truncatedQuery...))
+			assert.NoError(t, err)
+
+			// still answered afterwards.
+			r := new(dns.Msg)
+			r.SetQuestion("foo.com.", dns.TypeA)
+			in, _, err := (&dns.Client{Net: "tcp"}).Exchange(r, s.Addr())
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"1.1.1.1"}, answerIPs(in))
+
+			in, _, err = (&dns.Client{Net: "udp"}).Exchange(r, s.Addr())
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"1.1.1.1"}, answerIPs(in))
+
+			metrics := scrape(t, s)
+			assert.Contains(t, metrics, `sdns_malformed_queries_total{protocol="udp"} 2`)
+			assert.Eventually(t, func() bool {
+				return strings.Contains(scrape(t, s),
+					`sdns_malformed_queries_total{protocol="tcp"} 1`)
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestNewSdns_malformedMalformedPolicy(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{
+		Port:            1232,
+		MalformedPolicy: "lol",
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"net/http"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsPath is the path metrics are served on when a
+// metrics port is configured.
+const MetricsPath = "/metrics"
+
+// metrics holds the collectors of an Sdns instance. Each
+// instance has its own registry so that instances (e.g.: in
+// tests) don't share counters.
+type metrics struct {
+	registry *prometheus.Registry
+
+	queries     prometheus.Counter
+	queryTypes  *prometheus.CounterVec
+	answers     *prometheus.CounterVec
+	cacheHits   prometheus.Counter
+	cacheMisses prometheus.Counter
+
+	recursorAttempts  *prometheus.CounterVec
+	recursorSuccesses *prometheus.CounterVec
+	recursorFailures  *prometheus.CounterVec
+	recursorFailovers *prometheus.CounterVec
+	recursorRTT       *prometheus.HistogramVec
+
+	responseSizes  *prometheus.HistogramVec
+	udpTruncations prometheus.Counter
+	tcpFallbacks   prometheus.Counter
+	droppedEvents  prometheus.Counter
+	rateLimited    prometheus.Counter
+	denied         *prometheus.CounterVec
+
+	malformedQueries *prometheus.CounterVec
+}
+
+func newRecursorCounter(name, help string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sdns",
+		Subsystem: "recursor",
+		Name:      name,
+		Help:      help,
+	}, []string{"recursor"})
+}
+
+func newMetrics() (m *metrics) {
+	m = &metrics{
+		registry: prometheus.NewRegistry(),
+		queries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "sdns",
+			Name:      "queries_total",
+			Help:      "Queries received.",
+		}),
+		queryTypes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "sdns",
+			Name:      "queries_by_type_total",
+			Help:      "Questions received by query type.",
+		}, []string{"qtype"}),
+		answers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "sdns",
+			Name:      "answers_total",
+			Help:      "Queries answered by where the answer came from (local or recursed).",
+		}, []string{"source"}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "sdns",
+			Subsystem: "cache",
+			Name:      "hits_total",
+			Help:      "Recursions answered from the cache.",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "sdns",
+			Subsystem: "cache",
+			Name:      "misses_total",
+			Help:      "Recursions that the cache couldn't answer.",
+		}),
+		recursorAttempts: newRecursorCounter("attempts_total",
+			"Questions forwarded to the recursor."),
+		recursorSuccesses: newRecursorCounter("successes_total",
+			"Questions the recursor answered."),
+		recursorFailures: newRecursorCounter("failures_total",
+			"Questions the recursor failed to answer."),
+		recursorFailovers: newRecursorCounter("failovers_total",
+			"Failures of the recursor that moved the question to the next one."),
+		recursorRTT: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "sdns",
+			Subsystem: "recursor",
+			Name:      "rtt_seconds",
+			Help:      "Round trip time of the exchanges the recursor answered.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+		}, []string{"recursor"}),
+		responseSizes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "sdns",
+			Name:      "response_size_bytes",
+			Help:      "Size of the responses written.",
+			Buckets:   []float64{64, 128, 256, 512, 1024, 1232, 2048, 4096, 8192, 16384, 65535},
+		}, []string{"protocol"}),
+		udpTruncations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "sdns",
+			Name:      "udp_truncations_total",
+			Help:      "UDP responses written with the TC bit set.",
+		}),
+		tcpFallbacks: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "sdns",
+			Name:      "tcp_fallbacks_total",
+			Help:      "TCP responses too large for the UDP size the client takes.",
+		}),
+		droppedEvents: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "sdns",
+			Name:      "dropped_events_total",
+			Help:      "Query events dropped as the event sink wasn't ready to take them.",
+		}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "sdns",
+			Name:      "rate_limited_total",
+			Help:      "Queries refused or dropped as their client went past its rate.",
+		}),
+		denied: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "sdns",
+			Name:      "denied_total",
+			Help:      "Queries refused by the access control lists by what was denied (query or recursion).",
+		}, []string{"scope"}),
+		malformedQueries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "sdns",
+			Name:      "malformed_queries_total",
+			Help:      "Queries that couldn't be unpacked by protocol.",
+		}, []string{"protocol"}),
+	}
+
+	m.registry.MustRegister(
+		m.queries,
+		m.queryTypes,
+		m.answers,
+		m.cacheHits,
+		m.cacheMisses,
+		m.recursorAttempts,
+		m.recursorSuccesses,
+		m.recursorFailures,
+		m.recursorFailovers,
+		m.recursorRTT,
+		m.responseSizes,
+		m.udpTruncations,
+		m.tcpFallbacks,
+		m.droppedEvents,
+		m.rateLimited,
+		m.denied,
+		m.malformedQueries,
+	)
+	return
+}
+
+// observeQuery counts the query and its questions by type.
+func (m *metrics) observeQuery(r *dns.Msg) {
+	m.queries.Inc()
+	for _, question := range r.Question {
+		m.queryTypes.WithLabelValues(dns.Type(question.Qtype).String()).Inc()
+	}
+}
+
+// observeResponse records the size of the response about to
+// be written and whether it got truncated (UDP) or only made
+// it because the client is using TCP.
+func (m *metrics) observeResponse(w dns.ResponseWriter, r *dns.Msg, resp *dns.Msg) {
+	var (
+		size     = resp.Len()
+		protocol = "tcp"
+	)
+
+	if isUDP(w) {
+		protocol = "udp"
+		if resp.Truncated {
+			m.udpTruncations.Inc()
+		}
+	} else if size > maxUDPSize(r) {
+		m.tcpFallbacks.Inc()
+	}
+
+	m.responseSizes.WithLabelValues(protocol).Observe(float64(size))
+}
+
+// MetricsHandler serves the metrics of the instance in the
+// Prometheus exposition format.
+func (s *Sdns) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{})
+}
+
+// metricsServer builds the server exposing the metrics on
+// MetricsPath.
+func (s *Sdns) metricsServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, s.MetricsHandler())
+
+	return &http.Server{
+		Addr:    s.metricsAddress,
+		Handler: mux,
+	}
+}
@@ -0,0 +1,170 @@
+package lib_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// scrape returns the metrics exposed by the instance.
+func scrape(t *testing.T, s *Sdns) string {
+	var (
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest("GET", "/metrics", nil)
+	)
+
+	s.MetricsHandler().ServeHTTP(w, r)
+
+	body, err := ioutil.ReadAll(w.Result().Body)
+	assert.NoError(t, err)
+
+	return string(body)
+}
+
+func TestHandle_recursorMetrics(t *testing.T) {
+	var (
+		failing = "127.0.0.1:1"
+		working = startRecursor(t, answerHandler(0, "1.1.1.1"))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{failing, working},
+	})
+	assert.NoError(t, err)
+
+	query(s, "foo.com", dns.TypeA)
+	query(s, "bar.com", dns.TypeA)
+
+	metrics := scrape(t, s)
+	for _, line := range []string{
+		`sdns_recursor_attempts_total{recursor="` + failing + `"} 2`,
+		`sdns_recursor_failures_total{recursor="` + failing + `"} 2`,
+		`sdns_recursor_failovers_total{recursor="` + failing + `"} 2`,
+		`sdns_recursor_attempts_total{recursor="` + working + `"} 2`,
+		`sdns_recursor_successes_total{recursor="` + working + `"} 2`,
+	} {
+		assert.Contains(t, metrics, line)
+	}
+
+	assert.NotContains(t, metrics, `sdns_recursor_successes_total{recursor="`+failing+`"}`)
+	assert.NotContains(t, metrics, `sdns_recursor_failures_total{recursor="`+working+`"}`)
+	assert.NotContains(t, metrics, `sdns_recursor_failovers_total{recursor="`+working+`"}`)
+}
+
+func TestHandle_responseMetrics(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{Name: "small.com", Addresses: []string{"1.1.1.1"}},
+			{
+				Name: "large.com",
+				Texts: []string{
+					strings.Repeat("a", 200),
+					strings.Repeat("b", 200),
+					strings.Repeat("c", 200),
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	m := query(s, "small.com", dns.TypeA)
+	assert.False(t, m.Truncated)
+
+	m = query(s, "large.com", dns.TypeTXT)
+	assert.True(t, m.Truncated)
+
+	var (
+		w = &testWriter{remote: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}}
+		r = new(dns.Msg)
+	)
+
+	r.SetQuestion("large.com.", dns.TypeTXT)
+	s.ServeDNS(w, r)
+	assert.False(t, w.msg.Truncated)
+
+	metrics := scrape(t, s)
+	for _, line := range []string{
+		`sdns_response_size_bytes_count{protocol="udp"} 2`,
+		`sdns_response_size_bytes_count{protocol="tcp"} 1`,
+		`sdns_response_size_bytes_bucket{protocol="udp",le="512"} 2`,
+		`sdns_response_size_bytes_bucket{protocol="tcp",le="512"} 0`,
+		`sdns_udp_truncations_total 1`,
+		`sdns_tcp_fallbacks_total 1`,
+	} {
+		assert.Contains(t, metrics, line)
+	}
+}
+
+func TestListen_metrics(t *testing.T) {
+	var (
+		metricsPort = freePort(t)
+		url         = "http://127.0.0.1:" + strconv.Itoa(metricsPort) + MetricsPath
+		recursor    = startRecursor(t, answerHandler(0, "7.7.7.7"))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Address:     "127.0.0.1",
+		Port:        freePort(t),
+		MetricsPort: metricsPort,
+		Recursors:   []string{recursor},
+		Cache:       true,
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	go s.Listen()
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		resp, err = http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	query(s, "foo.com", dns.TypeA)
+	query(s, "bar.com", dns.TypeA)
+	query(s, "bar.com", dns.TypeA)
+	query(s, "foo.com", dns.TypeMX)
+
+	resp, err = http.Get(url)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	metrics := string(body)
+	for _, line := range []string{
+		`sdns_queries_total 4`,
+		`sdns_queries_by_type_total{qtype="A"} 3`,
+		`sdns_queries_by_type_total{qtype="MX"} 1`,
+		`sdns_answers_total{source="local"} 2`,
+		`sdns_answers_total{source="recursed"} 2`,
+		`sdns_cache_hits_total 1`,
+		`sdns_cache_misses_total 1`,
+		`sdns_recursor_rtt_seconds_count{recursor="` + recursor + `"} 1`,
+	} {
+		assert.Contains(t, metrics, line)
+	}
+}
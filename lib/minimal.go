@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"github.com/miekg/dns"
+)
+
+// hasType tells whether there's a record of a given type
+// among the records.
+func hasType(rrs []dns.RR, rrtype uint16) bool {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == rrtype {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withoutType filters out the records of a given type.
+func withoutType(rrs []dns.RR, rrtype uint16) (filtered []dns.RR) {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != rrtype {
+			filtered = append(filtered, rr)
+		}
+	}
+
+	return
+}
+
+// onlyType keeps only the records of a given type.
+func onlyType(rrs []dns.RR, rrtype uint16) (filtered []dns.RR) {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == rrtype {
+			filtered = append(filtered, rr)
+		}
+	}
+
+	return
+}
+
+// minimize strips the authority and additional sections of
+// the response if minimal responses are configured (or the
+// query turned them on, see FeatureMinimalResponses). Only
+// the OPT record and the SOA of negative answers (needed for
+// negative caching) are kept.
+func (s *Sdns) minimize(ctx *SdnsContext, m *dns.Msg) {
+	if !s.minimal && !ctx.enabled(FeatureMinimalResponses) {
+		return
+	}
+
+	if m.Rcode == dns.RcodeNameError || len(m.Answer) == 0 {
+		m.Ns = onlyType(m.Ns, dns.TypeSOA)
+	} else {
+		m.Ns = nil
+	}
+
+	m.Extra = onlyType(m.Extra, dns.TypeOPT)
+}
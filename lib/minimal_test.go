@@ -0,0 +1,72 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_minimalResponses(t *testing.T) {
+	var (
+		rich     = startRecursor(t, richHandler())
+		negative = startRecursor(t, nxdomainHandler(true))
+	)
+
+	var testCases = []struct {
+		name      string
+		recursor  string
+		minimal   bool
+		rcode     int
+		answers   int
+		authority int
+		extra     int
+	}{
+		{
+			name:      "rich response kept",
+			recursor:  rich,
+			minimal:   false,
+			rcode:     dns.RcodeSuccess,
+			answers:   1,
+			authority: 1,
+			extra:     1,
+		},
+		{
+			name:      "rich response stripped",
+			recursor:  rich,
+			minimal:   true,
+			rcode:     dns.RcodeSuccess,
+			answers:   1,
+			authority: 0,
+			extra:     0,
+		},
+		{
+			name:      "negative response keeps soa",
+			recursor:  negative,
+			minimal:   true,
+			rcode:     dns.RcodeNameError,
+			answers:   0,
+			authority: 1,
+			extra:     0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSdns(SdnsConfig{
+				Port:             1232,
+				Recursors:        []string{tc.recursor},
+				MinimalResponses: tc.minimal,
+			})
+			assert.NoError(t, err)
+
+			m := query(s, "example.com", dns.TypeA)
+			assert.Equal(t, tc.rcode, m.Rcode)
+			assert.Len(t, m.Answer, tc.answers)
+			assert.Len(t, m.Ns, tc.authority)
+			assert.Len(t, m.Extra, tc.extra)
+		})
+	}
+}
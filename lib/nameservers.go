@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// NameserverOrder determines the order in which the
+// nameservers of a domain answer NS questions.
+type NameserverOrder string
+
+const (
+	// NameserversAsConfigured answers with the nameservers
+	// in the order they're configured.
+	NameserversAsConfigured NameserverOrder = "as-configured"
+
+	// NameserversSorted answers with the nameservers
+	// sorted by name, regardless of the configuration.
+	NameserversSorted NameserverOrder = "sorted"
+
+	// NameserversRoundRobin answers with the nameservers
+	// rotated by one from one question to the next.
+	NameserversRoundRobin NameserverOrder = "round-robin"
+)
+
+// validateNameserverOrder makes sure that the order is
+// known, accepting the empty one (i.e.: the default).
+func validateNameserverOrder(order NameserverOrder) (err error) {
+	switch order {
+	case "", NameserversAsConfigured, NameserversSorted, NameserversRoundRobin:
+	default:
+		err = errors.Errorf("unknown nameserver order %s", order)
+	}
+
+	return
+}
+
+// nameserverOrder tells the nameserver order of a domain,
+// which can override the global one.
+func (s *Sdns) nameserverOrder(domain *Domain) NameserverOrder {
+	if domain.NameserverOrder != "" {
+		return domain.NameserverOrder
+	}
+
+	return s.nameservers
+}
+
+// validateNameservers makes sure that nameservers are
+// hostnames (see validHostname).
+func validateNameservers(nameservers []string) (err error) {
+	for _, nameserver := range nameservers {
+		if !validHostname(nameserver) {
+			err = errors.Errorf("malformed nameserver %s", nameserver)
+			return
+		}
+	}
+
+	return
+}
+
+// answerNameservers returns the nameservers of the domain
+// in the order that they're answered with.
+func (s *Sdns) answerNameservers(domain *Domain) (nameservers []string) {
+	configured := domain.loaded().nameservers
+
+	switch s.nameserverOrder(domain) {
+	case NameserversSorted:
+		nameservers = append(nameservers, configured...)
+		sort.Slice(nameservers, func(i, j int) bool {
+			return strings.ToLower(nameservers[i]) < strings.ToLower(nameservers[j])
+		})
+	case NameserversRoundRobin:
+		if len(configured) == 0 {
+			return
+		}
+
+		start := int((atomic.AddUint64(&domain.nextNameserver, 1) - 1) % uint64(len(configured)))
+		nameservers = append(nameservers, configured[start:]...)
+		nameservers = append(nameservers, configured[:start]...)
+	default:
+		nameservers = configured
+	}
+
+	return
+}
@@ -0,0 +1,117 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// answerNameservers renders the nameservers answered.
+func answerNameservers(m *dns.Msg) (nameservers []string) {
+	for _, rr := range m.Answer {
+		if ns, ok := rr.(*dns.NS); ok {
+			nameservers = append(nameservers, ns.Ns)
+		}
+	}
+
+	return
+}
+
+func TestHandle_nameserverOrder(t *testing.T) {
+	var nameservers = []string{"ns2.foo.com.", "NS3.foo.com.", "ns1.foo.com."}
+
+	var testCases = []struct {
+		name     string
+		global   NameserverOrder
+		domain   NameserverOrder
+		expected [][]string
+	}{
+		{
+			name: "default",
+			expected: [][]string{
+				{"ns2.foo.com.", "NS3.foo.com.", "ns1.foo.com."},
+				{"ns2.foo.com.", "NS3.foo.com.", "ns1.foo.com."},
+			},
+		},
+		{
+			name:   "as configured",
+			global: NameserversAsConfigured,
+			expected: [][]string{
+				{"ns2.foo.com.", "NS3.foo.com.", "ns1.foo.com."},
+				{"ns2.foo.com.", "NS3.foo.com.", "ns1.foo.com."},
+			},
+		},
+		{
+			name:   "sorted",
+			global: NameserversSorted,
+			expected: [][]string{
+				{"ns1.foo.com.", "ns2.foo.com.", "NS3.foo.com."},
+				{"ns1.foo.com.", "ns2.foo.com.", "NS3.foo.com."},
+			},
+		},
+		{
+			name:   "round-robin",
+			global: NameserversRoundRobin,
+			expected: [][]string{
+				{"ns2.foo.com.", "NS3.foo.com.", "ns1.foo.com."},
+				{"NS3.foo.com.", "ns1.foo.com.", "ns2.foo.com."},
+				{"ns1.foo.com.", "ns2.foo.com.", "NS3.foo.com."},
+				{"ns2.foo.com.", "NS3.foo.com.", "ns1.foo.com."},
+			},
+		},
+		{
+			name:   "domain override",
+			global: NameserversRoundRobin,
+			domain: NameserversSorted,
+			expected: [][]string{
+				{"ns1.foo.com.", "ns2.foo.com.", "NS3.foo.com."},
+				{"ns1.foo.com.", "ns2.foo.com.", "NS3.foo.com."},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSdns(SdnsConfig{
+				Port:            1232,
+				Recursors:       []string{"127.0.0.1:1"},
+				NameserverOrder: tc.global,
+				Domains: []*Domain{
+					{
+						Name:            "foo.com",
+						Nameservers:     nameservers,
+						NameserverOrder: tc.domain,
+					},
+				},
+			})
+			assert.NoError(t, err)
+
+			for _, expected := range tc.expected {
+				m := query(s, "foo.com", dns.TypeNS)
+				assert.Equal(t, expected, answerNameservers(m))
+			}
+
+			// the configuration is left untouched.
+			assert.Equal(t, []string{"ns2.foo.com.", "NS3.foo.com.", "ns1.foo.com."}, nameservers)
+		})
+	}
+}
+
+func TestLoad_malformedNameserverOrder(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{
+		Port:            1232,
+		NameserverOrder: "lol",
+	})
+	assert.Error(t, err)
+
+	_, err = NewSdns(SdnsConfig{
+		Port: 1232,
+		Domains: []*Domain{
+			{Name: "foo.com", NameserverOrder: "lol"},
+		},
+	})
+	assert.Error(t, err)
+}
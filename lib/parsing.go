@@ -0,0 +1,362 @@
+package lib
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	util "github.com/cirocosta/sdns/util"
+)
+
+// ParseDomainArg converts a domain configuration string
+// (e.g.: 'domain=a.com,ip=1.1.1.1,ns=ns1.a.com' or
+// 'domain=www.a.com,cname=a.com') into a Domain.
+func ParseDomainArg(arg string) (domain *Domain, err error) {
+	mapping, err := util.CsvStringToMap(arg)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"malformed domain configuration")
+		return
+	}
+
+	name, present := mapping["domain"]
+	if !present {
+		err = errors.Errorf(
+			"a domain name must be present - %s", arg)
+		return
+	}
+
+	domain = &Domain{Name: name[0]}
+
+	ips, present := mapping["ip"]
+	if present {
+		domain.Addresses = ips
+	}
+
+	nameservers, present := mapping["ns"]
+	if present {
+		domain.Nameservers = nameservers
+	}
+
+	cname, present := mapping["cname"]
+	if present {
+		domain.Cname = cname[0]
+	}
+
+	mailExchangers, present := mapping["mx"]
+	if present {
+		domain.MailExchangers = mailExchangers
+	}
+
+	texts, present := mapping["txt"]
+	if present {
+		domain.Texts = texts
+	}
+
+	caa, present := mapping["caa"]
+	if present {
+		domain.CAA = caa
+	}
+
+	srv, present := mapping["srv"]
+	if present {
+		domain.SRV = srv
+	}
+
+	services, present := mapping["service"]
+	if present {
+		domain.Services = services
+	}
+
+	apexTypes, present := mapping["apex"]
+	if present {
+		domain.ApexTypes = apexTypes
+	}
+
+	wildcardTypes, present := mapping["wildcard-types"]
+	if present {
+		domain.WildcardTypes = wildcardTypes
+	}
+
+	wildcardPolicy, present := mapping["wildcard-policy"]
+	if present {
+		domain.WildcardPolicy = WildcardPolicy(wildcardPolicy[0])
+	}
+
+	addressMode, present := mapping["addresses"]
+	if present {
+		domain.AddressMode = AddressMode(addressMode[0])
+	}
+
+	nameserverOrder, present := mapping["ns-order"]
+	if present {
+		domain.NameserverOrder = NameserverOrder(nameserverOrder[0])
+	}
+
+	delegation, present := mapping["delegation"]
+	if present {
+		domain.Delegation = DelegationPolicy(delegation[0])
+	}
+
+	transport, present := mapping["transport"]
+	if present {
+		domain.Transport = TransportPolicy(transport[0])
+	}
+
+	maxQPS, present := mapping["max-qps"]
+	if present {
+		domain.MaxQPS, err = strconv.Atoi(maxQPS[0])
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed max-qps value - %s", arg)
+			return
+		}
+	}
+
+	recursors, present := mapping["recursor"]
+	if present {
+		domain.Recursors = recursors
+	}
+
+	cacheNamespace, present := mapping["cache-namespace"]
+	if present {
+		domain.CacheNamespace = cacheNamespace[0]
+	}
+
+	for _, bound := range []struct {
+		key   string
+		value *uint32
+	}{
+		{"cache-min-ttl", &domain.CacheMinTTL},
+		{"cache-max-ttl", &domain.CacheMaxTTL},
+	} {
+		ttl, present := mapping[bound.key]
+		if !present {
+			continue
+		}
+
+		var value uint64
+		value, err = strconv.ParseUint(ttl[0], 10, 32)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed %s value - %s", bound.key, arg)
+			return
+		}
+
+		*bound.value = uint32(value)
+	}
+
+	noCache, present := mapping["no-cache"]
+	if present {
+		domain.NoCache, err = strconv.ParseBool(noCache[0])
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed no-cache value - %s", arg)
+			return
+		}
+	}
+
+	fallback, present := mapping["fallback"]
+	if present {
+		domain.Fallback = fallback
+	}
+
+	responses, present := mapping["response"]
+	if present {
+		domain.Responses = responses
+	}
+
+	logLevel, present := mapping["log"]
+	if present {
+		domain.LogLevel = logLevel[0]
+	}
+
+	ttl, present := mapping["ttl"]
+	if present {
+		var value uint64
+		value, err = strconv.ParseUint(ttl[0], 10, 32)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed ttl value - %s", arg)
+			return
+		}
+
+		domain.TTL = uint32(value)
+	}
+
+	cutover, present := mapping["cutover"]
+	if present {
+		domain.Cutover, err = time.Parse(time.RFC3339, cutover[0])
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed cutover value - %s", arg)
+			return
+		}
+	}
+
+	cutoverRamp, present := mapping["cutover-ramp"]
+	if present {
+		domain.CutoverRamp, err = time.ParseDuration(cutoverRamp[0])
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed cutover-ramp value - %s", arg)
+			return
+		}
+	}
+
+	cutoverTTL, present := mapping["cutover-ttl"]
+	if present {
+		var value uint64
+		value, err = strconv.ParseUint(cutoverTTL[0], 10, 32)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed cutover-ttl value - %s", arg)
+			return
+		}
+
+		domain.CutoverTTL = uint32(value)
+	}
+
+	check, present := mapping["check"]
+	if present {
+		domain.HealthCheck = HealthCheck(check[0])
+	}
+
+	checkPort, present := mapping["check-port"]
+	if present {
+		domain.HealthCheckPort, err = strconv.Atoi(checkPort[0])
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed check-port value - %s", arg)
+			return
+		}
+	}
+
+	checkPath, present := mapping["check-path"]
+	if present {
+		domain.HealthCheckPath = checkPath[0]
+	}
+
+	checkInterval, present := mapping["check-interval"]
+	if present {
+		domain.HealthCheckInterval, err = time.ParseDuration(checkInterval[0])
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed check-interval value - %s", arg)
+			return
+		}
+	}
+
+	truncate, present := mapping["truncate"]
+	if present {
+		domain.ForceTruncate, err = strconv.ParseBool(truncate[0])
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed truncate value - %s", arg)
+			return
+		}
+	}
+
+	compress, present := mapping["compress"]
+	if present {
+		var value bool
+		value, err = strconv.ParseBool(compress[0])
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed compress value - %s", arg)
+			return
+		}
+
+		domain.Compress = &value
+	}
+
+	return
+}
+
+// ParseDomainArgs converts a list of domain configuration
+// strings into domains, failing on the first malformed one.
+func ParseDomainArgs(args []string) (domains []*Domain, err error) {
+	var domain *Domain
+
+	domains = make([]*Domain, 0, len(args))
+	for _, arg := range args {
+		domain, err = ParseDomainArg(arg)
+		if err != nil {
+			return
+		}
+
+		domains = append(domains, domain)
+	}
+
+	return
+}
+
+// ParseZoneArg converts a zone configuration string (e.g.:
+// 'zone=a.com,ns=ns1.a.com,mbox=admin@a.com,serial=10' or,
+// with default nameservers for its domains,
+// 'zone=a.com,ns=ns1.a.com,mbox=admin@a.com,default-ns=ns1.a.com')
+// into a Zone.
+func ParseZoneArg(arg string) (zone *Zone, err error) {
+	mapping, err := util.CsvStringToMap(arg)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"malformed zone configuration")
+		return
+	}
+
+	name, present := mapping["zone"]
+	if !present {
+		err = errors.Errorf(
+			"a zone name must be present - %s", arg)
+		return
+	}
+
+	zone = &Zone{Name: name[0]}
+
+	if ns, present := mapping["ns"]; present {
+		zone.Ns = ns[0]
+	}
+
+	if mbox, present := mapping["mbox"]; present {
+		zone.Mbox = mbox[0]
+	}
+
+	if nameservers, present := mapping["default-ns"]; present {
+		zone.Nameservers = nameservers
+	}
+
+	if format, present := mapping["serial-format"]; present {
+		zone.SerialFormat = SerialFormat(format[0])
+	}
+
+	var timers = []struct {
+		key   string
+		value *uint32
+	}{
+		{"serial", &zone.Serial},
+		{"refresh", &zone.Refresh},
+		{"retry", &zone.Retry},
+		{"expire", &zone.Expire},
+		{"minttl", &zone.Minttl},
+	}
+
+	for _, timer := range timers {
+		values, present := mapping[timer.key]
+		if !present {
+			continue
+		}
+
+		var value uint64
+		value, err = strconv.ParseUint(values[0], 10, 32)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed %s - %s", timer.key, arg)
+			return
+		}
+
+		*timer.value = uint32(value)
+	}
+
+	return
+}
@@ -0,0 +1,408 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestParseDomainArgs(t *testing.T) {
+	var uncompressed = false
+
+	var testCases = []struct {
+		name        string
+		input       []string
+		expected    []*Domain
+		shouldError bool
+	}{
+		{
+			name:     "no domains",
+			input:    []string{},
+			expected: []*Domain{},
+		},
+		{
+			name:  "only domain",
+			input: []string{"domain=a.com"},
+			expected: []*Domain{
+				{Name: "a.com"},
+			},
+		},
+		{
+			name:  "ips and nameservers",
+			input: []string{"domain=a.com,ip=1.1.1.1,ip=2.2.2.2,ns=ns1.a.com"},
+			expected: []*Domain{
+				{
+					Name:        "a.com",
+					Addresses:   []string{"1.1.1.1", "2.2.2.2"},
+					Nameservers: []string{"ns1.a.com"},
+				},
+			},
+		},
+		{
+			name:  "weighted ips",
+			input: []string{"domain=a.com,ip=1.1.1.1;weight=80,ip=2.2.2.2;weight=20"},
+			expected: []*Domain{
+				{
+					Name:      "a.com",
+					Addresses: []string{"1.1.1.1;weight=80", "2.2.2.2;weight=20"},
+				},
+			},
+		},
+		{
+			name:  "multiple domains",
+			input: []string{"domain=a.com,ip=1.1.1.1", "domain=*.b.com,ns=ns1.b.com"},
+			expected: []*Domain{
+				{Name: "a.com", Addresses: []string{"1.1.1.1"}},
+				{Name: "*.b.com", Nameservers: []string{"ns1.b.com"}},
+			},
+		},
+		{
+			name:  "alias",
+			input: []string{"domain=www.a.com,cname=a.com"},
+			expected: []*Domain{
+				{Name: "www.a.com", Cname: "a.com"},
+			},
+		},
+		{
+			name:  "mail exchangers",
+			input: []string{"domain=a.com,mx=10 mx1.a.com,mx=20 mx2.a.com"},
+			expected: []*Domain{
+				{
+					Name:           "a.com",
+					MailExchangers: []string{"10 mx1.a.com", "20 mx2.a.com"},
+				},
+			},
+		},
+		{
+			name:  "texts",
+			input: []string{"domain=a.com,txt=v=spf1 -all,txt=token"},
+			expected: []*Domain{
+				{Name: "a.com", Texts: []string{"v=spf1 -all", "token"}},
+			},
+		},
+		{
+			name:  "services",
+			input: []string{"domain=a.com,srv=10 60 5060 sip.a.com,service=_dmarc TXT v=DMARC1; p=none"},
+			expected: []*Domain{
+				{
+					Name:     "a.com",
+					SRV:      []string{"10 60 5060 sip.a.com"},
+					Services: []string{"_dmarc TXT v=DMARC1; p=none"},
+				},
+			},
+		},
+		{
+			name:  "forced truncation",
+			input: []string{"domain=a.com,truncate=true"},
+			expected: []*Domain{
+				{Name: "a.com", ForceTruncate: true},
+			},
+		},
+		{
+			name:  "wildcard with apex types",
+			input: []string{"domain=*.a.com,ip=1.1.1.1,apex=A,apex=NS"},
+			expected: []*Domain{
+				{
+					Name:      "*.a.com",
+					Addresses: []string{"1.1.1.1"},
+					ApexTypes: []string{"A", "NS"},
+				},
+			},
+		},
+		{
+			name:  "wildcard with types",
+			input: []string{"domain=*.a.com,ip=1.1.1.1,wildcard-types=A,wildcard-types=AAAA,wildcard-policy=nodata"},
+			expected: []*Domain{
+				{
+					Name:           "*.a.com",
+					Addresses:      []string{"1.1.1.1"},
+					WildcardTypes:  []string{"A", "AAAA"},
+					WildcardPolicy: WildcardNodata,
+				},
+			},
+		},
+		{
+			name:  "fallback chain",
+			input: []string{"domain=a.com,cname=b.com,ip=1.1.1.1,fallback=A,fallback=CNAME"},
+			expected: []*Domain{
+				{
+					Name:      "a.com",
+					Cname:     "b.com",
+					Addresses: []string{"1.1.1.1"},
+					Fallback:  []string{"A", "CNAME"},
+				},
+			},
+		},
+		{
+			name:  "address mode",
+			input: []string{"domain=a.com,ip=1.1.1.1,addresses=all"},
+			expected: []*Domain{
+				{
+					Name:        "a.com",
+					Addresses:   []string{"1.1.1.1"},
+					AddressMode: AddressesAll,
+				},
+			},
+		},
+		{
+			name:  "nameserver order",
+			input: []string{"domain=a.com,ns=ns2.a.com,ns=ns1.a.com,ns-order=sorted"},
+			expected: []*Domain{
+				{
+					Name:            "a.com",
+					Nameservers:     []string{"ns2.a.com", "ns1.a.com"},
+					NameserverOrder: NameserversSorted,
+				},
+			},
+		},
+		{
+			name:  "delegation policy",
+			input: []string{"domain=sub.a.com,ns=ns1.b.com,delegation=referral"},
+			expected: []*Domain{
+				{
+					Name:        "sub.a.com",
+					Nameservers: []string{"ns1.b.com"},
+					Delegation:  DelegationReferral,
+				},
+			},
+		},
+		{
+			name:  "transport policy",
+			input: []string{"domain=a.com,ip=1.1.1.1,transport=tcp-only"},
+			expected: []*Domain{
+				{
+					Name:      "a.com",
+					Addresses: []string{"1.1.1.1"},
+					Transport: TransportTCPOnly,
+				},
+			},
+		},
+		{
+			name:  "max qps",
+			input: []string{"domain=a.com,ip=1.1.1.1,max-qps=50"},
+			expected: []*Domain{
+				{Name: "a.com", Addresses: []string{"1.1.1.1"}, MaxQPS: 50},
+			},
+		},
+		{
+			name:        "malformed max qps",
+			input:       []string{"domain=a.com,max-qps=lol"},
+			shouldError: true,
+		},
+		{
+			name:  "health checks",
+			input: []string{"domain=a.com,ip=10.0.0.1,check=http,check-port=8080,check-path=/healthz,check-interval=5s"},
+			expected: []*Domain{
+				{
+					Name:                "a.com",
+					Addresses:           []string{"10.0.0.1"},
+					HealthCheck:         HealthCheckHTTP,
+					HealthCheckPort:     8080,
+					HealthCheckPath:     "/healthz",
+					HealthCheckInterval: 5 * time.Second,
+				},
+			},
+		},
+		{
+			name:        "malformed health check port",
+			input:       []string{"domain=a.com,check=tcp,check-port=lol"},
+			shouldError: true,
+		},
+		{
+			name:        "malformed health check interval",
+			input:       []string{"domain=a.com,check=tcp,check-interval=lol"},
+			shouldError: true,
+		},
+		{
+			name:  "conditional forwarding",
+			input: []string{"domain=*.corp.internal,recursor=10.0.0.1:53,recursor=10.0.0.2:53"},
+			expected: []*Domain{
+				{
+					Name:      "*.corp.internal",
+					Recursors: []string{"10.0.0.1:53", "10.0.0.2:53"},
+				},
+			},
+		},
+		{
+			name: "forwarding cache policy",
+			input: []string{"domain=*.corp.internal,recursor=10.0.0.1:53," +
+				"cache-namespace=internal,cache-min-ttl=5,cache-max-ttl=60,no-cache=true"},
+			expected: []*Domain{
+				{
+					Name:           "*.corp.internal",
+					Recursors:      []string{"10.0.0.1:53"},
+					CacheNamespace: "internal",
+					CacheMinTTL:    5,
+					CacheMaxTTL:    60,
+					NoCache:        true,
+				},
+			},
+		},
+		{
+			name:        "malformed cache max ttl",
+			input:       []string{"domain=*.corp.internal,recursor=10.0.0.1:53,cache-max-ttl=lol"},
+			shouldError: true,
+		},
+		{
+			name:  "compression override",
+			input: []string{"domain=a.com,compress=false"},
+			expected: []*Domain{
+				{Name: "a.com", Compress: &uncompressed},
+			},
+		},
+		{
+			name:  "ttl",
+			input: []string{"domain=a.com,ip=1.1.1.1,ttl=30"},
+			expected: []*Domain{
+				{Name: "a.com", Addresses: []string{"1.1.1.1"}, TTL: 30},
+			},
+		},
+		{
+			name:  "cutover",
+			input: []string{"domain=a.com,ip=1.1.1.1,cutover=2026-10-16T12:00:00Z,cutover-ramp=1h,cutover-ttl=5"},
+			expected: []*Domain{
+				{
+					Name:        "a.com",
+					Addresses:   []string{"1.1.1.1"},
+					Cutover:     time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC),
+					CutoverRamp: time.Hour,
+					CutoverTTL:  5,
+				},
+			},
+		},
+		{
+			name:  "canned responses",
+			input: []string{"domain=a.com,response=A a.bin,response=TXT txt.dig"},
+			expected: []*Domain{
+				{Name: "a.com", Responses: []string{"A a.bin", "TXT txt.dig"}},
+			},
+		},
+		{
+			name:  "log level",
+			input: []string{"domain=a.com,log=debug"},
+			expected: []*Domain{
+				{Name: "a.com", LogLevel: "debug"},
+			},
+		},
+		{
+			name:        "malformed ttl",
+			input:       []string{"domain=a.com,ttl=-1"},
+			shouldError: true,
+		},
+		{
+			name:        "malformed cutover",
+			input:       []string{"domain=a.com,cutover=tomorrow"},
+			shouldError: true,
+		},
+		{
+			name:        "malformed compression",
+			input:       []string{"domain=a.com,compress=lol"},
+			shouldError: true,
+		},
+		{
+			name:        "malformed truncation",
+			input:       []string{"domain=a.com,truncate=lol"},
+			shouldError: true,
+		},
+		{
+			name:        "missing domain key",
+			input:       []string{"ip=1.1.1.1"},
+			shouldError: true,
+		},
+		{
+			name:        "malformed input",
+			input:       []string{"domain=a.com,lol"},
+			shouldError: true,
+		},
+		{
+			name:        "one malformed among valid",
+			input:       []string{"domain=a.com", ""},
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			domains, err := ParseDomainArgs(tc.input)
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, domains)
+		})
+	}
+}
+
+func TestParseZoneArg(t *testing.T) {
+	var testCases = []struct {
+		input       string
+		expected    *Zone
+		shouldError bool
+	}{
+		{
+			input: "zone=a.com,ns=ns1.a.com,mbox=admin@a.com",
+			expected: &Zone{
+				Name: "a.com",
+				Ns:   "ns1.a.com",
+				Mbox: "admin@a.com",
+			},
+		},
+		{
+			input: "zone=a.com,ns=ns1.a.com,mbox=admin.a.com,serial=10,refresh=1,retry=2,expire=3,minttl=4",
+			expected: &Zone{
+				Name:    "a.com",
+				Ns:      "ns1.a.com",
+				Mbox:    "admin.a.com",
+				Serial:  10,
+				Refresh: 1,
+				Retry:   2,
+				Expire:  3,
+				Minttl:  4,
+			},
+		},
+		{
+			input: "zone=a.com,ns=ns1.a.com,mbox=admin@a.com,default-ns=ns1.a.com,default-ns=ns2.a.com",
+			expected: &Zone{
+				Name:        "a.com",
+				Ns:          "ns1.a.com",
+				Mbox:        "admin@a.com",
+				Nameservers: []string{"ns1.a.com", "ns2.a.com"},
+			},
+		},
+		{
+			input: "zone=a.com,ns=ns1.a.com,mbox=admin@a.com,serial-format=date",
+			expected: &Zone{
+				Name:         "a.com",
+				Ns:           "ns1.a.com",
+				Mbox:         "admin@a.com",
+				SerialFormat: SerialDate,
+			},
+		},
+		{
+			input:       "ns=ns1.a.com",
+			shouldError: true,
+		},
+		{
+			input:       "zone=a.com,serial=lol",
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			zone, err := ParseZoneArg(tc.input)
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, zone)
+		})
+	}
+}
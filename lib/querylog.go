@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// summaryWriter records when a response got written, and
+// its rcode, for the summary of the query.
+type summaryWriter struct {
+	dns.ResponseWriter
+	written time.Time
+	rcode   int
+}
+
+func (w *summaryWriter) WriteMsg(m *dns.Msg) error {
+	w.written, w.rcode = time.Now(), m.Rcode
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// Write takes the rcode from the header of the packed
+// message (e.g.: canned responses).
+func (w *summaryWriter) Write(b []byte) (int, error) {
+	w.written = time.Now()
+	if len(b) >= 4 {
+		w.rcode = int(b[3] & 0xf)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// logSummary logs a single line summarizing how the query
+// was handled: the client, the question, the rcode and
+// where the answer came from, as well as the time it took
+// from being received to being answered.
+func (s *Sdns) logSummary(ctx *SdnsContext, r *dns.Msg, w *summaryWriter, start time.Time) {
+	event := ctx.logger.Info().
+		Str("client", ctx.client.String()).
+		Str("decision", string(ctx.decision))
+
+	if len(r.Question) > 0 {
+		event = event.
+			Str("name", r.Question[0].Name).
+			Str("qtype", dns.TypeToString[r.Question[0].Qtype])
+	}
+
+	if w.written.IsZero() {
+		event = event.
+			Bool("dropped", true).
+			Dur("duration", time.Since(start))
+	} else {
+		event = event.
+			Str("rcode", dns.RcodeToString[w.rcode]).
+			Dur("duration", w.written.Sub(start))
+	}
+
+	event.Msg("query handled")
+}
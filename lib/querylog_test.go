@@ -0,0 +1,92 @@
+package lib_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// summaries returns the summaries of the queries logged
+// (as JSON).
+func summaries(t *testing.T, logs *bytes.Buffer) (lines []map[string]interface{}) {
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var fields map[string]interface{}
+
+		assert.NoError(t, json.Unmarshal([]byte(line), &fields))
+		if fields["message"] == "query handled" {
+			lines = append(lines, fields)
+		}
+	}
+
+	return
+}
+
+func TestHandle_querySummary(t *testing.T) {
+	var (
+		logs     bytes.Buffer
+		recursor = startRecursor(t, answerHandler(0, "7.7.7.7"))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:             1232,
+		Recursors:        []string{recursor},
+		LogLevel:         "info",
+		LogOutput:        &logs,
+		RecursionClients: []string{"10.0.0.0/8"},
+		ClientRateLimit:  1,
+		ClientRateAction: RateLimitDrop,
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		client   string
+		name     string
+		rcode    string
+		decision Decision
+		dropped  bool
+	}{
+		{"10.0.0.1", "foo.com.", "NOERROR", DecisionLocal, false},
+		{"10.0.0.2", "bar.com.", "NOERROR", DecisionRecursed, false},
+		{"172.16.0.1", "bar.com.", "REFUSED", DecisionRejected, false},
+		{"172.16.0.1", "foo.com.", "", DecisionRejected, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.client+" "+tc.name, func(t *testing.T) {
+			logs.Reset()
+			clientQuery(s, tc.client, tc.name)
+
+			lines := summaries(t, &logs)
+			assert.Len(t, lines, 1)
+
+			summary := lines[0]
+			assert.Equal(t, "info", summary["level"])
+			assert.Equal(t, tc.client, summary["client"])
+			assert.Equal(t, tc.name, summary["name"])
+			assert.Equal(t, "A", summary["qtype"])
+			assert.Equal(t, string(tc.decision), summary["decision"])
+			assert.Contains(t, summary, "duration")
+
+			if tc.dropped {
+				assert.Equal(t, true, summary["dropped"])
+				assert.NotContains(t, summary, "rcode")
+			} else {
+				assert.Equal(t, tc.rcode, summary["rcode"])
+				assert.NotContains(t, summary, "dropped")
+			}
+		})
+	}
+
+	// the steps are only logged in debug.
+	logs.Reset()
+	clientQuery(s, "10.0.0.3", "foo.com")
+	assert.NotContains(t, logs.String(), "looking for domain")
+}
@@ -0,0 +1,161 @@
+package lib
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RateLimitAction determines what happens to the queries
+// of clients past their rate (see SdnsConfig.ClientRateLimit).
+type RateLimitAction string
+
+const (
+	// RateLimitRefuse answers them REFUSED.
+	RateLimitRefuse RateLimitAction = "refuse"
+
+	// RateLimitDrop doesn't answer them at all, which
+	// keeps spoofed clients from using sdns to amplify.
+	RateLimitDrop RateLimitAction = "drop"
+)
+
+// DefaultRateLimitSweep is how often the buckets of clients
+// that went quiet are dropped while listening.
+const DefaultRateLimitSweep = time.Minute
+
+// clientLimiter rate limits queries by client IP, each
+// client having its own token bucket. Clients in the
+// allowlist aren't limited.
+type clientLimiter struct {
+	sync.Mutex
+	rate      int
+	burst     int
+	action    RateLimitAction
+	allowlist []*net.IPNet
+	buckets   map[string]*tokenBucket
+}
+
+// newClientLimiter validates the rate limiting settings of
+// cfg, returning a nil limiter (i.e.: no limits) if the
+// rate is zero. The burst defaults to the rate.
+func newClientLimiter(cfg SdnsConfig) (limiter *clientLimiter, err error) {
+	switch {
+	case cfg.ClientRateLimit < 0:
+		err = errors.Errorf("client rate limit must not be negative - %d",
+			cfg.ClientRateLimit)
+	case cfg.ClientRateBurst < 0:
+		err = errors.Errorf("client rate burst must not be negative - %d",
+			cfg.ClientRateBurst)
+	case cfg.ClientRateAction != "" && cfg.ClientRateAction != RateLimitRefuse &&
+		cfg.ClientRateAction != RateLimitDrop:
+		err = errors.Errorf("unknown rate limit action %s", cfg.ClientRateAction)
+	}
+
+	if err != nil || cfg.ClientRateLimit == 0 {
+		return
+	}
+
+	limiter = &clientLimiter{
+		rate:    cfg.ClientRateLimit,
+		burst:   cfg.ClientRateBurst,
+		action:  cfg.ClientRateAction,
+		buckets: make(map[string]*tokenBucket),
+	}
+
+	if limiter.burst == 0 {
+		limiter.burst = limiter.rate
+	}
+
+	if limiter.action == "" {
+		limiter.action = RateLimitRefuse
+	}
+
+	limiter.allowlist, err = parseSubnets(cfg.ClientRateAllowlist)
+	if err != nil {
+		err = errors.Wrapf(err, "invalid rate limit allowlist")
+		limiter = nil
+		return
+	}
+
+	return
+}
+
+// allow tells whether the client may be answered at now.
+// Unknown clients (e.g.: without an IP) always are.
+func (l *clientLimiter) allow(client net.IP, now time.Time) bool {
+	if client == nil {
+		return true
+	}
+
+	if containsIP(l.allowlist, client) {
+		return true
+	}
+
+	key := client.String()
+
+	l.Lock()
+	bucket, found := l.buckets[key]
+	if !found {
+		bucket = newTokenBucket(l.rate, l.burst)
+		l.buckets[key] = bucket
+	}
+	l.Unlock()
+
+	return bucket.take(now)
+}
+
+// sweep drops the buckets that refilled, which new ones
+// would be no different from.
+func (l *clientLimiter) sweep(now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+
+	for key, bucket := range l.buckets {
+		if bucket.idle(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// len is the number of clients being tracked.
+func (l *clientLimiter) len() int {
+	l.Lock()
+	defer l.Unlock()
+
+	return len(l.buckets)
+}
+
+// rateLimited tells whether the client of the query went
+// past its rate.
+func (s *Sdns) rateLimited(ctx *SdnsContext) (limited bool) {
+	if s.limiter == nil || s.limiter.allow(ctx.client, s.now()) {
+		return
+	}
+
+	s.metrics.rateLimited.Inc()
+	ctx.logger.Info().
+		Str("client", ctx.client.String()).
+		Str("action", string(s.limiter.action)).
+		Msg("client rate limited")
+
+	limited = true
+	return
+}
+
+// sweepClients periodically drops the buckets of clients
+// that went quiet until done is closed.
+func (s *Sdns) sweepClients(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.limiter.sweep(s.now())
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package lib_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// clientQuery queries the name as if coming from the IP,
+// returning the response (nil if none was written).
+func clientQuery(s *Sdns, ip string, name string) *dns.Msg {
+	var (
+		w = &testWriter{remote: &net.UDPAddr{IP: net.ParseIP(ip), Port: 40000}}
+		r = new(dns.Msg)
+	)
+
+	r.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	s.ServeDNS(w, r)
+
+	return w.msg
+}
+
+func TestHandle_clientRateLimit(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		action   RateLimitAction
+		rate     int
+		burst    int
+		answered int
+		refilled int
+	}{
+		{name: "default", rate: 5, answered: 5, refilled: 5},
+		{name: "burst", rate: 1, burst: 3, answered: 3, refilled: 1},
+		{name: "refuse", action: RateLimitRefuse, rate: 5, answered: 5, refilled: 5},
+		{name: "drop", action: RateLimitDrop, rate: 5, answered: 5, refilled: 5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var now = time.Unix(1000, 0)
+
+			s, err := NewSdns(SdnsConfig{
+				Port:                1232,
+				Recursors:           []string{"127.0.0.1:1"},
+				ClientRateLimit:     tc.rate,
+				ClientRateBurst:     tc.burst,
+				ClientRateAction:    tc.action,
+				ClientRateAllowlist: []string{"192.168.0.0/16"},
+				Clock:               func() time.Time { return now },
+				Domains: []*Domain{
+					{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+				},
+			})
+			assert.NoError(t, err)
+
+			assertLimited := func(ip string, answered int) {
+				for i := 0; i < 10; i++ {
+					m := clientQuery(s, ip, "foo.com")
+
+					switch {
+					case i < answered:
+						assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+					case tc.action == RateLimitDrop:
+						assert.Nil(t, m)
+					default:
+						assert.Equal(t, dns.RcodeRefused, m.Rcode)
+						assert.Equal(t, []string{}, answerIPs(m))
+					}
+				}
+			}
+
+			// fired above the limit, only the excess of
+			// each client is limited.
+			assertLimited("10.0.0.1", tc.answered)
+			assertLimited("10.0.0.2", tc.answered)
+			assertLimited("192.168.1.1", 10)
+
+			// refilled at the rate.
+			now = now.Add(time.Second)
+
+			assertLimited("10.0.0.1", tc.refilled)
+		})
+	}
+}
+
+func TestHandle_clientRateLimitSweep(t *testing.T) {
+	var now = time.Unix(1000, 0)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:            1232,
+		Recursors:       []string{"127.0.0.1:1"},
+		ClientRateLimit: 2,
+		ClientRateBurst: 4,
+		Clock:           func() time.Time { return now },
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	clientQuery(s, "10.0.0.1", "foo.com")
+	clientQuery(s, "10.0.0.2", "foo.com")
+	clientQuery(s, "10.0.0.2", "foo.com")
+	assert.Equal(t, 2, s.RateLimitedClients())
+
+	// still refilling.
+	s.SweepClients(now.Add(250 * time.Millisecond))
+	assert.Equal(t, 2, s.RateLimitedClients())
+
+	// only the client that took a single token refilled.
+	s.SweepClients(now.Add(500 * time.Millisecond))
+	assert.Equal(t, 1, s.RateLimitedClients())
+
+	s.SweepClients(now.Add(time.Second))
+	assert.Equal(t, 0, s.RateLimitedClients())
+}
+
+func TestNewSdns_malformedClientRateLimit(t *testing.T) {
+	var testCases = []struct {
+		name string
+		cfg  SdnsConfig
+	}{
+		{"negative limit", SdnsConfig{ClientRateLimit: -1}},
+		{"negative burst", SdnsConfig{ClientRateLimit: 1, ClientRateBurst: -1}},
+		{"unknown action", SdnsConfig{ClientRateLimit: 1, ClientRateAction: "lol"}},
+		{"malformed allowlist", SdnsConfig{ClientRateLimit: 1, ClientRateAllowlist: []string{"10.0.0.1"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.Port = 1232
+			tc.cfg.Recursors = []string{"127.0.0.1:1"}
+
+			_, err := NewSdns(tc.cfg)
+			assert.Error(t, err)
+		})
+	}
+}
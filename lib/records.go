@@ -0,0 +1,576 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// defaultTTL is the TTL of the records built by sdns when
+// not configured, the same that dns.NewRR defaults to.
+const defaultTTL uint32 = 3600
+
+// maxTxtChunk is the maximum length of each of the
+// character-strings that compose a TXT record.
+const maxTxtChunk = 255
+
+// mailExchanger is the parsed form of an entry of
+// Domain.MailExchangers.
+type mailExchanger struct {
+	preference uint16
+	host       string
+}
+
+// parseMailExchanger parses a mail exchanger entry in the
+// form '<priority> <host>' (e.g.: '10 mail.foo.com').
+func parseMailExchanger(entry string) (mx mailExchanger, err error) {
+	fields := strings.Fields(entry)
+	if len(fields) != 2 {
+		err = errors.Errorf(
+			"mail exchanger must be in the form '<priority> <host>' - %s",
+			entry)
+		return
+	}
+
+	preference, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"malformed mail exchanger priority - %s",
+			entry)
+		return
+	}
+
+	mx.preference = uint16(preference)
+	mx.host = dns.Fqdn(fields[1])
+	return
+}
+
+// certAuthority is the parsed form of an entry of
+// Domain.CAA.
+type certAuthority struct {
+	flag  uint8
+	tag   string
+	value string
+}
+
+// parseCertAuthority parses a CAA entry in the form
+// '<flag> <tag> <value>' (e.g.: '0 issue "letsencrypt.org"').
+// Quotes surrounding the value are optional.
+func parseCertAuthority(entry string) (caa certAuthority, err error) {
+	fields := strings.SplitN(strings.TrimSpace(entry), " ", 3)
+	if len(fields) != 3 {
+		err = errors.Errorf(
+			"CAA must be in the form '<flag> <tag> <value>' - %s",
+			entry)
+		return
+	}
+
+	flag, err := strconv.ParseUint(fields[0], 10, 8)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"malformed CAA flag - %s", entry)
+		return
+	}
+
+	tag := fields[1]
+	if tag == "" || strings.TrimLeft(strings.ToLower(tag),
+		"abcdefghijklmnopqrstuvwxyz0123456789") != "" {
+		err = errors.Errorf(
+			"CAA tag must be alphanumeric - %s", entry)
+		return
+	}
+
+	value := strings.TrimSpace(fields[2])
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	caa.flag = uint8(flag)
+	caa.tag = tag
+	caa.value = value
+	return
+}
+
+// domainRecords are the records of a domain parsed out of
+// its configuration. They get replaced as a whole whenever
+// the domain is loaded so that reloads don't race with the
+// queries being answered.
+type domainRecords struct {
+	nameservers     []string
+	addressesV4     []string
+	addressesV6     []string
+	weightsV4       []uint32
+	weightsV6       []uint32
+	mailExchangers  []mailExchanger
+	certAuthorities []certAuthority
+	serviceLocators []serviceLocator
+	fallback        []uint16
+	logLevel        zerolog.Level
+	cannedResponses map[uint16][]byte
+	health          *addressHealth
+	bucket          *tokenBucket
+	wildcardTypes   map[uint16]bool
+}
+
+// DefaultFallback is the chain of record types that address
+// questions are answered with when a domain doesn't set one:
+// aliases take precedence over addresses.
+var DefaultFallback = []string{"CNAME", "A", "AAAA"}
+
+// CnamePolicy determines how domains that set both addresses
+// and an alias, without a fallback chain picking between them
+// (see Domain.Fallback), are handled when loaded.
+type CnamePolicy string
+
+const (
+	// CnamePrecedence loads the domain with a warning. The
+	// alias answers address questions (see DefaultFallback)
+	// and the addresses only answer reverse lookups.
+	CnamePrecedence CnamePolicy = "precedence"
+
+	// CnameReject fails loading the domain.
+	CnameReject CnamePolicy = "reject"
+)
+
+// validateCnamePolicy makes sure that the policy is known,
+// accepting the empty one (i.e.: the default).
+func validateCnamePolicy(policy CnamePolicy) (err error) {
+	switch policy {
+	case "", CnamePrecedence, CnameReject:
+	default:
+		err = errors.Errorf("unknown cname policy %s", policy)
+	}
+
+	return
+}
+
+// ambiguous tells whether the domain sets both addresses and
+// an alias without a fallback chain picking between them.
+func (d *Domain) ambiguous() bool {
+	return d.Cname != "" && len(d.Addresses) > 0 && len(d.Fallback) == 0
+}
+
+// checkAmbiguity handles domains that are ambiguous as to
+// what answers address questions according to the cname
+// policy, either warning about them or rejecting them.
+func (s *Sdns) checkAmbiguity(domain *Domain) (err error) {
+	if !domain.ambiguous() {
+		return
+	}
+
+	if s.cnamePolicy == CnameReject {
+		err = errors.Errorf(
+			"domain %s sets both addresses and a cname - "+
+				"a fallback chain must pick between them",
+			domain.Name)
+		return
+	}
+
+	s.logger.Warn().
+		Str("domain", domain.Name).
+		Str("cname", domain.Cname).
+		Msg("cname takes precedence over addresses")
+	return
+}
+
+// parseFallback parses a chain of record types that address
+// questions can be answered with.
+func parseFallback(chain []string) (fallback []uint16, err error) {
+	if len(chain) == 0 {
+		chain = DefaultFallback
+	}
+
+	fallback = make([]uint16, 0, len(chain))
+	for _, typeName := range chain {
+		rrtype := dns.StringToType[strings.ToUpper(typeName)]
+		switch rrtype {
+		case dns.TypeA, dns.TypeAAAA, dns.TypeCNAME:
+		default:
+			err = errors.Errorf(
+				"fallback types must be A, AAAA or CNAME - %s",
+				typeName)
+			return
+		}
+
+		fallback = append(fallback, rrtype)
+	}
+
+	return
+}
+
+// resolution tells which record type, out of the fallback
+// chain of the domain, answers an address question of type
+// qtype (A or AAAA), or zero if none does.
+func (d *Domain) resolution(qtype uint16) uint16 {
+	records := d.loaded()
+
+	for _, rrtype := range records.fallback {
+		switch {
+		case rrtype == dns.TypeCNAME && d.Cname != "":
+			return rrtype
+		case rrtype == dns.TypeA && qtype == rrtype && len(records.addressesV4) > 0:
+			return rrtype
+		case rrtype == dns.TypeAAAA && qtype == rrtype && len(records.addressesV6) > 0:
+			return rrtype
+		}
+	}
+
+	return 0
+}
+
+// splitAddresses splits the addresses of a domain by family.
+// Hostnames are resolved at answer time to addresses of the
+// family asked for, so they go in both pools.
+// Weights are only kept if any of the addresses has one.
+func splitAddresses(entries []string) (v4, v6 []string, weightsV4, weightsV6 []uint32) {
+	var keepWeights = weighted(entries)
+
+	for _, entry := range entries {
+		address, weight, _ := parseAddress(entry)
+
+		ip := net.ParseIP(address)
+		if ip == nil || ip.To4() != nil {
+			v4 = append(v4, address)
+			if keepWeights {
+				weightsV4 = append(weightsV4, weight)
+			}
+		}
+
+		if ip == nil || ip.To4() == nil {
+			v6 = append(v6, address)
+			if keepWeights {
+				weightsV6 = append(weightsV6, weight)
+			}
+		}
+	}
+
+	return
+}
+
+// buildRecords parses and validates the records configured
+// for the domain, which only get answered with once stored
+// (see storeRecords).
+func (d *Domain) buildRecords() (records *domainRecords, err error) {
+	var (
+		mx       mailExchanger
+		caa      certAuthority
+		srv      serviceLocator
+		qtype    uint16
+		response []byte
+	)
+
+	records = &domainRecords{health: &addressHealth{}}
+
+	err = validateAddresses(d.Addresses)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"invalid addresses for domain %s", d.Name)
+		return
+	}
+
+	records.addressesV4, records.addressesV6,
+		records.weightsV4, records.weightsV6 = splitAddresses(d.Addresses)
+
+	err = validateNameservers(d.Nameservers)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"invalid nameservers for domain %s", d.Name)
+		return
+	}
+
+	records.nameservers = d.Nameservers
+
+	err = validateRecursors(d.Recursors)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"invalid recursors for domain %s", d.Name)
+		return
+	}
+
+	err = validateCachePolicy(d)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"invalid cache policy for domain %s", d.Name)
+		return
+	}
+
+	err = validateAddressMode(d.AddressMode)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"invalid address mode for domain %s", d.Name)
+		return
+	}
+
+	err = validateHealthCheck(d)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"invalid health check for domain %s", d.Name)
+		return
+	}
+
+	err = validateNameserverOrder(d.NameserverOrder)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"invalid nameserver order for domain %s", d.Name)
+		return
+	}
+
+	err = validateDelegation(d.Delegation)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"invalid delegation policy for domain %s", d.Name)
+		return
+	}
+
+	if (len(d.WildcardTypes) > 0 || d.WildcardPolicy != "") && !strings.HasPrefix(d.Name, "*") {
+		err = errors.Errorf(
+			"domain %s: wildcard types can only be set on wildcards",
+			d.Name)
+		return
+	}
+
+	records.wildcardTypes, err = parseWildcardTypes(d.WildcardTypes)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"invalid wildcard types for domain %s", d.Name)
+		return
+	}
+
+	err = validateWildcardPolicy(d.WildcardPolicy)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"invalid wildcard policy for domain %s", d.Name)
+		return
+	}
+
+	err = validateTransport(d.Transport)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"invalid transport policy for domain %s", d.Name)
+		return
+	}
+
+	if d.Cutover.IsZero() && (d.CutoverRamp != 0 || d.CutoverTTL != 0) {
+		err = errors.Errorf(
+			"domain %s: cutover ramp and ttl need a cutover",
+			d.Name)
+		return
+	}
+
+	if d.CutoverRamp < 0 {
+		err = errors.Errorf(
+			"cutover ramp must not be negative for domain %s - %s",
+			d.Name, d.CutoverRamp)
+		return
+	}
+
+	if d.MaxQPS < 0 {
+		err = errors.Errorf(
+			"max qps must not be negative for domain %s - %d",
+			d.Name, d.MaxQPS)
+		return
+	}
+
+	if d.MaxQPS > 0 {
+		records.bucket = newTokenBucket(d.MaxQPS, d.MaxQPS)
+	}
+
+	records.fallback, err = parseFallback(d.Fallback)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"invalid fallback for domain %s", d.Name)
+		return
+	}
+
+	records.logLevel, err = parseLogLevel(d.LogLevel)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"invalid log level for domain %s", d.Name)
+		return
+	}
+
+	records.mailExchangers = make([]mailExchanger, 0, len(d.MailExchangers))
+	for _, entry := range d.MailExchangers {
+		mx, err = parseMailExchanger(entry)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"invalid MX for domain %s", d.Name)
+			return
+		}
+
+		records.mailExchangers = append(records.mailExchangers, mx)
+	}
+
+	records.certAuthorities = make([]certAuthority, 0, len(d.CAA))
+	for _, entry := range d.CAA {
+		caa, err = parseCertAuthority(entry)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"invalid CAA for domain %s", d.Name)
+			return
+		}
+
+		records.certAuthorities = append(records.certAuthorities, caa)
+	}
+
+	records.serviceLocators = make([]serviceLocator, 0, len(d.SRV))
+	for _, entry := range d.SRV {
+		srv, err = parseServiceLocator(entry)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"invalid SRV for domain %s", d.Name)
+			return
+		}
+
+		records.serviceLocators = append(records.serviceLocators, srv)
+	}
+
+	records.cannedResponses = make(map[uint16][]byte, len(d.Responses))
+	for _, entry := range d.Responses {
+		qtype, response, err = parseCannedResponse(entry)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"invalid canned response for domain %s", d.Name)
+			return
+		}
+
+		records.cannedResponses[qtype] = response
+	}
+
+	return
+}
+
+// storeRecords makes the records built by a load get
+// answered with, which only happens once the whole load
+// succeeded so that the domains loaded are left alone
+// otherwise.
+func storeRecords(pending map[*Domain]*domainRecords) {
+	for domain, records := range pending {
+		domain.records.Store(records)
+	}
+}
+
+// loaded retrieves the records last loaded. Domains that
+// were never loaded (e.g.: used on their own) only get
+// their addresses and the default fallback.
+func (d *Domain) loaded() (records *domainRecords) {
+	records, _ = d.records.Load().(*domainRecords)
+	if records != nil {
+		return
+	}
+
+	records = &domainRecords{
+		nameservers: d.Nameservers,
+		health:      &addressHealth{},
+	}
+	records.addressesV4, records.addressesV6,
+		records.weightsV4, records.weightsV6 = splitAddresses(d.Addresses)
+	records.fallback, _ = parseFallback(nil)
+	records.logLevel = zerolog.NoLevel
+	return
+}
+
+func (s *Sdns) answerMX(ctx *SdnsContext, m *dns.Msg) (err error) {
+	var (
+		name string = m.Question[0].Name
+		rr   dns.RR
+	)
+
+	ctx.logger.Debug().
+		Str("name", name).
+		Str("query", "MX").
+		Msg("looking for domain")
+
+	domain, found := s.findDomain(strings.TrimRight(name, "."))
+	if !found {
+		err = ErrDomainNotFound
+		return
+	}
+
+	for _, mx := range domain.loaded().mailExchangers {
+		rr, err = dns.NewRR(fmt.Sprintf("%s MX %d %s",
+			name, mx.preference, mx.host))
+		if err != nil {
+			err = errors.Wrapf(err, "Couldn't create RR msg")
+			return
+		}
+		rr.Header().Ttl = s.answerTTL(domain)
+		m.Answer = append(m.Answer, rr)
+	}
+	return
+}
+
+// splitTxt splits a text into chunks that fit in TXT
+// character-strings.
+func splitTxt(text string) (chunks []string) {
+	for len(text) > maxTxtChunk {
+		chunks = append(chunks, text[:maxTxtChunk])
+		text = text[maxTxtChunk:]
+	}
+
+	chunks = append(chunks, text)
+	return
+}
+
+func (s *Sdns) answerTXT(ctx *SdnsContext, m *dns.Msg) (err error) {
+	var name string = m.Question[0].Name
+
+	ctx.logger.Debug().
+		Str("name", name).
+		Str("query", "TXT").
+		Msg("looking for domain")
+
+	domain, found := s.findDomain(strings.TrimRight(name, "."))
+	if !found {
+		err = ErrDomainNotFound
+		return
+	}
+
+	for _, text := range domain.Texts {
+		m.Answer = append(m.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   name,
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassINET,
+				Ttl:    s.answerTTL(domain),
+			},
+			Txt: splitTxt(text),
+		})
+	}
+	return
+}
+
+func (s *Sdns) answerCAA(ctx *SdnsContext, m *dns.Msg) (err error) {
+	var name string = m.Question[0].Name
+
+	ctx.logger.Debug().
+		Str("name", name).
+		Str("query", "CAA").
+		Msg("looking for domain")
+
+	domain, found := s.findDomain(strings.TrimRight(name, "."))
+	if !found {
+		err = ErrDomainNotFound
+		return
+	}
+
+	for _, caa := range domain.loaded().certAuthorities {
+		m.Answer = append(m.Answer, &dns.CAA{
+			Hdr: dns.RR_Header{
+				Name:   name,
+				Rrtype: dns.TypeCAA,
+				Class:  dns.ClassINET,
+				Ttl:    s.answerTTL(domain),
+			},
+			Flag:  caa.flag,
+			Tag:   caa.tag,
+			Value: caa.value,
+		})
+	}
+	return
+}
@@ -0,0 +1,237 @@
+package lib_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_mx(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{
+				Name: "foo.com",
+				MailExchangers: []string{
+					"20 mail2.foo.com",
+					"10 mail1.foo.com.",
+				},
+			},
+			{
+				Name:      "nomail.com",
+				Addresses: []string{"1.1.1.1"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	m := query(s, "foo.com", dns.TypeMX)
+	assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+	assert.Equal(t, []string{
+		"foo.com.\t3600\tIN\tMX\t20 mail2.foo.com.",
+		"foo.com.\t3600\tIN\tMX\t10 mail1.foo.com.",
+	}, answerStrings(m))
+
+	m = query(s, "nomail.com", dns.TypeMX)
+	assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+	assert.Empty(t, m.Answer)
+}
+
+func TestLoad_malformedMX(t *testing.T) {
+	var testCases = []struct {
+		entry       string
+		shouldError bool
+	}{
+		{"10 mail.foo.com", false},
+		{"mail.foo.com", true},
+		{"lol mail.foo.com", true},
+		{"-1 mail.foo.com", true},
+		{"70000 mail.foo.com", true},
+		{"10 mail.foo.com extra", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.entry, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port: 1232,
+				Domains: []*Domain{
+					{
+						Name:           "foo.com",
+						MailExchangers: []string{tc.entry},
+					},
+				},
+			})
+
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestHandle_txt(t *testing.T) {
+	var long = strings.Repeat("a", 300) + strings.Repeat("b", 300)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{
+				Name:  "foo.com",
+				Texts: []string{"v=spf1 -all", "token=abc", long},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	// over TCP, as the response doesn't fit in 512 bytes.
+	var (
+		w = &testWriter{remote: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}}
+		r = new(dns.Msg)
+	)
+
+	r.SetQuestion("foo.com.", dns.TypeTXT)
+	s.ServeDNS(w, r)
+
+	// go through the wire format to make sure that
+	// what clients get is intact.
+	packed, err := w.msg.Pack()
+	assert.NoError(t, err)
+
+	m := new(dns.Msg)
+	assert.NoError(t, m.Unpack(packed))
+	assert.Len(t, m.Answer, 3)
+
+	texts := []string{}
+	for _, rr := range m.Answer {
+		txt, ok := rr.(*dns.TXT)
+		assert.True(t, ok)
+
+		for _, chunk := range txt.Txt {
+			assert.True(t, len(chunk) <= 255)
+		}
+
+		texts = append(texts, strings.Join(txt.Txt, ""))
+	}
+
+	assert.Equal(t, []string{"v=spf1 -all", "token=abc", long}, texts)
+	assert.Len(t, m.Answer[2].(*dns.TXT).Txt, 3)
+}
+
+func TestHandle_caa(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{
+				Name: "foo.com",
+				CAA: []string{
+					`0 issue "letsencrypt.org"`,
+					`128 iodef "mailto:security@foo.com"`,
+					`0 issuewild ;`,
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	var (
+		w = &testWriter{}
+		r = new(dns.Msg)
+	)
+
+	r.SetQuestion("foo.com.", dns.TypeCAA)
+	s.ServeDNS(w, r)
+
+	packed, err := w.msg.Pack()
+	assert.NoError(t, err)
+
+	m := new(dns.Msg)
+	assert.NoError(t, m.Unpack(packed))
+	assert.Equal(t, []string{
+		"foo.com.\t3600\tIN\tCAA\t0 issue \"letsencrypt.org\"",
+		"foo.com.\t3600\tIN\tCAA\t128 iodef \"mailto:security@foo.com\"",
+		"foo.com.\t3600\tIN\tCAA\t0 issuewild \";\"",
+	}, answerStrings(m))
+}
+
+func TestLoad_malformedCAA(t *testing.T) {
+	var testCases = []struct {
+		entry       string
+		shouldError bool
+	}{
+		{`0 issue "letsencrypt.org"`, false},
+		{`0 issue letsencrypt.org`, false},
+		{`0 issue`, true},
+		{`lol issue "letsencrypt.org"`, true},
+		{`256 issue "letsencrypt.org"`, true},
+		{`0 iss-ue "letsencrypt.org"`, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.entry, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port: 1232,
+				Domains: []*Domain{
+					{Name: "foo.com", CAA: []string{tc.entry}},
+				},
+			})
+
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestHandle_any(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{startRecursor(t, answerHandler(0, "7.7.7.7"))},
+		Domains: []*Domain{
+			{
+				Name:           "foo.com",
+				Addresses:      []string{"1.1.1.1", "::1"},
+				Nameservers:    []string{"ns1.foo.com."},
+				MailExchangers: []string{"10 mail.foo.com"},
+				Texts:          []string{"hello"},
+			},
+			{
+				Name:  "alias.com",
+				Cname: "foo.com",
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	m := query(s, "foo.com", dns.TypeANY)
+	assert.Equal(t, []string{
+		"foo.com.\t3600\tIN\tA\t1.1.1.1",
+		"foo.com.\t3600\tIN\tAAAA\t::1",
+		"foo.com.\t3600\tIN\tNS\tns1.foo.com.",
+		"foo.com.\t3600\tIN\tMX\t10 mail.foo.com.",
+		"foo.com.\t3600\tIN\tTXT\t\"hello\"",
+	}, answerStrings(m))
+
+	m = query(s, "alias.com", dns.TypeANY)
+	assert.Equal(t, []string{
+		"alias.com.\t3600\tIN\tCNAME\tfoo.com.",
+		"foo.com.\t3600\tIN\tA\t1.1.1.1",
+		"foo.com.\t3600\tIN\tAAAA\t::1",
+	}, answerStrings(m))
+
+	m = query(s, "unknown.com", dns.TypeANY)
+	assert.Equal(t, []string{"7.7.7.7"}, answerIPs(m))
+}
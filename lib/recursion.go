@@ -0,0 +1,430 @@
+package lib
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// ParallelPolicy determines which response wins when more
+// than one recursor answers a question in parallel mode.
+type ParallelPolicy string
+
+const (
+	// PolicyFirst uses the first successful response and
+	// cancels the exchanges that are still in flight.
+	PolicyFirst ParallelPolicy = "first"
+
+	// PolicyMostAnswers waits for every recursor and uses
+	// the response with the most answers. Ties are broken
+	// by the order in which recursors are configured.
+	PolicyMostAnswers ParallelPolicy = "most-answers"
+)
+
+// RecursorOrder determines the order in which recursors are
+// tried (or, when recursing in parallel, which ones are
+// preferred on ties).
+type RecursorOrder string
+
+const (
+	// OrderPriority always tries the recursors in the
+	// order they're configured.
+	OrderPriority RecursorOrder = "priority"
+
+	// OrderRoundRobin starts each question at the recursor
+	// after the one the previous question started at.
+	OrderRoundRobin RecursorOrder = "round-robin"
+
+	// OrderRandom tries the recursors in a random order.
+	OrderRandom RecursorOrder = "random"
+)
+
+// orderRecursors returns the recursors in the order that a
+// question tries them, spreading the load across them
+// unless the order is OrderPriority.
+func (s *Sdns) orderRecursors(recursors []string) (ordered []string) {
+	switch s.recursorOrder {
+	case OrderRoundRobin:
+		if len(recursors) == 0 {
+			return recursors
+		}
+
+		start := int((atomic.AddUint64(&s.nextRecursor, 1) - 1) % uint64(len(recursors)))
+		ordered = append(ordered, recursors[start:]...)
+		ordered = append(ordered, recursors[:start]...)
+	case OrderRandom:
+		ordered = append(ordered, recursors...)
+		rand.Shuffle(len(ordered), func(i, j int) {
+			ordered[i], ordered[j] = ordered[j], ordered[i]
+		})
+	default:
+		ordered = recursors
+	}
+
+	return
+}
+
+// DefaultRecursionTimeout bounds each of the steps (dial,
+// write and read) of an exchange with a recursor when not
+// configured.
+const DefaultRecursionTimeout = 2 * time.Second
+
+// orDefault returns the timeout or, if unset, the fallback.
+func orDefault(timeout, fallback time.Duration) time.Duration {
+	if timeout <= 0 {
+		return fallback
+	}
+
+	return timeout
+}
+
+func (s *Sdns) recurse(ctx *SdnsContext, m *dns.Msg, server string) (in *dns.Msg, err error) {
+	var (
+		rtt time.Duration
+		rm  = &dns.Msg{Question: s.rewriteQuestions(m.Question)}
+	)
+
+	rm.RecursionDesired = true
+	// whether data is authentic is decided by sdns'
+	// validation, never by what clients say.
+	rm.AuthenticatedData = false
+
+	recursionEDNS(ctx.request, rm)
+	s.forwardOptions(ctx.request, rm)
+	s.forwardClientSubnet(ctx, rm)
+	s.rewriteForRecursor(server, rm)
+
+	ctx.logger.Debug().
+		Str("server", server).
+		Msg("recursing question")
+
+	s.metrics.recursorAttempts.WithLabelValues(server).Inc()
+
+	start := time.Now()
+	in, rtt, err = s.exchange(ctx.context, rm, server)
+	ctx.trace.attempt(server, time.Since(start), err)
+	if err != nil {
+		// exchanges abandoned (e.g.: another recursor
+		// answered first) aren't the recursor's fault.
+		if ctx.context.Err() == nil {
+			s.metrics.recursorFailures.WithLabelValues(server).Inc()
+			s.health.failure(server)
+		}
+
+		err = errors.Wrapf(err,
+			"errored forwarding msg %+v",
+			*rm)
+		return
+	}
+
+	s.metrics.recursorSuccesses.WithLabelValues(server).Inc()
+	s.metrics.recursorRTT.WithLabelValues(server).Observe(rtt.Seconds())
+	s.health.success(server)
+
+	ctx.logger.Debug().
+		Str("server", server).
+		Dur("duration", rtt).
+		Msg("recursion finished")
+
+	return
+}
+
+// exchange performs the exchange with the server, giving
+// up as soon as the context gets cancelled.
+// dns.Client.ExchangeContext isn't used as it mutates the
+// client (not safe for concurrent use) and doesn't abort
+// in-flight exchanges.
+func (s *Sdns) exchange(ctx context.Context, m *dns.Msg, server string) (in *dns.Msg, rtt time.Duration, err error) {
+	type result struct {
+		in  *dns.Msg
+		rtt time.Duration
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		in, rtt, err := s.client.Exchange(m, server)
+		done <- result{in: in, rtt: rtt, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		in, rtt, err = res.in, res.rtt, res.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return
+}
+
+// copyRecursed copies the sections of a recursed response
+// into the reply, as well as whether recursion is available.
+// The upstream OPT record isn't copied as EDNS is negotiated
+// between sdns and the client (see echoOptions).
+func copyRecursed(m *dns.Msg, in *dns.Msg) {
+	m.RecursionAvailable = in.RecursionAvailable
+	m.Answer = in.Answer
+	m.Ns = in.Ns
+	m.Extra = append(m.Extra, withoutType(in.Extra, dns.TypeOPT)...)
+}
+
+// recurseQuestion forwards the question to the recursors,
+// either one after another or all at once, returning a
+// single response.
+func (s *Sdns) recurseQuestion(ctx *SdnsContext, m *dns.Msg) (in *dns.Msg, err error) {
+	var (
+		question           = m.Question[0]
+		recursors          = s.recursorsFor(question.Name)
+		domain, delegation = s.delegation(question.Name, s.rewriteQtype(question.Qtype))
+	)
+
+	if delegation == DelegationRecurse {
+		recursors, err = s.delegatedRecursors(ctx, domain)
+		if err != nil {
+			return
+		}
+	}
+
+	recursors = s.health.order(s.orderRecursors(recursors))
+
+	ctx.logger.Debug().
+		Strs("recursors", recursors).
+		Bool("parallel", s.parallel).
+		Msg("starting to recurse")
+
+	if s.parallel {
+		in, err = s.recurseParallel(ctx, m, recursors)
+		return
+	}
+
+	in, err = s.recurseSequential(ctx, m, recursors)
+	return
+}
+
+// Recursors returns the recursors currently in use.
+func (s *Sdns) Recursors() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.recursors
+}
+
+// SetRecursors swaps the recursors in use, leaving domains
+// untouched. The state kept about recursions (circuit
+// breaker, recursors' health, resolved hostnames and cached
+// responses) is reset as it refers to the previous recursors.
+func (s *Sdns) SetRecursors(recursors []string) (err error) {
+	if len(recursors) == 0 {
+		err = errors.Errorf("at least one recursor must be specified")
+		return
+	}
+
+	err = validateRecursors(recursors)
+	if err != nil {
+		return
+	}
+
+	recursors = append([]string(nil), recursors...)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.recursors = recursors
+	s.breaker.reset()
+	s.health.reset()
+	s.hostnames.reset()
+	if s.cache != nil {
+		s.cache.reset()
+	}
+	s.cacheGeneration = cacheGeneration(recursors, s.loaded.Domains)
+
+	s.logger.Debug().
+		Strs("recursors", recursors).
+		Msg("recursors set")
+	return
+}
+
+// recurseGuarded recurses the question unless the circuit
+// breaker suspended recursion of the name, keeping the
+// breaker posted about the outcome.
+func (s *Sdns) recurseGuarded(ctx *SdnsContext, m *dns.Msg) (in *dns.Msg, err error) {
+	qname := strings.ToLower(m.Question[0].Name)
+	if !s.breaker.allow(qname) {
+		ctx.logger.Warn().
+			Str("name", qname).
+			Msg("recursion suspended by breaker")
+		err = ErrRecursionSuspended
+		return
+	}
+
+	in, err = s.recurseQuestion(ctx, m)
+	if err != nil {
+		s.breaker.failure(qname)
+		return
+	}
+
+	if in.Rcode == dns.RcodeServerFailure {
+		s.breaker.failure(qname)
+	} else {
+		s.breaker.success(qname)
+	}
+
+	return
+}
+
+// validateRecursors makes sure that recursors are in the
+// form host:port.
+func validateRecursors(recursors []string) (err error) {
+	for _, recursor := range recursors {
+		_, port, splitErr := net.SplitHostPort(recursor)
+		if splitErr != nil {
+			err = errors.Wrapf(splitErr,
+				"malformed recursor %s", recursor)
+			return
+		}
+
+		_, err = strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed port of recursor %s", recursor)
+			return
+		}
+	}
+
+	return
+}
+
+// forwarded tells whether questions for the domain are
+// forwarded to recursors of its own instead of answered
+// locally (conditional forwarding).
+func (d *Domain) forwarded() bool {
+	return len(d.Recursors) > 0
+}
+
+// forwarder returns the forwarded domain that the name
+// belongs to, if any.
+func (s *Sdns) forwarder(name string) (domain *Domain, found bool) {
+	domain, found = s.findDomain(strings.TrimRight(name, "."))
+	if found && !domain.forwarded() {
+		domain, found = nil, false
+	}
+
+	return
+}
+
+// forwards tells whether the name belongs to a forwarded
+// domain, in which case it's never answered locally.
+func (s *Sdns) forwards(name string) bool {
+	_, found := s.forwarder(name)
+	return found
+}
+
+// recursorsFor tells the recursors that questions for a
+// name go to: the ones of the domain it belongs to, if
+// forwarded, or the global ones otherwise.
+func (s *Sdns) recursorsFor(name string) []string {
+	domain, found := s.forwarder(name)
+	if found {
+		return domain.Recursors
+	}
+
+	return s.Recursors()
+}
+
+// recurseSequential tries each recursor in order until one
+// of them answers.
+func (s *Sdns) recurseSequential(ctx *SdnsContext, m *dns.Msg, recursors []string) (in *dns.Msg, err error) {
+	for idx, server := range recursors {
+		in, err = s.recurse(ctx, m, server)
+		if err != nil {
+			ctx.logger.Error().
+				Err(err).
+				Str("server", server).
+				Msg("errored recursing")
+
+			if idx < len(recursors)-1 {
+				s.metrics.recursorFailovers.WithLabelValues(server).Inc()
+			}
+			continue
+		}
+
+		ctx.trace.answer(server)
+		return
+	}
+
+	in, err = nil, ErrRecursorsExhausted
+	return
+}
+
+// recurseParallel asks all the recursors at once and picks
+// a single response according to the parallel policy.
+func (s *Sdns) recurseParallel(ctx *SdnsContext, m *dns.Msg, recursors []string) (in *dns.Msg, err error) {
+	type result struct {
+		idx int
+		in  *dns.Msg
+		err error
+	}
+
+	var (
+		cctx, cancel = context.WithCancel(ctx.context)
+		results      = make(chan result, len(recursors))
+		responses    = make([]*dns.Msg, len(recursors))
+	)
+
+	defer cancel()
+
+	for idx, server := range recursors {
+		go func(idx int, server string) {
+			child := *ctx
+			child.context = cctx
+
+			in, err := s.recurse(&child, m, server)
+			results <- result{idx: idx, in: in, err: err}
+		}(idx, server)
+	}
+
+	for range recursors {
+		res := <-results
+		if res.err != nil {
+			ctx.logger.Error().
+				Err(res.err).
+				Str("server", recursors[res.idx]).
+				Msg("errored recursing")
+			continue
+		}
+
+		if s.parallelPolicy == PolicyFirst {
+			in = res.in
+			ctx.trace.answer(recursors[res.idx])
+			return
+		}
+
+		responses[res.idx] = res.in
+	}
+
+	selected := -1
+	for idx, response := range responses {
+		if response == nil {
+			continue
+		}
+
+		if in == nil || len(response.Answer) > len(in.Answer) {
+			in, selected = response, idx
+		}
+	}
+
+	if in == nil {
+		err = ErrRecursorsExhausted
+		return
+	}
+
+	ctx.trace.answer(recursors[selected])
+	return
+}
@@ -0,0 +1,457 @@
+package lib_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_parallelPolicy(t *testing.T) {
+	var (
+		fast = startRecursor(t, answerHandler(0, "1.1.1.1"))
+		slow = startRecursor(t, answerHandler(
+			100*time.Millisecond, "2.2.2.2", "3.3.3.3"))
+		tie = startRecursor(t, answerHandler(
+			50*time.Millisecond, "4.4.4.4", "5.5.5.5"))
+	)
+
+	var testCases = []struct {
+		name      string
+		policy    ParallelPolicy
+		recursors []string
+		expected  []string
+	}{
+		{
+			name:      "first response wins",
+			policy:    PolicyFirst,
+			recursors: []string{slow, fast},
+			expected:  []string{"1.1.1.1"},
+		},
+		{
+			name:      "defaults to first response",
+			policy:    "",
+			recursors: []string{slow, fast},
+			expected:  []string{"1.1.1.1"},
+		},
+		{
+			name:      "most answers wins",
+			policy:    PolicyMostAnswers,
+			recursors: []string{fast, slow},
+			expected:  []string{"2.2.2.2", "3.3.3.3"},
+		},
+		{
+			name:      "ties broken by recursor order",
+			policy:    PolicyMostAnswers,
+			recursors: []string{fast, slow, tie},
+			expected:  []string{"2.2.2.2", "3.3.3.3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSdns(SdnsConfig{
+				Port:              1232,
+				Recursors:         tc.recursors,
+				ParallelRecursion: true,
+				ParallelPolicy:    tc.policy,
+			})
+			assert.NoError(t, err)
+
+			m := query(s, "example.com", dns.TypeA)
+			assert.Equal(t, tc.expected, answerIPs(m))
+		})
+	}
+}
+
+// hangingHandler never answers, holding every question
+// until released.
+func hangingHandler(release <-chan struct{}) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		<-release
+	}
+}
+
+func TestHandle_parallelHangingRecursor(t *testing.T) {
+	var (
+		release = make(chan struct{})
+		hanging = startRecursor(t, hangingHandler(release))
+		fast    = startRecursor(t, answerHandler(0, "1.1.1.1"))
+	)
+
+	// released before the recursor gets shut down, which
+	// waits for the questions in flight.
+	t.Cleanup(func() { close(release) })
+
+	s, err := NewSdns(SdnsConfig{
+		Port:              1232,
+		Recursors:         []string{hanging, "127.0.0.1:1", fast},
+		ParallelRecursion: true,
+	})
+	assert.NoError(t, err)
+
+	start := time.Now()
+	m := query(s, "example.com", dns.TypeA)
+	elapsed := time.Since(start)
+
+	assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+	assert.True(t, elapsed < time.Second, "took %s", elapsed)
+}
+
+func TestHandle_recursionTimeout(t *testing.T) {
+	var (
+		release = make(chan struct{})
+		hanging = startRecursor(t, hangingHandler(release))
+	)
+
+	t.Cleanup(func() { close(release) })
+
+	s, err := NewSdns(SdnsConfig{
+		Port:        1232,
+		Recursors:   []string{hanging},
+		ReadTimeout: 100 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+
+	start := time.Now()
+	m := query(s, "example.com", dns.TypeA)
+	elapsed := time.Since(start)
+
+	assert.Equal(t, dns.RcodeServerFailure, m.Rcode)
+	assert.True(t, elapsed >= 100*time.Millisecond, "took %s", elapsed)
+	assert.True(t, elapsed < time.Second, "took %s", elapsed)
+}
+
+func TestHandle_recursorOrder(t *testing.T) {
+	var testCases = []struct {
+		order    RecursorOrder
+		expected func(t *testing.T, counts []int32)
+	}{
+		{
+			order: OrderPriority,
+			expected: func(t *testing.T, counts []int32) {
+				assert.Equal(t, []int32{30, 0, 0}, counts)
+			},
+		},
+		{
+			order: OrderRoundRobin,
+			expected: func(t *testing.T, counts []int32) {
+				assert.Equal(t, []int32{10, 10, 10}, counts)
+			},
+		},
+		{
+			order: OrderRandom,
+			expected: func(t *testing.T, counts []int32) {
+				for _, count := range counts {
+					assert.NotZero(t, count)
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.order), func(t *testing.T) {
+			var (
+				healthy   int32 = 1
+				received        = make([]int32, 3)
+				recursors       = make([]string, len(received))
+			)
+
+			for idx := range recursors {
+				recursors[idx] = startRecursor(t, flakyHandler(&healthy, &received[idx]))
+			}
+
+			s, err := NewSdns(SdnsConfig{
+				Port:          1053,
+				Recursors:     recursors,
+				RecursorOrder: tc.order,
+			})
+			assert.NoError(t, err)
+
+			for i := 0; i < 30; i++ {
+				m := query(s, "example.com", dns.TypeA)
+				assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+			}
+
+			counts := make([]int32, len(received))
+			for idx := range received {
+				counts[idx] = atomic.LoadInt32(&received[idx])
+			}
+
+			tc.expected(t, counts)
+		})
+	}
+}
+
+func TestNewSdns_unknownRecursorOrder(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{
+		Port:          1232,
+		RecursorOrder: "lol",
+	})
+	assert.Error(t, err)
+}
+
+func TestNewSdns_unknownParallelPolicy(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{
+		Port:           1232,
+		ParallelPolicy: "lol",
+	})
+	assert.Error(t, err)
+}
+
+// rcodeHandler answers every question with the rcode.
+func rcodeHandler(rcode int) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, rcode)
+		w.WriteMsg(m)
+	}
+}
+
+func TestHandle_recursionRcode(t *testing.T) {
+	var testCases = []struct {
+		name      string
+		recursors func(t *testing.T) []string
+		rcode     int
+	}{
+		{
+			name: "unreachable recursors",
+			recursors: func(t *testing.T) []string {
+				return []string{"127.0.0.1:1", "127.0.0.1:2"}
+			},
+			rcode: dns.RcodeServerFailure,
+		},
+		{
+			name: "upstream refused",
+			recursors: func(t *testing.T) []string {
+				return []string{startRecursor(t, rcodeHandler(dns.RcodeRefused))}
+			},
+			rcode: dns.RcodeRefused,
+		},
+		{
+			name: "upstream nxdomain",
+			recursors: func(t *testing.T) []string {
+				return []string{startRecursor(t, nxdomainHandler(false))}
+			},
+			rcode: dns.RcodeNameError,
+		},
+		{
+			name: "upstream answer",
+			recursors: func(t *testing.T) []string {
+				return []string{startRecursor(t, answerHandler(0, "1.1.1.1"))}
+			},
+			rcode: dns.RcodeSuccess,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSdns(SdnsConfig{
+				Port:      1053,
+				Recursors: tc.recursors(t),
+			})
+			assert.NoError(t, err)
+
+			m := query(s, "foo.com", dns.TypeA)
+			assert.Equal(t, tc.rcode, m.Rcode)
+		})
+	}
+}
+
+func TestSetRecursors(t *testing.T) {
+	var (
+		previous = startRecursor(t, answerHandler(0, "1.1.1.1"))
+		next     = startRecursor(t, answerHandler(0, "2.2.2.2"))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{previous},
+		Domains: []*Domain{
+			{Name: "local.com", Addresses: []string{"9.9.9.9"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	m := query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+
+	assert.NoError(t, s.SetRecursors([]string{next}))
+	assert.Equal(t, []string{next}, s.Recursors())
+
+	m = query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"2.2.2.2"}, answerIPs(m))
+
+	m = query(s, "local.com", dns.TypeA)
+	assert.Equal(t, []string{"9.9.9.9"}, answerIPs(m))
+}
+
+func TestSetRecursors_invalid(t *testing.T) {
+	var testCases = []struct {
+		name      string
+		recursors []string
+	}{
+		{name: "none", recursors: []string{}},
+		{name: "missing port", recursors: []string{"1.1.1.1"}},
+		{name: "malformed port", recursors: []string{"1.1.1.1:lol"}},
+		{name: "port out of range", recursors: []string{"1.1.1.1:65536"}},
+		{name: "one malformed", recursors: []string{"1.1.1.1:53", "lol"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSdns(SdnsConfig{
+				Port:      1053,
+				Recursors: []string{"127.0.0.1:1"},
+			})
+			assert.NoError(t, err)
+
+			assert.Error(t, s.SetRecursors(tc.recursors))
+			assert.Equal(t, []string{"127.0.0.1:1"}, s.Recursors())
+		})
+	}
+}
+
+func TestHandle_conditionalForwarding(t *testing.T) {
+	var (
+		public   = startRecursor(t, answerHandler(0, "7.7.7.7"))
+		internal = startRecursor(t, answerHandler(0, "10.0.0.1"))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{public},
+		Domains: []*Domain{
+			{Name: "corp.internal", Recursors: []string{internal}},
+			{Name: "*.corp.internal", Recursors: []string{internal}},
+			{Name: "local.com", Addresses: []string{"1.1.1.1"}},
+			{Name: "intranet.com", Addresses: []string{"intranet.corp.internal"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name     string
+		expected []string
+	}{
+		{"corp.internal", []string{"10.0.0.1"}},
+		{"wiki.corp.internal", []string{"10.0.0.1"}},
+		{"a.b.corp.internal", []string{"10.0.0.1"}},
+		{"intranet.com", []string{"10.0.0.1"}},
+		{"local.com", []string{"1.1.1.1"}},
+		{"example.com", []string{"7.7.7.7"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := query(s, tc.name, dns.TypeA)
+			assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+			assert.Equal(t, tc.expected, answerIPs(m))
+		})
+	}
+}
+
+func TestHandle_conditionalForwardingIsolation(t *testing.T) {
+	var (
+		healthy  int32 = 1
+		received int32
+		public   = startRecursor(t, flakyHandler(&healthy, &received))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{public},
+		Domains: []*Domain{
+			{Name: "*.corp.internal", Recursors: []string{"127.0.0.1:1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	// internal names never leak to the default recursors,
+	// not even when the internal ones fail.
+	m := query(s, "wiki.corp.internal", dns.TypeA)
+	assert.Equal(t, dns.RcodeServerFailure, m.Rcode)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&received))
+
+	m = query(s, "example.com", dns.TypeA)
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+}
+
+func TestLoad_malformedDomainRecursors(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{
+		Port: 1053,
+		Domains: []*Domain{
+			{Name: "corp.internal", Recursors: []string{"10.0.0.1"}},
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestHandle_recursedSections(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		handler dns.HandlerFunc
+		rcode   int
+		answer  []string
+		ns      []string
+		extra   []string
+	}{
+		{
+			name:    "answer with delegation and glue",
+			handler: richHandler(),
+			rcode:   dns.RcodeSuccess,
+			answer:  []string{"foo.com.\t3600\tIN\tA\t1.1.1.1"},
+			ns:      []string{"foo.com.\t3600\tIN\tNS\tns1.upstream."},
+			extra:   []string{"ns1.upstream.\t3600\tIN\tA\t9.9.9.9"},
+		},
+		{
+			name:    "nxdomain with soa",
+			handler: nxdomainHandler(true),
+			rcode:   dns.RcodeNameError,
+			answer:  []string{},
+			ns: []string{
+				"upstream.\t3600\tIN\tSOA\tns.upstream. admin.upstream. 7 1 1 1 1",
+			},
+			extra: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := func(w dns.ResponseWriter, r *dns.Msg) {
+				tc.handler(&recursionAvailableWriter{w}, r)
+			}
+
+			s, err := NewSdns(SdnsConfig{
+				Port:      1053,
+				Recursors: []string{startRecursor(t, handler)},
+			})
+			assert.NoError(t, err)
+
+			m := query(s, "foo.com", dns.TypeA)
+			assert.Equal(t, tc.rcode, m.Rcode)
+			assert.True(t, m.RecursionAvailable)
+			assert.False(t, m.Authoritative)
+			assert.Equal(t, tc.answer, rrStrings(m.Answer))
+			assert.Equal(t, tc.ns, rrStrings(m.Ns))
+			assert.Equal(t, tc.extra, rrStrings(m.Extra))
+		})
+	}
+}
+
+// recursionAvailableWriter sets the RA bit of the messages
+// written.
+type recursionAvailableWriter struct {
+	dns.ResponseWriter
+}
+
+func (w *recursionAvailableWriter) WriteMsg(m *dns.Msg) error {
+	m.RecursionAvailable = true
+	return w.ResponseWriter.WriteMsg(m)
+}
@@ -0,0 +1,220 @@
+package lib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultRedisTimeout bounds connecting to Redis as well
+	// as each command sent to it.
+	DefaultRedisTimeout = 250 * time.Millisecond
+
+	// DefaultRedisRetry is how long Redis is left alone
+	// after failing before being used again.
+	DefaultRedisRetry = 5 * time.Second
+)
+
+var (
+	redisKeyPrefix = "sdns:"
+	redisMaxIdle   = 16
+)
+
+// redisCache is a responseCache shared by sdns instances
+// through Redis, in front of which a local cache answers
+// first: responses missing from it are read from Redis
+// while the ones set are written to both.
+// Whenever Redis fails, only the local cache is used until
+// DefaultRedisRetry elapses.
+type redisCache struct {
+	sync.Mutex
+	local  *memoryCache
+	pool   *redis.Pool
+	logger zerolog.Logger
+	retry  time.Time
+}
+
+// newRedisCache creates a cache backed by the Redis server
+// at the address, which is either host:port or a redis://
+// (or rediss://) URL. Redis isn't connected to until used.
+func newRedisCache(address string, local *memoryCache, logger zerolog.Logger) (c *redisCache, err error) {
+	var (
+		options = []redis.DialOption{
+			redis.DialConnectTimeout(DefaultRedisTimeout),
+			redis.DialReadTimeout(DefaultRedisTimeout),
+			redis.DialWriteTimeout(DefaultRedisTimeout),
+		}
+		dial func() (redis.Conn, error)
+	)
+
+	if strings.HasPrefix(address, "redis://") || strings.HasPrefix(address, "rediss://") {
+		_, err = url.Parse(address)
+		dial = func() (redis.Conn, error) {
+			return redis.DialURL(address, options...)
+		}
+	} else {
+		_, _, err = net.SplitHostPort(address)
+		dial = func() (redis.Conn, error) {
+			return redis.Dial("tcp", address, options...)
+		}
+	}
+
+	if err != nil {
+		err = errors.Wrapf(err,
+			"malformed redis address %s", address)
+		return
+	}
+
+	c = &redisCache{
+		local:  local,
+		logger: logger,
+		pool: &redis.Pool{
+			MaxIdle:     redisMaxIdle,
+			IdleTimeout: time.Minute,
+			Dial:        dial,
+		},
+	}
+	return
+}
+
+// redisKey is the key of Redis that the response is shared
+// under.
+func (key cacheKey) redisKey() string {
+	return fmt.Sprintf("%s%s|%s|%s|%s|%d|%d", redisKeyPrefix, key.generation,
+		key.namespace, key.subnet, key.name, key.qtype, key.qclass)
+}
+
+// available tells whether Redis may be used.
+func (c *redisCache) available(now time.Time) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	return !now.Before(c.retry)
+}
+
+// fail stops using Redis for DefaultRedisRetry.
+func (c *redisCache) fail(err error, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	if now.Before(c.retry) {
+		return
+	}
+
+	c.retry = now.Add(DefaultRedisRetry)
+	c.logger.Warn().
+		Err(err).
+		Dur("retry-in", DefaultRedisRetry).
+		Msg("redis failed - using the local cache only")
+}
+
+// get takes the responses found in Redis into the local
+// cache. Responses shared by instances whose clocks are
+// ahead are taken as just cached.
+func (c *redisCache) get(key cacheKey, now time.Time) (msg *dns.Msg, found bool) {
+	msg, found = c.local.get(key, now)
+	if found || !c.available(now) {
+		return
+	}
+
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	value, err := redis.Bytes(conn.Do("GET", key.redisKey()))
+	if err == redis.ErrNil {
+		return
+	}
+
+	if err != nil {
+		c.fail(err, now)
+		return
+	}
+
+	if len(value) < 8 {
+		return
+	}
+
+	var (
+		stored = time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+		cached time.Duration
+	)
+
+	msg = new(dns.Msg)
+	err = msg.Unpack(value[8:])
+	if err != nil {
+		c.logger.Debug().
+			Err(err).
+			Str("key", key.redisKey()).
+			Msg("malformed response in redis")
+		msg = nil
+		return
+	}
+
+	if now.After(stored) {
+		cached = now.Sub(stored)
+	}
+
+	ttl, cacheable := cacheTTL(msg)
+	if !cacheable || cached >= time.Duration(ttl)*time.Second {
+		msg = nil
+		return
+	}
+
+	age(msg, cached)
+	c.local.set(key, msg, now)
+	found = true
+	return
+}
+
+// set writes the response to Redis along with the time it
+// got cached at, expiring it once its TTL elapses.
+func (c *redisCache) set(key cacheKey, msg *dns.Msg, now time.Time) {
+	c.local.set(key, msg, now)
+
+	ttl, cacheable := cacheTTL(msg)
+	if !cacheable || !c.available(now) {
+		return
+	}
+
+	packed, err := msg.Pack()
+	if err != nil {
+		return
+	}
+
+	value := make([]byte, 8, 8+len(packed))
+	binary.BigEndian.PutUint64(value, uint64(now.UnixNano()))
+	value = append(value, packed...)
+
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("SET", key.redisKey(), value,
+		"PX", int64(ttl)*int64(time.Second/time.Millisecond))
+	if err != nil {
+		c.fail(err, now)
+	}
+}
+
+// sweep only sweeps the local cache: Redis expires the
+// responses by itself.
+func (c *redisCache) sweep(now time.Time) {
+	c.local.sweep(now)
+}
+
+// reset only resets the local cache, leaving the responses
+// shared with other instances to expire: the ones of a
+// previous configuration aren't read back as their keys
+// are of another generation (see cacheGeneration).
+func (c *redisCache) reset() {
+	c.local.reset()
+}
@@ -0,0 +1,277 @@
+package lib_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// stubRedis is a Redis server understanding just enough of
+// it (GET and SET) for sdns instances to share responses.
+type stubRedis struct {
+	sync.Mutex
+	address string
+	values  map[string]string
+}
+
+func startStubRedis(t *testing.T, address string) (stub *stubRedis) {
+	listener, err := net.Listen("tcp", address)
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	stub = &stubRedis{
+		address: listener.Addr().String(),
+		values:  make(map[string]string),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go stub.serve(conn)
+		}
+	}()
+
+	return
+}
+
+func (stub *stubRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		var count int
+
+		_, err := fmt.Fscanf(reader, "*%d\r\n", &count)
+		if err != nil {
+			return
+		}
+
+		args := make([]string, count)
+		for idx := range args {
+			var length int
+
+			_, err = fmt.Fscanf(reader, "$%d\r\n", &length)
+			if err != nil {
+				return
+			}
+
+			arg := make([]byte, length+2)
+			_, err = io.ReadFull(reader, arg)
+			if err != nil {
+				return
+			}
+
+			args[idx] = string(arg[:length])
+		}
+
+		stub.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "GET":
+			value, found := stub.values[args[1]]
+			if found {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+			} else {
+				fmt.Fprint(conn, "$-1\r\n")
+			}
+		case "SET":
+			stub.values[args[1]] = args[2]
+			fmt.Fprint(conn, "+OK\r\n")
+		default:
+			fmt.Fprint(conn, "-ERR unknown command\r\n")
+		}
+		stub.Unlock()
+	}
+}
+
+func (stub *stubRedis) len() int {
+	stub.Lock()
+	defer stub.Unlock()
+
+	return len(stub.values)
+}
+
+// corrupt replaces the values by ones that aren't responses.
+func (stub *stubRedis) corrupt() {
+	stub.Lock()
+	defer stub.Unlock()
+
+	for key := range stub.values {
+		stub.values[key] = "lol"
+	}
+}
+
+func TestHandle_cacheRedis(t *testing.T) {
+	var (
+		received int32
+		now      = time.Unix(1000, 0)
+		stub     = startStubRedis(t, "127.0.0.1:0")
+		recursor = startRecursor(t,
+			countingHandler(&received, answerHandler(0, "7.7.7.7")))
+		instances []*Sdns
+	)
+
+	for i := 0; i < 3; i++ {
+		s, err := NewSdns(SdnsConfig{
+			Port:       1053,
+			Recursors:  []string{recursor},
+			CacheRedis: stub.address,
+			Clock:      func() time.Time { return now },
+		})
+		assert.NoError(t, err)
+
+		instances = append(instances, s)
+	}
+
+	m := query(instances[0], "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"foo.com.\t3600\tIN\tA\t7.7.7.7"}, answerStrings(m))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+	assert.Equal(t, 1, stub.len())
+
+	// shared, with the TTL decremented by the time cached.
+	now = now.Add(100 * time.Second)
+
+	m = query(instances[1], "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"foo.com.\t3500\tIN\tA\t7.7.7.7"}, answerStrings(m))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+	// kept locally once read.
+	stub.corrupt()
+
+	m = query(instances[1], "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"foo.com.\t3500\tIN\tA\t7.7.7.7"}, answerStrings(m))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+	// malformed responses are misses.
+	m = query(instances[2], "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"foo.com.\t3600\tIN\tA\t7.7.7.7"}, answerStrings(m))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&received))
+
+	// expired.
+	now = now.Add(time.Hour)
+
+	m = query(instances[0], "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"foo.com.\t3600\tIN\tA\t7.7.7.7"}, answerStrings(m))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&received))
+}
+
+func TestHandle_cacheRedisReload(t *testing.T) {
+	var (
+		before, after int32
+		stub          = startStubRedis(t, "127.0.0.1:0")
+		config        = func(handler dns.HandlerFunc) SdnsConfig {
+			return SdnsConfig{
+				Port:       1053,
+				Recursors:  []string{startRecursor(t, handler)},
+				CacheRedis: stub.address,
+			}
+		}
+		previous = config(countingHandler(&before, answerHandler(0, "7.7.7.7")))
+		current  = config(countingHandler(&after, answerHandler(0, "8.8.8.8")))
+	)
+
+	s, err := NewSdns(previous)
+	assert.NoError(t, err)
+
+	m := query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"7.7.7.7"}, answerIPs(m))
+
+	// what's shared under the previous configuration isn't
+	// read back.
+	assert.NoError(t, s.Load(current))
+
+	m = query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"8.8.8.8"}, answerIPs(m))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&after))
+
+	// while instances with the same configuration share.
+	other, err := NewSdns(current)
+	assert.NoError(t, err)
+
+	m = query(other, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"8.8.8.8"}, answerIPs(m))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&after))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&before))
+}
+
+func TestHandle_cacheRedisUnavailable(t *testing.T) {
+	var (
+		received int32
+		now      = time.Unix(1000, 0)
+		address  = "127.0.0.1:" + strconv.Itoa(freePort(t))
+		recursor = startRecursor(t,
+			countingHandler(&received, answerHandler(0, "7.7.7.7")))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:       1053,
+		Recursors:  []string{recursor},
+		CacheRedis: address,
+		Clock:      func() time.Time { return now },
+	})
+	assert.NoError(t, err)
+
+	// answered and cached locally regardless.
+	m := query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"7.7.7.7"}, answerIPs(m))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+	m = query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"7.7.7.7"}, answerIPs(m))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received))
+
+	// left alone for a while once failed.
+	stub := startStubRedis(t, address)
+
+	query(s, "bar.com", dns.TypeA)
+	assert.Equal(t, 0, stub.len())
+
+	now = now.Add(DefaultRedisRetry)
+
+	query(s, "baz.com", dns.TypeA)
+	assert.Equal(t, 1, stub.len())
+}
+
+func TestNewSdns_malformedCacheRedis(t *testing.T) {
+	var testCases = []struct {
+		address     string
+		shouldError bool
+	}{
+		{"127.0.0.1:6379", false},
+		{"redis.internal:6379", false},
+		{"redis://:secret@redis.internal:6379/1", false},
+		{"redis.internal", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.address, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port:       1053,
+				Recursors:  []string{"127.0.0.1:1"},
+				CacheRedis: tc.address,
+			})
+
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"os"
+	"syscall"
+)
+
+// awaitShutdown blocks until one of the servers fails or a
+// signal to stop is received, reloading the configuration
+// on every SIGHUP in the meantime.
+func (s *Sdns) awaitShutdown(errs <-chan error, signals <-chan os.Signal) (err error) {
+	for {
+		select {
+		case err = <-errs:
+			return
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				s.reload(s.reloadSource)
+				continue
+			}
+
+			s.logger.Info().
+				Str("signal", sig.String()).
+				Msg("shutting down")
+			return
+		}
+	}
+}
+
+// reload loads the configuration given by the source,
+// keeping the current one if either fails.
+func (s *Sdns) reload(source func() (SdnsConfig, error)) {
+	s.logger.Info().
+		Msg("reloading configuration")
+
+	cfg, err := source()
+	if err == nil {
+		err = s.Load(cfg)
+	}
+
+	if err != nil {
+		s.logger.Error().
+			Err(err).
+			Msg("couldn't reload configuration")
+	}
+}
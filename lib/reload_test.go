@@ -0,0 +1,77 @@
+package lib_test
+
+import (
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestListen_reloadOnHangup(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		domains = []string{"domain=foo.com,ip=1.1.1.1"}
+		source  = func() (cfg SdnsConfig, err error) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			cfg.Recursors = []string{"127.0.0.1:1"}
+			cfg.Domains, err = ParseDomainArgs(domains)
+			return
+		}
+	)
+
+	cfg, err := source()
+	assert.NoError(t, err)
+
+	cfg.Address = "127.0.0.1"
+	cfg.Reload = source
+
+	s, err := NewSdns(cfg)
+	assert.NoError(t, err)
+
+	go s.Listen()
+
+	select {
+	case <-s.Listening():
+	case <-time.After(time.Second):
+		t.Fatalf("server didn't start listening")
+	}
+
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(query(s, "foo.com", dns.TypeA)))
+	assert.Equal(t, dns.RcodeServerFailure, query(s, "bar.com", dns.TypeA).Rcode)
+
+	lock.Lock()
+	domains = []string{"domain=foo.com,ip=2.2.2.2", "domain=bar.com,ip=3.3.3.3"}
+	lock.Unlock()
+
+	assert.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGHUP))
+
+	var m *dns.Msg
+	for i := 0; i < 50; i++ {
+		m = query(s, "bar.com", dns.TypeA)
+		if m.Rcode == dns.RcodeSuccess {
+			break
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	assert.Equal(t, []string{"3.3.3.3"}, answerIPs(m))
+	assert.Equal(t, []string{"2.2.2.2"}, answerIPs(query(s, "foo.com", dns.TypeA)))
+
+	// a broken configuration keeps the current one.
+	lock.Lock()
+	domains = []string{"lol"}
+	lock.Unlock()
+
+	assert.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGHUP))
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, []string{"3.3.3.3"}, answerIPs(query(s, "bar.com", dns.TypeA)))
+}
@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// DefaultResolvConf is the resolv.conf(5) file consulted
+// for system resolvers.
+const DefaultResolvConf = "/etc/resolv.conf"
+
+// RecursorsFromResolvConf retrieves the nameservers listed
+// in a resolv.conf(5) file as recursors (host:port).
+// Nameservers that point back to `self` (the address sdns
+// listens on) are left out so that sdns doesn't recurse to
+// itself.
+func RecursorsFromResolvConf(path string, self string) (recursors []string, err error) {
+	cfg, err := dns.ClientConfigFromFile(path)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"couldn't parse resolv.conf file %s", path)
+		return
+	}
+
+	recursors = make([]string, 0, len(cfg.Servers))
+	for _, server := range cfg.Servers {
+		recursor := net.JoinHostPort(server, cfg.Port)
+		if isSelfAddress(recursor, self) {
+			continue
+		}
+
+		recursors = append(recursors, recursor)
+	}
+
+	return
+}
+
+// isSelfAddress checks whether the address `addr` would
+// reach a server listening on `self`.
+func isSelfAddress(addr, self string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	selfHost, selfPort, err := net.SplitHostPort(self)
+	if err != nil {
+		return false
+	}
+
+	portNum, _ := strconv.Atoi(port)
+	selfPortNum, _ := strconv.Atoi(selfPort)
+	if portNum != selfPortNum {
+		return false
+	}
+
+	var (
+		ip     = net.ParseIP(host)
+		selfIP = net.ParseIP(selfHost)
+	)
+
+	if selfHost == "" || (selfIP != nil && selfIP.IsUnspecified()) {
+		return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+	}
+
+	if ip != nil && selfIP != nil {
+		return ip.Equal(selfIP)
+	}
+
+	return host == selfHost
+}
@@ -0,0 +1,99 @@
+package lib_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+const sampleResolvConf = `# generated by something
+search corp.internal
+nameserver 10.0.0.2
+nameserver 127.0.0.1
+nameserver 2001:db8::53
+options ndots:2
+`
+
+func writeResolvConf(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestRecursorsFromResolvConf(t *testing.T) {
+	path := writeResolvConf(t, sampleResolvConf)
+
+	var testCases = []struct {
+		name     string
+		self     string
+		expected []string
+	}{
+		{
+			name: "other port keeps everything",
+			self: ":1053",
+			expected: []string{
+				"10.0.0.2:53", "127.0.0.1:53", "[2001:db8::53]:53",
+			},
+		},
+		{
+			name: "wildcard bind excludes loopback",
+			self: ":53",
+			expected: []string{
+				"10.0.0.2:53", "[2001:db8::53]:53",
+			},
+		},
+		{
+			name: "exact bind excludes itself",
+			self: "10.0.0.2:53",
+			expected: []string{
+				"127.0.0.1:53", "[2001:db8::53]:53",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recursors, err := RecursorsFromResolvConf(path, tc.self)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, recursors)
+		})
+	}
+}
+
+func TestRecursorsFromResolvConf_missingFile(t *testing.T) {
+	_, err := RecursorsFromResolvConf("/inexistent/resolv.conf", ":53")
+	assert.Error(t, err)
+}
+
+func TestNewSdns_systemResolvers(t *testing.T) {
+	path := writeResolvConf(t, sampleResolvConf)
+
+	_, err := NewSdns(SdnsConfig{
+		Port:               1232,
+		Recursors:          []string{"8.8.8.8:53"},
+		UseSystemResolvers: true,
+		ResolvConf:         "/inexistent/resolv.conf",
+	})
+	assert.Error(t, err)
+
+	_, err = NewSdns(SdnsConfig{
+		Port:       1232,
+		ResolvConf: "/inexistent/resolv.conf",
+	})
+	assert.NoError(t, err)
+
+	_, err = NewSdns(SdnsConfig{
+		Port:       1232,
+		ResolvConf: path,
+	})
+	assert.NoError(t, err)
+}
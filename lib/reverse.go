@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// loadReverse indexes the domain by the reverse names
+// (in-addr.arpa and ip6.arpa) of each of its addresses.
+// Addresses shared by several domains map to all of them,
+// each listed once even if it repeats the address (e.g.: in
+// different notations, like '2001:db8::1' and '2001:0db8::1').
+func (s *Sdns) loadReverse(domain *Domain) {
+	for _, entry := range domain.Addresses {
+		address := addressOf(entry)
+		if net.ParseIP(address) == nil {
+			continue
+		}
+
+		reverse, err := dns.ReverseAddr(address)
+		if err != nil {
+			continue
+		}
+
+		reverse = strings.TrimRight(reverse, ".")
+		if indexed(s.reverseDomains[reverse], domain) {
+			continue
+		}
+
+		s.reverseDomains[reverse] = append(
+			s.reverseDomains[reverse], domain)
+	}
+}
+
+func indexed(domains []*Domain, domain *Domain) bool {
+	for _, candidate := range domains {
+		if candidate == domain {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Sdns) answerPTR(ctx *SdnsContext, m *dns.Msg) (err error) {
+	var name string = m.Question[0].Name
+
+	ctx.logger.Debug().
+		Str("name", name).
+		Str("query", "PTR").
+		Msg("looking for domain")
+
+	// exact forward domains take precedence over the
+	// reverse index which, in turn, takes precedence
+	// over wildcards (see findDomain).
+	var key = strings.ToLower(strings.TrimRight(name, "."))
+
+	s.lock.RLock()
+	domains, found := s.reverseDomains[key]
+	_, exact := s.exactDomains[key]
+	s.lock.RUnlock()
+
+	if exact {
+		return
+	}
+
+	if !found {
+		_, found = s.findDomain(key)
+		if !found {
+			err = ErrDomainNotFound
+		}
+		return
+	}
+
+	// the records of an RRset must share the TTL: the
+	// smallest among the domains is used.
+	var ttl = s.answerTTL(domains[0])
+	for _, domain := range domains[1:] {
+		if domainTTL := s.answerTTL(domain); domainTTL < ttl {
+			ttl = domainTTL
+		}
+	}
+
+	for _, domain := range domains {
+		m.Answer = append(m.Answer, &dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   name,
+				Rrtype: dns.TypePTR,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			Ptr: dns.Fqdn(domain.Name),
+		})
+	}
+	return
+}
@@ -0,0 +1,141 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_ptr(t *testing.T) {
+	recursor := startRecursor(t, nxdomainHandler(false))
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{recursor},
+		Domains: []*Domain{
+			{Name: "a.com", Addresses: []string{"1.2.3.4", "2001:db8::1"}},
+			{Name: "b.com", Addresses: []string{"1.2.3.4"}},
+			{Name: "*.c.com", Addresses: []string{"1.2.3.4"}},
+			{Name: "d.com", Addresses: []string{"5.6.7.8"}},
+			{Name: "e.com", Addresses: []string{"2001:db8::2", "2001:0db8:0::2"}},
+			{Name: "f.com", Addresses: []string{"2001:db8::2"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name     string
+		rcode    int
+		expected []string
+	}{
+		{
+			name:     "4.3.2.1.in-addr.arpa",
+			rcode:    dns.RcodeSuccess,
+			expected: []string{"a.com.", "b.com."},
+		},
+		{
+			name:     "8.7.6.5.in-addr.arpa",
+			rcode:    dns.RcodeSuccess,
+			expected: []string{"d.com."},
+		},
+		{
+			name:     "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+			rcode:    dns.RcodeSuccess,
+			expected: []string{"a.com."},
+		},
+		{
+			name:     "2.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+			rcode:    dns.RcodeSuccess,
+			expected: []string{"e.com.", "f.com."},
+		},
+		{
+			name:     "1.1.1.1.in-addr.arpa",
+			rcode:    dns.RcodeNameError,
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := query(s, tc.name, dns.TypePTR)
+			assert.Equal(t, tc.rcode, m.Rcode)
+
+			targets := []string{}
+			for _, rr := range m.Answer {
+				targets = append(targets, rr.(*dns.PTR).Ptr)
+			}
+			assert.Equal(t, tc.expected, targets)
+		})
+	}
+}
+
+func TestHandle_reverseForwardPrecedence(t *testing.T) {
+	recursor := startRecursor(t, nxdomainHandler(false))
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{recursor},
+		Domains: []*Domain{
+			{Name: "4.3.2.1.in-addr.arpa", Texts: []string{"exact"}},
+			{Name: "*.3.2.1.in-addr.arpa", Texts: []string{"wildcard"}},
+			{Name: "a.com", Addresses: []string{"1.2.3.4"}},
+			{Name: "b.com", Addresses: []string{"1.2.3.5"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		desc     string
+		name     string
+		qtype    uint16
+		expected []string
+	}{
+		{
+			desc:     "exact forward beats reverse",
+			name:     "4.3.2.1.in-addr.arpa",
+			qtype:    dns.TypePTR,
+			expected: []string{},
+		},
+		{
+			desc:     "exact forward serves its records",
+			name:     "4.3.2.1.in-addr.arpa",
+			qtype:    dns.TypeTXT,
+			expected: []string{"4.3.2.1.in-addr.arpa.\t3600\tIN\tTXT\t\"exact\""},
+		},
+		{
+			desc:     "reverse beats wildcard",
+			name:     "5.3.2.1.in-addr.arpa",
+			qtype:    dns.TypePTR,
+			expected: []string{"5.3.2.1.in-addr.arpa.\t3600\tIN\tPTR\tb.com."},
+		},
+		{
+			desc:     "wildcard records shadowed by reverse",
+			name:     "5.3.2.1.in-addr.arpa",
+			qtype:    dns.TypeTXT,
+			expected: []string{},
+		},
+		{
+			desc:     "wildcard used when nothing else matches",
+			name:     "6.3.2.1.in-addr.arpa",
+			qtype:    dns.TypeTXT,
+			expected: []string{"6.3.2.1.in-addr.arpa.\t3600\tIN\tTXT\t\"wildcard\""},
+		},
+		{
+			desc:     "wildcard without pointers",
+			name:     "6.3.2.1.in-addr.arpa",
+			qtype:    dns.TypePTR,
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			m := query(s, tc.name, tc.qtype)
+			assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+			assert.Equal(t, tc.expected, answerStrings(m))
+		})
+	}
+}
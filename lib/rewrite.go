@@ -0,0 +1,163 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// parseQtype parses a query type given either by its name
+// or in the generic form of RFC 3597 (e.g.: 'TYPE38' for the
+// long obsoleted A6, unknown to the dns package).
+func parseQtype(name string) (qtype uint16, err error) {
+	name = strings.ToUpper(name)
+
+	qtype, found := dns.StringToType[name]
+	if found {
+		return
+	}
+
+	if !strings.HasPrefix(name, "TYPE") {
+		err = errors.Errorf("unknown query type %s", name)
+		return
+	}
+
+	number, err := strconv.ParseUint(strings.TrimPrefix(name, "TYPE"), 10, 16)
+	if err != nil {
+		err = errors.Wrapf(err, "malformed query type %s", name)
+		return
+	}
+
+	qtype = uint16(number)
+	return
+}
+
+// parseQtypeRewrites parses the query types to rewrite into
+// others (e.g.: 'TYPE38' (A6) into 'AAAA').
+func parseQtypeRewrites(rewrites map[string]string) (parsed map[uint16]uint16, err error) {
+	parsed = make(map[uint16]uint16, len(rewrites))
+
+	for from, to := range rewrites {
+		var fromType, toType uint16
+
+		fromType, err = parseQtype(from)
+		if err != nil {
+			err = errors.Wrapf(err, "malformed query type rewrite %s", from)
+			return
+		}
+
+		toType, err = parseQtype(to)
+		if err != nil {
+			err = errors.Wrapf(err, "malformed query type rewrite of %s", from)
+			return
+		}
+
+		parsed[fromType] = toType
+	}
+
+	return
+}
+
+// rewriteQtype tells the query type that questions of type
+// qtype are resolved as.
+func (s *Sdns) rewriteQtype(qtype uint16) uint16 {
+	if rewritten, found := s.qtypeRewrites[qtype]; found {
+		return rewritten
+	}
+
+	return qtype
+}
+
+// rewriteQuestions returns the questions with their types
+// rewritten, leaving the ones passed (i.e.: the ones the
+// response echoes back) untouched. Empty names, which can't
+// be sent, are rewritten as the root one.
+func (s *Sdns) rewriteQuestions(questions []dns.Question) []dns.Question {
+	rewritten := make([]dns.Question, len(questions))
+	for idx, question := range questions {
+		question.Qtype = s.rewriteQtype(question.Qtype)
+		if question.Name == "" {
+			question.Name = "."
+		}
+
+		rewritten[idx] = question
+	}
+
+	return rewritten
+}
+
+// QueryRewrite is a transformation applied to the queries
+// forwarded to a given recursor (e.g.: legacy upstreams that
+// misbehave with EDNS).
+type QueryRewrite string
+
+const (
+	// RewriteStripEDNS removes the OPT record, dropping
+	// every EDNS option (including the client subnet).
+	RewriteStripEDNS QueryRewrite = "strip-edns"
+
+	// RewriteSetCD sets the CD (checking disabled) bit.
+	RewriteSetCD QueryRewrite = "set-cd"
+
+	// RewriteClearCD clears the CD (checking disabled) bit.
+	RewriteClearCD QueryRewrite = "clear-cd"
+
+	// RewriteClearRD clears the RD (recursion desired) bit.
+	RewriteClearRD QueryRewrite = "clear-rd"
+)
+
+// ParseRecursorRewrite parses a query rewrite of a recursor
+// given as 'recursor=rewrite' (e.g.: '10.0.0.1:53=strip-edns').
+func ParseRecursorRewrite(entry string) (recursor string, rewrite QueryRewrite, err error) {
+	parts := strings.SplitN(entry, "=", 2)
+	if len(parts) != 2 {
+		err = errors.Errorf(
+			"malformed recursor rewrite %s - expected recursor=rewrite", entry)
+		return
+	}
+
+	recursor, rewrite = parts[0], QueryRewrite(parts[1])
+	return
+}
+
+// validateRecursorRewrites makes sure that the recursors
+// are in the form host:port and their rewrites are known.
+func validateRecursorRewrites(rewrites map[string][]QueryRewrite) (err error) {
+	for recursor, recursorRewrites := range rewrites {
+		err = validateRecursors([]string{recursor})
+		if err != nil {
+			return
+		}
+
+		for _, rewrite := range recursorRewrites {
+			switch rewrite {
+			case RewriteStripEDNS, RewriteSetCD, RewriteClearCD, RewriteClearRD:
+			default:
+				err = errors.Errorf(
+					"unknown rewrite %s of recursor %s", rewrite, recursor)
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// rewriteForRecursor applies the rewrites configured for the
+// recursor to the query about to be forwarded to it.
+func (s *Sdns) rewriteForRecursor(server string, rm *dns.Msg) {
+	for _, rewrite := range s.recursorRewrites[server] {
+		switch rewrite {
+		case RewriteStripEDNS:
+			rm.Extra = withoutType(rm.Extra, dns.TypeOPT)
+		case RewriteSetCD:
+			rm.CheckingDisabled = true
+		case RewriteClearCD:
+			rm.CheckingDisabled = false
+		case RewriteClearRD:
+			rm.RecursionDesired = false
+		}
+	}
+}
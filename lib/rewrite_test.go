@@ -0,0 +1,168 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// qtypeHandler answers every question with a TXT record
+// telling the query type that it got asked.
+func qtypeHandler() dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		rr, _ := dns.NewRR(r.Question[0].Name + " TXT " +
+			dns.TypeToString[r.Question[0].Qtype])
+		m.Answer = append(m.Answer, rr)
+
+		w.WriteMsg(m)
+	}
+}
+
+func TestHandle_qtypeRewrite(t *testing.T) {
+	const a6 uint16 = 38
+
+	s, err := NewSdns(SdnsConfig{
+		Port:          1232,
+		Recursors:     []string{startRecursor(t, qtypeHandler())},
+		QtypeRewrites: map[string]string{"type38": "AAAA", "MAILA": "MX"},
+		Domains: []*Domain{
+			{
+				Name:           "foo.com",
+				Addresses:      []string{"1.1.1.1", "::1"},
+				MailExchangers: []string{"10 mail.foo.com"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	m := query(s, "foo.com", a6)
+	assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+	assert.Equal(t, a6, m.Question[0].Qtype)
+	assert.Equal(t, []string{
+		"foo.com.\t3600\tIN\tAAAA\t::1",
+	}, answerStrings(m))
+
+	m = query(s, "foo.com", dns.TypeMAILA)
+	assert.Equal(t, []string{
+		"foo.com.\t3600\tIN\tMX\t10 mail.foo.com.",
+	}, answerStrings(m))
+
+	// types not rewritten are answered as asked.
+	m = query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{
+		"foo.com.\t3600\tIN\tA\t1.1.1.1",
+	}, answerStrings(m))
+
+	// recursors get asked the rewritten type while the
+	// client still gets its question back.
+	m = query(s, "unknown.com", a6)
+	assert.Equal(t, a6, m.Question[0].Qtype)
+	assert.Equal(t, []string{
+		"unknown.com.\t3600\tIN\tTXT\t\"AAAA\"",
+	}, answerStrings(m))
+}
+
+func TestNewSdns_malformedQtypeRewrite(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		rewrites map[string]string
+	}{
+		{"unknown source type", map[string]string{"LOL": "AAAA"}},
+		{"unknown target type", map[string]string{"MAILA": "LOL"}},
+		{"malformed generic type", map[string]string{"TYPE70000": "AAAA"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port:          1232,
+				QtypeRewrites: tc.rewrites,
+			})
+			assert.Error(t, err)
+		})
+	}
+}
+
+// forwardedHandler reports the queries it gets forwarded.
+func forwardedHandler(received chan<- *dns.Msg) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		received <- r
+		answerHandler(0, "1.1.1.1")(w, r)
+	}
+}
+
+func TestHandle_recursorRewrites(t *testing.T) {
+	var (
+		legacyReceived = make(chan *dns.Msg, 1)
+		modernReceived = make(chan *dns.Msg, 1)
+		legacy         = startRecursor(t, forwardedHandler(legacyReceived))
+		modern         = startRecursor(t, forwardedHandler(modernReceived))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:              1232,
+		Recursors:         []string{legacy, modern},
+		ParallelRecursion: true,
+		ParallelPolicy:    PolicyMostAnswers,
+		RecursorRewrites: map[string][]QueryRewrite{
+			legacy: {RewriteStripEDNS, RewriteSetCD, RewriteClearRD},
+		},
+	})
+	assert.NoError(t, err)
+
+	var (
+		w = &testWriter{}
+		r = new(dns.Msg)
+	)
+
+	r.SetQuestion("foo.com.", dns.TypeA)
+	r.SetEdns0(1232, true)
+	s.ServeDNS(w, r)
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(w.msg))
+
+	forwarded := <-legacyReceived
+	assert.Nil(t, forwarded.IsEdns0())
+	assert.True(t, forwarded.CheckingDisabled)
+	assert.False(t, forwarded.RecursionDesired)
+
+	forwarded = <-modernReceived
+	assert.NotNil(t, forwarded.IsEdns0())
+	assert.False(t, forwarded.CheckingDisabled)
+	assert.True(t, forwarded.RecursionDesired)
+}
+
+func TestNewSdns_malformedRecursorRewrites(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		rewrites map[string][]QueryRewrite
+	}{
+		{"unknown rewrite", map[string][]QueryRewrite{"127.0.0.1:53": {"lol"}}},
+		{"malformed recursor", map[string][]QueryRewrite{"127.0.0.1": {RewriteStripEDNS}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port:             1232,
+				RecursorRewrites: tc.rewrites,
+			})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseRecursorRewrite(t *testing.T) {
+	recursor, rewrite, err := ParseRecursorRewrite("10.0.0.1:53=strip-edns")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:53", recursor)
+	assert.Equal(t, RewriteStripEDNS, rewrite)
+
+	_, _, err = ParseRecursorRewrite("10.0.0.1:53")
+	assert.Error(t, err)
+}
@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// RootPolicy determines how questions for the root (or an
+// empty) name are handled, which otherwise would be taken
+// as names of unknown domains.
+type RootPolicy string
+
+const (
+	// RootRecurse forwards the question to the recursors
+	// like any other name that isn't known locally.
+	RootRecurse RootPolicy = "recurse"
+
+	// RootRefuse answers with REFUSED.
+	RootRefuse RootPolicy = "refuse"
+
+	// RootReferral answers with the root nameservers,
+	// referring the client to them.
+	RootReferral RootPolicy = "referral"
+)
+
+// rootTTL is the TTL of the root nameservers as served by
+// the root zone.
+const rootTTL = 518400
+
+// rootServers are the names of the root nameservers.
+var rootServers = []string{
+	"a.root-servers.net.", "b.root-servers.net.", "c.root-servers.net.",
+	"d.root-servers.net.", "e.root-servers.net.", "f.root-servers.net.",
+	"g.root-servers.net.", "h.root-servers.net.", "i.root-servers.net.",
+	"j.root-servers.net.", "k.root-servers.net.", "l.root-servers.net.",
+	"m.root-servers.net.",
+}
+
+// validateRootPolicy makes sure that the policy is known,
+// accepting the empty one (i.e.: the default).
+func validateRootPolicy(policy RootPolicy) (err error) {
+	switch policy {
+	case "", RootRecurse, RootRefuse, RootReferral:
+	default:
+		err = errors.Errorf("unknown root policy %s", policy)
+	}
+
+	return
+}
+
+// isRoot tells whether the name is the root one, which
+// an empty name is taken as.
+func isRoot(name string) bool {
+	return name == "" || name == "."
+}
+
+// answerRoot handles a question for the root name according
+// to the root policy, telling whether it got answered.
+// Referrals answer NS questions with the root nameservers and
+// any other with them in the authority section.
+func (s *Sdns) answerRoot(ctx *SdnsContext, m *dns.Msg) (answered bool) {
+	if len(m.Question) == 0 || !isRoot(m.Question[0].Name) {
+		return
+	}
+
+	switch s.rootPolicy {
+	case RootRefuse:
+		ctx.decision = DecisionRejected
+		m.Rcode = dns.RcodeRefused
+	case RootReferral:
+		ctx.decision = DecisionLocal
+		nameservers := make([]dns.RR, 0, len(rootServers))
+		for _, server := range rootServers {
+			nameservers = append(nameservers, &dns.NS{
+				Hdr: dns.RR_Header{
+					Name:   ".",
+					Rrtype: dns.TypeNS,
+					Class:  dns.ClassINET,
+					Ttl:    rootTTL,
+				},
+				Ns: server,
+			})
+		}
+
+		if m.Question[0].Qtype == dns.TypeNS {
+			m.Answer = nameservers
+		} else {
+			m.Ns = nameservers
+		}
+	default:
+		return
+	}
+
+	ctx.logger.Debug().
+		Str("policy", string(s.rootPolicy)).
+		Msg("answered root question")
+
+	answered = true
+	return
+}
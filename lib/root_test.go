@@ -0,0 +1,79 @@
+package lib_test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_rootPolicy(t *testing.T) {
+	var testCases = []struct {
+		policy    RootPolicy
+		qtype     uint16
+		rcode     int
+		answers   int
+		authority int
+		recursed  bool
+	}{
+		{policy: "", qtype: dns.TypeNS, rcode: dns.RcodeSuccess, answers: 1, recursed: true},
+		{policy: RootRecurse, qtype: dns.TypeNS, rcode: dns.RcodeSuccess, answers: 1, recursed: true},
+		{policy: RootRefuse, qtype: dns.TypeNS, rcode: dns.RcodeRefused},
+		{policy: RootReferral, qtype: dns.TypeNS, rcode: dns.RcodeSuccess, answers: 13},
+		{policy: RootReferral, qtype: dns.TypeA, rcode: dns.RcodeSuccess, authority: 13},
+	}
+
+	for _, tc := range testCases {
+		for _, name := range []string{".", ""} {
+			t.Run(string(tc.policy)+"/"+dns.TypeToString[tc.qtype]+"/"+name, func(t *testing.T) {
+				var received int32
+
+				s, err := NewSdns(SdnsConfig{
+					Port:       1232,
+					RootPolicy: tc.policy,
+					Recursors: []string{startRecursor(t,
+						countingHandler(&received, answerHandler(0, "7.7.7.7")))},
+					Domains: []*Domain{
+						{Name: "*.foo.com", Addresses: []string{"1.1.1.1"}},
+					},
+				})
+				assert.NoError(t, err)
+
+				var (
+					w = &testWriter{}
+					r = new(dns.Msg)
+				)
+
+				r.SetQuestion(name, tc.qtype)
+				s.ServeDNS(w, r)
+
+				m := w.msg
+				assert.Equal(t, tc.rcode, m.Rcode)
+				assert.Len(t, m.Answer, tc.answers)
+				assert.Len(t, m.Ns, tc.authority)
+				assert.Equal(t, tc.recursed, atomic.LoadInt32(&received) == 1)
+				assert.False(t, m.Authoritative)
+
+				for _, rr := range append(m.Answer, m.Ns...) {
+					if tc.recursed {
+						break
+					}
+
+					assert.Equal(t, ".", rr.Header().Name)
+					assert.Equal(t, dns.TypeNS, rr.Header().Rrtype)
+				}
+			})
+		}
+	}
+}
+
+func TestNewSdns_unknownRootPolicy(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{
+		Port:       1232,
+		RootPolicy: "lol",
+	})
+	assert.Error(t, err)
+}
@@ -2,12 +2,22 @@
 package lib
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/miekg/dns"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
@@ -15,43 +25,585 @@ import (
 
 // SdnsConfig configures SDNS.
 type SdnsConfig struct {
+	// Port is the port DNS is served on over both UDP and
+	// TCP. Zero makes the system pick a free one (see
+	// Sdns.Addr).
 	Port      int
 	Address   string
 	Debug     bool
 	Recursors []string
 	Domains   []*Domain
+	Zones     []*Zone
+
+	// ConfigFile is a YAML file merged into the configuration
+	// (see LoadConfigFile) when instantiating and on every
+	// Load, so that its domains get reloaded.
+	ConfigFile string
+
+	// ZoneFile is a BIND zone file whose records are added
+	// to the domains (see LoadZoneFile) on every Load.
+	ZoneFile string
+
+	// HostsFile is a hosts(5) file whose mappings are added
+	// to the domains (see LoadHostsFile) on every Load.
+	HostsFile string
+
+	// NegativeSOA makes negative (NXDOMAIN) recursed
+	// responses always carry an SOA in the authority
+	// section - the upstream's one if present or a
+	// placeholder otherwise.
+	NegativeSOA bool
+
+	// PlaceholderSOA is the SOA rdata (presentation
+	// format, without owner and type) used when the
+	// upstream didn't send one.
+	// Defaults to DefaultPlaceholderSOA.
+	PlaceholderSOA string
+
+	// NSID is the server identifier returned to clients
+	// that request it via EDNS (RFC 5001).
+	// Defaults to the hostname.
+	NSID string
+
+	// UseSystemResolvers makes the nameservers from
+	// ResolvConf be used as recursors. This is also the
+	// case when no Recursors are specified.
+	UseSystemResolvers bool
+
+	// ResolvConf is the resolv.conf(5) file to take the
+	// system resolvers from.
+	// Defaults to DefaultResolvConf.
+	ResolvConf string
+
+	// ResponseDelay is an artificial latency injected in
+	// every response. Meant for chaos testing only.
+	ResponseDelay time.Duration
+
+	// ResponseDelayJitter is a random extra latency (up
+	// to the value) added to ResponseDelay.
+	ResponseDelayJitter time.Duration
+
+	// TTL is the TTL of the records answered locally
+	// (domains can override it). Defaults to 3600.
+	TTL uint32
+
+	// LogLevel is the minimum level (e.g.: 'info' or
+	// 'warn') of the messages logged (domains can
+	// override it). Defaults to 'info', or to logging
+	// everything in debug mode.
+	LogLevel string
+
+	// LogFormat is the format messages are logged in.
+	// Defaults to LogFormatConsole in debug mode and to
+	// LogFormatJSON otherwise.
+	LogFormat LogFormat
+
+	// LogOutput is where messages get logged to.
+	// Defaults to os.Stderr.
+	LogOutput io.Writer
+
+	// EventSink receives an event per query handled (see
+	// QueryEvent), for embedders to consume without parsing
+	// logs. Events the sink isn't ready to take are
+	// dropped rather than stalling queries.
+	EventSink chan<- QueryEvent
+
+	// TTLJitter is the fraction (0 to 1) by which the TTLs
+	// of locally answered records are randomly spread, up
+	// or down, so that clients don't expire them in sync.
+	TTLJitter float64
+
+	// ChaosFailureRate is the fraction (0 to 1) of the
+	// queries answered with SERVFAIL on purpose. Meant
+	// for chaos testing only.
+	ChaosFailureRate float64
+
+	// ChaosFailureNames restricts the failures injected
+	// to these names. When empty, any query can fail.
+	ChaosFailureNames []string
+
+	// Validator validates DNSSEC signatures of recursed
+	// responses. Only validated responses get the AD bit
+	// set. When nil, the AD bit is always cleared.
+	Validator Validator
+
+	// MinimalResponses leaves out of responses (either
+	// local or recursed) the authority and additional
+	// records that aren't required, keeping only the
+	// SOA of negative answers.
+	MinimalResponses bool
+
+	// BreakerThreshold is the number of consecutive
+	// failed recursions of a name (within BreakerWindow)
+	// after which the name is answered with SERVFAIL
+	// without recursing for BreakerCooldown.
+	// Zero disables the breaker.
+	BreakerThreshold int
+	BreakerWindow    time.Duration
+	BreakerCooldown  time.Duration
+
+	// RecursorFailures is the number of consecutive failed
+	// exchanges with a recursor after which it's skipped
+	// for RecursorCooldown, being probed again afterwards.
+	// Zero disables health tracking.
+	RecursorFailures int
+	RecursorCooldown time.Duration
+
+	// Clock tells the current time.
+	// Defaults to time.Now (meant to be set in tests).
+	Clock func() time.Time
+
+	// ClockReference is the URL of an HTTP server whose
+	// Date header the clock is checked against on Listen,
+	// warning if they're more than MaxClockSkew apart
+	// (defaults to DefaultMaxClockSkew). Not checked when
+	// empty.
+	ClockReference string
+	MaxClockSkew   time.Duration
+
+	// AddressMode determines how many addresses of a
+	// domain answer address questions (domains can
+	// override it). Defaults to AddressesRoundRobin.
+	AddressMode AddressMode
+
+	// NameserverOrder determines the order of the
+	// nameservers answering NS questions (domains can
+	// override it). Defaults to NameserversAsConfigured.
+	NameserverOrder NameserverOrder
+
+	// Delegation determines how address questions for
+	// domains that only have nameservers are answered
+	// (domains can override it). Defaults to
+	// DelegationNodata.
+	Delegation DelegationPolicy
+
+	// WildcardPolicy determines how questions of the types
+	// that a wildcard doesn't match (see
+	// Domain.WildcardTypes) are answered (wildcards can
+	// override it). Defaults to WildcardRecurse.
+	WildcardPolicy WildcardPolicy
+
+	// DelegationPort is the port that the nameservers of
+	// delegated domains are asked on (see
+	// DelegationRecurse). Defaults to DefaultDelegationPort.
+	DelegationPort int
+
+	// Transport determines which transports questions are
+	// answered over (domains can override it).
+	// Defaults to TransportAny.
+	Transport TransportPolicy
+
+	// ThrottlePolicy determines how questions for domains
+	// past their maximum rate (see Domain.MaxQPS) are
+	// answered. Defaults to ThrottleRefused.
+	ThrottlePolicy ThrottlePolicy
+
+	// RootPolicy determines how questions for the root
+	// (or an empty) name are handled.
+	// Defaults to RootRecurse.
+	RootPolicy RootPolicy
+
+	// CnamePolicy determines how domains that set both
+	// addresses and a cname (without a fallback chain) are
+	// handled. Defaults to CnamePrecedence.
+	CnamePolicy CnamePolicy
+
+	// DuplicatePolicy determines how domains sharing a name
+	// (case-insensitively) are loaded, a warning being
+	// logged either way. Defaults to DuplicatesLast.
+	DuplicatePolicy DuplicatePolicy
+
+	// MaxChainLength bounds the number of aliases that are
+	// followed locally when answering a question, past
+	// which questions are answered according to
+	// ChainPolicy. Defaults to MaxCnameChain.
+	MaxChainLength int
+
+	// ChainPolicy determines how questions whose alias
+	// chain is too long are answered.
+	// Defaults to ChainServfail.
+	ChainPolicy ChainPolicy
+
+	// DialTimeout, ReadTimeout and WriteTimeout bound
+	// each exchange with a recursor. They default to
+	// DefaultRecursionTimeout.
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// QtypeRewrites makes questions of a query type (the
+	// key, e.g.: 'MAILA' or 'TYPE38' for the legacy A6) be
+	// resolved as of another (the value, e.g.: 'AAAA').
+	// Responses keep the question as asked.
+	QtypeRewrites map[string]string
+
+	// RecursorRewrites are transformations applied to the
+	// queries forwarded to a given recursor (the key, in
+	// the form host:port).
+	RecursorRewrites map[string][]QueryRewrite
+
+	// ParallelRecursion fans questions out to all the
+	// recursors at once instead of trying them in order.
+	ParallelRecursion bool
+
+	// ParallelPolicy decides which response is used when
+	// recursing in parallel. Defaults to PolicyFirst.
+	ParallelPolicy ParallelPolicy
+
+	// RecursorOrder decides the order in which recursors
+	// are tried. Defaults to OrderPriority.
+	RecursorOrder RecursorOrder
+
+	// TLSCertFile and TLSKeyFile are the PEM encoded
+	// certificate and key used to serve DNS-over-TLS.
+	// DoT is only served when they're set.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// TLSPort is the port DNS-over-TLS is served on.
+	// Defaults to DefaultTLSPort.
+	TLSPort int
+
+	// EDNSPassthrough lists the codes of the EDNS options
+	// that are forwarded from clients to recursors and
+	// echoed back from recursors to clients. Any other
+	// option is stripped.
+	EDNSPassthrough []uint16
+
+	// FeatureOption is the code of the EDNS local option
+	// through which clients turn features on for their
+	// queries (see Feature), listing their names separated
+	// by commas. Zero disables per-query features.
+	FeatureOption uint16
+
+	// ClientSubnets makes questions sent to recursors carry
+	// the subnet of the client (EDNS Client Subnet, RFC
+	// 7871): the one the client sent, if any, or its
+	// address truncated to ClientSubnetV4 or
+	// ClientSubnetV6 bits (defaulting to
+	// DefaultClientSubnetV4 and DefaultClientSubnetV6).
+	ClientSubnets  bool
+	ClientSubnetV4 int
+	ClientSubnetV6 int
+
+	// IgnoreEDNSVersion makes requests advertising an EDNS
+	// version other than 0 (the only one sdns supports) be
+	// answered as if they were of version 0 instead of
+	// with BADVERS (RFC 6891).
+	IgnoreEDNSVersion bool
+
+	// MalformedPolicy determines how queries that can't be
+	// unpacked are answered, which are counted and logged
+	// regardless. Defaults to MalformedFormerr.
+	MalformedPolicy MalformedPolicy
+
+	// Compress makes responses use name compression.
+	// Can be overridden per domain (see Domain.Compress).
+	Compress bool
+
+	// TCPMaxConnections caps the number of simultaneous
+	// TCP (and DoT) connections; the ones in excess are
+	// closed right away. Zero means unlimited.
+	TCPMaxConnections int
+
+	// ClientRateLimit caps the queries per second answered
+	// to each client (by IP), letting bursts of up to
+	// ClientRateBurst (defaults to the limit) through.
+	// Queries past it are refused or dropped according to
+	// ClientRateAction (defaults to RateLimitRefuse), while
+	// clients within the ClientRateAllowlist subnets (e.g.:
+	// '10.0.0.0/8') aren't limited. Zero means unlimited.
+	ClientRateLimit     int
+	ClientRateBurst     int
+	ClientRateAction    RateLimitAction
+	ClientRateAllowlist []string
+
+	// AllowClients and DenyClients are subnets (e.g.:
+	// '10.0.0.0/8') restricting which clients are answered:
+	// clients within DenyClients are refused and, if
+	// AllowClients is set, so are the ones outside it.
+	AllowClients []string
+	DenyClients  []string
+
+	// RecursionClients, if set, restricts recursion to the
+	// clients within its subnets; the others are refused
+	// unless answered locally, which keeps sdns from being
+	// an open resolver.
+	RecursionClients []string
+
+	// Cache enables caching the responses of the recursors
+	// for as long as their TTLs allow, apart for each
+	// client subnet that recursors get (see ClientSubnets
+	// and EDNSPassthrough).
+	Cache bool
+
+	// CacheSize is the maximum number of responses cached,
+	// past which the least recently used are evicted.
+	// Defaults to DefaultCacheSize.
+	CacheSize int
+
+	// CacheSweepInterval is how often expired responses
+	// are swept from the cache while listening. They're
+	// otherwise only dropped when looked up or evicted.
+	CacheSweepInterval time.Duration
+
+	// CacheRedis is the address (host:port or redis:// URL)
+	// of a Redis server that sdns instances share responses
+	// through, read through when the local cache (see
+	// CacheSize) misses. Implies Cache.
+	CacheRedis string
+
+	// TCPIdleTimeout is the time a TCP connection is kept
+	// open waiting for another query.
+	// Defaults to miekg/dns' default (8s).
+	TCPIdleTimeout time.Duration
+
+	// ShutdownTimeout is how long in-flight queries are
+	// given to finish once Listen is shutting down, the
+	// ones left being cancelled afterwards.
+	// Defaults to DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+
+	// Reload gives the configuration to load (see Load)
+	// whenever a SIGHUP is received while listening. SIGHUP
+	// isn't handled if unset.
+	Reload func() (SdnsConfig, error)
+
+	// WatchFiles makes Listen reload the configuration
+	// whenever ConfigFile, ZoneFile or HostsFile change:
+	// the one given by Reload, if set, or this one
+	// otherwise. Changes are only loaded once the files
+	// are left alone for WatchDebounce (defaults to
+	// DefaultWatchDebounce).
+	WatchFiles    bool
+	WatchDebounce time.Duration
+
+	// DoHPort is the port DNS-over-HTTPS is served on.
+	// HTTPS is used if a TLS certificate is configured,
+	// plain HTTP otherwise (e.g.: behind a reverse proxy).
+	// Zero disables DoH.
+	DoHPort int
+
+	// DoHPath is the path DoH queries are served on.
+	// Defaults to DefaultDoHPath.
+	DoHPath string
+
+	// MetricsPort is the port Prometheus metrics are served
+	// on (under MetricsPath). Zero disables the endpoint.
+	MetricsPort int
+
+	// AdminAddress is the address (host:port) the admin
+	// API (see AdminHandler) is served on. Empty disables
+	// it, which is the default as the API isn't
+	// authenticated.
+	AdminAddress string
 }
 
+// DefaultPlaceholderSOA is the SOA rdata used for negative
+// responses that lack one when NegativeSOA is set.
+const DefaultPlaceholderSOA = "sdns. hostmaster.sdns. 1 3600 600 86400 60"
+
 // SdnsContext wraps a context that gets passed
 // through the methods that are responsible
 // for responding to queries.
 type SdnsContext struct {
-	logger zerolog.Logger
+	logger   zerolog.Logger
+	context  context.Context
+	trace    *recursionTrace
+	request  *dns.Msg
+	client   net.IP
+	flags    map[Feature]bool
+	decision Decision
 }
 
 // Sdns containers the internal representation of a
 // configured set of domains.
 type Sdns struct {
+	// nextRecursor comes first to be 64-bit aligned, as
+	// required by sync/atomic.
+	nextRecursor uint64
+
+	// lock guards the state that can be changed while
+	// serving (e.g.: recursors).
+	lock sync.RWMutex
+
+	// loadLock serializes loads, including the ones that
+	// change what's loaded (see update).
+	loadLock sync.Mutex
+	loaded   SdnsConfig
+
 	exactDomains    map[string]*Domain
 	wildcardDomains map[string]*Domain
-	reverseDomains  map[string]*Domain
+	reverseDomains  map[string][]*Domain
+	apexDomains     map[string]*Domain
+	zones           map[string]*Zone
+	serials         map[string]uint32
 	address         string
 	recursors       []string
 	logger          zerolog.Logger
 	client          *dns.Client
+	negativeSOA     bool
+	placeholderSOA  string
+	nsid            string
+	debug           bool
+	parallel        bool
+	parallelPolicy  ParallelPolicy
+	recursorOrder   RecursorOrder
+	addresses       AddressMode
+	nameservers     NameserverOrder
+	delegations     DelegationPolicy
+	delegationPort  int
+	wildcards       WildcardPolicy
+	malformed       MalformedPolicy
+	rootPolicy      RootPolicy
+	transportPolicy TransportPolicy
+	throttlePolicy  ThrottlePolicy
+	cnamePolicy     CnamePolicy
+	chainPolicy     ChainPolicy
+	maxChain        int
+	qtypeRewrites   map[uint16]uint16
+	validator       Validator
+	minimal         bool
+	now             func() time.Time
+	breaker         *breaker
+	clockReference  string
+	maxClockSkew    time.Duration
+	health          *recursorHealth
+	metrics         *metrics
+	hostnames       *hostnameCache
+	cache           responseCache
+	cacheGeneration string
+	cacheSweep      time.Duration
+	limiter         *clientLimiter
+	acl             *accessList
+	tlsAddress      string
+	tlsConfig       *tls.Config
+	dohAddress      string
+	dohPath         string
+	metricsAddress  string
+	adminAddress    string
+	boundAddress    string
+	listening       chan struct{}
+	lifetime        context.Context
+	endLifetime     context.CancelFunc
+
+	compression       bool
+	passthrough       map[uint16]bool
+	featureOption     uint16
+	events            chan<- QueryEvent
+	recursorRewrites  map[string][]QueryRewrite
+	ignoreEDNSVersion bool
+	clientSubnets     bool
+	clientSubnetV4    int
+	clientSubnetV6    int
+	tcpMaxConnections int
+	tcpIdle           time.Duration
+	shutdownTimeout   time.Duration
+	reloadSource      func() (SdnsConfig, error)
+	watchSource       func() (SdnsConfig, error)
+	watched           []string
+	watchDebounce     time.Duration
+
+	ttl       uint32
+	ttlJitter float64
+
+	responseDelayBase   time.Duration
+	responseDelayJitter time.Duration
+	chaosFailureRate    float64
+	chaosFailureNames   map[string]bool
 }
 
 // NewSdns instantiates a Sdns given a configuration.
-func NewSdns(cfg SdnsConfig) (s Sdns, err error) {
-	if cfg.Port == 0 {
-		err = errors.Errorf("a port must be specified")
+func NewSdns(cfg SdnsConfig) (s *Sdns, err error) {
+	s = &Sdns{listening: make(chan struct{})}
+
+	if cfg.WatchFiles {
+		s.watched, err = watchedFiles(cfg)
+		if err != nil {
+			return
+		}
+
+		// taken before ConfigFile gets merged below.
+		original := cfg
+		s.watchSource = cfg.Reload
+		if s.watchSource == nil {
+			s.watchSource = func() (SdnsConfig, error) {
+				return original, nil
+			}
+		}
+
+		s.watchDebounce = orDefault(cfg.WatchDebounce, DefaultWatchDebounce)
+	}
+
+	if cfg.ConfigFile != "" {
+		cfg, err = LoadConfigFile(cfg.ConfigFile, cfg)
+		if err != nil {
+			return
+		}
+
+		// already merged, which Load mustn't do again.
+		cfg.ConfigFile = ""
+	}
+
+	s.debug = cfg.Debug
+	s.metrics = newMetrics()
+	s.hostnames = &hostnameCache{entries: make(map[hostnameKey]hostnameEntry)}
+	s.now = cfg.Clock
+	if s.now == nil {
+		s.now = time.Now
+	}
+
+	if cfg.LogOutput == nil {
+		cfg.LogOutput = os.Stderr
+	}
+
+	s.logger, err = newLogger(cfg.LogOutput, cfg.LogFormat, cfg.LogLevel, cfg.Debug)
+	if err != nil {
 		return
 	}
 
-	if cfg.Debug {
-		s.logger = zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr})
-	} else {
-		s.logger = zerolog.New(os.Stderr)
+	switch {
+	case cfg.CacheRedis != "":
+		s.cache, err = newRedisCache(cfg.CacheRedis,
+			newMemoryCache(cfg.CacheSize), s.logger)
+		if err != nil {
+			return
+		}
+	case cfg.Cache:
+		s.cache = newMemoryCache(cfg.CacheSize)
+	}
+	s.cacheSweep = cfg.CacheSweepInterval
+
+	s.limiter, err = newClientLimiter(cfg)
+	if err != nil {
+		return
+	}
+
+	s.acl, err = newAccessList(cfg)
+	if err != nil {
+		return
+	}
+
+	s.address = fmt.Sprintf("%s:%d", cfg.Address, cfg.Port)
+	s.breaker = newBreaker(cfg.BreakerThreshold,
+		cfg.BreakerWindow, cfg.BreakerCooldown, s.now)
+	s.health = newRecursorHealth(cfg.RecursorFailures,
+		cfg.RecursorCooldown, s.now)
+
+	s.clockReference = cfg.ClockReference
+	s.maxClockSkew = cfg.MaxClockSkew
+	if s.maxClockSkew <= 0 {
+		s.maxClockSkew = DefaultMaxClockSkew
+	}
+
+	err = validateCnamePolicy(cfg.CnamePolicy)
+	if err != nil {
+		return
+	}
+
+	s.cnamePolicy = cfg.CnamePolicy
+	if s.cnamePolicy == "" {
+		s.cnamePolicy = CnamePrecedence
 	}
 
 	err = s.Load(cfg)
@@ -61,36 +613,436 @@ func NewSdns(cfg SdnsConfig) (s Sdns, err error) {
 		return
 	}
 
-	s.client = &dns.Client{SingleInflight: true}
-	s.recursors = cfg.Recursors
-	s.address = fmt.Sprintf("%s:%d", cfg.Address, cfg.Port)
+	s.placeholderSOA = cfg.PlaceholderSOA
+	if s.placeholderSOA == "" {
+		s.placeholderSOA = DefaultPlaceholderSOA
+	}
+
+	_, err = dns.NewRR(". SOA " + s.placeholderSOA)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"malformed placeholder SOA %s",
+			s.placeholderSOA)
+		return
+	}
+
+	s.nsid = cfg.NSID
+	if s.nsid == "" {
+		s.nsid, err = os.Hostname()
+		if err != nil {
+			err = errors.Wrapf(err,
+				"couldn't retrieve hostname for NSID")
+			return
+		}
+	}
+
+	// SingleInflight collapses in-flight exchanges by
+	// question regardless of the server, which would
+	// defeat asking several recursors in parallel.
+	s.client = &dns.Client{
+		SingleInflight: !cfg.ParallelRecursion,
+		DialTimeout:    orDefault(cfg.DialTimeout, DefaultRecursionTimeout),
+		ReadTimeout:    orDefault(cfg.ReadTimeout, DefaultRecursionTimeout),
+		WriteTimeout:   orDefault(cfg.WriteTimeout, DefaultRecursionTimeout),
+	}
+	s.negativeSOA = cfg.NegativeSOA
+	s.parallel = cfg.ParallelRecursion
+	s.validator = cfg.Validator
+	s.minimal = cfg.MinimalResponses
+	s.responseDelayBase = cfg.ResponseDelay
+	s.responseDelayJitter = cfg.ResponseDelayJitter
+
+	if cfg.TTLJitter < 0 || cfg.TTLJitter > 1 {
+		err = errors.Errorf("ttl jitter must be between 0 and 1 - %f",
+			cfg.TTLJitter)
+		return
+	}
+
+	s.ttlJitter = cfg.TTLJitter
+
+	s.ttl = cfg.TTL
+	if s.ttl == 0 {
+		s.ttl = defaultTTL
+	}
+
+	if cfg.ChaosFailureRate < 0 || cfg.ChaosFailureRate > 1 {
+		err = errors.Errorf("chaos failure rate must be between 0 and 1 - %f",
+			cfg.ChaosFailureRate)
+		return
+	}
+
+	s.chaosFailureRate = cfg.ChaosFailureRate
+	s.chaosFailureNames = make(map[string]bool)
+	for _, name := range cfg.ChaosFailureNames {
+		s.chaosFailureNames[strings.ToLower(strings.TrimRight(name, "."))] = true
+	}
+
+	err = s.loadTLS(cfg)
+	if err != nil {
+		return
+	}
+
+	s.compression = cfg.Compress
+	s.ignoreEDNSVersion = cfg.IgnoreEDNSVersion
+
+	s.clientSubnets = cfg.ClientSubnets
+	s.clientSubnetV4 = cfg.ClientSubnetV4
+	if s.clientSubnetV4 == 0 {
+		s.clientSubnetV4 = DefaultClientSubnetV4
+	}
+
+	s.clientSubnetV6 = cfg.ClientSubnetV6
+	if s.clientSubnetV6 == 0 {
+		s.clientSubnetV6 = DefaultClientSubnetV6
+	}
+
+	err = validateClientSubnets(s.clientSubnetV4, s.clientSubnetV6)
+	if err != nil {
+		return
+	}
+
+	s.passthrough = make(map[uint16]bool)
+	for _, code := range cfg.EDNSPassthrough {
+		s.passthrough[code] = true
+	}
+
+	err = validateFeatureOption(cfg.FeatureOption)
+	if err != nil {
+		return
+	}
+
+	s.featureOption = cfg.FeatureOption
+	s.events = cfg.EventSink
+
+	s.tcpMaxConnections = cfg.TCPMaxConnections
+	s.tcpIdle = cfg.TCPIdleTimeout
+	s.shutdownTimeout = orDefault(cfg.ShutdownTimeout, DefaultShutdownTimeout)
+	s.reloadSource = cfg.Reload
+
+	s.dohPath = cfg.DoHPath
+	if s.dohPath == "" {
+		s.dohPath = DefaultDoHPath
+	}
+
+	if cfg.DoHPort != 0 {
+		s.dohAddress = fmt.Sprintf("%s:%d", cfg.Address, cfg.DoHPort)
+	}
+
+	if cfg.MetricsPort != 0 {
+		s.metricsAddress = fmt.Sprintf("%s:%d", cfg.Address, cfg.MetricsPort)
+	}
+
+	s.adminAddress = cfg.AdminAddress
+
+	s.qtypeRewrites, err = parseQtypeRewrites(cfg.QtypeRewrites)
+	if err != nil {
+		return
+	}
+
+	err = validateRecursorRewrites(cfg.RecursorRewrites)
+	if err != nil {
+		return
+	}
+
+	s.recursorRewrites = cfg.RecursorRewrites
+
+	err = validateAddressMode(cfg.AddressMode)
+	if err != nil {
+		return
+	}
+
+	s.addresses = cfg.AddressMode
+	if s.addresses == "" {
+		s.addresses = AddressesRoundRobin
+	}
+
+	err = validateTransport(cfg.Transport)
+	if err != nil {
+		return
+	}
+
+	s.transportPolicy = cfg.Transport
+	if s.transportPolicy == "" {
+		s.transportPolicy = TransportAny
+	}
+
+	err = validateThrottle(cfg.ThrottlePolicy)
+	if err != nil {
+		return
+	}
+
+	s.throttlePolicy = cfg.ThrottlePolicy
+	if s.throttlePolicy == "" {
+		s.throttlePolicy = ThrottleRefused
+	}
+
+	err = validateNameserverOrder(cfg.NameserverOrder)
+	if err != nil {
+		return
+	}
+
+	s.nameservers = cfg.NameserverOrder
+	if s.nameservers == "" {
+		s.nameservers = NameserversAsConfigured
+	}
 
+	err = validateDelegation(cfg.Delegation)
+	if err != nil {
+		return
+	}
+
+	s.delegations = cfg.Delegation
+	if s.delegations == "" {
+		s.delegations = DelegationNodata
+	}
+
+	s.delegationPort = cfg.DelegationPort
+	if s.delegationPort == 0 {
+		s.delegationPort = DefaultDelegationPort
+	}
+
+	err = validateMalformed(cfg.MalformedPolicy)
+	if err != nil {
+		return
+	}
+
+	s.malformed = cfg.MalformedPolicy
+	if s.malformed == "" {
+		s.malformed = MalformedFormerr
+	}
+
+	err = validateWildcardPolicy(cfg.WildcardPolicy)
+	if err != nil {
+		return
+	}
+
+	s.wildcards = cfg.WildcardPolicy
+	if s.wildcards == "" {
+		s.wildcards = WildcardRecurse
+	}
+
+	err = validateChain(cfg.MaxChainLength, cfg.ChainPolicy)
+	if err != nil {
+		return
+	}
+
+	s.maxChain = cfg.MaxChainLength
+	if s.maxChain == 0 {
+		s.maxChain = MaxCnameChain
+	}
+
+	s.chainPolicy = cfg.ChainPolicy
+	if s.chainPolicy == "" {
+		s.chainPolicy = ChainServfail
+	}
+
+	err = validateRootPolicy(cfg.RootPolicy)
+	if err != nil {
+		return
+	}
+
+	s.rootPolicy = cfg.RootPolicy
+	if s.rootPolicy == "" {
+		s.rootPolicy = RootRecurse
+	}
+
+	s.parallelPolicy = cfg.ParallelPolicy
+	switch s.parallelPolicy {
+	case "":
+		s.parallelPolicy = PolicyFirst
+	case PolicyFirst, PolicyMostAnswers:
+	default:
+		err = errors.Errorf("unknown parallel policy %s",
+			s.parallelPolicy)
+		return
+	}
+
+	s.recursorOrder = cfg.RecursorOrder
+	switch s.recursorOrder {
+	case "":
+		s.recursorOrder = OrderPriority
+	case OrderPriority, OrderRoundRobin, OrderRandom:
+	default:
+		err = errors.Errorf("unknown recursor order %s",
+			s.recursorOrder)
+		return
+	}
+	return
+}
+
+// systemResolvers takes the recursors from resolv.conf.
+// Failing to do so is only an error if the system resolvers
+// were explicitly asked for, in which case the configured
+// recursors are kept.
+func (s *Sdns) systemResolvers(cfg SdnsConfig) (recursors []string, err error) {
+	var path = cfg.ResolvConf
+
+	if path == "" {
+		path = DefaultResolvConf
+	}
+
+	recursors, err = RecursorsFromResolvConf(path, s.address)
+	if err != nil {
+		if cfg.UseSystemResolvers {
+			return
+		}
+
+		s.logger.Warn().
+			Err(err).
+			Msg("couldn't load system resolvers")
+		recursors, err = cfg.Recursors, nil
+		return
+	}
+
+	s.logger.Debug().
+		Str("resolv-conf", path).
+		Strs("recursors", recursors).
+		Msg("using system resolvers")
 	return
 }
 
+// sameRecursors tells whether two lists of recursors are
+// the same (in the same order).
+func sameRecursors(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+
+	return true
+}
+
 // Load loads internal mappings using a configuration.
 // This method is fired when the constructor is called
-// but can also be used to perform hot reload.
+// but can also be used to perform hot reload of domains,
+// zones and recursors. If it fails, the previous ones are
+// kept.
 // Note:	the address and port that the server listens
 //		to cannot be modified. If so, it'll be ignored.
 func (s *Sdns) Load(cfg SdnsConfig) (err error) {
-	s.exactDomains = make(map[string]*Domain)
-	s.wildcardDomains = make(map[string]*Domain)
+	s.loadLock.Lock()
+	defer s.loadLock.Unlock()
+
+	err = s.load(cfg)
+	return
+}
+
+// load performs Load, leaving the serialization of loads up
+// to the caller.
+func (s *Sdns) load(cfg SdnsConfig) (err error) {
+	if cfg.ConfigFile != "" {
+		cfg, err = LoadConfigFile(cfg.ConfigFile, cfg)
+		if err != nil {
+			return
+		}
+	}
+
+	if cfg.ZoneFile != "" {
+		var domains []*Domain
+
+		domains, err = LoadZoneFile(cfg.ZoneFile)
+		if err != nil {
+			return
+		}
+
+		cfg.Domains = append(append([]*Domain{}, cfg.Domains...), domains...)
+	}
+
+	if cfg.HostsFile != "" {
+		var domains []*Domain
 
-	if len(cfg.Domains) == 0 {
+		domains, err = LoadHostsFile(cfg.HostsFile)
+		if err != nil {
+			return
+		}
+
+		cfg.Domains = append(append([]*Domain{}, cfg.Domains...), domains...)
+	}
+
+	// what's loaded already has the files merged into it.
+	cfg.ConfigFile, cfg.ZoneFile, cfg.HostsFile = "", "", ""
+
+	err = validateDuplicatePolicy(cfg.DuplicatePolicy)
+	if err != nil {
 		return
 	}
 
+	cfg.Domains, err = s.dedupeDomains(cfg.Domains, cfg.DuplicatePolicy)
+	if err != nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var (
+		exactDomains    = s.exactDomains
+		wildcardDomains = s.wildcardDomains
+		reverseDomains  = s.reverseDomains
+		apexDomains     = s.apexDomains
+		zones           = s.zones
+		serials         = s.serials
+		recursors       = cfg.Recursors
+		pending         = make(map[*Domain]*domainRecords)
+	)
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		s.exactDomains = exactDomains
+		s.wildcardDomains = wildcardDomains
+		s.reverseDomains = reverseDomains
+		s.apexDomains = apexDomains
+		s.zones = zones
+		s.serials = serials
+	}()
+
+	if cfg.UseSystemResolvers || len(recursors) == 0 {
+		recursors, err = s.systemResolvers(cfg)
+		if err != nil {
+			return
+		}
+	}
+
+	s.exactDomains = make(map[string]*Domain)
+	s.wildcardDomains = make(map[string]*Domain)
+	s.reverseDomains = make(map[string][]*Domain)
+
 	for _, domain := range cfg.Domains {
-		if domain.Name[0] == '*' {
-			if domain.Name[1] != '.' {
-				err = errors.Errorf("malformed domain name. " +
-					"'*' must be followed by '.'")
-				return
-			}
-			s.wildcardDomains[domain.Name[1:]] = domain
-		} else {
-			s.exactDomains[domain.Name] = domain
+		err = validateDomainName(domain.Name)
+		if err != nil {
+			return
+		}
+
+		var (
+			key     = strings.ToLower(domain.Name)
+			domains = s.exactDomains
+		)
+
+		if key[0] == '*' {
+			key, domains = key[1:], s.wildcardDomains
+		}
+
+		domains[key] = domain
+		if domain.Name[0] != '*' {
+			s.loadReverse(domain)
+		}
+
+		pending[domain], err = domain.buildRecords()
+		if err != nil {
+			return
+		}
+
+		err = s.checkAmbiguity(domain)
+		if err != nil {
+			return
 		}
 
 		s.logger.Debug().
@@ -100,34 +1052,43 @@ func (s *Sdns) Load(cfg SdnsConfig) (err error) {
 			Msg("loaded")
 	}
 
-	return
-}
-
-func (s *Sdns) recurse(ctx *SdnsContext, m *dns.Msg, server string) (in *dns.Msg, err error) {
-	var (
-		rtt time.Duration
-		rm  = &dns.Msg{Question: m.Question}
-	)
-
-	rm.RecursionDesired = true
+	err = s.loadServices(cfg.Domains, pending)
+	if err != nil {
+		return
+	}
 
-	ctx.logger.Info().
-		Str("server", server).
-		Msg("recursing question")
+	err = s.loadApex(cfg.Domains, pending)
+	if err != nil {
+		return
+	}
 
-	in, rtt, err = s.client.Exchange(rm, server)
+	err = s.loadZones(cfg.Zones)
 	if err != nil {
-		err = errors.Wrapf(err,
-			"errored forwarding msg %+v",
-			*rm)
 		return
 	}
 
-	ctx.logger.Info().
-		Str("server", server).
-		Dur("duration", rtt).
-		Msg("recursion finished")
+	s.inheritNameservers(pending)
+	carryHealth(pending, exactDomains, wildcardDomains, apexDomains)
+	storeRecords(pending)
 
+	if !sameRecursors(s.recursors, recursors) {
+		s.recursors = recursors
+		s.breaker.reset()
+		s.hostnames.reset()
+	}
+
+	// domains might have started or stopped being
+	// forwarded, changing where responses come from.
+	if s.cache != nil {
+		s.cache.reset()
+	}
+	s.cacheGeneration = cacheGeneration(recursors, cfg.Domains)
+	s.loaded = cfg
+
+	s.logger.Info().
+		Int("domains", len(cfg.Domains)).
+		Int("zones", len(cfg.Zones)).
+		Msg("configuration loaded")
 	return
 }
 
@@ -135,6 +1096,9 @@ var (
 	ErrDomainNotFound       = errors.Errorf("Domain not found")
 	ErrNoQuestions          = errors.Errorf("No questions provided")
 	ErrUnsupportedQueryType = errors.Errorf("Query type not support")
+	ErrRecursorsExhausted   = errors.Errorf("No recursor could answer")
+	ErrCnameChainTooLong    = errors.Errorf("CNAME chain too long")
+	ErrRecursionSuspended   = errors.Errorf("Recursion suspended by breaker")
 )
 
 func (s *Sdns) answerNS(ctx *SdnsContext, m *dns.Msg) (err error) {
@@ -143,93 +1107,395 @@ func (s *Sdns) answerNS(ctx *SdnsContext, m *dns.Msg) (err error) {
 		rr   dns.RR
 	)
 
-	s.logger.Info().
+	ctx.logger.Debug().
 		Str("name", name).
 		Str("query", "NS").
 		Msg("looking for domain")
 
-	domain, found := s.FindDomainFromName(strings.TrimRight(name, "."))
+	domain, found := s.findDomain(strings.TrimRight(name, "."))
 	if !found {
 		err = ErrDomainNotFound
 		return
 	}
 
-	for _, ns := range domain.Nameservers {
+	for _, ns := range s.answerNameservers(domain) {
 		rr, err = dns.NewRR(fmt.Sprintf("%s NS %s", name, ns))
 		if err != nil {
 			err = errors.Wrapf(err, "Couldn't create RR msg")
 			return
 		}
+		rr.Header().Ttl = s.answerTTL(domain)
+		m.Answer = append(m.Answer, rr)
+	}
+
+	return
+}
+
+// MaxCnameChain is the number of aliases that are followed
+// locally when answering a question if not configured (see
+// SdnsConfig.MaxChainLength).
+const MaxCnameChain = 8
+
+func (s *Sdns) answerA(ctx *SdnsContext, m *dns.Msg) (err error) {
+	err = s.answerAddress(ctx, m, dns.TypeA)
+	return
+}
+
+func (s *Sdns) answerAAAA(ctx *SdnsContext, m *dns.Msg) (err error) {
+	err = s.answerAddress(ctx, m, dns.TypeAAAA)
+	return
+}
+
+// answerAddress answers A and AAAA questions, chasing the
+// aliases (CNAMEs) that are locally configured so that the
+// final addresses come in the same response.
+func (s *Sdns) answerAddress(ctx *SdnsContext, m *dns.Msg, qtype uint16) (err error) {
+	var (
+		name    string = m.Question[0].Name
+		rr      dns.RR
+		address string
+	)
+
+	ctx.logger.Debug().
+		Str("name", name).
+		Str("query", dns.TypeToString[qtype]).
+		Msg("looking for domain")
+
+	domain, found := s.findDomain(strings.TrimRight(name, "."))
+	if !found {
+		err = ErrDomainNotFound
+		return
+	}
+
+	for depth := 0; ; depth++ {
+		rrtype := domain.resolution(qtype)
+		if rrtype == 0 {
+			return
+		}
+
+		if rrtype != dns.TypeCNAME {
+			break
+		}
+
+		if depth == s.maxChain {
+			err = s.chainTooLong(ctx, m.Question[0].Name)
+			return
+		}
+
+		rr, err = newCNAME(name, domain.Cname)
+		if err != nil {
+			return
+		}
+		rr.Header().Ttl = s.answerTTL(domain)
 		m.Answer = append(m.Answer, rr)
+
+		name = dns.Fqdn(domain.Cname)
+		domain, found = s.findDomain(strings.TrimRight(name, "."))
+		if !found {
+			return
+		}
+	}
+
+	var (
+		built  int
+		failed error
+	)
+
+	for _, address = range s.answerAddresses(domain, qtype) {
+		addresses := []string{address}
+		if net.ParseIP(address) == nil {
+			addresses, err = s.resolveHostname(ctx, address, qtype, 0)
+			if err != nil {
+				failed = skipRecord(ctx, address, err)
+				continue
+			}
+		}
+
+		for _, address = range addresses {
+			rr, err = dns.NewRR(fmt.Sprintf(
+				"%s %s %s", name, dns.TypeToString[qtype], address))
+			if err != nil {
+				err = errors.Wrapf(err, "Couldn't create RR msg")
+				return
+			}
+			rr.Header().Ttl = s.answerTTL(domain)
+			m.Answer = append(m.Answer, rr)
+			built++
+		}
+	}
+
+	err = nil
+	if built == 0 {
+		err = failed
+	}
+	return
+}
+
+// skipRecord logs a record that couldn't be built (e.g.: a
+// hostname that didn't resolve), which is left out of the
+// answer unless none of the others can be built either.
+func skipRecord(ctx *SdnsContext, record string, err error) error {
+	ctx.logger.Warn().
+		Err(err).
+		Str("record", record).
+		Msg("skipping record")
+	return err
+}
+
+func newCNAME(name, target string) (rr dns.RR, err error) {
+	rr, err = dns.NewRR(fmt.Sprintf(
+		"%s CNAME %s", name, dns.Fqdn(target)))
+	if err != nil {
+		err = errors.Wrapf(err, "Couldn't create RR msg")
+		return
 	}
+
 	return
 }
 
-func (s *Sdns) answerA(ctx *SdnsContext, m *dns.Msg) (err error) {
+func (s *Sdns) answerCNAME(ctx *SdnsContext, m *dns.Msg) (err error) {
 	var (
 		name string = m.Question[0].Name
 		rr   dns.RR
 	)
 
-	s.logger.Info().
+	ctx.logger.Debug().
 		Str("name", name).
-		Str("query", "A").
+		Str("query", "CNAME").
 		Msg("looking for domain")
 
-	domain, found := s.FindDomainFromName(strings.TrimRight(name, "."))
+	domain, found := s.findDomain(strings.TrimRight(name, "."))
 	if !found {
 		err = ErrDomainNotFound
 		return
 	}
 
-	rr, err = dns.NewRR(fmt.Sprintf(
-		"%s A %s", name, domain.GetAddress()))
+	if domain.Cname == "" {
+		return
+	}
+
+	rr, err = newCNAME(name, domain.Cname)
 	if err != nil {
-		err = errors.Wrapf(err, "Couldn't create RR msg")
 		return
 	}
+	rr.Header().Ttl = s.answerTTL(domain)
 	m.Answer = append(m.Answer, rr)
 	return
 }
 
+// answerer answers, locally, questions of a given type.
+type answerer struct {
+	qtype  uint16
+	answer func(s *Sdns, ctx *SdnsContext, m *dns.Msg) error
+}
+
+// answerers lists the query types that can be answered
+// locally. ANY questions are answered with all of them.
+var answerers = []answerer{
+	{dns.TypeA, (*Sdns).answerA},
+	{dns.TypeAAAA, (*Sdns).answerAAAA},
+	{dns.TypeCNAME, (*Sdns).answerCNAME},
+	{dns.TypeNS, (*Sdns).answerNS},
+	{dns.TypeMX, (*Sdns).answerMX},
+	{dns.TypeTXT, (*Sdns).answerTXT},
+	{dns.TypeSRV, (*Sdns).answerSRV},
+	{dns.TypePTR, (*Sdns).answerPTR},
+	{dns.TypeSOA, (*Sdns).answerSOA},
+	{dns.TypeCAA, (*Sdns).answerCAA},
+}
+
 func (s *Sdns) answerQuery(ctx *SdnsContext, m *dns.Msg) (err error) {
 	if len(m.Question) == 0 {
 		err = ErrNoQuestions
 		return
 	}
 
-	switch m.Question[0].Qtype {
-	case dns.TypeA:
-		err = s.answerA(ctx, m)
-	case dns.TypeNS:
-		err = s.answerNS(ctx, m)
-	default:
-		err = ErrUnsupportedQueryType
+	qtype := s.rewriteQtype(m.Question[0].Qtype)
+	if s.forwards(m.Question[0].Name) || s.apexOmits(m.Question[0].Name, qtype) {
+		err = ErrDomainNotFound
+		return
+	}
+
+	omits, policy := s.wildcardOmits(m.Question[0].Name, qtype)
+	if omits {
+		if policy == WildcardRecurse {
+			err = ErrDomainNotFound
+		}
+
+		return
+	}
+
+	domain, delegation := s.delegation(m.Question[0].Name, qtype)
+	switch delegation {
+	case DelegationReferral:
+		err = s.answerReferral(ctx, m, domain)
+		return
+	case DelegationRecurse:
+		err = ErrDomainNotFound
+		return
+	}
+
+	if qtype == dns.TypeANY {
+		err = s.answerANY(ctx, m)
 		return
 	}
 
+	for _, a := range answerers {
+		if a.qtype == qtype {
+			err = a.answer(s, ctx, m)
+			return
+		}
+	}
+
+	err = ErrUnsupportedQueryType
+	return
+}
+
+// answerANY aggregates the answers of every locally
+// supported type, failing with ErrDomainNotFound only if
+// none of them knows about the name.
+func (s *Sdns) answerANY(ctx *SdnsContext, m *dns.Msg) (err error) {
+	var found bool
+
+	for _, a := range answerers {
+		err = a.answer(s, ctx, m)
+		switch err {
+		case nil:
+			found = true
+		case ErrDomainNotFound:
+		default:
+			return
+		}
+	}
+
+	if !found {
+		err = ErrDomainNotFound
+		return
+	}
+
+	// aliases are chased by both the A and AAAA answers.
+	m.Answer = dns.Dedup(m.Answer, nil)
+	m.Ns = dns.Dedup(m.Ns, nil)
+	err = nil
 	return
 }
 
+// appendNegativeSOA makes sure that the authority section
+// of a negative response carries the right SOA: the one of
+// the zone if sdns is authoritative for the name or, if
+// NegativeSOA is set, the upstream's or a placeholder.
+func (s *Sdns) appendNegativeSOA(ctx *SdnsContext, m *dns.Msg) {
+	zone, found := s.findZone(strings.TrimRight(m.Question[0].Name, "."))
+	if found {
+		m.Ns = append(withoutType(m.Ns, dns.TypeSOA), s.soa(zone))
+		return
+	}
+
+	if !s.negativeSOA || hasType(m.Ns, dns.TypeSOA) {
+		return
+	}
+
+	rr, err := dns.NewRR(fmt.Sprintf("%s SOA %s",
+		m.Question[0].Name, s.placeholderSOA))
+	if err != nil {
+		ctx.logger.Error().
+			Err(err).
+			Msg("couldn't create placeholder SOA")
+		return
+	}
+
+	m.Ns = append(m.Ns, rr)
+}
+
+// ServeDNS implements dns.Handler so that Sdns can be
+// plugged into any dns.Server.
+func (s *Sdns) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	s.handle(w, r)
+}
+
 func (s *Sdns) handle(w dns.ResponseWriter, r *dns.Msg) {
 	var (
-		err error
-		m   = dns.Msg{}
-		ctx = SdnsContext{
+		err   error
+		start = time.Now()
+		m     = dns.Msg{}
+		ctx   = SdnsContext{
 			logger: s.logger.With().
 				Uint16("id", r.Id).
 				Logger(),
+			context: s.queryContext(),
+			trace:   &recursionTrace{},
+			request: r,
+			client:  remoteIP(w.RemoteAddr()),
+			flags:   s.requestedFeatures(r),
 		}
 	)
 
+	ctx.logger = s.queryLogger(ctx.logger, r)
+	if len(ctx.flags) > 0 {
+		ctx.logger = ctx.logger.With().
+			Strs("features", ctx.featureNames()).
+			Logger()
+	}
+	s.metrics.observeQuery(r)
+	defer s.publishEvent(&ctx, r, start)
+
+	summary := &summaryWriter{ResponseWriter: w}
+	defer s.logSummary(&ctx, r, summary, start)
+	w = summary
+
 	m.SetReply(r)
-	m.Compress = false
 
-	switch r.Opcode {
-	case dns.OpcodeQuery:
+	switch {
+	case s.denied(&ctx):
+		ctx.decision = DecisionRejected
+		m.Rcode = dns.RcodeRefused
+	case s.rateLimited(&ctx):
+		ctx.decision = DecisionRejected
+		if s.limiter.action == RateLimitDrop {
+			return
+		}
+
+		m.Rcode = dns.RcodeRefused
+	case s.rejectsEDNSVersion(&ctx, r):
+		ctx.decision = DecisionRejected
+		m.Rcode = dns.RcodeBadVers
+	case r.Opcode == dns.OpcodeQuery:
+		for _, question := range r.Question {
+			ctx.logger.Debug().
+				Str("name", question.Name).
+				Str("query", dns.TypeToString[question.Qtype]).
+				Msg("handling query")
+		}
+
+		if s.enforceTransport(&ctx, w, &m) {
+			ctx.decision = DecisionRejected
+			break
+		}
+
+		if s.throttle(&ctx, &m) {
+			ctx.decision = DecisionRejected
+			break
+		}
+
+		if s.answerCanned(&ctx, w, r) {
+			ctx.decision = DecisionLocal
+			s.metrics.answers.WithLabelValues("local").Inc()
+			return
+		}
+
+		if s.chaosFailure(&ctx, &m) {
+			ctx.decision = DecisionFailed
+			m.Rcode = dns.RcodeServerFailure
+			break
+		}
+
+		if s.answerRoot(&ctx, &m) {
+			break
+		}
+
 		err = s.answerQuery(&ctx, &m)
 		if err != nil {
-			s.logger.Warn().
+			ctx.logger.Debug().
 				Err(err).
 				Msg("couldn't answer right away")
 		}
@@ -240,52 +1506,263 @@ func (s *Sdns) handle(w dns.ResponseWriter, r *dns.Msg) {
 		case ErrDomainNotFound:
 			var in *dns.Msg
 
-			s.logger.Info().
-				Strs("recursors", s.recursors).
-				Msg("starting to recurse")
-
-			for _, server := range s.recursors {
-				in, err = s.recurse(&ctx, &m, server)
-				if err != nil {
-					ctx.logger.Error().
-						Err(err).
-						Str("server", server).
-						Msg("errored recursing")
-					continue
-				}
+			if s.recursionDenied(&ctx) {
+				ctx.decision = DecisionRejected
+				m.Rcode = dns.RcodeRefused
+				break
+			}
+
+			in, err = s.recurseCached(&ctx, &m)
+			if err != nil {
+				ctx.logger.Error().
+					Err(err).
+					Msg("couldn't recurse")
+				ctx.decision = DecisionFailed
+				m.Rcode = dns.RcodeServerFailure
+				break
+			}
 
-				m.Answer = in.Answer
+			err = s.authenticate(&ctx, in, &m)
+			if err != nil {
+				ctx.decision = DecisionFailed
+				m.Rcode = dns.RcodeServerFailure
 				break
 			}
+
+			if ctx.decision != DecisionCached {
+				ctx.decision = DecisionRecursed
+			}
+
+			s.metrics.answers.WithLabelValues("recursed").Inc()
+			copyRecursed(&m, in)
+			s.echoOptions(r, in, &m)
+			m.Rcode = in.Rcode
+			if in.Rcode == dns.RcodeNameError {
+				s.appendNegativeSOA(&ctx, &m)
+			}
 		case nil:
+			ctx.decision = DecisionLocal
+			s.metrics.answers.WithLabelValues("local").Inc()
+			m.Authoritative = !isReferral(&m)
+			s.jitterTTLs(&m)
 		default:
 			ctx.logger.Error().
 				Err(err).
 				Msg("couldn't answer query")
+
+			ctx.decision = DecisionFailed
+			m.Answer = nil
+			m.Rcode = dns.RcodeServerFailure
 		}
 	default:
+		ctx.decision = DecisionRejected
 		ctx.logger.Info().
 			Int("opcode", r.Opcode).
 			Msg("query for unsuported opcode")
 	}
 
+	m.Compress = s.compress(&m)
+	s.minimize(&ctx, &m)
+	s.reportTrace(&ctx, r, &m)
+	answerEDNS(r, &m)
+	s.answerNSID(r, &m)
+	s.forceTruncate(w, &m)
+	truncateToFit(w, r, &m)
+
+	err = s.delayResponse(ctx.context, &m)
+	if err != nil {
+		ctx.logger.Warn().
+			Err(err).
+			Msg("delayed response cancelled")
+		return
+	}
+
+	s.metrics.observeResponse(w, r, &m)
 	w.WriteMsg(&m)
 }
 
+// Listen serves DNS over both UDP and TCP, blocking until
+// one of the servers fails or a SIGINT or SIGTERM is
+// received, in which case it shuts the servers down and
+// returns nil. SIGHUPs reload the configuration (see
+// SdnsConfig.Reload).
 func (s *Sdns) Listen() (err error) {
-	dns.HandleFunc(".", s.handle)
+	var (
+		servers = []*dns.Server{
+			{
+				Addr:           s.address,
+				Net:            "udp",
+				Handler:        s,
+				DecorateReader: s.decorateReader,
+			},
+			{
+				Addr:           s.address,
+				Net:            "tcp",
+				Handler:        s,
+				IdleTimeout:    s.tcpIdleTimeout(),
+				DecorateReader: s.decorateReader,
+			},
+		}
+		dohServer     *http.Server
+		metricsServer *http.Server
+		adminServer   *http.Server
+	)
+
+	if s.tlsConfig != nil {
+		servers = append(servers, &dns.Server{
+			Addr:           s.tlsAddress,
+			Net:            "tcp-tls",
+			TLSConfig:      s.tlsConfig,
+			Handler:        s,
+			IdleTimeout:    s.tcpIdleTimeout(),
+			DecorateReader: s.decorateReader,
+		})
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	if s.reloadSource != nil {
+		signal.Notify(signals, syscall.SIGHUP)
+	}
+	defer signal.Stop(signals)
+
+	if len(s.watched) > 0 {
+		var watcher *fsnotify.Watcher
+
+		watcher, err = s.newWatcher()
+		if err != nil {
+			return
+		}
+
+		done := make(chan struct{})
+		defer close(done)
 
-	server := &dns.Server{Addr: s.address, Net: "udp"}
+		go s.watchFiles(watcher, done)
+	}
 
-	err = server.ListenAndServe()
-	defer server.Shutdown()
+	err = s.bindDNS(servers[0], servers[1])
 	if err != nil {
-		err = errors.Wrapf(err,
-			"errored listening on address %s",
-			s.address)
 		return
 	}
 
+	for _, server := range servers[2:] {
+		server.Listener, err = s.listenTCP(server.Addr, server.TLSConfig)
+		if err != nil {
+			servers[0].PacketConn.Close()
+			for _, server := range servers {
+				if server.Listener != nil {
+					server.Listener.Close()
+				}
+			}
+			return
+		}
+	}
+
+	go func() {
+		_, err := s.CheckClockSkew()
+		if err != nil {
+			s.logger.Warn().
+				Err(err).
+				Msg("couldn't check clock skew")
+		}
+	}()
+
+	healthChecksDone := make(chan struct{})
+	defer close(healthChecksDone)
+
+	go s.runHealthChecks(healthChecksDone)
+
+	if s.cache != nil && s.cacheSweep > 0 {
+		done := make(chan struct{})
+		defer close(done)
+
+		go s.sweepCache(s.cacheSweep, done)
+	}
+
+	if s.limiter != nil {
+		done := make(chan struct{})
+		defer close(done)
+
+		go s.sweepClients(DefaultRateLimitSweep, done)
+	}
+
+	s.beginLifetime()
+
+	var (
+		errs    = make(chan error, len(servers)+3)
+		started = make(chan struct{}, len(servers))
+	)
+
+	for _, server := range servers {
+		server.NotifyStartedFunc = func() { started <- struct{}{} }
+
+		go func(server *dns.Server) {
+			errs <- errors.Wrapf(server.ActivateAndServe(),
+				"errored listening on %s address %s",
+				server.Net, server.Addr)
+		}(server)
+	}
+
+	// servers can only be shut down once serving, which
+	// they'd otherwise start doing after Listen returned.
+	for range servers {
+		select {
+		case <-started:
+		case err = <-errs:
+			s.shutdown(servers)
+			return
+		}
+	}
+
+	s.bound(servers[0].Addr)
+
+	if s.dohAddress != "" {
+		dohServer = &http.Server{
+			Addr:      s.dohAddress,
+			Handler:   s.DoHHandler(),
+			TLSConfig: s.tlsConfig,
+		}
+
+		go func() {
+			var err error
+			if dohServer.TLSConfig != nil {
+				err = dohServer.ListenAndServeTLS("", "")
+			} else {
+				err = dohServer.ListenAndServe()
+			}
+
+			errs <- errors.Wrapf(err,
+				"errored listening on doh address %s",
+				dohServer.Addr)
+		}()
+	}
+
+	if s.metricsAddress != "" {
+		metricsServer = s.metricsServer()
+
+		go func() {
+			errs <- errors.Wrapf(metricsServer.ListenAndServe(),
+				"errored listening on metrics address %s",
+				metricsServer.Addr)
+		}()
+	}
+
+	if s.adminAddress != "" {
+		adminServer = &http.Server{
+			Addr:    s.adminAddress,
+			Handler: s.AdminHandler(),
+		}
+
+		go func() {
+			errs <- errors.Wrapf(adminServer.ListenAndServe(),
+				"errored listening on admin address %s",
+				adminServer.Addr)
+		}()
+	}
+
+	err = s.awaitShutdown(errs, signals)
+	s.shutdown(servers, dohServer, metricsServer, adminServer)
+
 	return
 }
 
@@ -300,34 +1777,341 @@ type Domain struct {
 
 	// Addresses is a list of IP addresses that
 	// are meant to be resolved by the IP.
+	// IPv4 addresses are served in A records while
+	// IPv6 ones in AAAA records. Hostnames are resolved
+	// (locally first, then via the recursors) to the
+	// addresses of the family asked for.
+	// Addresses can carry a weight (e.g.: '10.0.0.1;weight=80')
+	// making them picked in proportion to it; the ones
+	// without weigh 1.
 	Addresses []string
 
 	// Nameservers is a list of nameservers that
 	// are capable of resolving domains related
-	// to 'Name'.
+	// to 'Name'. Defaults to the ones of the zone
+	// the domain belongs to (see Zone.Nameservers).
 	Nameservers []string
 
-	nextIdx uint64
-	once    sync.Once
+	// Cname makes the domain an alias of another
+	// one. When the target is also configured,
+	// address queries get the target's addresses
+	// in the same response.
+	Cname string
+
+	// MailExchangers is a list of mail exchangers in
+	// the form '<priority> <host>' (e.g.: '10 mx.a.com')
+	// served in the order they're specified.
+	MailExchangers []string
+
+	// Texts is a list of texts (e.g.: SPF policies or
+	// verification tokens) each served as a TXT record.
+	Texts []string
+
+	// CAA is a list of certification authority
+	// authorizations in the form '<flag> <tag> <value>'
+	// (e.g.: '0 issue "letsencrypt.org"').
+	CAA []string
+
+	// SRV is a list of service locators in the form
+	// '<priority> <weight> <port> <target>' (e.g.:
+	// '10 60 5060 sip.a.com').
+	SRV []string
+
+	// Services attaches records to underscore-prefixed
+	// names under the domain, in the form '<labels>
+	// <type> <data>' with type TXT or SRV (e.g.: '_dmarc
+	// TXT v=DMARC1; p=none' or '_sip._tcp SRV 10 60 5060
+	// sip.a.com'). Ignored for names that are configured.
+	Services []string
+
+	// ResponseDelay overrides the global artificial
+	// latency for this domain (chaos testing only).
+	ResponseDelay time.Duration
+
+	// ResponseDelayJitter overrides the global jitter
+	// added to ResponseDelay.
+	ResponseDelayJitter time.Duration
+
+	// ForceTruncate makes UDP responses for this domain
+	// always come truncated (with the TC bit set and no
+	// answers) so that clients retry over TCP.
+	// Meant for testing clients' TCP fallback.
+	ForceTruncate bool
+
+	// Seed offsets, pseudo-randomly, the address the
+	// round-robin over the domain's addresses starts from
+	// and seeds the order of shuffled addresses (see
+	// AddressesShuffled) as well as the picks of weighted
+	// ones. When not set (zero), it starts from the first
+	// one and the others are random.
+	Seed int64
+
+	// Compress overrides, for the domain, whether the
+	// responses use name compression (see SdnsConfig.Compress).
+	Compress *bool
+
+	// ApexTypes makes a wildcard also answer for its apex
+	// (e.g.: '*.mysite.com' for 'mysite.com') with its
+	// records of the types listed (A, AAAA, NS, MX, TXT
+	// or CAA). Ignored if the apex is configured.
+	ApexTypes []string
+
+	// WildcardTypes restricts the question types (e.g.:
+	// A, AAAA and CNAME) that a wildcard matches, the
+	// others being answered according to WildcardPolicy.
+	// When not set, it matches all of them.
+	WildcardTypes []string
+
+	// WildcardPolicy overrides, for the wildcard, how
+	// questions of the types it doesn't match are answered
+	// (see SdnsConfig.WildcardPolicy).
+	WildcardPolicy WildcardPolicy
+
+	// Fallback is the ordered chain of record types (A,
+	// AAAA or CNAME) that address questions are answered
+	// with locally: the first that the domain has records
+	// of wins and, if none, the answer is NODATA.
+	// Defaults to DefaultFallback.
+	Fallback []string
+
+	// AddressMode overrides, for the domain, how many of
+	// its addresses answer address questions (see
+	// SdnsConfig.AddressMode).
+	AddressMode AddressMode
+
+	// TTL overrides, for the domain, the TTL of the
+	// records answered (see SdnsConfig.TTL).
+	TTL uint32
+
+	// Cutover is when the domain is planned to change (e.g.:
+	// a migration), towards which its TTL ramps down so
+	// that clients re-query more often around it. The
+	// ramp starts CutoverRamp ahead (defaults to the TTL)
+	// and ends at CutoverTTL (defaults to
+	// DefaultCutoverTTL).
+	Cutover     time.Time
+	CutoverRamp time.Duration
+	CutoverTTL  uint32
+
+	// HealthCheck makes the addresses (IPs) of the domain
+	// be checked every HealthCheckInterval (defaults to
+	// DefaultHealthCheckInterval) on HealthCheckPort, over
+	// TCP or HTTP (at HealthCheckPath, defaulting to '/').
+	// Addresses found down aren't answered with unless
+	// all of them are.
+	HealthCheck         HealthCheck
+	HealthCheckPort     int
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+
+	// NameserverOrder overrides, for the domain, the order
+	// of the nameservers answering NS questions (see
+	// SdnsConfig.NameserverOrder).
+	NameserverOrder NameserverOrder
+
+	// Delegation overrides, for the domain, how address
+	// questions are answered when it only has nameservers
+	// (see SdnsConfig.Delegation).
+	Delegation DelegationPolicy
+
+	// Transport overrides, for the domain, the transports
+	// that questions are answered over (see
+	// SdnsConfig.Transport).
+	Transport TransportPolicy
+
+	// MaxQPS caps the rate of the questions answered for
+	// the domain (e.g.: an expensive one), the ones past it
+	// being answered according to SdnsConfig.ThrottlePolicy.
+	// Bursts of up to a second's worth of questions are let
+	// through. Zero means unlimited.
+	MaxQPS int
+
+	// Recursors makes questions for the domain (e.g.: an
+	// internal zone, when set on '*.corp.internal') go to
+	// these recursors instead of being answered locally.
+	Recursors []string
+
+	// CacheNamespace is the namespace that the responses of
+	// the domain's recursors are cached under, apart from
+	// the ones of the global recursors. Defaults to the
+	// domain's name; forwarded domains setting the same one
+	// share their cached responses.
+	CacheNamespace string
+
+	// CacheMinTTL and CacheMaxTTL bound the TTLs of the
+	// responses of the domain's recursors and, thus, how
+	// long they're cached for (zero meaning unbounded).
+	// NoCache keeps them out of the cache altogether.
+	CacheMinTTL uint32
+	CacheMaxTTL uint32
+	NoCache     bool
+
+	// LogLevel overrides, for the queries of the domain,
+	// the minimum level of the messages logged (see
+	// SdnsConfig.LogLevel).
+	LogLevel string
+
+	// Responses attaches canned responses to the domain,
+	// in the form '<type> <path>' (e.g.: 'A a.bin'), loaded
+	// from files either in wire format or as printed by
+	// dig. Questions of the type get the response as is,
+	// with only the id and the question taken from the
+	// request (meant for protocol testing).
+	Responses []string
+
+	nextIdx        uint64
+	nextNameserver uint64
+	once           sync.Once
+	rng            *rand.Rand
+	rngLock        sync.Mutex
+	records        atomic.Value // *domainRecords
+	apexTypes      map[uint16]bool
 }
 
 func (d *Domain) init() {
-	d.nextIdx = uint64(time.Now().UnixNano())
+	if d.Seed == 0 {
+		return
+	}
+
+	d.rng = rand.New(rand.NewSource(d.Seed))
+	atomic.StoreUint64(&d.nextIdx, uint64(d.rng.Int63()))
+}
+
+// shuffle shuffles, like rand.Shuffle, using the source
+// seeded with Seed or, if not set, the global one.
+// Safe for concurrent use.
+func (d *Domain) shuffle(n int, swap func(i, j int)) {
+	d.once.Do(d.init)
+	if d.rng == nil {
+		rand.Shuffle(n, swap)
+		return
+	}
+
+	d.rngLock.Lock()
+	defer d.rngLock.Unlock()
+
+	d.rng.Shuffle(n, swap)
+}
+
+// int63n returns, like rand.Int63n, a number in [0,n) from
+// the source seeded with Seed or, if not set, the global
+// one. Safe for concurrent use.
+func (d *Domain) int63n(n int64) int64 {
+	d.once.Do(d.init)
+	if d.rng == nil {
+		return rand.Int63n(n)
+	}
+
+	d.rngLock.Lock()
+	defer d.rngLock.Unlock()
+
+	return d.rng.Int63n(n)
+}
+
+// pick returns the next address from a pool of addresses
+// (round-robin) or an empty string if the pool is empty.
+// Safe for concurrent use.
+func (d *Domain) pick(addresses []string) string {
+	if len(addresses) == 0 {
+		return ""
+	}
+
+	idx := atomic.AddUint64(&d.nextIdx, 1) - 1
+	return addresses[idx%uint64(len(addresses))]
 }
 
-// GetAddress returns a random address from the pool of
-// addresses that it has.
+// GetAddress returns the next IPv4 address from the pool
+// of addresses that it has or, if they're weighted, one
+// picked in proportion to its weight. Addresses found down
+// by health checks are skipped, unless all of them are.
 func (d *Domain) GetAddress() string {
+	records := d.loaded()
+
+	addresses, weights := records.health.healthy(records.addressesV4, records.weightsV4)
+	if weights != nil {
+		return d.pickWeighted(addresses, weights)
+	}
+
 	d.once.Do(d.init)
-	d.nextIdx++
+	return d.pick(addresses)
+}
+
+// GetAddressV6 returns the next IPv6 address from the pool
+// of addresses that it has or, if they're weighted, one
+// picked in proportion to its weight. Addresses found down
+// by health checks are skipped, unless all of them are.
+func (d *Domain) GetAddressV6() string {
+	records := d.loaded()
+
+	addresses, weights := records.health.healthy(records.addressesV6, records.weightsV6)
+	if weights != nil {
+		return d.pickWeighted(addresses, weights)
+	}
+
+	d.once.Do(d.init)
+	return d.pick(addresses)
+}
+
+// validateDomainName makes sure that the name of a domain
+// is present and that wildcards ('*') only show up as the
+// whole leftmost label (e.g.: '*.foo.com').
+func validateDomainName(name string) (err error) {
+	switch {
+	case name == "":
+		err = errors.Errorf("a domain name must be present")
+	case strings.Contains(strings.TrimPrefix(name, "*."), "*"),
+		name == "*.":
+		err = errors.Errorf("malformed domain name %s. "+
+			"'*' must be the leftmost label, followed by '.'", name)
+	}
 
-	return d.Addresses[d.nextIdx%uint64(len(d.Addresses))]
+	return
 }
 
 // MatchesDomain verifies whether the domain (a) matches
 // another.
 func DomainMatches(a, b string) bool {
-	return a == b
+	return strings.EqualFold(a, b)
+}
+
+// noRecords stands for names that sdns knows about but that
+// have no forward records (e.g.: reverse names).
+var noRecords = &Domain{}
+
+// findDomain looks up the domain that answers for a name,
+// following the precedence:
+//  1. exact forward domains;
+//  2. reverse names (in-addr.arpa and ip6.arpa), which
+//     resolve to noRecords; and
+//  3. wildcard forward domains.
+func (s *Sdns) findDomain(name string) (domain *Domain, found bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if name == "" {
+		return
+	}
+
+	name = strings.ToLower(name)
+
+	domain, found = s.exactDomains[name]
+	if found {
+		return
+	}
+
+	_, found = s.reverseDomains[name]
+	if found {
+		domain = noRecords
+		return
+	}
+
+	domain, found = s.findDomainFromName(name)
+	if found {
+		return
+	}
+
+	domain, found = s.apexDomains[name]
+	return
 }
 
 // FindDomainFromName performs the job of resolving the
@@ -335,28 +2119,40 @@ func DomainMatches(a, b string) bool {
 // For instance:
 //	-	what are the IPs of mysite.com ?
 func (s *Sdns) FindDomainFromName(name string) (domain *Domain, found bool) {
-	var (
-		strippedDomain string
-		domainFound    interface{}
-	)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	domain, found = s.findDomainFromName(name)
+	return
+}
 
+// findDomainFromName looks for an exact match of the name
+// and, failing that, for the most specific wildcard that
+// covers it at any depth (e.g.: '*.something.com' matches
+// both 'x.something.com' and 'x.y.something.com').
+func (s *Sdns) findDomainFromName(name string) (domain *Domain, found bool) {
 	if name == "" {
 		return
 	}
 
-	domainFound, found = s.exactDomains[name]
-	if !found {
-		lastDomainNdx := strings.IndexByte(name, '.')
-		if lastDomainNdx < 0 {
+	name = strings.ToLower(name)
+	domain, found = s.exactDomains[name]
+	if found {
+		return
+	}
+
+	for idx := strings.IndexByte(name, '.'); idx >= 0; {
+		domain, found = s.wildcardDomains[name[idx:]]
+		if found {
 			return
 		}
 
-		strippedDomain = name[lastDomainNdx:]
-		domainFound, found = s.wildcardDomains[strippedDomain]
-	}
+		next := strings.IndexByte(name[idx+1:], '.')
+		if next < 0 {
+			break
+		}
 
-	if domainFound != nil {
-		domain = domainFound.(*Domain)
+		idx += next + 1
 	}
 
 	return
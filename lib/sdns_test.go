@@ -1,8 +1,13 @@
 package lib_test
 
 import (
+	"bytes"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 
 	. "github.com/cirocosta/sdns/lib"
@@ -33,6 +38,12 @@ func TestDomainMatches(t *testing.T) {
 			d2:      "",
 			matches: true,
 		},
+		{
+			name:    "matches regardless of casing",
+			d1:      "Foo.COM",
+			d2:      "foo.com",
+			matches: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -110,6 +121,11 @@ func TestFindDomainFromName_exactDomain(t *testing.T) {
 			true,
 			d1,
 		},
+		{
+			"Test.SOMETHING.com",
+			true,
+			d1,
+		},
 		{
 			"",
 			false,
@@ -179,10 +195,20 @@ func TestFindDomainFromName_wildcardDomain(t *testing.T) {
 		Nameservers: []string{"us1.sdns.io"},
 	}
 
+	var d3 = &Domain{
+		Name:      "*.inner.something.com",
+		Addresses: []string{"192.168.0.104"},
+	}
+
+	var d4 = &Domain{
+		Name:      "exact.inner.something.com",
+		Addresses: []string{"192.168.0.105"},
+	}
+
 	s, err := NewSdns(SdnsConfig{
 		Port:    1232,
 		Address: ":",
-		Domains: []*Domain{d1, d2},
+		Domains: []*Domain{d1, d2, d3, d4},
 	})
 	assert.NoError(t, err)
 
@@ -221,6 +247,46 @@ func TestFindDomainFromName_wildcardDomain(t *testing.T) {
 			true,
 			d1,
 		},
+		{
+			"LOL.Something.com",
+			true,
+			d1,
+		},
+		{
+			"x.y.something.com",
+			true,
+			d1,
+		},
+		{
+			"x.y.z.something.com",
+			true,
+			d1,
+		},
+		{
+			"inner.something.com",
+			true,
+			d1,
+		},
+		{
+			"x.inner.something.com",
+			true,
+			d3,
+		},
+		{
+			"x.y.inner.something.com",
+			true,
+			d3,
+		},
+		{
+			"exact.inner.something.com",
+			true,
+			d4,
+		},
+		{
+			"something.org",
+			false,
+			nil,
+		},
 		{
 			".something.com",
 			true,
@@ -241,3 +307,842 @@ func TestFindDomainFromName_wildcardDomain(t *testing.T) {
 		})
 	}
 }
+
+func TestHandle_negativeSOA(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		negativeSOA bool
+		upstreamSOA bool
+		expectedSOA string
+	}{
+		{
+			name:        "no soa without the option",
+			negativeSOA: false,
+			upstreamSOA: false,
+			expectedSOA: "",
+		},
+		{
+			name:        "upstream soa passed through without the option",
+			negativeSOA: false,
+			upstreamSOA: true,
+			expectedSOA: "ns.upstream.",
+		},
+		{
+			name:        "upstream soa passed through",
+			negativeSOA: true,
+			upstreamSOA: true,
+			expectedSOA: "ns.upstream.",
+		},
+		{
+			name:        "placeholder soa synthesized",
+			negativeSOA: true,
+			upstreamSOA: false,
+			expectedSOA: "sdns.",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recursor := startRecursor(t, nxdomainHandler(tc.upstreamSOA))
+
+			s, err := NewSdns(SdnsConfig{
+				Port:        1232,
+				Recursors:   []string{recursor},
+				NegativeSOA: tc.negativeSOA,
+			})
+			assert.NoError(t, err)
+
+			m := query(s, "missing.test", dns.TypeA)
+			assert.Equal(t, dns.RcodeNameError, m.Rcode)
+
+			if tc.expectedSOA == "" {
+				assert.Empty(t, m.Ns)
+				return
+			}
+
+			assert.Len(t, m.Ns, 1)
+			soa, ok := m.Ns[0].(*dns.SOA)
+			assert.True(t, ok)
+			assert.Equal(t, tc.expectedSOA, soa.Ns)
+		})
+	}
+}
+
+func TestNewSdns_malformedPlaceholderSOA(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{
+		Port:           1232,
+		PlaceholderSOA: "not an soa",
+	})
+	assert.Error(t, err)
+}
+
+func TestHandle_addressFamilies(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{
+				Name:      "foo.com",
+				Addresses: []string{"2001:db8::1", "1.1.1.1"},
+			},
+			{
+				Name:      "v4.com",
+				Addresses: []string{"1.1.1.1"},
+			},
+			{
+				Name:      "v6.com",
+				Addresses: []string{"2001:db8::1"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name     string
+		qtype    uint16
+		expected []string
+	}{
+		{"foo.com", dns.TypeA, []string{"1.1.1.1"}},
+		{"foo.com", dns.TypeAAAA, []string{"2001:db8::1"}},
+		{"v4.com", dns.TypeA, []string{"1.1.1.1"}},
+		{"v4.com", dns.TypeAAAA, []string{}},
+		{"v6.com", dns.TypeA, []string{}},
+		{"v6.com", dns.TypeAAAA, []string{"2001:db8::1"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name+" "+dns.TypeToString[tc.qtype], func(t *testing.T) {
+			m := query(s, tc.name, tc.qtype)
+			assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+
+			addresses := []string{}
+			for _, rr := range m.Answer {
+				switch rr := rr.(type) {
+				case *dns.A:
+					assert.Equal(t, dns.TypeA, tc.qtype)
+					addresses = append(addresses, rr.A.String())
+				case *dns.AAAA:
+					assert.Equal(t, dns.TypeAAAA, tc.qtype)
+					addresses = append(addresses, rr.AAAA.String())
+				}
+			}
+
+			assert.Equal(t, tc.expected, addresses)
+		})
+	}
+}
+
+func TestHandle_cname(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{Name: "mysite.com", Addresses: []string{"1.1.1.1"}},
+			{Name: "www.mysite.com", Cname: "mysite.com"},
+			{Name: "alias.mysite.com", Cname: "www.mysite.com"},
+			{Name: "external.mysite.com", Cname: "elsewhere.com"},
+			{Name: "a.loop.com", Cname: "b.loop.com"},
+			{Name: "b.loop.com", Cname: "a.loop.com"},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name    string
+		qtype   uint16
+		rcode   int
+		answers []string
+	}{
+		{
+			name:  "www.mysite.com",
+			qtype: dns.TypeA,
+			rcode: dns.RcodeSuccess,
+			answers: []string{
+				"www.mysite.com.\t3600\tIN\tCNAME\tmysite.com.",
+				"mysite.com.\t3600\tIN\tA\t1.1.1.1",
+			},
+		},
+		{
+			name:  "alias.mysite.com",
+			qtype: dns.TypeA,
+			rcode: dns.RcodeSuccess,
+			answers: []string{
+				"alias.mysite.com.\t3600\tIN\tCNAME\twww.mysite.com.",
+				"www.mysite.com.\t3600\tIN\tCNAME\tmysite.com.",
+				"mysite.com.\t3600\tIN\tA\t1.1.1.1",
+			},
+		},
+		{
+			name:  "www.mysite.com",
+			qtype: dns.TypeCNAME,
+			rcode: dns.RcodeSuccess,
+			answers: []string{
+				"www.mysite.com.\t3600\tIN\tCNAME\tmysite.com.",
+			},
+		},
+		{
+			name:  "external.mysite.com",
+			qtype: dns.TypeA,
+			rcode: dns.RcodeSuccess,
+			answers: []string{
+				"external.mysite.com.\t3600\tIN\tCNAME\telsewhere.com.",
+			},
+		},
+		{
+			name:    "a.loop.com",
+			qtype:   dns.TypeA,
+			rcode:   dns.RcodeServerFailure,
+			answers: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name+" "+dns.TypeToString[tc.qtype], func(t *testing.T) {
+			m := query(s, tc.name, tc.qtype)
+			assert.Equal(t, tc.rcode, m.Rcode)
+
+			assert.Equal(t, tc.answers, answerStrings(m))
+		})
+	}
+}
+
+func TestHandle_fallback(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{Name: "target.com", Addresses: []string{"1.1.1.1", "::1"}},
+			{
+				Name:     "alias.com",
+				Cname:    "target.com",
+				Fallback: []string{"A", "CNAME"},
+			},
+			{
+				Name:      "both.com",
+				Addresses: []string{"2.2.2.2"},
+				Cname:     "target.com",
+				Fallback:  []string{"A", "CNAME"},
+			},
+			{
+				Name:      "default.com",
+				Addresses: []string{"2.2.2.2"},
+				Cname:     "target.com",
+			},
+			{
+				Name:      "addresses.com",
+				Addresses: []string{"2.2.2.2"},
+				Cname:     "target.com",
+				Fallback:  []string{"a"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name    string
+		qtype   uint16
+		answers []string
+	}{
+		{
+			name:  "alias.com",
+			qtype: dns.TypeA,
+			answers: []string{
+				"alias.com.\t3600\tIN\tCNAME\ttarget.com.",
+				"target.com.\t3600\tIN\tA\t1.1.1.1",
+			},
+		},
+		{
+			name:    "both.com",
+			qtype:   dns.TypeA,
+			answers: []string{"both.com.\t3600\tIN\tA\t2.2.2.2"},
+		},
+		{
+			name:  "both.com",
+			qtype: dns.TypeAAAA,
+			answers: []string{
+				"both.com.\t3600\tIN\tCNAME\ttarget.com.",
+				"target.com.\t3600\tIN\tAAAA\t::1",
+			},
+		},
+		{
+			name:  "default.com",
+			qtype: dns.TypeA,
+			answers: []string{
+				"default.com.\t3600\tIN\tCNAME\ttarget.com.",
+				"target.com.\t3600\tIN\tA\t1.1.1.1",
+			},
+		},
+		{
+			name:    "addresses.com",
+			qtype:   dns.TypeA,
+			answers: []string{"addresses.com.\t3600\tIN\tA\t2.2.2.2"},
+		},
+		{
+			name:    "addresses.com",
+			qtype:   dns.TypeAAAA,
+			answers: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name+" "+dns.TypeToString[tc.qtype], func(t *testing.T) {
+			m := query(s, tc.name, tc.qtype)
+			assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+			assert.Equal(t, tc.answers, answerStrings(m))
+		})
+	}
+}
+
+func TestLoad_malformedFallback(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{
+		Port: 1232,
+		Domains: []*Domain{
+			{Name: "a.com", Cname: "b.com", Fallback: []string{"CNAME", "MX"}},
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestLoad_malformedDomainNames(t *testing.T) {
+	var testCases = []struct {
+		names       []string
+		shouldError bool
+	}{
+		{[]string{"foo.com", "*.foo.com", "bar.com"}, false},
+		{[]string{""}, true},
+		{[]string{"*"}, true},
+		{[]string{"*."}, true},
+		{[]string{"*foo.com"}, true},
+		{[]string{"foo.*.com"}, true},
+		{[]string{"*.*.foo.com"}, true},
+		{[]string{"foo.com", "FOO.com"}, false},
+		{[]string{"*.foo.com", "*.foo.com"}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(strings.Join(tc.names, " "), func(t *testing.T) {
+			domains := []*Domain{}
+			for _, name := range tc.names {
+				domains = append(domains, &Domain{Name: name, Addresses: []string{"1.1.1.1"}})
+			}
+
+			_, err := NewSdns(SdnsConfig{
+				Port:      1232,
+				Recursors: []string{"127.0.0.1:1"},
+				Domains:   domains,
+			})
+
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestLoad_cnamePolicy(t *testing.T) {
+	var testCases = []struct {
+		policy      CnamePolicy
+		fallback    []string
+		answers     []string
+		warned      bool
+		shouldError bool
+	}{
+		{
+			policy: "",
+			answers: []string{
+				"both.com.\t3600\tIN\tCNAME\ttarget.com.",
+				"target.com.\t3600\tIN\tA\t1.1.1.1",
+			},
+			warned: true,
+		},
+		{
+			policy: CnamePrecedence,
+			answers: []string{
+				"both.com.\t3600\tIN\tCNAME\ttarget.com.",
+				"target.com.\t3600\tIN\tA\t1.1.1.1",
+			},
+			warned: true,
+		},
+		{
+			policy:      CnameReject,
+			shouldError: true,
+		},
+		{
+			policy:   CnameReject,
+			fallback: []string{"A", "CNAME"},
+			answers:  []string{"both.com.\t3600\tIN\tA\t2.2.2.2"},
+		},
+		{
+			policy:      "lol",
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			var logs bytes.Buffer
+
+			s, err := NewSdns(SdnsConfig{
+				Port:        1232,
+				Recursors:   []string{"127.0.0.1:1"},
+				CnamePolicy: tc.policy,
+				LogOutput:   &logs,
+				Domains: []*Domain{
+					{Name: "target.com", Addresses: []string{"1.1.1.1"}},
+					{
+						Name:      "both.com",
+						Addresses: []string{"2.2.2.2"},
+						Cname:     "target.com",
+						Fallback:  tc.fallback,
+					},
+				},
+			})
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.warned,
+				strings.Contains(logs.String(), "cname takes precedence over addresses"))
+			assert.Equal(t, tc.answers, answerStrings(query(s, "both.com", dns.TypeA)))
+		})
+	}
+}
+
+func TestHandle_skipBadRecords(t *testing.T) {
+	// nothing answers on port 1, so hostnames elsewhere
+	// can't be resolved.
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{
+				Name:        "mixed.com",
+				Addresses:   []string{"1.1.1.1", "unresolved.example.org", "2.2.2.2"},
+				AddressMode: AddressesAll,
+			},
+			{
+				Name:      "bad.com",
+				Addresses: []string{"unresolved.example.org"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name    string
+		rcode   int
+		answers []string
+	}{
+		{
+			name:  "mixed.com",
+			rcode: dns.RcodeSuccess,
+			answers: []string{
+				"mixed.com.\t3600\tIN\tA\t1.1.1.1",
+				"mixed.com.\t3600\tIN\tA\t2.2.2.2",
+			},
+		},
+		{
+			name:    "bad.com",
+			rcode:   dns.RcodeServerFailure,
+			answers: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := query(s, tc.name, dns.TypeA)
+			assert.Equal(t, tc.rcode, m.Rcode)
+			assert.Equal(t, tc.answers, answerStrings(m))
+		})
+	}
+}
+
+func TestLoad_badRecords(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		domain      *Domain
+		shouldError bool
+	}{
+		{
+			name: "valid",
+			domain: &Domain{
+				Name:        "foo.com",
+				Addresses:   []string{"1.1.1.1", "::1", "target.foo.com", "2.2.2.2;weight=10"},
+				Nameservers: []string{"ns1.foo.com", "ns2.foo.com."},
+			},
+		},
+		{
+			name:        "truncated ip",
+			domain:      &Domain{Name: "foo.com", Addresses: []string{"1.1.1.1", "1.1.1"}},
+			shouldError: true,
+		},
+		{
+			name:        "out of range ip",
+			domain:      &Domain{Name: "foo.com", Addresses: []string{"1.1.1.300"}},
+			shouldError: true,
+		},
+		{
+			name:        "malformed ipv6",
+			domain:      &Domain{Name: "foo.com", Addresses: []string{"2001:db8::g"}},
+			shouldError: true,
+		},
+		{
+			name:        "malformed weighted ip",
+			domain:      &Domain{Name: "foo.com", Addresses: []string{"1.1.1;weight=10"}},
+			shouldError: true,
+		},
+		{
+			name:        "malformed hostname",
+			domain:      &Domain{Name: "foo.com", Addresses: []string{"bad host.com"}},
+			shouldError: true,
+		},
+		{
+			name:        "nameserver with a space",
+			domain:      &Domain{Name: "foo.com", Nameservers: []string{"ns1.foo.com", "bad ns"}},
+			shouldError: true,
+		},
+		{
+			name:        "nameserver with an empty label",
+			domain:      &Domain{Name: "foo.com", Nameservers: []string{"ns1..foo.com"}},
+			shouldError: true,
+		},
+		{
+			name:        "nameserver as an ip",
+			domain:      &Domain{Name: "foo.com", Nameservers: []string{"10.0.0.1"}},
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port:      1232,
+				Recursors: []string{"127.0.0.1:1"},
+				Domains:   []*Domain{tc.domain},
+			})
+
+			if tc.shouldError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "foo.com")
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestDomain_seed(t *testing.T) {
+	var (
+		addresses = []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4"}
+		d1        = &Domain{Name: "a.com", Addresses: addresses, Seed: 42}
+		d2        = &Domain{Name: "b.com", Addresses: addresses, Seed: 42}
+		d3        = &Domain{Name: "c.com", Addresses: addresses, Seed: 43}
+		seq1      = []string{}
+		seq2      = []string{}
+		seq3      = []string{}
+	)
+
+	for i := 0; i < 10; i++ {
+		seq1 = append(seq1, d1.GetAddress())
+		seq2 = append(seq2, d2.GetAddress())
+		seq3 = append(seq3, d3.GetAddress())
+	}
+
+	assert.Equal(t, seq1, seq2)
+	assert.NotEqual(t, seq1, seq3)
+}
+
+func TestDomain_roundRobin(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		domain   *Domain
+		expected []string
+	}{
+		{
+			name:     "no addresses",
+			domain:   &Domain{Name: "a.com"},
+			expected: []string{"", "", ""},
+		},
+		{
+			name:     "single address",
+			domain:   &Domain{Name: "a.com", Addresses: []string{"1.1.1.1"}},
+			expected: []string{"1.1.1.1", "1.1.1.1"},
+		},
+		{
+			name: "cycles from the first address",
+			domain: &Domain{
+				Name:      "a.com",
+				Addresses: []string{"1.1.1.1", "::1", "2.2.2.2", "3.3.3.3"},
+			},
+			expected: []string{
+				"1.1.1.1", "2.2.2.2", "3.3.3.3",
+				"1.1.1.1", "2.2.2.2", "3.3.3.3",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port:    1232,
+				Domains: []*Domain{tc.domain},
+			})
+			assert.NoError(t, err)
+
+			sequence := []string{}
+			for range tc.expected {
+				sequence = append(sequence, tc.domain.GetAddress())
+			}
+
+			assert.Equal(t, tc.expected, sequence)
+		})
+	}
+}
+
+func TestDomain_concurrentRoundRobin(t *testing.T) {
+	var (
+		addresses = []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}
+		domain    = &Domain{Name: "a.com", Addresses: addresses}
+		picked    = make(chan string, 30*len(addresses))
+		wg        sync.WaitGroup
+	)
+
+	_, err := NewSdns(SdnsConfig{
+		Port:    1232,
+		Domains: []*Domain{domain},
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 3*len(addresses); j++ {
+				picked <- domain.GetAddress()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(picked)
+
+	counts := map[string]int{}
+	for address := range picked {
+		counts[address]++
+	}
+
+	assert.Equal(t, map[string]int{
+		"1.1.1.1": 30,
+		"2.2.2.2": 30,
+		"3.3.3.3": 30,
+	}, counts)
+}
+
+func TestHandle_authoritative(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{startRecursor(t, answerHandler(0, "7.7.7.7"))},
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	m := query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+	assert.True(t, m.Authoritative)
+
+	m = query(s, "bar.com", dns.TypeA)
+	assert.Equal(t, []string{"7.7.7.7"}, answerIPs(m))
+	assert.False(t, m.Authoritative)
+}
+
+func TestHandle_mixedCase(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1053,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+			{Name: "*.Bar.COM", Addresses: []string{"2.2.2.2"}},
+			{Name: "Baz.com", Addresses: []string{"3.3.3.3"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name     string
+		expected []string
+	}{
+		{"FOO.com", []string{"FOO.com.\t3600\tIN\tA\t1.1.1.1"}},
+		{"www.bar.com", []string{"www.bar.com.\t3600\tIN\tA\t2.2.2.2"}},
+		{"WWW.bar.Com", []string{"WWW.bar.Com.\t3600\tIN\tA\t2.2.2.2"}},
+		{"baz.com", []string{"baz.com.\t3600\tIN\tA\t3.3.3.3"}},
+		{"bAZ.COM", []string{"bAZ.COM.\t3600\tIN\tA\t3.3.3.3"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := query(s, tc.name, dns.TypeA)
+			assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+			assert.Equal(t, tc.expected, answerStrings(m))
+		})
+	}
+}
+
+func TestHandle_compression(t *testing.T) {
+	var (
+		enabled  = true
+		disabled = false
+	)
+
+	var testCases = []struct {
+		name     string
+		global   bool
+		override *bool
+		expected bool
+	}{
+		{
+			name:     "global disabled",
+			global:   false,
+			expected: false,
+		},
+		{
+			name:     "global enabled",
+			global:   true,
+			expected: true,
+		},
+		{
+			name:     "domain disables",
+			global:   true,
+			override: &disabled,
+			expected: false,
+		},
+		{
+			name:     "domain enables",
+			global:   false,
+			override: &enabled,
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSdns(SdnsConfig{
+				Port:      1053,
+				Recursors: []string{"127.0.0.1:1"},
+				Compress:  tc.global,
+				Domains: []*Domain{
+					{
+						Name:      "foo.com",
+						Addresses: []string{"1.1.1.1"},
+						Compress:  tc.override,
+					},
+				},
+			})
+			assert.NoError(t, err)
+
+			m := query(s, "foo.com", dns.TypeA)
+			assert.Equal(t, tc.expected, m.Compress)
+		})
+	}
+}
+
+func TestLoad_reload(t *testing.T) {
+	var (
+		previous = startRecursor(t, answerHandler(0, "1.1.1.1"))
+		next     = startRecursor(t, answerHandler(0, "2.2.2.2"))
+		cfg      = SdnsConfig{
+			Port:      1053,
+			Recursors: []string{previous},
+			Domains: []*Domain{
+				{Name: "local.com", Addresses: []string{"9.9.9.9"}},
+			},
+		}
+	)
+
+	s, err := NewSdns(cfg)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(query(s, "foo.com", dns.TypeA)))
+	assert.Equal(t, []string{"9.9.9.9"}, answerIPs(query(s, "local.com", dns.TypeA)))
+
+	cfg.Recursors = []string{next}
+	cfg.Domains = []*Domain{
+		{Name: "local.com", Addresses: []string{"8.8.8.8"}},
+	}
+	assert.NoError(t, s.Load(cfg))
+
+	assert.Equal(t, []string{next}, s.Recursors())
+	assert.Equal(t, []string{"2.2.2.2"}, answerIPs(query(s, "foo.com", dns.TypeA)))
+	assert.Equal(t, []string{"8.8.8.8"}, answerIPs(query(s, "local.com", dns.TypeA)))
+
+	// changes to the addresses of the same domain apply.
+	cfg.Domains[0].Addresses = []string{"7.7.7.7"}
+	assert.NoError(t, s.Load(cfg))
+	assert.Equal(t, []string{"7.7.7.7"}, answerIPs(query(s, "local.com", dns.TypeA)))
+
+	// a failed reload keeps what was there.
+	cfg.Recursors = []string{previous}
+	cfg.Domains = []*Domain{{Name: "*lol.com"}}
+	assert.Error(t, s.Load(cfg))
+
+	assert.Equal(t, []string{next}, s.Recursors())
+	assert.Equal(t, []string{"7.7.7.7"}, answerIPs(query(s, "local.com", dns.TypeA)))
+}
+
+func TestLoad_concurrentQueries(t *testing.T) {
+	cfg := SdnsConfig{
+		Port:      1053,
+		Recursors: []string{startRecursor(t, answerHandler(0, "1.1.1.1"))},
+		Domains: []*Domain{
+			{
+				Name:           "local.com",
+				Addresses:      []string{"9.9.9.9"},
+				MailExchangers: []string{"10 mx.local.com"},
+			},
+			{Name: "*.wild.com", Addresses: []string{"9.9.9.9"}},
+		},
+	}
+
+	s, err := NewSdns(cfg)
+	assert.NoError(t, err)
+
+	var (
+		done = make(chan struct{})
+		wg   sync.WaitGroup
+	)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				query(s, "local.com", dns.TypeA)
+				query(s, "local.com", dns.TypeMX)
+				query(s, "a.wild.com", dns.TypeA)
+				query(s, "9.9.9.9.in-addr.arpa", dns.TypePTR)
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, s.Load(cfg))
+		time.Sleep(time.Millisecond)
+	}
+
+	close(done)
+	wg.Wait()
+}
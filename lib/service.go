@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// serviceLocator is the parsed form of an entry of
+// Domain.SRV.
+type serviceLocator struct {
+	priority uint16
+	weight   uint16
+	port     uint16
+	target   string
+}
+
+// parseServiceLocator parses an SRV entry in the form
+// '<priority> <weight> <port> <target>' (e.g.:
+// '10 60 5060 sip.a.com').
+func parseServiceLocator(entry string) (srv serviceLocator, err error) {
+	fields := strings.Fields(entry)
+	if len(fields) != 4 {
+		err = errors.Errorf(
+			"SRV must be in the form '<priority> <weight> <port> <target>' - %s",
+			entry)
+		return
+	}
+
+	var values [3]uint64
+	for idx := range values {
+		values[idx], err = strconv.ParseUint(fields[idx], 10, 16)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed SRV - %s", entry)
+			return
+		}
+	}
+
+	srv.priority = uint16(values[0])
+	srv.weight = uint16(values[1])
+	srv.port = uint16(values[2])
+	srv.target = dns.Fqdn(fields[3])
+	return
+}
+
+// parseService parses an entry of Domain.Services in the
+// form '<labels> <type> <data>', where all the labels are
+// underscore-prefixed (e.g.: '_sip._tcp SRV 10 60 5060
+// sip.a.com').
+func parseService(entry string) (labels string, rrtype uint16, data string, err error) {
+	fields := strings.SplitN(strings.TrimSpace(entry), " ", 3)
+	if len(fields) != 3 {
+		err = errors.Errorf(
+			"service must be in the form '<labels> <type> <data>' - %s",
+			entry)
+		return
+	}
+
+	labels = fields[0]
+	for _, label := range strings.Split(labels, ".") {
+		if len(label) < 2 || label[0] != '_' {
+			err = errors.Errorf(
+				"service labels must be underscore-prefixed - %s",
+				entry)
+			return
+		}
+	}
+
+	rrtype = dns.StringToType[strings.ToUpper(fields[1])]
+	if rrtype != dns.TypeTXT && rrtype != dns.TypeSRV {
+		err = errors.Errorf(
+			"service type must be TXT or SRV - %s", entry)
+		return
+	}
+
+	data = strings.TrimSpace(fields[2])
+	return
+}
+
+// loadServices synthesizes the domains for the underscore-
+// prefixed names that domains attach records to (see
+// Domain.Services), unless they're explicitly configured.
+// Their records are added to the ones pending (see
+// storeRecords).
+func (s *Sdns) loadServices(domains []*Domain, pending map[*Domain]*domainRecords) (err error) {
+	var (
+		services = make(map[string]*Domain)
+		names    []string
+	)
+
+	for _, parent := range domains {
+		if len(parent.Services) == 0 {
+			continue
+		}
+
+		if parent.Name[0] == '*' {
+			err = errors.Errorf(
+				"domain %s: services can't be attached to wildcards",
+				parent.Name)
+			return
+		}
+
+		for _, entry := range parent.Services {
+			var (
+				labels string
+				rrtype uint16
+				data   string
+			)
+
+			labels, rrtype, data, err = parseService(entry)
+			if err != nil {
+				err = errors.Wrapf(err,
+					"invalid service for domain %s", parent.Name)
+				return
+			}
+
+			name := labels + "." + parent.Name
+			key := strings.ToLower(name)
+
+			service, found := services[key]
+			if !found {
+				service = &Domain{Name: name, TTL: parent.TTL}
+				services[key] = service
+				names = append(names, key)
+			}
+
+			switch rrtype {
+			case dns.TypeTXT:
+				service.Texts = append(service.Texts, data)
+			case dns.TypeSRV:
+				service.SRV = append(service.SRV, data)
+			}
+		}
+	}
+
+	for _, key := range names {
+		if _, found := s.exactDomains[key]; found {
+			continue
+		}
+
+		pending[services[key]], err = services[key].buildRecords()
+		if err != nil {
+			return
+		}
+
+		s.exactDomains[key] = services[key]
+	}
+
+	return
+}
+
+func (s *Sdns) answerSRV(ctx *SdnsContext, m *dns.Msg) (err error) {
+	var name string = m.Question[0].Name
+
+	ctx.logger.Debug().
+		Str("name", name).
+		Str("query", "SRV").
+		Msg("looking for domain")
+
+	domain, found := s.findDomain(strings.TrimRight(name, "."))
+	if !found {
+		err = ErrDomainNotFound
+		return
+	}
+
+	for _, srv := range domain.loaded().serviceLocators {
+		m.Answer = append(m.Answer, &dns.SRV{
+			Hdr: dns.RR_Header{
+				Name:   name,
+				Rrtype: dns.TypeSRV,
+				Class:  dns.ClassINET,
+				Ttl:    s.answerTTL(domain),
+			},
+			Priority: srv.priority,
+			Weight:   srv.weight,
+			Port:     srv.port,
+			Target:   srv.target,
+		})
+	}
+	return
+}
@@ -0,0 +1,123 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_services(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{
+				Name:      "example.com",
+				Addresses: []string{"1.1.1.1"},
+				Services: []string{
+					"_dmarc TXT v=DMARC1; p=none",
+					"_sip._tcp SRV 10 60 5060 sip.example.com",
+					"_sip._tcp SRV 20 0 5060 backup.example.com.",
+					"_acme-challenge TXT ignored",
+				},
+			},
+			{Name: "*.example.com", Addresses: []string{"9.9.9.9"}},
+			{Name: "_acme-challenge.example.com", Texts: []string{"token"}},
+			{Name: "sip.example.com", SRV: []string{"0 5 5061 sip2.example.com"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name     string
+		qtype    uint16
+		expected []string
+	}{
+		{
+			name:     "_dmarc.example.com",
+			qtype:    dns.TypeTXT,
+			expected: []string{"_dmarc.example.com.\t3600\tIN\tTXT\t\"v=DMARC1; p=none\""},
+		},
+		{
+			name:  "_sip._tcp.example.com",
+			qtype: dns.TypeSRV,
+			expected: []string{
+				"_sip._tcp.example.com.\t3600\tIN\tSRV\t10 60 5060 sip.example.com.",
+				"_sip._tcp.example.com.\t3600\tIN\tSRV\t20 0 5060 backup.example.com.",
+			},
+		},
+		{
+			name:     "_dmarc.example.com",
+			qtype:    dns.TypeA,
+			expected: []string{},
+		},
+		{
+			name:     "_acme-challenge.example.com",
+			qtype:    dns.TypeTXT,
+			expected: []string{"_acme-challenge.example.com.\t3600\tIN\tTXT\t\"token\""},
+		},
+		{
+			name:     "_other.example.com",
+			qtype:    dns.TypeA,
+			expected: []string{"_other.example.com.\t3600\tIN\tA\t9.9.9.9"},
+		},
+		{
+			name:     "sip.example.com",
+			qtype:    dns.TypeSRV,
+			expected: []string{"sip.example.com.\t3600\tIN\tSRV\t0 5 5061 sip2.example.com."},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name+" "+dns.TypeToString[tc.qtype], func(t *testing.T) {
+			m := query(s, tc.name, tc.qtype)
+			assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+			assert.Equal(t, tc.expected, answerStrings(m))
+		})
+	}
+}
+
+func TestLoad_malformedServices(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		domain *Domain
+	}{
+		{
+			name:   "labels without underscore",
+			domain: &Domain{Name: "a.com", Services: []string{"dmarc TXT v=DMARC1"}},
+		},
+		{
+			name:   "unsupported type",
+			domain: &Domain{Name: "a.com", Services: []string{"_mail MX 10 mx.a.com"}},
+		},
+		{
+			name:   "missing data",
+			domain: &Domain{Name: "a.com", Services: []string{"_dmarc TXT"}},
+		},
+		{
+			name:   "malformed srv",
+			domain: &Domain{Name: "a.com", Services: []string{"_sip._tcp SRV 10 5060 sip.a.com"}},
+		},
+		{
+			name:   "wildcard",
+			domain: &Domain{Name: "*.a.com", Services: []string{"_dmarc TXT v=DMARC1"}},
+		},
+		{
+			name:   "malformed srv port",
+			domain: &Domain{Name: "a.com", SRV: []string{"10 60 70000 sip.a.com"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port:    1232,
+				Domains: []*Domain{tc.domain},
+			})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultClientSubnetV4 is the length of the prefix
+	// of IPv4 clients' addresses sent to recursors.
+	DefaultClientSubnetV4 = 24
+
+	// DefaultClientSubnetV6 is the length of the prefix
+	// of IPv6 clients' addresses sent to recursors.
+	DefaultClientSubnetV6 = 56
+)
+
+// validateClientSubnets makes sure that the prefix lengths
+// fit addresses of their families.
+func validateClientSubnets(v4, v6 int) (err error) {
+	if v4 < 0 || v4 > net.IPv4len*8 {
+		err = errors.Errorf("invalid client subnet prefix length %d for ipv4", v4)
+		return
+	}
+
+	if v6 < 0 || v6 > net.IPv6len*8 {
+		err = errors.Errorf("invalid client subnet prefix length %d for ipv6", v6)
+		return
+	}
+
+	return
+}
+
+// remoteIP tells the IP address of the client, if known.
+func remoteIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+
+	return nil
+}
+
+// clientSubnet tells the client subnet (RFC 7871) that a
+// question goes to recursors with: the one the client sent,
+// if any (including the ones opting out, with a zero
+// prefix), or its address truncated to the prefix length
+// configured for its family.
+func (s *Sdns) clientSubnet(ctx *SdnsContext) (subnet *dns.EDNS0_SUBNET) {
+	if ctx.request != nil {
+		option, found := requestedOption(ctx.request, dns.EDNS0SUBNET)
+		if found {
+			subnet, _ = option.(*dns.EDNS0_SUBNET)
+			return
+		}
+	}
+
+	if ctx.client == nil {
+		return
+	}
+
+	subnet = &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET}
+	if ip := ctx.client.To4(); ip != nil {
+		subnet.Family = 1
+		subnet.SourceNetmask = uint8(s.clientSubnetV4)
+		subnet.Address = ip.Mask(net.CIDRMask(s.clientSubnetV4, net.IPv4len*8))
+		return
+	}
+
+	subnet.Family = 2
+	subnet.SourceNetmask = uint8(s.clientSubnetV6)
+	subnet.Address = ctx.client.Mask(net.CIDRMask(s.clientSubnetV6, net.IPv6len*8))
+	return
+}
+
+// forwardClientSubnet adds the client subnet to the OPT
+// record of the question sent to a recursor, unless it
+// already passed through (see EDNSPassthrough).
+func (s *Sdns) forwardClientSubnet(ctx *SdnsContext, rm *dns.Msg) {
+	if !s.clientSubnets {
+		return
+	}
+
+	_, found := requestedOption(rm, dns.EDNS0SUBNET)
+	if found {
+		return
+	}
+
+	subnet := s.clientSubnet(ctx)
+	if subnet == nil {
+		return
+	}
+
+	opt := rm.IsEdns0()
+	opt.Option = append(opt.Option, subnet)
+}
+
+// cacheSubnet tells the subnet that responses to the client
+// are cached under, as recursors may tailor them to it:
+// either the one sdns sends or, if it's allowed to pass
+// through (see EDNSPassthrough), the one the client sent.
+func (s *Sdns) cacheSubnet(ctx *SdnsContext) string {
+	if s.clientSubnets {
+		subnet := s.clientSubnet(ctx)
+		if subnet == nil {
+			return ""
+		}
+
+		return subnet.String()
+	}
+
+	if !s.passthrough[dns.EDNS0SUBNET] || ctx.request == nil {
+		return ""
+	}
+
+	option, found := requestedOption(ctx.request, dns.EDNS0SUBNET)
+	if !found {
+		return ""
+	}
+
+	return option.String()
+}
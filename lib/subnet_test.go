@@ -0,0 +1,202 @@
+package lib_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// subnetHandler reports the client subnet option it gets
+// (nil if none) and answers with an A record.
+func subnetHandler(received chan<- *dns.EDNS0_SUBNET) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		var subnet *dns.EDNS0_SUBNET
+
+		if opt := r.IsEdns0(); opt != nil {
+			for _, option := range opt.Option {
+				if option.Option() == dns.EDNS0SUBNET {
+					subnet = option.(*dns.EDNS0_SUBNET)
+				}
+			}
+		}
+
+		received <- subnet
+		answerHandler(0, "1.1.1.1")(w, r)
+	}
+}
+
+func TestHandle_clientSubnet(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		disabled bool
+		v4, v6   int
+		client   net.IP
+		sent     *dns.EDNS0_SUBNET
+		expected string
+	}{
+		{
+			name:     "ipv4 client",
+			client:   net.ParseIP("192.168.10.20"),
+			expected: "192.168.10.0/24/0",
+		},
+		{
+			name:     "ipv6 client",
+			client:   net.ParseIP("2001:db8:1234:5678::1"),
+			expected: "[2001:db8:1234:5600::]/56/0",
+		},
+		{
+			name:     "configured prefix lengths",
+			v4:       16,
+			client:   net.ParseIP("192.168.10.20"),
+			expected: "192.168.0.0/16/0",
+		},
+		{
+			name:   "subnet sent by the client",
+			client: net.ParseIP("192.168.10.20"),
+			sent: &dns.EDNS0_SUBNET{
+				Code:          dns.EDNS0SUBNET,
+				Family:        1,
+				SourceNetmask: 8,
+				Address:       net.ParseIP("10.0.0.0").To4(),
+			},
+			expected: "10.0.0.0/8/0",
+		},
+		{
+			name:     "disabled",
+			disabled: true,
+			client:   net.ParseIP("192.168.10.20"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			received := make(chan *dns.EDNS0_SUBNET, 1)
+
+			s, err := NewSdns(SdnsConfig{
+				Port:           1053,
+				Recursors:      []string{startRecursor(t, subnetHandler(received))},
+				ClientSubnets:  !tc.disabled,
+				ClientSubnetV4: tc.v4,
+				ClientSubnetV6: tc.v6,
+			})
+			assert.NoError(t, err)
+
+			var (
+				w = &testWriter{remote: &net.UDPAddr{IP: tc.client, Port: 40000}}
+				r = new(dns.Msg)
+			)
+
+			r.SetQuestion("example.com.", dns.TypeA)
+			if tc.sent != nil {
+				r.SetEdns0(4096, false)
+				r.IsEdns0().Option = append(r.IsEdns0().Option, tc.sent)
+			}
+
+			s.ServeDNS(w, r)
+			assert.Equal(t, []string{"1.1.1.1"}, answerIPs(w.msg))
+
+			subnet := <-received
+			if tc.expected == "" {
+				assert.Nil(t, subnet)
+				return
+			}
+
+			if assert.NotNil(t, subnet) {
+				assert.Equal(t, tc.expected, subnet.String())
+			}
+		})
+	}
+}
+
+func TestHandle_clientSubnetCache(t *testing.T) {
+	received := make(chan *dns.EDNS0_SUBNET, 2)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:          1053,
+		Recursors:     []string{startRecursor(t, subnetHandler(received))},
+		ClientSubnets: true,
+		Cache:         true,
+	})
+	assert.NoError(t, err)
+
+	for _, client := range []string{"192.168.10.1", "192.168.10.2", "10.0.0.1"} {
+		w := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP(client), Port: 40000}}
+		r := new(dns.Msg)
+
+		r.SetQuestion("example.com.", dns.TypeA)
+		s.ServeDNS(w, r)
+		assert.Equal(t, []string{"1.1.1.1"}, answerIPs(w.msg))
+	}
+
+	// clients of the same subnet share cached responses.
+	assert.Equal(t, "192.168.10.0/24/0", (<-received).String())
+	assert.Equal(t, "10.0.0.0/24/0", (<-received).String())
+	assert.Empty(t, received)
+}
+
+func TestHandle_clientSubnetCacheViews(t *testing.T) {
+	var (
+		internal = &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        1,
+			SourceNetmask: 8,
+			Address:       net.ParseIP("10.0.0.0").To4(),
+		}
+		external = &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        1,
+			SourceNetmask: 24,
+			Address:       net.ParseIP("203.0.113.0").To4(),
+		}
+	)
+
+	// the recursor answers each view differently.
+	recursor := func(w dns.ResponseWriter, r *dns.Msg) {
+		option, _ := r.IsEdns0().Option[0].(*dns.EDNS0_SUBNET)
+		if option != nil && option.Address.Equal(internal.Address) {
+			answerHandler(0, "10.1.1.1")(w, r)
+			return
+		}
+
+		answerHandler(0, "1.1.1.1")(w, r)
+	}
+
+	s, err := NewSdns(SdnsConfig{
+		Port:            1053,
+		Recursors:       []string{startRecursor(t, recursor)},
+		EDNSPassthrough: []uint16{dns.EDNS0SUBNET},
+		Cache:           true,
+	})
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		subnet   *dns.EDNS0_SUBNET
+		expected string
+	}{
+		{internal, "10.1.1.1"},
+		{external, "1.1.1.1"},
+		{internal, "10.1.1.1"},
+	} {
+		w := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP("192.168.10.1"), Port: 40000}}
+		r := new(dns.Msg)
+
+		r.SetQuestion("example.com.", dns.TypeA)
+		r.SetEdns0(4096, false)
+		r.IsEdns0().Option = append(r.IsEdns0().Option, tc.subnet)
+
+		s.ServeDNS(w, r)
+		assert.Equal(t, []string{tc.expected}, answerIPs(w.msg))
+	}
+}
+
+func TestNewSdns_malformedClientSubnet(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{Port: 1232, ClientSubnetV4: 33})
+	assert.Error(t, err)
+
+	_, err = NewSdns(SdnsConfig{Port: 1232, ClientSubnetV6: 129})
+	assert.Error(t, err)
+}
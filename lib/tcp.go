@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// limitListener closes the connections accepted while
+// there are already `max` of them open.
+type limitListener struct {
+	net.Listener
+	max    int64
+	active int64
+}
+
+func (l *limitListener) Accept() (conn net.Conn, err error) {
+	for {
+		conn, err = l.Listener.Accept()
+		if err != nil {
+			return
+		}
+
+		if atomic.AddInt64(&l.active, 1) <= l.max {
+			conn = &limitConn{Conn: conn, listener: l}
+			return
+		}
+
+		atomic.AddInt64(&l.active, -1)
+		conn.Close()
+	}
+}
+
+// limitConn releases its slot in the listener once closed.
+type limitConn struct {
+	net.Conn
+	listener *limitListener
+	once     sync.Once
+}
+
+func (c *limitConn) Close() error {
+	c.once.Do(func() {
+		atomic.AddInt64(&c.listener.active, -1)
+	})
+
+	return c.Conn.Close()
+}
+
+// listenTCP creates the listener of a TCP (or TLS, if a
+// config is given) server, limited to the maximum number
+// of connections configured.
+func (s *Sdns) listenTCP(address string, tlsConfig *tls.Config) (listener net.Listener, err error) {
+	listener, err = net.Listen("tcp", address)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"couldn't listen on tcp address %s", address)
+		return
+	}
+
+	if s.tcpMaxConnections > 0 {
+		listener = &limitListener{
+			Listener: listener,
+			max:      int64(s.tcpMaxConnections),
+		}
+	}
+
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	return
+}
+
+// tcpIdleTimeout is the time a TCP connection is kept open
+// waiting for a query after the previous one. Nil makes the
+// default of miekg/dns be used.
+func (s *Sdns) tcpIdleTimeout() func() time.Duration {
+	if s.tcpIdle == 0 {
+		return nil
+	}
+
+	return func() time.Duration { return s.tcpIdle }
+}
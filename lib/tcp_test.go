@@ -0,0 +1,120 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// listen starts serving the configuration on a port picked
+// by the system, returning the address of the servers once
+// they're bound.
+func listen(t *testing.T, cfg SdnsConfig) string {
+	cfg.Address = "127.0.0.1"
+	cfg.Port = 0
+	cfg.Recursors = []string{"127.0.0.1:1"}
+
+	s, err := NewSdns(cfg)
+	assert.NoError(t, err)
+
+	go s.Listen()
+
+	select {
+	case <-s.Listening():
+	case <-time.After(time.Second):
+		t.Fatalf("server didn't start listening")
+	}
+
+	return s.Addr()
+}
+
+// exchangeTCP sends a question over the connection and
+// reads the response.
+func exchangeTCP(conn *dns.Conn, name string) (in *dns.Msg, err error) {
+	r := new(dns.Msg)
+	r.SetQuestion(dns.Fqdn(name), dns.TypeA)
+
+	err = conn.WriteMsg(r)
+	if err != nil {
+		return
+	}
+
+	in, err = conn.ReadMsg()
+	return
+}
+
+func TestListen_tcpIdleTimeout(t *testing.T) {
+	address := listen(t, SdnsConfig{
+		TCPIdleTimeout: 100 * time.Millisecond,
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+
+	conn, err := dns.Dial("tcp", address)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	in, err := exchangeTCP(conn, "foo.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(in))
+
+	time.Sleep(300 * time.Millisecond)
+
+	_, err = exchangeTCP(conn, "foo.com")
+	assert.Error(t, err)
+}
+
+func TestListen_tcpMaxConnections(t *testing.T) {
+	address := listen(t, SdnsConfig{
+		TCPMaxConnections: 1,
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+
+	// the connection used to wait for the server to be
+	// up takes a while to be released.
+	var (
+		first *dns.Conn
+		err   error
+	)
+
+	for i := 0; i < 50; i++ {
+		first, err = dns.Dial("tcp", address)
+		assert.NoError(t, err)
+
+		_, err = exchangeTCP(first, "foo.com")
+		if err == nil {
+			break
+		}
+
+		first.Close()
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.NoError(t, err)
+	defer first.Close()
+
+	second, err := dns.Dial("tcp", address)
+	assert.NoError(t, err)
+	defer second.Close()
+
+	_, err = exchangeTCP(second, "foo.com")
+	assert.Error(t, err)
+
+	// once the first goes away, there's room again.
+	first.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	third, err := dns.Dial("tcp", address)
+	assert.NoError(t, err)
+	defer third.Close()
+
+	in, err := exchangeTCP(third, "foo.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(in))
+}
@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// ThrottlePolicy determines how questions for domains that
+// went past their maximum rate (see Domain.MaxQPS) are
+// answered.
+type ThrottlePolicy string
+
+const (
+	// ThrottleRefused answers them REFUSED.
+	ThrottleRefused ThrottlePolicy = "refused"
+
+	// ThrottleServfail answers them SERVFAIL.
+	ThrottleServfail ThrottlePolicy = "servfail"
+)
+
+// validateThrottle makes sure that the policy is known,
+// accepting the empty one (i.e.: the default).
+func validateThrottle(policy ThrottlePolicy) (err error) {
+	switch policy {
+	case "", ThrottleRefused, ThrottleServfail:
+	default:
+		err = errors.Errorf("unknown throttle policy %s", policy)
+	}
+
+	return
+}
+
+// tokenBucket lets through up to rate takes per second,
+// holding up to burst of them.
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// take tells whether a token was available at now, taking
+// it if so.
+func (b *tokenBucket) take(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+
+	if b.last.IsZero() || now.After(b.last) {
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// idle tells whether the bucket would be full at now, in
+// which case it's no different from a new one.
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	missing := b.burst - b.tokens
+	return now.Sub(b.last).Seconds()*b.rate >= missing
+}
+
+// throttle answers questions for domains that went past
+// their maximum rate according to the throttle policy,
+// telling whether it did so. They're neither answered
+// locally nor recursed.
+func (s *Sdns) throttle(ctx *SdnsContext, m *dns.Msg) (throttled bool) {
+	if len(m.Question) == 0 {
+		return
+	}
+
+	domain, found := s.findDomain(strings.TrimRight(m.Question[0].Name, "."))
+	if !found {
+		return
+	}
+
+	bucket := domain.loaded().bucket
+	if bucket == nil || bucket.take(s.now()) {
+		return
+	}
+
+	m.Rcode = dns.RcodeRefused
+	if s.throttlePolicy == ThrottleServfail {
+		m.Rcode = dns.RcodeServerFailure
+	}
+
+	ctx.logger.Info().
+		Str("domain", domain.Name).
+		Int("max-qps", domain.MaxQPS).
+		Msg("domain throttled")
+
+	throttled = true
+	return
+}
@@ -0,0 +1,106 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_throttle(t *testing.T) {
+	var testCases = []struct {
+		policy ThrottlePolicy
+		rcode  int
+	}{
+		{"", dns.RcodeRefused},
+		{ThrottleRefused, dns.RcodeRefused},
+		{ThrottleServfail, dns.RcodeServerFailure},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			var now = time.Unix(1000, 0)
+
+			s, err := NewSdns(SdnsConfig{
+				Port:           1232,
+				Recursors:      []string{"127.0.0.1:1"},
+				ThrottlePolicy: tc.policy,
+				Clock:          func() time.Time { return now },
+				Domains: []*Domain{
+					{Name: "expensive.com", Addresses: []string{"1.1.1.1"}, MaxQPS: 5},
+					{Name: "cheap.com", Addresses: []string{"2.2.2.2"}},
+				},
+			})
+			assert.NoError(t, err)
+
+			// flooded, only the expensive domain is throttled
+			// once past its burst.
+			for i := 0; i < 10; i++ {
+				m := query(s, "expensive.com", dns.TypeA)
+				if i < 5 {
+					assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+					assert.Equal(t, []string{"1.1.1.1"}, answerIPs(m))
+				} else {
+					assert.Equal(t, tc.rcode, m.Rcode)
+					assert.Equal(t, []string{}, answerIPs(m))
+				}
+
+				m = query(s, "cheap.com", dns.TypeA)
+				assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+				assert.Equal(t, []string{"2.2.2.2"}, answerIPs(m))
+			}
+
+			// refilled at the rate.
+			now = now.Add(400 * time.Millisecond)
+
+			for i := 0; i < 3; i++ {
+				m := query(s, "expensive.com", dns.TypeA)
+				if i < 2 {
+					assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+				} else {
+					assert.Equal(t, tc.rcode, m.Rcode)
+				}
+			}
+		})
+	}
+}
+
+func TestHandle_throttleWildcard(t *testing.T) {
+	var now = time.Unix(1000, 0)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Clock:     func() time.Time { return now },
+		Domains: []*Domain{
+			{Name: "*.expensive.com", Addresses: []string{"1.1.1.1"}, MaxQPS: 1},
+		},
+	})
+	assert.NoError(t, err)
+
+	// names under a wildcard share its rate.
+	m := query(s, "a.expensive.com", dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+
+	m = query(s, "b.expensive.com", dns.TypeA)
+	assert.Equal(t, dns.RcodeRefused, m.Rcode)
+}
+
+func TestNewSdns_malformedThrottle(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{
+		Port:           1232,
+		ThrottlePolicy: "lol",
+	})
+	assert.Error(t, err)
+
+	_, err = NewSdns(SdnsConfig{
+		Port: 1232,
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}, MaxQPS: -1},
+		},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultTLSPort is the port DNS-over-TLS is served on
+// (RFC 7858).
+const DefaultTLSPort = 853
+
+// loadTLS loads the certificate used to serve DNS-over-TLS,
+// if configured.
+func (s *Sdns) loadTLS(cfg SdnsConfig) (err error) {
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		return
+	}
+
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		err = errors.Errorf(
+			"both a TLS certificate and key must be specified")
+		return
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"couldn't load TLS certificate %s and key %s",
+			cfg.TLSCertFile, cfg.TLSKeyFile)
+		return
+	}
+
+	port := cfg.TLSPort
+	if port == 0 {
+		port = DefaultTLSPort
+	}
+
+	s.tlsAddress = fmt.Sprintf("%s:%d", cfg.Address, port)
+	s.tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	return
+}
@@ -0,0 +1,172 @@
+package lib_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// writeCertificate writes a self-signed certificate for
+// 127.0.0.1 and its key to a temporary directory, returning
+// their paths.
+func writeCertificate(t *testing.T) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sdns"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader,
+		template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(
+		&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	return
+}
+
+// freePort finds a port that's free both for TCP and UDP.
+func freePort(t *testing.T) int {
+	for {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+
+		port := listener.Addr().(*net.TCPAddr).Port
+		conn, err := net.ListenPacket("udp", "127.0.0.1:"+strconv.Itoa(port))
+
+		listener.Close()
+		if err == nil {
+			conn.Close()
+			return port
+		}
+	}
+}
+
+func TestNewSdns_tls(t *testing.T) {
+	certFile, keyFile := writeCertificate(t)
+
+	var testCases = []struct {
+		name        string
+		certFile    string
+		keyFile     string
+		shouldError bool
+	}{
+		{
+			name: "no tls",
+		},
+		{
+			name:     "valid pair",
+			certFile: certFile,
+			keyFile:  keyFile,
+		},
+		{
+			name:        "missing key",
+			certFile:    certFile,
+			shouldError: true,
+		},
+		{
+			name:        "inexistent files",
+			certFile:    "/inexistent/cert.pem",
+			keyFile:     "/inexistent/key.pem",
+			shouldError: true,
+		},
+		{
+			name:        "mismatched files",
+			certFile:    keyFile,
+			keyFile:     certFile,
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port:        1053,
+				Recursors:   []string{"127.0.0.1:1"},
+				TLSCertFile: tc.certFile,
+				TLSKeyFile:  tc.keyFile,
+			})
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestListen_tls(t *testing.T) {
+	var (
+		certFile, keyFile = writeCertificate(t)
+		tlsPort           = freePort(t)
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Address:     "127.0.0.1",
+		Port:        freePort(t),
+		Recursors:   []string{"127.0.0.1:1"},
+		TLSCertFile: certFile,
+		TLSKeyFile:  keyFile,
+		TLSPort:     tlsPort,
+		Domains: []*Domain{
+			{Name: "foo.com", Addresses: []string{"1.1.1.1"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	go s.Listen()
+
+	var (
+		client = &dns.Client{
+			Net:       "tcp-tls",
+			TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+		r  = new(dns.Msg)
+		in *dns.Msg
+	)
+
+	r.SetQuestion("foo.com.", dns.TypeA)
+	for i := 0; i < 50; i++ {
+		in, _, err = client.Exchange(r, "127.0.0.1:"+strconv.Itoa(tlsPort))
+		if err == nil {
+			break
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(in))
+}
@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TraceOptionCode is the EDNS0 local option that clients
+// (e.g.: `dig +ednsopt=65400`) send to get the recursion
+// trace back in the additional section when sdns runs in
+// debug mode.
+const TraceOptionCode uint16 = 65400
+
+// recursionAttempt describes an exchange with a recursor.
+type recursionAttempt struct {
+	server string
+	rtt    time.Duration
+	err    error
+}
+
+func (a recursionAttempt) String() string {
+	result := "ok"
+	if a.err != nil {
+		result = "error"
+	}
+
+	return fmt.Sprintf("server=%s rtt=%s result=%s",
+		a.server, a.rtt, result)
+}
+
+// recursionTrace keeps track of the recursors tried while
+// answering a question.
+type recursionTrace struct {
+	sync.Mutex
+	attempts []recursionAttempt
+	answered string
+}
+
+func (t *recursionTrace) attempt(server string, rtt time.Duration, err error) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.attempts = append(t.attempts, recursionAttempt{
+		server: server,
+		rtt:    rtt,
+		err:    err,
+	})
+}
+
+func (t *recursionTrace) answer(server string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.answered = server
+}
+
+// lines renders the trace, one line per attempt followed
+// by the recursor that answered (if any).
+func (t *recursionTrace) lines() (lines []string) {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, attempt := range t.attempts {
+		lines = append(lines, attempt.String())
+	}
+
+	if t.answered != "" {
+		lines = append(lines, "answered="+t.answered)
+	}
+
+	return
+}
+
+// reportTrace logs the recursion trace and, in debug mode,
+// puts it in the additional section of the response if the
+// client asked for it.
+func (s *Sdns) reportTrace(ctx *SdnsContext, r *dns.Msg, m *dns.Msg) {
+	lines := ctx.trace.lines()
+	if len(lines) == 0 {
+		return
+	}
+
+	ctx.logger.Info().
+		Strs("trace", lines).
+		Msg("recursion trace")
+
+	if !s.debug {
+		return
+	}
+
+	_, requested := requestedOption(r, TraceOptionCode)
+	if !requested {
+		return
+	}
+
+	m.Extra = append(m.Extra, &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   m.Question[0].Name,
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+		},
+		Txt: lines,
+	})
+}
@@ -0,0 +1,92 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// traceQuery sends a question asking for the recursion
+// trace and returns the trace lines sent back.
+func traceQuery(s *Sdns, name string) (lines []string) {
+	var (
+		w = &testWriter{}
+		r = new(dns.Msg)
+	)
+
+	r.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	r.SetEdns0(4096, false)
+	opt := r.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{
+		Code: TraceOptionCode,
+	})
+
+	s.ServeDNS(w, r)
+
+	for _, rr := range w.msg.Extra {
+		if txt, ok := rr.(*dns.TXT); ok {
+			lines = append(lines, txt.Txt...)
+		}
+	}
+
+	return
+}
+
+func TestHandle_recursionTrace(t *testing.T) {
+	var (
+		dead  = "127.0.0.1:1"
+		alive = startRecursor(t, answerHandler(0, "1.1.1.1"))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Debug:     true,
+		Recursors: []string{dead, alive},
+	})
+	assert.NoError(t, err)
+
+	lines := traceQuery(s, "example.com")
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "server="+dead)
+	assert.Contains(t, lines[0], "result=error")
+	assert.Contains(t, lines[1], "server="+alive)
+	assert.Contains(t, lines[1], "result=ok")
+	assert.Equal(t, "answered="+alive, lines[2])
+}
+
+func TestHandle_recursionTraceOnlyInDebug(t *testing.T) {
+	alive := startRecursor(t, answerHandler(0, "1.1.1.1"))
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Debug:     false,
+		Recursors: []string{alive},
+	})
+	assert.NoError(t, err)
+
+	assert.Empty(t, traceQuery(s, "example.com"))
+}
+
+func TestHandle_recursionTraceParallel(t *testing.T) {
+	var (
+		fewer = startRecursor(t, answerHandler(0, "1.1.1.1"))
+		more  = startRecursor(t, answerHandler(50*time.Millisecond, "2.2.2.2", "3.3.3.3"))
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:              1232,
+		Debug:             true,
+		Recursors:         []string{fewer, more},
+		ParallelRecursion: true,
+		ParallelPolicy:    PolicyMostAnswers,
+	})
+	assert.NoError(t, err)
+
+	lines := traceQuery(s, "example.com")
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "answered="+more, lines[2])
+}
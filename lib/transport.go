@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// TransportPolicy determines which transports (UDP or TCP,
+// the latter including DoT and DoH) questions are answered
+// over.
+type TransportPolicy string
+
+const (
+	// TransportAny answers over any transport.
+	TransportAny TransportPolicy = "any"
+
+	// TransportTCP answers UDP questions truncated (with
+	// the TC bit set and no records) so that clients retry
+	// over TCP.
+	TransportTCP TransportPolicy = "tcp"
+
+	// TransportTCPOnly refuses UDP questions.
+	TransportTCPOnly TransportPolicy = "tcp-only"
+
+	// TransportUDPOnly refuses questions over TCP.
+	TransportUDPOnly TransportPolicy = "udp-only"
+)
+
+// validateTransport makes sure that the policy is known,
+// accepting the empty one (i.e.: the default).
+func validateTransport(policy TransportPolicy) (err error) {
+	switch policy {
+	case "", TransportAny, TransportTCP, TransportTCPOnly, TransportUDPOnly:
+	default:
+		err = errors.Errorf("unknown transport policy %s", policy)
+	}
+
+	return
+}
+
+// transport tells the transport policy of a name: the one
+// of the domain it belongs to, if set, or the global one.
+func (s *Sdns) transport(name string) TransportPolicy {
+	domain, found := s.findDomain(strings.TrimRight(name, "."))
+	if found && domain.Transport != "" {
+		return domain.Transport
+	}
+
+	return s.transportPolicy
+}
+
+// enforceTransport answers questions that came over a
+// transport that their transport policy doesn't allow,
+// telling whether it did so. They're neither answered
+// locally nor recursed.
+func (s *Sdns) enforceTransport(ctx *SdnsContext, w dns.ResponseWriter, m *dns.Msg) (enforced bool) {
+	if len(m.Question) == 0 {
+		return
+	}
+
+	var (
+		udp    = isUDP(w)
+		policy = s.transport(m.Question[0].Name)
+	)
+
+	switch {
+	case policy == TransportTCP && udp:
+		m.Truncated = true
+	case policy == TransportTCPOnly && udp,
+		policy == TransportUDPOnly && !udp:
+		m.Rcode = dns.RcodeRefused
+	default:
+		return
+	}
+
+	ctx.logger.Info().
+		Str("policy", string(policy)).
+		Bool("udp", udp).
+		Msg("transport not allowed")
+
+	enforced = true
+	return
+}
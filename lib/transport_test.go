@@ -0,0 +1,84 @@
+package lib_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_transport(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Transport: TransportTCP,
+		Domains: []*Domain{
+			{Name: "tcp.com", Addresses: []string{"1.1.1.1"}},
+			{Name: "tcp-only.com", Addresses: []string{"1.1.1.1"}, Transport: TransportTCPOnly},
+			{Name: "udp-only.com", Addresses: []string{"1.1.1.1"}, Transport: TransportUDPOnly},
+			{Name: "any.com", Addresses: []string{"1.1.1.1"}, Transport: TransportAny},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name      string
+		tcp       bool
+		rcode     int
+		truncated bool
+		answers   []string
+	}{
+		{name: "tcp.com", tcp: false, truncated: true, answers: []string{}},
+		{name: "tcp.com", tcp: true, answers: []string{"1.1.1.1"}},
+		{name: "tcp-only.com", tcp: false, rcode: dns.RcodeRefused, answers: []string{}},
+		{name: "tcp-only.com", tcp: true, answers: []string{"1.1.1.1"}},
+		{name: "udp-only.com", tcp: false, answers: []string{"1.1.1.1"}},
+		{name: "udp-only.com", tcp: true, rcode: dns.RcodeRefused, answers: []string{}},
+		{name: "any.com", tcp: false, answers: []string{"1.1.1.1"}},
+		{name: "any.com", tcp: true, answers: []string{"1.1.1.1"}},
+	}
+
+	for _, tc := range testCases {
+		transport := "udp"
+		if tc.tcp {
+			transport = "tcp"
+		}
+
+		t.Run(tc.name+" over "+transport, func(t *testing.T) {
+			var (
+				w = &testWriter{}
+				r = new(dns.Msg)
+			)
+
+			if tc.tcp {
+				w.remote = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+			}
+
+			r.SetQuestion(dns.Fqdn(tc.name), dns.TypeA)
+			s.ServeDNS(w, r)
+
+			assert.Equal(t, tc.rcode, w.msg.Rcode)
+			assert.Equal(t, tc.truncated, w.msg.Truncated)
+			assert.Equal(t, tc.answers, answerIPs(w.msg))
+		})
+	}
+}
+
+func TestNewSdns_malformedTransport(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Transport: "lol",
+	})
+	assert.Error(t, err)
+
+	_, err = NewSdns(SdnsConfig{
+		Port: 1232,
+		Domains: []*Domain{
+			{Name: "foo.com", Transport: "lol"},
+		},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// isUDP tells whether the response is going to be written
+// to an UDP client.
+func isUDP(w dns.ResponseWriter) bool {
+	_, ok := w.RemoteAddr().(*net.UDPAddr)
+	return ok
+}
+
+// forceTruncate truncates UDP responses for domains that
+// are configured to always be truncated.
+func (s *Sdns) forceTruncate(w dns.ResponseWriter, m *dns.Msg) {
+	if len(m.Question) == 0 || !isUDP(w) {
+		return
+	}
+
+	domain, found := s.findDomain(
+		strings.TrimRight(m.Question[0].Name, "."))
+	if !found || !domain.ForceTruncate {
+		return
+	}
+
+	m.Truncated = true
+	m.Answer = nil
+}
+
+// maxUDPSize is the largest UDP response the client that
+// sent the request can take: the size advertised via EDNS
+// or 512 bytes otherwise.
+func maxUDPSize(r *dns.Msg) int {
+	opt := r.IsEdns0()
+	if opt == nil || opt.UDPSize() < dns.MinMsgSize {
+		return dns.MinMsgSize
+	}
+
+	return int(opt.UDPSize())
+}
+
+// truncateToFit drops records from UDP responses that don't
+// fit the size the client can take. Additional records (but
+// OPT) go first and, if that's not enough, authority and
+// answer ones, in which case the TC bit is set so that the
+// client retries over TCP.
+func truncateToFit(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg) {
+	if !isUDP(w) {
+		return
+	}
+
+	var size = maxUDPSize(r)
+	if m.Len() <= size {
+		return
+	}
+
+	m.Extra = onlyType(m.Extra, dns.TypeOPT)
+	if m.Len() <= size {
+		return
+	}
+
+	m.Truncated = true
+	for _, section := range []*[]dns.RR{&m.Ns, &m.Answer} {
+		for len(*section) > 0 && m.Len() > size {
+			*section = (*section)[:len(*section)-1]
+		}
+	}
+}
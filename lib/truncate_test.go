@@ -0,0 +1,147 @@
+package lib_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_forceTruncate(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{
+				Name:          "truncated.com",
+				Addresses:     []string{"1.1.1.1"},
+				ForceTruncate: true,
+			},
+			{
+				Name:      "regular.com",
+				Addresses: []string{"1.1.1.1"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name      string
+		remote    net.Addr
+		truncated bool
+	}{
+		{
+			name:      "truncated.com",
+			remote:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+			truncated: true,
+		},
+		{
+			name:      "truncated.com",
+			remote:    &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)},
+			truncated: false,
+		},
+		{
+			name:      "regular.com",
+			remote:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+			truncated: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name+" "+tc.remote.Network(), func(t *testing.T) {
+			var (
+				w = &testWriter{remote: tc.remote}
+				r = new(dns.Msg)
+			)
+
+			r.SetQuestion(dns.Fqdn(tc.name), dns.TypeA)
+			s.ServeDNS(w, r)
+
+			assert.Equal(t, tc.truncated, w.msg.Truncated)
+			if tc.truncated {
+				assert.Empty(t, w.msg.Answer)
+				return
+			}
+
+			assert.Equal(t, []string{"1.1.1.1"}, answerIPs(w.msg))
+		})
+	}
+}
+
+func TestHandle_truncateToFit(t *testing.T) {
+	var texts []string
+	for i := 1; i <= 40; i++ {
+		texts = append(texts, fmt.Sprintf("text-%02d", i))
+	}
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{Name: "big.com", Texts: texts},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name      string
+		remote    net.Addr
+		udpSize   uint16
+		truncated bool
+	}{
+		{
+			name:      "udp",
+			remote:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+			truncated: true,
+		},
+		{
+			name:      "udp with small edns size",
+			remote:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+			udpSize:   600,
+			truncated: true,
+		},
+		{
+			name:      "udp with large edns size",
+			remote:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+			udpSize:   4096,
+			truncated: false,
+		},
+		{
+			name:      "tcp",
+			remote:    &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)},
+			truncated: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				w    = &testWriter{remote: tc.remote}
+				r    = new(dns.Msg)
+				size = dns.MinMsgSize
+			)
+
+			r.SetQuestion("big.com.", dns.TypeTXT)
+			if tc.udpSize != 0 {
+				r.SetEdns0(tc.udpSize, false)
+				size = int(tc.udpSize)
+			}
+
+			s.ServeDNS(w, r)
+
+			assert.Equal(t, tc.truncated, w.msg.Truncated)
+			if !tc.truncated {
+				assert.Len(t, w.msg.Answer, len(texts))
+				return
+			}
+
+			assert.NotEmpty(t, w.msg.Answer)
+			assert.Less(t, len(w.msg.Answer), len(texts))
+			assert.LessOrEqual(t, w.msg.Len(), size)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultCutoverTTL is the TTL that domains ramp down to as
+// their cutover approaches when they don't set one.
+const DefaultCutoverTTL = 10
+
+// answerTTL tells the TTL of the records answered for a
+// domain, which can override the global one and ramp it
+// down towards its cutover.
+func (s *Sdns) answerTTL(domain *Domain) (ttl uint32) {
+	ttl = s.ttl
+	if domain.TTL != 0 {
+		ttl = domain.TTL
+	}
+
+	if !domain.Cutover.IsZero() {
+		ttl = cutoverTTL(domain, ttl, s.now())
+	}
+
+	return
+}
+
+// cutoverTTL ramps the TTL down linearly from its value,
+// CutoverRamp ahead of the cutover of the domain (defaults
+// to the TTL itself, so that records cached before the ramp
+// expire by the cutover), to CutoverTTL at the cutover,
+// where it stays. TTLs already below it are kept.
+func cutoverTTL(domain *Domain, ttl uint32, now time.Time) uint32 {
+	var (
+		floor     = domain.CutoverTTL
+		ramp      = domain.CutoverRamp
+		remaining = domain.Cutover.Sub(now)
+	)
+
+	if floor == 0 {
+		floor = DefaultCutoverTTL
+	}
+
+	if ramp == 0 {
+		ramp = time.Duration(ttl) * time.Second
+	}
+
+	switch {
+	case ttl <= floor, remaining >= ramp:
+		return ttl
+	case remaining <= 0:
+		return floor
+	}
+
+	return floor + uint32(float64(ttl-floor)*remaining.Seconds()/ramp.Seconds())
+}
+
+// jitterTTLs spreads the TTLs of a locally answered response
+// by a random offset of up to ±ttlJitter so that clients
+// caching the same records don't all expire at once.
+// A single offset is used for the whole response so that
+// the records of an RRset keep sharing the same TTL.
+func (s *Sdns) jitterTTLs(m *dns.Msg) {
+	if s.ttlJitter <= 0 {
+		return
+	}
+
+	factor := 1 + (rand.Float64()*2-1)*s.ttlJitter
+
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			// the TTL of an OPT carries the extended
+			// rcode and flags instead.
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+
+			rr.Header().Ttl = jitterTTL(rr.Header().Ttl, factor)
+		}
+	}
+}
+
+// jitterTTL scales a TTL by a factor, keeping it within the
+// bounds of a TTL.
+func jitterTTL(ttl uint32, factor float64) uint32 {
+	scaled := math.Round(float64(ttl) * factor)
+	if scaled > math.MaxUint32 {
+		return math.MaxUint32
+	}
+
+	return uint32(scaled)
+}
@@ -0,0 +1,209 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_ttl(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		TTL:       300,
+		Domains: []*Domain{
+			{Name: "default.com", Addresses: []string{"1.1.1.1"}},
+			{Name: "short.com", Addresses: []string{"2.2.2.2"}, TTL: 30},
+			{Name: "alias.com", Cname: "short.com", TTL: 60},
+			{Name: "ns.com", Nameservers: []string{"ns1.ns.com"}, TTL: 120},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name     string
+		qtype    uint16
+		expected []string
+	}{
+		{
+			name:     "default.com",
+			qtype:    dns.TypeA,
+			expected: []string{"default.com.\t300\tIN\tA\t1.1.1.1"},
+		},
+		{
+			name:     "short.com",
+			qtype:    dns.TypeA,
+			expected: []string{"short.com.\t30\tIN\tA\t2.2.2.2"},
+		},
+		{
+			name:  "alias.com",
+			qtype: dns.TypeA,
+			expected: []string{
+				"alias.com.\t60\tIN\tCNAME\tshort.com.",
+				"short.com.\t30\tIN\tA\t2.2.2.2",
+			},
+		},
+		{
+			name:     "ns.com",
+			qtype:    dns.TypeNS,
+			expected: []string{"ns.com.\t120\tIN\tNS\tns1.ns.com."},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := query(s, tc.name, tc.qtype)
+			assert.Equal(t, tc.expected, answerStrings(m))
+		})
+	}
+}
+
+func TestHandle_ttlJitter(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		jitter   float64
+		min, max uint32
+		varies   bool
+	}{
+		{"no jitter", 0, 3600, 3600, false},
+		{"ten percent", 0.1, 3240, 3960, true},
+		{"half", 0.5, 1800, 5400, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSdns(SdnsConfig{
+				Port:      1232,
+				Recursors: []string{"127.0.0.1:1"},
+				TTLJitter: tc.jitter,
+				Domains: []*Domain{
+					{Name: "foo.com", Texts: []string{"a", "b"}},
+				},
+			})
+			assert.NoError(t, err)
+
+			ttls := map[uint32]bool{}
+			for i := 0; i < 50; i++ {
+				m := query(s, "foo.com", dns.TypeTXT)
+				assert.Len(t, m.Answer, 2)
+
+				ttl := m.Answer[0].Header().Ttl
+				assert.Equal(t, ttl, m.Answer[1].Header().Ttl,
+					"records of an RRset must share the TTL")
+				assert.True(t, ttl >= tc.min && ttl <= tc.max,
+					"ttl %d out of [%d, %d]", ttl, tc.min, tc.max)
+
+				ttls[ttl] = true
+			}
+
+			assert.Equal(t, tc.varies, len(ttls) > 1)
+		})
+	}
+}
+
+func TestNewSdns_malformedTTLJitter(t *testing.T) {
+	for _, jitter := range []float64{-0.1, 1.5} {
+		_, err := NewSdns(SdnsConfig{
+			Port:      1232,
+			Recursors: []string{"127.0.0.1:1"},
+			TTLJitter: jitter,
+		})
+		assert.Error(t, err)
+	}
+}
+
+func TestHandle_cutoverTTL(t *testing.T) {
+	var (
+		cutover = time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+		now     time.Time
+	)
+
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		TTL:       300,
+		Clock:     func() time.Time { return now },
+		Domains: []*Domain{
+			{
+				Name:        "ramp.com",
+				Addresses:   []string{"1.1.1.1"},
+				TTL:         3600,
+				Cutover:     cutover,
+				CutoverRamp: time.Hour,
+				CutoverTTL:  60,
+			},
+			{
+				Name:      "default.com",
+				Addresses: []string{"2.2.2.2"},
+				Cutover:   cutover,
+			},
+			{
+				Name:       "short.com",
+				Addresses:  []string{"3.3.3.3"},
+				TTL:        5,
+				Cutover:    cutover,
+				CutoverTTL: 30,
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name   string
+		before time.Duration
+		ttl    uint32
+	}{
+		{"ramp.com", 2 * time.Hour, 3600},
+		{"ramp.com", time.Hour, 3600},
+		{"ramp.com", 30 * time.Minute, 1830},
+		{"ramp.com", 15 * time.Minute, 945},
+		{"ramp.com", time.Minute, 119},
+		{"ramp.com", 0, 60},
+		{"ramp.com", -time.Hour, 60},
+
+		// ramps down over the TTL to the default floor.
+		{"default.com", 10 * time.Minute, 300},
+		{"default.com", 5 * time.Minute, 300},
+		{"default.com", 150 * time.Second, 155},
+		{"default.com", 0, DefaultCutoverTTL},
+
+		// already below the floor.
+		{"short.com", time.Second, 5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name+" "+tc.before.String(), func(t *testing.T) {
+			now = cutover.Add(-tc.before)
+
+			m := query(s, tc.name, dns.TypeA)
+			assert.Len(t, m.Answer, 1)
+			assert.Equal(t, tc.ttl, m.Answer[0].Header().Ttl)
+		})
+	}
+}
+
+func TestLoad_malformedCutover(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		domain *Domain
+	}{
+		{"ramp without cutover", &Domain{Name: "a.com", CutoverRamp: time.Hour}},
+		{"ttl without cutover", &Domain{Name: "a.com", CutoverTTL: 30}},
+		{"negative ramp", &Domain{Name: "a.com", Cutover: time.Now(), CutoverRamp: -time.Hour}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port:      1232,
+				Recursors: []string{"127.0.0.1:1"},
+				Domains:   []*Domain{tc.domain},
+			})
+			assert.Error(t, err)
+		})
+	}
+}
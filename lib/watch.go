@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// DefaultWatchDebounce is how long watched files have to be
+// left alone after changing before the configuration gets
+// reloaded, so that partial writes aren't loaded.
+const DefaultWatchDebounce = 500 * time.Millisecond
+
+// watchedFiles lists the files that the configuration is
+// loaded from.
+func watchedFiles(cfg SdnsConfig) (files []string, err error) {
+	for _, file := range []string{cfg.ConfigFile, cfg.ZoneFile, cfg.HostsFile} {
+		if file == "" {
+			continue
+		}
+
+		file, err = filepath.Abs(file)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"couldn't watch file %s", file)
+			return
+		}
+
+		files = append(files, file)
+	}
+
+	return
+}
+
+// newWatcher watches the directories of the watched files
+// rather than the files themselves, which stop being
+// watched once replaced (e.g.: renamed over or, in the case
+// of a Kubernetes ConfigMap, swapped through a symlink).
+func (s *Sdns) newWatcher() (watcher *fsnotify.Watcher, err error) {
+	watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		err = errors.Wrapf(err,
+			"couldn't create file watcher")
+		return
+	}
+
+	for _, file := range s.watched {
+		err = watcher.Add(filepath.Dir(file))
+		if err != nil {
+			watcher.Close()
+			err = errors.Wrapf(err,
+				"couldn't watch file %s", file)
+			return
+		}
+	}
+
+	return
+}
+
+// watchFiles reloads the configuration (see reload) once
+// the watched files change and settle for the debounce
+// period, until done is closed.
+func (s *Sdns) watchFiles(watcher *fsnotify.Watcher, done <-chan struct{}) {
+	defer watcher.Close()
+
+	var (
+		timer   = time.NewTimer(s.watchDebounce)
+		targets = make(map[string]string, len(s.watched))
+	)
+
+	timer.Stop()
+	defer timer.Stop()
+
+	for _, file := range s.watched {
+		targets[file], _ = filepath.EvalSymlinks(file)
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			if event.Op == fsnotify.Chmod || !changed(event, targets) {
+				continue
+			}
+
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(s.watchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+
+			s.logger.Error().
+				Err(err).
+				Msg("errored watching files")
+		case <-timer.C:
+			s.logger.Info().
+				Strs("files", s.watched).
+				Msg("watched files changed")
+			s.reload(s.watchSource)
+		}
+	}
+}
+
+// changed tells whether the event changed one of the files,
+// either directly or by making its path resolve to another
+// file, keeping track of where the paths resolve to.
+func changed(event fsnotify.Event, targets map[string]string) (changed bool) {
+	for file, target := range targets {
+		if filepath.Clean(event.Name) == file {
+			changed = true
+		}
+
+		current, _ := filepath.EvalSymlinks(file)
+		if current != target {
+			targets[file] = current
+			changed = true
+		}
+	}
+
+	return
+}
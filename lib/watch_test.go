@@ -0,0 +1,115 @@
+package lib_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+// awaitAnswer queries sdns until the name resolves to the
+// addresses or a second goes by.
+func awaitAnswer(s *Sdns, name string, ips []string) (answered []string) {
+	for i := 0; i < 50; i++ {
+		answered = answerIPs(query(s, name, dns.TypeA))
+		if assert.ObjectsAreEqual(ips, answered) {
+			return
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	return
+}
+
+func TestListen_watchFiles(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		path = filepath.Join(dir, "hosts")
+	)
+
+	writeHostsFile(t, path, "1.1.1.1 foo.com\n")
+
+	s, err := NewSdns(SdnsConfig{
+		Address:       "127.0.0.1",
+		Recursors:     []string{"127.0.0.1:1"},
+		HostsFile:     path,
+		WatchFiles:    true,
+		WatchDebounce: 20 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+
+	go s.Listen()
+
+	select {
+	case <-s.Listening():
+	case <-time.After(time.Second):
+		t.Fatalf("server didn't start listening")
+	}
+
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(query(s, "foo.com", dns.TypeA)))
+
+	// written in place
+	writeHostsFile(t, path, "2.2.2.2 foo.com\n")
+	assert.Equal(t, []string{"2.2.2.2"}, awaitAnswer(s, "foo.com", []string{"2.2.2.2"}))
+
+	// replaced by renaming another file over it
+	replacement := filepath.Join(dir, "hosts.new")
+	writeHostsFile(t, replacement, "3.3.3.3 foo.com\n")
+	assert.NoError(t, os.Rename(replacement, path))
+	assert.Equal(t, []string{"3.3.3.3"}, awaitAnswer(s, "foo.com", []string{"3.3.3.3"}))
+
+	// a broken file keeps the last good configuration
+	writeHostsFile(t, path, "lol foo.com\n")
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, []string{"3.3.3.3"}, answerIPs(query(s, "foo.com", dns.TypeA)))
+
+	// and gets picked up once fixed
+	writeHostsFile(t, path, "4.4.4.4 foo.com\n")
+	assert.Equal(t, []string{"4.4.4.4"}, awaitAnswer(s, "foo.com", []string{"4.4.4.4"}))
+}
+
+func TestListen_watchSymlinkedFiles(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		path = filepath.Join(dir, "hosts")
+	)
+
+	// laid out like a mounted ConfigMap: the file points
+	// through a symlink that gets swapped on updates.
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "v1"), 0755))
+	writeHostsFile(t, filepath.Join(dir, "v1", "hosts"), "1.1.1.1 foo.com\n")
+	assert.NoError(t, os.Symlink("v1", filepath.Join(dir, "..data")))
+	assert.NoError(t, os.Symlink(filepath.Join("..data", "hosts"), path))
+
+	s, err := NewSdns(SdnsConfig{
+		Address:       "127.0.0.1",
+		Recursors:     []string{"127.0.0.1:1"},
+		HostsFile:     path,
+		WatchFiles:    true,
+		WatchDebounce: 20 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+
+	go s.Listen()
+
+	select {
+	case <-s.Listening():
+	case <-time.After(time.Second):
+		t.Fatalf("server didn't start listening")
+	}
+
+	assert.Equal(t, []string{"1.1.1.1"}, answerIPs(query(s, "foo.com", dns.TypeA)))
+
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "v2"), 0755))
+	writeHostsFile(t, filepath.Join(dir, "v2", "hosts"), "2.2.2.2 foo.com\n")
+	assert.NoError(t, os.Symlink("v2", filepath.Join(dir, "..data_tmp")))
+	assert.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+
+	assert.Equal(t, []string{"2.2.2.2"}, awaitAnswer(s, "foo.com", []string{"2.2.2.2"}))
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// addressWeightKey introduces the weight of an address in
+// the entries of Domain.Addresses (e.g.: '10.0.0.1;weight=80').
+const addressWeightKey = ";weight="
+
+// parseAddress splits an entry of Domain.Addresses into the
+// address, which can't be empty, and its weight, which
+// defaults to 1.
+func parseAddress(entry string) (address string, weight uint32, err error) {
+	idx := strings.Index(entry, addressWeightKey)
+	if idx < 0 {
+		idx = len(entry)
+		weight = 1
+	}
+
+	address = entry[:idx]
+	if strings.TrimSpace(address) == "" {
+		err = errors.Errorf("empty address in entry '%s'", entry)
+		return
+	}
+
+	if idx == len(entry) {
+		return
+	}
+
+	value, err := strconv.ParseUint(entry[idx+len(addressWeightKey):], 10, 32)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"malformed weight of address %s", entry)
+		return
+	}
+
+	if value == 0 {
+		err = errors.Errorf("weight of address %s must be positive", entry)
+		return
+	}
+
+	weight = uint32(value)
+	return
+}
+
+// addressOf strips the weight, if any, off an entry of
+// Domain.Addresses.
+func addressOf(entry string) string {
+	address, _, _ := parseAddress(entry)
+	return address
+}
+
+// validateAddresses makes sure that addresses are either
+// IPs or hostnames (see validHostname) and that the weights
+// given to them are well formed.
+func validateAddresses(entries []string) (err error) {
+	var address string
+
+	for _, entry := range entries {
+		address, _, err = parseAddress(entry)
+		if err != nil {
+			return
+		}
+
+		if net.ParseIP(address) == nil && !validHostname(address) {
+			err = errors.Errorf(
+				"malformed address %s - neither an ip nor a hostname",
+				address)
+			return
+		}
+	}
+
+	return
+}
+
+// weighted tells whether any of the entries carries a
+// weight, in which case addresses are picked in proportion
+// to their weights instead of in turns.
+func weighted(entries []string) bool {
+	for _, entry := range entries {
+		if strings.Contains(entry, addressWeightKey) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pickWeighted picks one of the addresses with probability
+// proportional to its weight.
+func (d *Domain) pickWeighted(addresses []string, weights []uint32) string {
+	var total uint64
+	for _, weight := range weights {
+		total += uint64(weight)
+	}
+
+	if total == 0 {
+		return ""
+	}
+
+	target := uint64(d.int63n(int64(total)))
+	for idx, weight := range weights {
+		if target < uint64(weight) {
+			return addresses[idx]
+		}
+		target -= uint64(weight)
+	}
+
+	return ""
+}
@@ -0,0 +1,149 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestDomain_weightedAddresses(t *testing.T) {
+	const picks = 10000
+
+	var testCases = []struct {
+		name      string
+		addresses []string
+		expected  map[string]float64
+	}{
+		{
+			name:      "weighted",
+			addresses: []string{"10.0.0.1;weight=80", "10.0.0.2;weight=20"},
+			expected:  map[string]float64{"10.0.0.1": 0.8, "10.0.0.2": 0.2},
+		},
+		{
+			name:      "partially weighted",
+			addresses: []string{"10.0.0.1;weight=3", "10.0.0.2"},
+			expected:  map[string]float64{"10.0.0.1": 0.75, "10.0.0.2": 0.25},
+		},
+		{
+			name:      "unweighted",
+			addresses: []string{"10.0.0.1", "10.0.0.2"},
+			expected:  map[string]float64{"10.0.0.1": 0.5, "10.0.0.2": 0.5},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSdns(SdnsConfig{
+				Port:      1232,
+				Recursors: []string{"127.0.0.1:1"},
+				Domains: []*Domain{
+					{Name: "foo.com", Addresses: tc.addresses},
+				},
+			})
+			assert.NoError(t, err)
+
+			domain, found := s.FindDomainFromName("foo.com")
+			assert.True(t, found)
+
+			counts := map[string]int{}
+			for i := 0; i < picks; i++ {
+				counts[domain.GetAddress()]++
+			}
+
+			assert.Len(t, counts, len(tc.expected))
+			for address, share := range tc.expected {
+				assert.InDelta(t, share, float64(counts[address])/picks, 0.03,
+					"share of %s", address)
+			}
+		})
+	}
+}
+
+func TestDomain_seededWeightedAddresses(t *testing.T) {
+	picks := func(seed int64) (picked []string) {
+		domain := &Domain{
+			Name:      "foo.com",
+			Addresses: []string{"10.0.0.1;weight=50", "10.0.0.2;weight=50"},
+			Seed:      seed,
+		}
+
+		_, err := NewSdns(SdnsConfig{
+			Port:      1232,
+			Recursors: []string{"127.0.0.1:1"},
+			Domains:   []*Domain{domain},
+		})
+		assert.NoError(t, err)
+
+		for i := 0; i < 20; i++ {
+			picked = append(picked, domain.GetAddress())
+		}
+		return
+	}
+
+	assert.Equal(t, picks(42), picks(42))
+	assert.NotEqual(t, picks(42), picks(43))
+}
+
+func TestHandle_weightedAddresses(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Domains: []*Domain{
+			{
+				Name:      "foo.com",
+				Addresses: []string{"10.0.0.1;weight=1000000", "::1;weight=5"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	// weights never make it into the records answered.
+	m := query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"10.0.0.1"}, answerIPs(m))
+
+	m = query(s, "foo.com", dns.TypeAAAA)
+	assert.Equal(t, []string{"foo.com.\t3600\tIN\tAAAA\t::1"}, answerStrings(m))
+
+	m = query(s, "1.0.0.10.in-addr.arpa", dns.TypePTR)
+	assert.Equal(t, []string{
+		"1.0.0.10.in-addr.arpa.\t3600\tIN\tPTR\tfoo.com.",
+	}, answerStrings(m))
+}
+
+func TestLoad_malformedAddressWeight(t *testing.T) {
+	var testCases = []struct {
+		entry       string
+		shouldError bool
+	}{
+		{"10.0.0.1;weight=10", false},
+		{"::1;weight=10", false},
+		{"host.foo.com;weight=10", false},
+		{"10.0.0.1;weight=0", true},
+		{"10.0.0.1;weight=-1", true},
+		{"10.0.0.1;weight=lol", true},
+		{";weight=10", true},
+		{"", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.entry, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port:      1232,
+				Recursors: []string{"127.0.0.1:1"},
+				Domains: []*Domain{
+					{Name: "foo.com", Addresses: []string{tc.entry}},
+				},
+			})
+
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// WildcardPolicy determines how questions of the types a
+// wildcard doesn't match (see Domain.WildcardTypes) are
+// answered.
+type WildcardPolicy string
+
+const (
+	// WildcardRecurse recurses them, as if the wildcard
+	// wasn't configured.
+	WildcardRecurse WildcardPolicy = "recurse"
+
+	// WildcardNodata answers them NODATA.
+	WildcardNodata WildcardPolicy = "nodata"
+)
+
+// validateWildcardPolicy makes sure that the policy is
+// known, accepting the empty one (i.e.: the default).
+func validateWildcardPolicy(policy WildcardPolicy) (err error) {
+	switch policy {
+	case "", WildcardRecurse, WildcardNodata:
+	default:
+		err = errors.Errorf("unknown wildcard policy %s", policy)
+	}
+
+	return
+}
+
+// parseWildcardTypes parses the types that a wildcard
+// matches, nil meaning all of them.
+func parseWildcardTypes(typeNames []string) (types map[uint16]bool, err error) {
+	if len(typeNames) == 0 {
+		return
+	}
+
+	types = make(map[uint16]bool, len(typeNames))
+	for _, typeName := range typeNames {
+		qtype, found := dns.StringToType[strings.ToUpper(typeName)]
+		if !found {
+			err = errors.Errorf("unknown record type %s", typeName)
+			types = nil
+			return
+		}
+
+		types[qtype] = true
+	}
+
+	return
+}
+
+// wildcardOmits tells whether the name resolves to a
+// wildcard that doesn't match questions of the type
+// qtype, along with the policy those are answered with.
+func (s *Sdns) wildcardOmits(name string, qtype uint16) (omits bool, policy WildcardPolicy) {
+	if qtype == dns.TypeANY {
+		return
+	}
+
+	domain, found := s.findDomain(strings.TrimRight(name, "."))
+	if !found || !strings.HasPrefix(domain.Name, "*") {
+		return
+	}
+
+	types := domain.loaded().wildcardTypes
+	if types == nil || types[qtype] {
+		return
+	}
+
+	policy = domain.WildcardPolicy
+	if policy == "" {
+		policy = s.wildcards
+	}
+
+	omits = true
+	return
+}
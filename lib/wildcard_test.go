@@ -0,0 +1,86 @@
+package lib_test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_wildcardTypes(t *testing.T) {
+	var testCases = []struct {
+		name      string
+		global    WildcardPolicy
+		policy    WildcardPolicy
+		qtype     uint16
+		answers   int
+		recursed  int32
+		authority bool
+	}{
+		{name: "matching type", qtype: dns.TypeA, answers: 1, authority: true},
+		{name: "other matching type", qtype: dns.TypeAAAA, answers: 1, authority: true},
+		{name: "default", qtype: dns.TypeMX, answers: 1, recursed: 1},
+		{name: "recurse", policy: WildcardRecurse, qtype: dns.TypeMX, answers: 1, recursed: 1},
+		{name: "nodata", policy: WildcardNodata, qtype: dns.TypeMX, authority: true},
+		{name: "global nodata", global: WildcardNodata, qtype: dns.TypeMX, authority: true},
+		{name: "wildcard overriding the global policy", global: WildcardNodata, policy: WildcardRecurse,
+			qtype: dns.TypeTXT, answers: 1, recursed: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				recursed int32
+				recursor = startRecursor(t, countingHandler(&recursed, answerHandler(0, "7.7.7.7")))
+			)
+
+			s, err := NewSdns(SdnsConfig{
+				Port:           1232,
+				Recursors:      []string{recursor},
+				WildcardPolicy: tc.global,
+				Domains: []*Domain{
+					{
+						Name:           "*.cdn.example.com",
+						Addresses:      []string{"1.1.1.1", "::1"},
+						MailExchangers: []string{"10 mx.example.com"},
+						Texts:          []string{"lol"},
+						WildcardTypes:  []string{"A", "aaaa", "CNAME"},
+						WildcardPolicy: tc.policy,
+					},
+				},
+			})
+			assert.NoError(t, err)
+
+			m := query(s, "x.cdn.example.com", tc.qtype)
+			assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+			assert.Len(t, m.Answer, tc.answers)
+			assert.Equal(t, tc.authority, m.Authoritative)
+			assert.Equal(t, tc.recursed, atomic.LoadInt32(&recursed))
+		})
+	}
+}
+
+func TestLoad_malformedWildcardTypes(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		domain *Domain
+	}{
+		{"unknown type", &Domain{Name: "*.a.com", Addresses: []string{"1.1.1.1"}, WildcardTypes: []string{"LOL"}}},
+		{"unknown policy", &Domain{Name: "*.a.com", Addresses: []string{"1.1.1.1"}, WildcardPolicy: "lol"}},
+		{"not a wildcard", &Domain{Name: "a.com", Addresses: []string{"1.1.1.1"}, WildcardTypes: []string{"A"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSdns(SdnsConfig{
+				Port:      1232,
+				Recursors: []string{"127.0.0.1:1"},
+				Domains:   []*Domain{tc.domain},
+			})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package lib
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// Zone describes a zone that sdns is authoritative for,
+// i.e., the zone's start of authority (SOA).
+type Zone struct {
+	// Name of the zone's apex e.g.: mysite.com.
+	Name string
+
+	// Ns is the primary nameserver of the zone.
+	Ns string
+
+	// Mbox is the email address of the person
+	// responsible for the zone, either in the
+	// usual form (admin@mysite.com) or in the
+	// DNS one (admin.mysite.com).
+	Mbox string
+
+	// Serial is the serial that the zone starts
+	// with. It gets bumped on every Load (see
+	// SerialFormat) so that secondaries notice
+	// changes.
+	Serial uint32
+
+	// SerialFormat determines how serials get
+	// bumped. Defaults to SerialIncrement.
+	SerialFormat SerialFormat
+
+	// Refresh, Retry, Expire and Minttl are the
+	// timers (in seconds) of the SOA. Zero values
+	// get the defaults from DefaultZoneTimers.
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minttl  uint32
+
+	// Nameservers are the nameservers of the domains
+	// under the zone that don't list their own.
+	Nameservers []string
+}
+
+// SerialFormat determines how the serial of a zone is
+// generated on every Load.
+type SerialFormat string
+
+const (
+	// SerialIncrement increments the serial by one.
+	SerialIncrement SerialFormat = "increment"
+
+	// SerialDate uses the date (UTC) in the YYYYMMDDnn
+	// form, nn counting the loads within the day. Past
+	// 99 loads in a day, the serial spills into the
+	// following day's ones.
+	SerialDate SerialFormat = "date"
+
+	// SerialUnix uses the unix timestamp.
+	SerialUnix SerialFormat = "unix"
+)
+
+// validateSerialFormat makes sure that the format is known,
+// accepting the empty one (i.e.: the default).
+func validateSerialFormat(format SerialFormat) (err error) {
+	switch format {
+	case "", SerialIncrement, SerialDate, SerialUnix:
+	default:
+		err = errors.Errorf("unknown serial format %s", format)
+	}
+
+	return
+}
+
+// nextSerial generates the serial of a zone being loaded
+// at a given time, given the serial previously loaded (if
+// reloaded). Serials never go below the configured one,
+// nor fail to increase across loads.
+func (z *Zone) nextSerial(previous uint32, reloaded bool, now time.Time) (serial uint32) {
+	switch z.SerialFormat {
+	case SerialDate:
+		now = now.UTC()
+		serial = uint32(now.Year()*1000000 + int(now.Month())*10000 + now.Day()*100)
+	case SerialUnix:
+		serial = uint32(now.Unix())
+	default:
+		serial = z.Serial
+		if reloaded {
+			serial = previous + 1
+		}
+	}
+
+	if serial < z.Serial {
+		serial = z.Serial
+	}
+
+	if reloaded && serial <= previous {
+		serial = previous + 1
+	}
+
+	return
+}
+
+// DefaultZoneTimers are the SOA timers used for the ones
+// not specified in a Zone.
+var DefaultZoneTimers = Zone{
+	Refresh: 3600,
+	Retry:   600,
+	Expire:  86400,
+	Minttl:  60,
+}
+
+// soa builds the zone's SOA record with the serial.
+func (z *Zone) soa(serial uint32) *dns.SOA {
+	var (
+		soa = &dns.SOA{
+			Hdr: dns.RR_Header{
+				Name:   dns.Fqdn(z.Name),
+				Rrtype: dns.TypeSOA,
+				Class:  dns.ClassINET,
+				Ttl:    defaultTTL,
+			},
+			Ns:      dns.Fqdn(z.Ns),
+			Mbox:    dns.Fqdn(strings.Replace(z.Mbox, "@", ".", 1)),
+			Serial:  serial,
+			Refresh: z.Refresh,
+			Retry:   z.Retry,
+			Expire:  z.Expire,
+			Minttl:  z.Minttl,
+		}
+	)
+
+	if soa.Refresh == 0 {
+		soa.Refresh = DefaultZoneTimers.Refresh
+	}
+	if soa.Retry == 0 {
+		soa.Retry = DefaultZoneTimers.Retry
+	}
+	if soa.Expire == 0 {
+		soa.Expire = DefaultZoneTimers.Expire
+	}
+	if soa.Minttl == 0 {
+		soa.Minttl = DefaultZoneTimers.Minttl
+	}
+
+	return soa
+}
+
+// loadZones validates the zones and bumps their serials
+// in respect to the ones previously loaded.
+func (s *Sdns) loadZones(zones []*Zone) (err error) {
+	var (
+		loaded  = make(map[string]*Zone, len(zones))
+		serials = make(map[string]uint32, len(zones))
+		now     = s.now()
+	)
+
+	for _, zone := range zones {
+		if zone.Name == "" || zone.Ns == "" || zone.Mbox == "" {
+			err = errors.Errorf(
+				"zone %s must specify a name, ns and mbox",
+				zone.Name)
+			return
+		}
+
+		err = validateSerialFormat(zone.SerialFormat)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"malformed zone %s", zone.Name)
+			return
+		}
+
+		key := strings.ToLower(zone.Name)
+		previous, reloaded := s.serials[key]
+		serials[key] = zone.nextSerial(previous, reloaded, now)
+
+		loaded[key] = zone
+	}
+
+	s.zones = loaded
+	s.serials = serials
+	return
+}
+
+// soa builds the SOA record of a loaded zone, with the
+// serial it was last loaded with.
+func (s *Sdns) soa(zone *Zone) *dns.SOA {
+	s.lock.RLock()
+	serial := s.serials[strings.ToLower(zone.Name)]
+	s.lock.RUnlock()
+
+	return zone.soa(serial)
+}
+
+// findZone retrieves the most specific zone that contains
+// a given name.
+func (s *Sdns) findZone(name string) (zone *Zone, found bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	zone, found = s.zoneOf(name)
+	return
+}
+
+// zoneOf retrieves the most specific zone that contains a
+// given name, leaving the locking up to the caller.
+func (s *Sdns) zoneOf(name string) (zone *Zone, found bool) {
+	name = strings.ToLower(name)
+	for name != "" {
+		zone, found = s.zones[name]
+		if found {
+			return
+		}
+
+		idx := strings.IndexByte(name, '.')
+		if idx < 0 {
+			return
+		}
+		name = name[idx+1:]
+	}
+
+	return
+}
+
+func (s *Sdns) answerSOA(ctx *SdnsContext, m *dns.Msg) (err error) {
+	var name string = m.Question[0].Name
+
+	ctx.logger.Debug().
+		Str("name", name).
+		Str("query", "SOA").
+		Msg("looking for zone")
+
+	zone, found := s.findZone(strings.TrimRight(name, "."))
+	if !found {
+		err = ErrDomainNotFound
+		return
+	}
+
+	if !strings.EqualFold(dns.Fqdn(zone.Name), name) {
+		m.Ns = append(m.Ns, s.soa(zone))
+		return
+	}
+
+	m.Answer = append(m.Answer, s.soa(zone))
+	return
+}
+
+// inheritNameservers makes the domains that don't list
+// nameservers of their own get the ones of the zone they
+// belong to, if any, among the records pending (see
+// storeRecords).
+func (s *Sdns) inheritNameservers(pending map[*Domain]*domainRecords) {
+	for _, domains := range []map[string]*Domain{
+		s.exactDomains,
+		s.wildcardDomains,
+		s.apexDomains,
+	} {
+		for _, domain := range domains {
+			if len(domain.Nameservers) > 0 {
+				continue
+			}
+
+			zone, found := s.zoneOf(strings.TrimPrefix(domain.Name, "*."))
+			if !found || len(zone.Nameservers) == 0 {
+				continue
+			}
+
+			records, found := pending[domain]
+			if !found {
+				continue
+			}
+
+			records.nameservers = zone.Nameservers
+		}
+	}
+}
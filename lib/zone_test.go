@@ -0,0 +1,305 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestHandle_soa(t *testing.T) {
+	var (
+		recursor = startRecursor(t, nxdomainHandler(false))
+		cfg      = SdnsConfig{
+			Port:      1232,
+			Recursors: []string{recursor},
+			Zones: []*Zone{
+				{
+					Name:   "foo.com",
+					Ns:     "ns1.foo.com",
+					Mbox:   "admin@foo.com",
+					Serial: 10,
+				},
+			},
+			Domains: []*Domain{
+				{Name: "www.foo.com", Addresses: []string{"1.1.1.1"}},
+			},
+		}
+	)
+
+	s, err := NewSdns(cfg)
+	assert.NoError(t, err)
+
+	m := query(s, "foo.com", dns.TypeSOA)
+	assert.Equal(t, []string{
+		"foo.com.\t3600\tIN\tSOA\tns1.foo.com. admin.foo.com. 10 3600 600 86400 60",
+	}, answerStrings(m))
+
+	// names under the zone get no data but the SOA
+	m = query(s, "www.foo.com", dns.TypeSOA)
+	assert.Empty(t, m.Answer)
+	assert.Len(t, m.Ns, 1)
+
+	// negative answers carry the zone's SOA
+	m = query(s, "missing.foo.com", dns.TypeA)
+	assert.Equal(t, dns.RcodeNameError, m.Rcode)
+	assert.Len(t, m.Ns, 1)
+	assert.Equal(t, uint32(10), m.Ns[0].(*dns.SOA).Serial)
+
+	// reloading bumps the serial
+	assert.NoError(t, s.Load(cfg))
+	assert.NoError(t, s.Load(cfg))
+
+	m = query(s, "foo.com", dns.TypeSOA)
+	assert.Len(t, m.Answer, 1)
+	assert.Equal(t, uint32(12), m.Answer[0].(*dns.SOA).Serial)
+}
+
+func TestLoad_malformedZone(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{
+		Port:  1232,
+		Zones: []*Zone{{Name: "foo.com"}},
+	})
+	assert.Error(t, err)
+}
+
+func TestHandle_zoneNameservers(t *testing.T) {
+	var cfg = SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Zones: []*Zone{
+			{
+				Name:        "foo.com",
+				Ns:          "ns1.foo.com",
+				Mbox:        "admin@foo.com",
+				Nameservers: []string{"ns1.foo.com.", "ns2.foo.com."},
+			},
+		},
+		Domains: []*Domain{
+			{Name: "www.foo.com", Addresses: []string{"1.1.1.1"}},
+			{Name: "*.apps.foo.com", Addresses: []string{"1.1.1.1"}},
+			{Name: "own.foo.com", Nameservers: []string{"ns.own.com."}},
+			{Name: "bar.com", Addresses: []string{"2.2.2.2"}},
+		},
+	}
+
+	s, err := NewSdns(cfg)
+	assert.NoError(t, err)
+
+	var testCases = []struct {
+		name     string
+		expected []string
+	}{
+		{"www.foo.com", []string{"ns1.foo.com.", "ns2.foo.com."}},
+		{"x.apps.foo.com", []string{"ns1.foo.com.", "ns2.foo.com."}},
+		{"own.foo.com", []string{"ns.own.com."}},
+		{"bar.com", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := query(s, tc.name, dns.TypeNS)
+			assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+
+			var nameservers []string
+			for _, rr := range m.Answer {
+				nameservers = append(nameservers, rr.(*dns.NS).Ns)
+			}
+
+			assert.Equal(t, tc.expected, nameservers)
+		})
+	}
+
+	// the defaults go away along with the zone.
+	cfg.Zones = nil
+	assert.NoError(t, s.Load(cfg))
+
+	m := query(s, "www.foo.com", dns.TypeNS)
+	assert.Empty(t, m.Answer)
+}
+
+func TestLoad_serialFormats(t *testing.T) {
+	var (
+		morning = time.Date(2021, 3, 4, 9, 0, 0, 0, time.UTC)
+		evening = time.Date(2021, 3, 4, 21, 0, 0, 0, time.UTC)
+		nextDay = time.Date(2021, 3, 5, 9, 0, 0, 0, time.UTC)
+	)
+
+	var testCases = []struct {
+		name     string
+		format   SerialFormat
+		serial   uint32
+		loads    []time.Time
+		expected []uint32
+	}{
+		{
+			name:     "increment",
+			format:   SerialIncrement,
+			serial:   10,
+			loads:    []time.Time{morning, morning, nextDay},
+			expected: []uint32{10, 11, 12},
+		},
+		{
+			name:     "default",
+			serial:   10,
+			loads:    []time.Time{morning, nextDay},
+			expected: []uint32{10, 11},
+		},
+		{
+			name:     "date",
+			format:   SerialDate,
+			loads:    []time.Time{morning, morning, evening, nextDay},
+			expected: []uint32{2021030400, 2021030401, 2021030402, 2021030500},
+		},
+		{
+			name:     "date with a serial ahead",
+			format:   SerialDate,
+			serial:   2021030499,
+			loads:    []time.Time{morning, morning, nextDay},
+			expected: []uint32{2021030499, 2021030500, 2021030501},
+		},
+		{
+			name:     "date in utc",
+			format:   SerialDate,
+			loads:    []time.Time{time.Date(2021, 3, 4, 23, 0, 0, 0, time.FixedZone("", -3*3600))},
+			expected: []uint32{2021030500},
+		},
+		{
+			name:     "unix",
+			format:   SerialUnix,
+			loads:    []time.Time{morning, morning, nextDay},
+			expected: []uint32{uint32(morning.Unix()), uint32(morning.Unix()) + 1, uint32(nextDay.Unix())},
+		},
+		{
+			name:     "clock going backwards",
+			format:   SerialUnix,
+			loads:    []time.Time{nextDay, morning},
+			expected: []uint32{uint32(nextDay.Unix()), uint32(nextDay.Unix()) + 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				now = tc.loads[0]
+				cfg = SdnsConfig{
+					Port:      1232,
+					Recursors: []string{"127.0.0.1:1"},
+					Clock:     func() time.Time { return now },
+					Zones: []*Zone{
+						{
+							Name:         "foo.com",
+							Ns:           "ns1.foo.com",
+							Mbox:         "admin@foo.com",
+							Serial:       tc.serial,
+							SerialFormat: tc.format,
+						},
+					},
+				}
+			)
+
+			s, err := NewSdns(cfg)
+			assert.NoError(t, err)
+
+			for idx, load := range tc.loads {
+				if idx > 0 {
+					now = load
+					assert.NoError(t, s.Load(cfg))
+				}
+
+				m := query(s, "foo.com", dns.TypeSOA)
+				assert.Len(t, m.Answer, 1)
+				assert.Equal(t, tc.expected[idx], m.Answer[0].(*dns.SOA).Serial)
+			}
+		})
+	}
+}
+
+func TestLoad_serialRaised(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		format SerialFormat
+	}{
+		{"increment", SerialIncrement},
+		{"unix", SerialUnix},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				now  = time.Unix(1000, 0)
+				zone = func(serial uint32) SdnsConfig {
+					return SdnsConfig{
+						Port:      1232,
+						Recursors: []string{"127.0.0.1:1"},
+						Clock:     func() time.Time { return now },
+						Zones: []*Zone{
+							{
+								Name:         "foo.com",
+								Ns:           "ns1.foo.com",
+								Mbox:         "admin@foo.com",
+								Serial:       serial,
+								SerialFormat: tc.format,
+							},
+						},
+					}
+				}
+			)
+
+			s, err := NewSdns(zone(10))
+			assert.NoError(t, err)
+			assert.NoError(t, s.Load(zone(10)))
+
+			// a serial configured ahead is jumped to.
+			assert.NoError(t, s.Load(zone(5000)))
+
+			m := query(s, "foo.com", dns.TypeSOA)
+			assert.Len(t, m.Answer, 1)
+			assert.Equal(t, uint32(5000), m.Answer[0].(*dns.SOA).Serial)
+		})
+	}
+}
+
+func TestLoad_serialPerServer(t *testing.T) {
+	cfg := SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		Zones: []*Zone{
+			{Name: "foo.com", Ns: "ns1.foo.com", Mbox: "admin@foo.com", Serial: 10},
+		},
+	}
+
+	s, err := NewSdns(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Load(cfg))
+
+	// the zones configured are shared but not their serials.
+	other, err := NewSdns(cfg)
+	assert.NoError(t, err)
+
+	m := query(s, "foo.com", dns.TypeSOA)
+	assert.Len(t, m.Answer, 1)
+	assert.Equal(t, uint32(11), m.Answer[0].(*dns.SOA).Serial)
+
+	m = query(other, "foo.com", dns.TypeSOA)
+	assert.Len(t, m.Answer, 1)
+	assert.Equal(t, uint32(10), m.Answer[0].(*dns.SOA).Serial)
+}
+
+func TestLoad_malformedSerialFormat(t *testing.T) {
+	_, err := NewSdns(SdnsConfig{
+		Port: 1232,
+		Zones: []*Zone{
+			{
+				Name:         "foo.com",
+				Ns:           "ns1.foo.com",
+				Mbox:         "admin@foo.com",
+				SerialFormat: "lol",
+			},
+		},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// LoadZoneFile reads the records of a BIND zone file into
+// domains, one per name (in the order names first show up).
+// $ORIGIN and $TTL are honored, while $INCLUDE isn't.
+// A, AAAA, NS, MX, CNAME and TXT records are supported; SOA
+// records are skipped as sdns answers with the ones of its
+// zones (see Zone).
+// As domains have a single TTL, they take the lowest of the
+// TTLs of their records.
+func LoadZoneFile(path string) (domains []*Domain, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"couldn't open zone file %s", path)
+		return
+	}
+	defer file.Close()
+
+	var (
+		parser = dns.NewZoneParser(file, "", path)
+		byName = make(map[string]*Domain)
+	)
+
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		var (
+			hdr    = rr.Header()
+			name   = strings.ToLower(strings.TrimSuffix(hdr.Name, "."))
+			domain = byName[name]
+		)
+
+		if hdr.Rrtype == dns.TypeSOA {
+			continue
+		}
+
+		if name == "" {
+			err = errors.Errorf(
+				"records of the root aren't supported - zone file %s",
+				path)
+			return
+		}
+
+		if domain == nil {
+			domain = &Domain{Name: name, TTL: hdr.Ttl}
+			byName[name] = domain
+			domains = append(domains, domain)
+		}
+
+		if hdr.Ttl < domain.TTL {
+			domain.TTL = hdr.Ttl
+		}
+
+		switch record := rr.(type) {
+		case *dns.A:
+			domain.Addresses = append(domain.Addresses, record.A.String())
+		case *dns.AAAA:
+			domain.Addresses = append(domain.Addresses, record.AAAA.String())
+		case *dns.NS:
+			domain.Nameservers = append(domain.Nameservers, record.Ns)
+		case *dns.MX:
+			domain.MailExchangers = append(domain.MailExchangers,
+				fmt.Sprintf("%d %s", record.Preference, record.Mx))
+		case *dns.TXT:
+			domain.Texts = append(domain.Texts, strings.Join(record.Txt, ""))
+		case *dns.CNAME:
+			if domain.Cname != "" {
+				err = errors.Errorf(
+					"more than one cname for %s in zone file %s",
+					hdr.Name, path)
+				return
+			}
+
+			domain.Cname = record.Target
+		default:
+			err = errors.Errorf(
+				"unsupported %s record for %s in zone file %s",
+				dns.TypeToString[hdr.Rrtype], hdr.Name, path)
+			return
+		}
+	}
+
+	err = parser.Err()
+	if err != nil {
+		err = errors.Wrapf(err,
+			"malformed zone file %s", path)
+		return
+	}
+
+	return
+}
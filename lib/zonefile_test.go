@@ -0,0 +1,114 @@
+package lib_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+const sampleZone = `$ORIGIN foo.com.
+$TTL 300
+@       IN SOA  ns1 admin 1 3600 600 86400 60
+@       IN NS   ns1
+@       IN NS   ns2.bar.com.
+@       IN A    1.1.1.1
+@       IN MX   10 mail
+@       IN TXT  "v=spf1 -all"
+ns1     IN A    2.2.2.2
+www 60  IN A    3.3.3.3
+www     IN AAAA ::1
+alias   IN CNAME www
+
+$ORIGIN sub.foo.com.
+*       IN A    4.4.4.4
+`
+
+func writeZoneFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "foo.com.zone")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+	return path
+}
+
+func TestLoadZoneFile(t *testing.T) {
+	domains, err := LoadZoneFile(writeZoneFile(t, sampleZone))
+	assert.NoError(t, err)
+	assert.Equal(t, []*Domain{
+		{
+			Name:           "foo.com",
+			TTL:            300,
+			Nameservers:    []string{"ns1.foo.com.", "ns2.bar.com."},
+			Addresses:      []string{"1.1.1.1"},
+			MailExchangers: []string{"10 mail.foo.com."},
+			Texts:          []string{"v=spf1 -all"},
+		},
+		{Name: "ns1.foo.com", TTL: 300, Addresses: []string{"2.2.2.2"}},
+		{Name: "www.foo.com", TTL: 60, Addresses: []string{"3.3.3.3", "::1"}},
+		{Name: "alias.foo.com", TTL: 300, Cname: "www.foo.com."},
+		{Name: "*.sub.foo.com", TTL: 300, Addresses: []string{"4.4.4.4"}},
+	}, domains)
+}
+
+func TestLoadZoneFile_malformed(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		content string
+	}{
+		{"unsupported type", "foo.com. 300 IN SRV 10 60 5060 sip.foo.com.\n"},
+		{"two cnames", "a.foo.com. 300 IN CNAME b.foo.com.\na.foo.com. 300 IN CNAME c.foo.com.\n"},
+		{"root records", ". 300 IN NS a.root-servers.net.\n"},
+		{"malformed record", "foo.com. 300 IN A lol\n"},
+		{"relative name without origin", "foo 300 IN A 1.1.1.1\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadZoneFile(writeZoneFile(t, tc.content))
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := LoadZoneFile(filepath.Join(t.TempDir(), "missing.zone"))
+	assert.Error(t, err)
+}
+
+func TestHandle_zoneFile(t *testing.T) {
+	s, err := NewSdns(SdnsConfig{
+		Port:      1232,
+		Recursors: []string{"127.0.0.1:1"},
+		ZoneFile:  writeZoneFile(t, sampleZone),
+		Domains: []*Domain{
+			{Name: "bar.com", Addresses: []string{"5.5.5.5"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	m := query(s, "foo.com", dns.TypeA)
+	assert.Equal(t, []string{"foo.com.\t300\tIN\tA\t1.1.1.1"}, answerStrings(m))
+
+	m = query(s, "www.foo.com", dns.TypeAAAA)
+	assert.Equal(t, []string{"www.foo.com.\t60\tIN\tAAAA\t::1"}, answerStrings(m))
+
+	m = query(s, "foo.com", dns.TypeMX)
+	assert.Equal(t, []string{"foo.com.\t300\tIN\tMX\t10 mail.foo.com."}, answerStrings(m))
+
+	m = query(s, "foo.com", dns.TypeTXT)
+	assert.Equal(t, []string{"foo.com.\t300\tIN\tTXT\t\"v=spf1 -all\""}, answerStrings(m))
+
+	m = query(s, "alias.foo.com", dns.TypeA)
+	assert.Equal(t, []string{
+		"alias.foo.com.\t300\tIN\tCNAME\twww.foo.com.",
+		"www.foo.com.\t60\tIN\tA\t3.3.3.3",
+	}, answerStrings(m))
+
+	m = query(s, "x.sub.foo.com", dns.TypeA)
+	assert.Equal(t, []string{"4.4.4.4"}, answerIPs(m))
+
+	m = query(s, "bar.com", dns.TypeA)
+	assert.Equal(t, []string{"5.5.5.5"}, answerIPs(m))
+}
@@ -2,13 +2,14 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"time"
 
 	"github.com/alexflint/go-arg"
 	"github.com/pkg/errors"
 
 	. "github.com/cirocosta/sdns/lib"
-	util "github.com/cirocosta/sdns/util"
 )
 
 var version = "master"
@@ -16,11 +17,81 @@ var version = "master"
 // config contains the structure for retrieval of
 // the SDNS configuration from the command line.
 type config struct {
-	Port      int      `arg:"-p,env,help:port to listen to"`
-	Address   string   `arg:"-a,env,help:address to bind to"`
-	Debug     bool     `arg:"-d,env,help:turn debug mode on"`
-	Recursors []string `arg:"-r,--recursor,help:list of recursors to honor"`
-	Domains   []string `arg:"positional,help:list of domains"`
+	Port               int               `arg:"-p,env,help:port to listen to (0 picks a free one)"`
+	Address            string            `arg:"-a,env,help:address to bind to"`
+	Debug              bool              `arg:"-d,env,help:turn debug mode on"`
+	Recursors          []string          `arg:"-r,--recursor,help:list of recursors to honor"`
+	SkipBadDomains     bool              `arg:"--skip-bad-domains,env,help:skip malformed domains instead of aborting"`
+	NSID               string            `arg:"--nsid,env,help:server identifier returned via EDNS NSID (defaults to hostname)"`
+	SystemResolvers    bool              `arg:"--use-system-resolvers,env,help:use the nameservers from /etc/resolv.conf as recursors"`
+	Zones              []string          `arg:"-z,--zone,help:list of zones to be authoritative for"`
+	BreakerThreshold   int               `arg:"--breaker-threshold,env,help:consecutive recursion failures of a name that suspend its recursion (0 disables)"`
+	BreakerWindow      time.Duration     `arg:"--breaker-window,env,help:window in which recursion failures of a name are counted"`
+	BreakerCooldown    time.Duration     `arg:"--breaker-cooldown,env,help:time recursion of a name stays suspended"`
+	ClockReference     string            `arg:"--clock-reference,env,help:URL of an HTTP server whose Date header the clock is checked against"`
+	MaxClockSkew       time.Duration     `arg:"--max-clock-skew,env,help:clock skew past which a warning is logged"`
+	TLSCert            string            `arg:"--tls-cert,env,help:PEM certificate to serve DNS-over-TLS with"`
+	TLSKey             string            `arg:"--tls-key,env,help:PEM key of the DNS-over-TLS certificate"`
+	TLSPort            int               `arg:"--tls-port,env,help:port to serve DNS-over-TLS on"`
+	DoHPort            int               `arg:"--doh-port,env,help:port to serve DNS-over-HTTPS on (HTTPS if a TLS certificate is set; disabled if 0)"`
+	DoHPath            string            `arg:"--doh-path,env,help:path to serve DNS-over-HTTPS on"`
+	MetricsPort        int               `arg:"--metrics-port,env,help:port to serve Prometheus metrics on under /metrics (disabled if 0)"`
+	AdminAddress       string            `arg:"--admin-address,env,help:address (e.g. 127.0.0.1:8053) to serve the unauthenticated admin API for managing domains on (disabled if empty)"`
+	TCPMaxConnections  int               `arg:"--tcp-max-connections,env,help:maximum simultaneous TCP connections (0 for unlimited)"`
+	ClientRateLimit    int               `arg:"--client-rate-limit,env,help:maximum queries per second answered to each client IP (0 for unlimited)"`
+	ClientRateBurst    int               `arg:"--client-rate-burst,env,help:maximum queries a client can send at once (defaults to the rate)"`
+	ClientRateAction   string            `arg:"--client-rate-action,env,help:what happens to queries past the client rate: refuse|drop"`
+	ClientAllowlist    []string          `arg:"--client-rate-allowlist,env,help:subnets (e.g. 10.0.0.0/8) whose clients aren't rate limited"`
+	AllowClients       []string          `arg:"--allow-client,env,help:subnets (e.g. 10.0.0.0/8) of the only clients answered (all if empty)"`
+	DenyClients        []string          `arg:"--deny-client,env,help:subnets of clients refused"`
+	RecursionClients   []string          `arg:"--recursion-client,env,help:subnets of the only clients whose questions are recursed (all if empty)"`
+	TCPIdleTimeout     time.Duration     `arg:"--tcp-idle-timeout,env,help:time idle TCP connections are kept open"`
+	ShutdownTimeout    time.Duration     `arg:"--shutdown-timeout,env,help:time in-flight queries are given to finish on SIGINT or SIGTERM"`
+	MalformedPolicy    string            `arg:"--malformed-policy,env,help:answer of queries that can't be unpacked: formerr|drop"`
+	Compress           bool              `arg:"--compress,env,help:compress responses (domains can override it with compress=)"`
+	EDNSPassthrough    []uint16          `arg:"--edns-passthrough,help:codes of EDNS options to pass through to recursors and back"`
+	FeatureOption      uint16            `arg:"--feature-option,env,help:code of the EDNS local option through which queries turn features on: minimal-responses|no-cache (0 disables)"`
+	IgnoreEDNSVersion  bool              `arg:"--ignore-edns-version,env,help:answer requests of unsupported EDNS versions instead of returning BADVERS"`
+	ClientSubnets      bool              `arg:"--client-subnets,env,help:send the subnet of clients to recursors (EDNS Client Subnet)"`
+	ClientSubnetV4     int               `arg:"--client-subnet-v4,env,help:prefix length of the ipv4 client subnets sent to recursors"`
+	ClientSubnetV6     int               `arg:"--client-subnet-v6,env,help:prefix length of the ipv6 client subnets sent to recursors"`
+	TTLJitter          float64           `arg:"--ttl-jitter,env,help:fraction (0 to 1) by which TTLs of local records are randomly spread"`
+	AddressMode        string            `arg:"--address-mode,env,help:addresses answering address questions: round-robin|all|shuffled (domains can override it with addresses=)"`
+	NameserverOrder    string            `arg:"--ns-order,env,help:order of the nameservers answered: as-configured|sorted|round-robin (domains can override it with ns-order=)"`
+	Delegation         string            `arg:"--delegation,env,help:answer of address questions for domains with only nameservers: nodata|referral|recurse (domains can override it with delegation=)"`
+	WildcardPolicy     string            `arg:"--wildcard-policy,env,help:answer of questions of the types wildcards don't match: recurse|nodata (wildcards can override it with wildcard-policy=)"`
+	DelegationPort     int               `arg:"--delegation-port,env,help:port the nameservers of domains are asked on when recursing via them"`
+	Transport          string            `arg:"--transport,env,help:transports questions are answered over: any|tcp|tcp-only|udp-only (domains can override it with transport=)"`
+	ThrottlePolicy     string            `arg:"--throttle-policy,env,help:how questions for domains past their max-qps= are answered: refused|servfail"`
+	CnamePolicy        string            `arg:"--cname-policy,env,help:handling of domains with both addresses and a cname but no fallback chain: precedence|reject"`
+	DuplicatePolicy    string            `arg:"--duplicate-policy,env,help:handling of domains sharing a name: last|reject|merge"`
+	MaxChainLength     int               `arg:"--max-chain-length,env,help:aliases (CNAMEs) followed locally when answering a question"`
+	ChainPolicy        string            `arg:"--chain-policy,env,help:answer of questions whose alias chain is longer than the maximum: servfail|partial"`
+	RootPolicy         string            `arg:"--root-policy,env,help:handling of questions for the root (or an empty) name: recurse|refuse|referral"`
+	TTL                uint32            `arg:"--ttl,env,help:TTL of the records answered locally (domains can override it with ttl=)"`
+	Cache              bool              `arg:"--cache,env,help:cache the responses of the recursors"`
+	CacheSize          int               `arg:"--cache-size,env,help:maximum number of responses cached"`
+	CacheSweepInterval time.Duration     `arg:"--cache-sweep-interval,env,help:how often expired responses are swept from the cache (0 disables)"`
+	CacheRedis         string            `arg:"--cache-redis,env,help:address (host:port or redis:// URL) of a Redis server sharing the cache among instances (implies --cache)"`
+	ParallelRecursion  bool              `arg:"--parallel-recursion,env,help:ask all the recursors at once instead of one after another"`
+	ParallelPolicy     string            `arg:"--parallel-policy,env,help:response used when recursing in parallel: first|most-answers"`
+	RecursorOrder      string            `arg:"--recursor-order,env,help:order in which recursors are tried: priority|round-robin|random"`
+	DialTimeout        time.Duration     `arg:"--dial-timeout,env,help:time to connect to a recursor"`
+	ReadTimeout        time.Duration     `arg:"--read-timeout,env,help:time to wait for a recursor to answer"`
+	WriteTimeout       time.Duration     `arg:"--write-timeout,env,help:time to send a question to a recursor"`
+	QtypeRewrites      map[string]string `arg:"--qtype-rewrite,help:query types resolved as others (e.g. MAILA=MX or TYPE38=AAAA)"`
+	RecursorRewrites   []string          `arg:"--recursor-rewrite,help:rewrite of the queries forwarded to a recursor as recursor=rewrite (strip-edns|set-cd|clear-cd|clear-rd)"`
+	LogFormat          string            `arg:"--log-format,env,help:format of the messages logged: json|console (defaults to console in debug mode)"`
+	LogLevel           string            `arg:"--log-level,env,help:minimum level of the messages logged: debug|info|warn|error (info unless debugging - domains can override it with log=)"`
+	RecursorFailures   int               `arg:"--recursor-failures,env,help:consecutive failures that get a recursor skipped (0 disables)"`
+	RecursorCooldown   time.Duration     `arg:"--recursor-cooldown,env,help:time a failing recursor is skipped before being probed again"`
+	ConfigFile         string            `arg:"--config-file,env,help:YAML file with domains and zones (re-read on SIGHUP) as well as settings that override the flags"`
+	ZoneFile           string            `arg:"--zone-file,env,help:BIND zone file with more domains (A|AAAA|NS|MX|CNAME|TXT records) that's re-read on SIGHUP"`
+	HostsFile          string            `arg:"--hosts-file,env,help:hosts file (IP name [name...] per line) with more domains that's re-read on SIGHUP"`
+	Watch              bool              `arg:"--watch,env,help:reload when the config|zone|hosts files change instead of only on SIGHUP"`
+	WatchDebounce      time.Duration     `arg:"--watch-debounce,env,help:time changed files are left alone before being reloaded"`
+	Check              bool              `arg:"--check,help:validate the configuration and exit (non-zero if invalid) without listening"`
+	Domains            []string          `arg:"positional,help:list of domains"`
 }
 
 func (c *config) Version() string {
@@ -35,57 +106,220 @@ var (
 			"8.8.8.8:53",
 			"8.8.4.4:53",
 		},
+		BreakerWindow:    time.Minute,
+		BreakerCooldown:  30 * time.Second,
+		RecursorCooldown: 30 * time.Second,
+		MaxClockSkew:     DefaultMaxClockSkew,
+		TLSPort:          DefaultTLSPort,
+		DoHPath:          DefaultDoHPath,
+		TCPIdleTimeout:   8 * time.Second,
+		ShutdownTimeout:  DefaultShutdownTimeout,
+		WatchDebounce:    DefaultWatchDebounce,
+		MaxChainLength:   MaxCnameChain,
+		DelegationPort:   DefaultDelegationPort,
+		ClientSubnetV4:   DefaultClientSubnetV4,
+		ClientSubnetV6:   DefaultClientSubnetV6,
+		CacheSize:        DefaultCacheSize,
+		DialTimeout:      DefaultRecursionTimeout,
+		ReadTimeout:      DefaultRecursionTimeout,
+		WriteTimeout:     DefaultRecursionTimeout,
 	}
 	sdnsConfig = SdnsConfig{}
-	s          Sdns
+	s          *Sdns
 	err        error
 )
 
+// parseDomains converts the positional domain arguments
+// into domains. If skipBad is set, malformed entries don't
+// abort the parsing - they're returned in `skipped` instead.
+func parseDomains(domainStrings []string, skipBad bool) (domains []*Domain, skipped []error, err error) {
+	if !skipBad {
+		domains, err = ParseDomainArgs(domainStrings)
+		return
+	}
+
+	for _, domainString := range domainStrings {
+		domain, parseErr := ParseDomainArg(domainString)
+		if parseErr != nil {
+			skipped = append(skipped, parseErr)
+			continue
+		}
+
+		domains = append(domains, domain)
+	}
+
+	return
+}
+
+// loadDomains parses the domains given as arguments,
+// warning about the ones skipped.
+func loadDomains() (domains []*Domain, err error) {
+	domains, skipped, err := parseDomains(args.Domains, args.SkipBadDomains)
+	if err != nil {
+		return
+	}
+
+	for _, skipErr := range skipped {
+		fmt.Fprintf(os.Stderr,
+			"WARN: Skipping domain - %s\n", skipErr)
+	}
+
+	if len(skipped) > 0 {
+		fmt.Fprintf(os.Stderr,
+			"WARN: Skipped %d out of %d domains\n",
+			len(skipped), len(args.Domains))
+	}
+
+	return
+}
+
+// check validates the configuration the way sdns gets
+// instantiated with it, without listening, reporting a
+// summary of what's configured when valid. Duplicate
+// domains are rejected unless a policy is set for them.
+func check(w io.Writer, cfg SdnsConfig) (err error) {
+	if cfg.DuplicatePolicy == "" {
+		cfg.DuplicatePolicy = DuplicatesReject
+	}
+
+	s, err := NewSdns(cfg)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w,
+		"OK: Valid configuration - %d domains\n",
+		len(s.Domains()))
+	return
+}
+
 func main() {
 	arg.MustParse(args)
 
-	if len(args.Domains) > 0 {
-		sdnsConfig.Domains = make([]*Domain, len(args.Domains))
-		for idx, domainString := range args.Domains {
-			domain := &Domain{}
-			mapping, err := util.CsvStringToMap(domainString)
-			if err != nil {
-				fmt.Fprintf(os.Stderr,
-					"ERROR: Malformed domain configuration - %s",
-					errors.Cause(err))
-				os.Exit(1)
-			}
-
-			name, present := mapping["domain"]
-			if !present {
-				fmt.Fprintf(os.Stderr,
-					"ERROR: Malformed domain configuration. "+
-						"A domain name must be present")
-				os.Exit(1)
-			}
-
-			if present {
-				domain.Name = name[0]
-			}
-
-			ips, present := mapping["ip"]
-			if present {
-				domain.Addresses = ips
-			}
-
-			nameservers, present := mapping["ns"]
-			if present {
-				domain.Nameservers = nameservers
-			}
-
-			sdnsConfig.Domains[idx] = domain
+	domains, err := loadDomains()
+	if err != nil {
+		fmt.Fprintf(os.Stderr,
+			"ERROR: Malformed domain configuration - %s",
+			errors.Cause(err))
+		os.Exit(1)
+	}
+
+	sdnsConfig.Domains = domains
+
+	for _, zoneString := range args.Zones {
+		zone, err := ParseZoneArg(zoneString)
+		if err != nil {
+			fmt.Fprintf(os.Stderr,
+				"ERROR: Malformed zone configuration - %s",
+				errors.Cause(err))
+			os.Exit(1)
 		}
+
+		sdnsConfig.Zones = append(sdnsConfig.Zones, zone)
+	}
+
+	sdnsConfig.RecursorRewrites = map[string][]QueryRewrite{}
+	for _, rewriteString := range args.RecursorRewrites {
+		recursor, rewrite, err := ParseRecursorRewrite(rewriteString)
+		if err != nil {
+			fmt.Fprintf(os.Stderr,
+				"ERROR: Malformed recursor rewrite - %s",
+				errors.Cause(err))
+			os.Exit(1)
+		}
+
+		sdnsConfig.RecursorRewrites[recursor] = append(
+			sdnsConfig.RecursorRewrites[recursor], rewrite)
 	}
 
+	sdnsConfig.ConfigFile = args.ConfigFile
+	sdnsConfig.ZoneFile = args.ZoneFile
+	sdnsConfig.HostsFile = args.HostsFile
+	sdnsConfig.WatchFiles = args.Watch
+	sdnsConfig.WatchDebounce = args.WatchDebounce
 	sdnsConfig.Recursors = args.Recursors
 	sdnsConfig.Debug = args.Debug
 	sdnsConfig.Address = args.Address
 	sdnsConfig.Port = args.Port
+	sdnsConfig.NSID = args.NSID
+	sdnsConfig.UseSystemResolvers = args.SystemResolvers
+	sdnsConfig.BreakerThreshold = args.BreakerThreshold
+	sdnsConfig.BreakerWindow = args.BreakerWindow
+	sdnsConfig.BreakerCooldown = args.BreakerCooldown
+	sdnsConfig.ClockReference = args.ClockReference
+	sdnsConfig.MaxClockSkew = args.MaxClockSkew
+	sdnsConfig.RecursorFailures = args.RecursorFailures
+	sdnsConfig.RecursorCooldown = args.RecursorCooldown
+	sdnsConfig.TLSCertFile = args.TLSCert
+	sdnsConfig.TLSKeyFile = args.TLSKey
+	sdnsConfig.TLSPort = args.TLSPort
+	sdnsConfig.DoHPort = args.DoHPort
+	sdnsConfig.DoHPath = args.DoHPath
+	sdnsConfig.MetricsPort = args.MetricsPort
+	sdnsConfig.AdminAddress = args.AdminAddress
+	sdnsConfig.MalformedPolicy = MalformedPolicy(args.MalformedPolicy)
+	sdnsConfig.Compress = args.Compress
+	sdnsConfig.EDNSPassthrough = args.EDNSPassthrough
+	sdnsConfig.FeatureOption = args.FeatureOption
+	sdnsConfig.IgnoreEDNSVersion = args.IgnoreEDNSVersion
+	sdnsConfig.ClientSubnets = args.ClientSubnets
+	sdnsConfig.ClientSubnetV4 = args.ClientSubnetV4
+	sdnsConfig.ClientSubnetV6 = args.ClientSubnetV6
+	sdnsConfig.TCPMaxConnections = args.TCPMaxConnections
+	sdnsConfig.ClientRateLimit = args.ClientRateLimit
+	sdnsConfig.ClientRateBurst = args.ClientRateBurst
+	sdnsConfig.ClientRateAction = RateLimitAction(args.ClientRateAction)
+	sdnsConfig.ClientRateAllowlist = args.ClientAllowlist
+	sdnsConfig.AllowClients = args.AllowClients
+	sdnsConfig.DenyClients = args.DenyClients
+	sdnsConfig.RecursionClients = args.RecursionClients
+	sdnsConfig.TCPIdleTimeout = args.TCPIdleTimeout
+	sdnsConfig.ShutdownTimeout = args.ShutdownTimeout
+	sdnsConfig.TTL = args.TTL
+	sdnsConfig.TTLJitter = args.TTLJitter
+	sdnsConfig.AddressMode = AddressMode(args.AddressMode)
+	sdnsConfig.NameserverOrder = NameserverOrder(args.NameserverOrder)
+	sdnsConfig.Delegation = DelegationPolicy(args.Delegation)
+	sdnsConfig.DelegationPort = args.DelegationPort
+	sdnsConfig.WildcardPolicy = WildcardPolicy(args.WildcardPolicy)
+	sdnsConfig.RootPolicy = RootPolicy(args.RootPolicy)
+	sdnsConfig.Transport = TransportPolicy(args.Transport)
+	sdnsConfig.ThrottlePolicy = ThrottlePolicy(args.ThrottlePolicy)
+	sdnsConfig.CnamePolicy = CnamePolicy(args.CnamePolicy)
+	sdnsConfig.DuplicatePolicy = DuplicatePolicy(args.DuplicatePolicy)
+	sdnsConfig.MaxChainLength = args.MaxChainLength
+	sdnsConfig.ChainPolicy = ChainPolicy(args.ChainPolicy)
+	sdnsConfig.Cache = args.Cache
+	sdnsConfig.CacheSize = args.CacheSize
+	sdnsConfig.CacheSweepInterval = args.CacheSweepInterval
+	sdnsConfig.CacheRedis = args.CacheRedis
+	sdnsConfig.ParallelRecursion = args.ParallelRecursion
+	sdnsConfig.ParallelPolicy = ParallelPolicy(args.ParallelPolicy)
+	sdnsConfig.RecursorOrder = RecursorOrder(args.RecursorOrder)
+	sdnsConfig.DialTimeout = args.DialTimeout
+	sdnsConfig.ReadTimeout = args.ReadTimeout
+	sdnsConfig.WriteTimeout = args.WriteTimeout
+	sdnsConfig.QtypeRewrites = args.QtypeRewrites
+	sdnsConfig.LogFormat = LogFormat(args.LogFormat)
+	sdnsConfig.LogLevel = args.LogLevel
+
+	sdnsConfig.Reload = func() (cfg SdnsConfig, err error) {
+		cfg = sdnsConfig
+		cfg.Domains, err = loadDomains()
+		return
+	}
+
+	if args.Check {
+		err = check(os.Stdout, sdnsConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr,
+				"ERROR: Invalid configuration - %s",
+				errors.Cause(err))
+			os.Exit(1)
+		}
+
+		return
+	}
 
 	s, err = NewSdns(sdnsConfig)
 	if err != nil {
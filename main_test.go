@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/cirocosta/sdns/lib"
+)
+
+func TestParseDomains(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		input       []string
+		skipBad     bool
+		domains     []string
+		skipped     int
+		shouldError bool
+	}{
+		{
+			name:    "all valid",
+			input:   []string{"domain=a.com,ip=1.1.1.1", "domain=b.com"},
+			domains: []string{"a.com", "b.com"},
+		},
+		{
+			name:        "mixed aborts without skipping",
+			input:       []string{"domain=a.com", "ip=1.1.1.1", "domain=b.com"},
+			shouldError: true,
+		},
+		{
+			name:    "mixed with skipping",
+			input:   []string{"domain=a.com", "ip=1.1.1.1", "lol", "domain=b.com"},
+			skipBad: true,
+			domains: []string{"a.com", "b.com"},
+			skipped: 2,
+		},
+		{
+			name:    "all invalid with skipping",
+			input:   []string{"ip=1.1.1.1", "lol"},
+			skipBad: true,
+			skipped: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			domains, skipped, err := parseDomains(tc.input, tc.skipBad)
+			if tc.shouldError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Len(t, skipped, tc.skipped)
+
+			names := []string{}
+			for _, domain := range domains {
+				names = append(names, domain.Name)
+			}
+
+			if tc.domains == nil {
+				tc.domains = []string{}
+			}
+			assert.Equal(t, tc.domains, names)
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		domains     []string
+		policy      DuplicatePolicy
+		loaded      int
+		shouldError bool
+	}{
+		{
+			name:    "valid",
+			domains: []string{"domain=a.com,ip=1.1.1.1,mx=10 mail.a.com", "domain=*.b.com,ip=2.2.2.2"},
+			loaded:  2,
+		},
+		{
+			name:        "malformed wildcard",
+			domains:     []string{"domain=*b.com,ip=2.2.2.2"},
+			shouldError: true,
+		},
+		{
+			name:        "wildcard past the leftmost label",
+			domains:     []string{"domain=a.*.b.com,ip=2.2.2.2"},
+			shouldError: true,
+		},
+		{
+			name:        "empty address",
+			domains:     []string{"domain=a.com,ip=;weight=10"},
+			shouldError: true,
+		},
+		{
+			name:        "mistyped ip",
+			domains:     []string{"domain=a.com,ip=1.1.1.300"},
+			shouldError: true,
+		},
+		{
+			name:        "mistyped nameserver",
+			domains:     []string{"domain=a.com,ns=ns1..a.com"},
+			shouldError: true,
+		},
+		{
+			name:        "health check without addresses",
+			domains:     []string{"domain=a.com,check=tcp,check-port=80"},
+			shouldError: true,
+		},
+		{
+			name:        "bad mx priority",
+			domains:     []string{"domain=a.com,mx=70000 mail.a.com"},
+			shouldError: true,
+		},
+		{
+			name:        "duplicate domains",
+			domains:     []string{"domain=a.com,ip=1.1.1.1", "domain=A.com,ip=2.2.2.2"},
+			shouldError: true,
+		},
+		{
+			name:        "duplicate wildcards",
+			domains:     []string{"domain=*.a.com,ip=1.1.1.1", "domain=*.a.com,ip=2.2.2.2"},
+			shouldError: true,
+		},
+		{
+			name:    "duplicate domains kept last",
+			domains: []string{"domain=a.com,ip=1.1.1.1", "domain=A.com,ip=2.2.2.2"},
+			policy:  DuplicatesLast,
+			loaded:  1,
+		},
+		{
+			name:    "duplicate domains merged",
+			domains: []string{"domain=a.com,ip=1.1.1.1", "domain=A.com,ip=2.2.2.2"},
+			policy:  DuplicatesMerge,
+			loaded:  1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			domains, err := ParseDomainArgs(tc.domains)
+			assert.NoError(t, err)
+
+			var out bytes.Buffer
+
+			err = check(&out, SdnsConfig{
+				Port:            1053,
+				Recursors:       []string{"127.0.0.1:53"},
+				DuplicatePolicy: tc.policy,
+				Domains:         domains,
+			})
+			if tc.shouldError {
+				assert.Error(t, err)
+				assert.Empty(t, out.String())
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t,
+				fmt.Sprintf("OK: Valid configuration - %d domains\n", tc.loaded),
+				out.String())
+		})
+	}
+}